@@ -0,0 +1,55 @@
+// cache/cache.go
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bi-backend/config"
+)
+
+// Cache 查询结果缓存，memory / redis 驱动均实现该接口
+type Cache interface {
+	// Get 读取缓存，未命中或已过期时返回 false
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set 写入缓存，ttl 为 0 表示不过期
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// DeletePrefix 删除指定前缀下的所有缓存
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+var current Cache
+
+// Init 根据配置初始化缓存，driver 为 none 时不启用缓存
+func Init(cfg config.CacheConfig) error {
+	switch cfg.Driver {
+	case "none":
+		current = nil
+		log.Printf("Query cache disabled")
+		return nil
+	case "memory":
+		current = NewLRU(cfg.MaxEntries, cfg.MaxBytes)
+	case "redis":
+		c, err := newRedisCache(cfg)
+		if err != nil {
+			return err
+		}
+		current = c
+	default:
+		return fmt.Errorf("unsupported cache driver: %s", cfg.Driver)
+	}
+	log.Printf("Query cache initialized successfully, driver: %s", cfg.Driver)
+	return nil
+}
+
+// Default 获取当前缓存，未启用时返回 nil
+func Default() Cache {
+	return current
+}
+
+// SetDefault 替换当前缓存，便于测试或嵌入其他实现
+func SetDefault(c Cache) {
+	current = c
+}
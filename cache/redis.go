@@ -0,0 +1,73 @@
+// cache/redis.go
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"bi-backend/config"
+)
+
+// redisCache 基于 Redis 的共享缓存，多个实例之间共用查询结果
+type redisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+func newRedisCache(cfg config.CacheConfig) (*redisCache, error) {
+	if cfg.RedisAddr == "" {
+		return nil, fmt.Errorf("redis cache requires CACHE_REDIS_ADDR")
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %v", err)
+	}
+	return &redisCache{client: client, prefix: cfg.RedisPrefix}, nil
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Redis cache get failed: %v", err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, c.prefix+key, value, ttl).Err()
+}
+
+func (c *redisCache) DeletePrefix(ctx context.Context, prefix string) error {
+	iter := c.client.Scan(ctx, 0, c.prefix+prefix+"*", 1000).Iterator()
+	keys := []string{}
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) >= 1000 {
+			if err := c.client.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return c.client.Unlink(ctx, keys...).Err()
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// charttype/builtin.go
+package charttype
+
+import "fmt"
+
+// colorPattern 颜色取值：#RGB、#RRGGBB、rgb()/rgba()/hsl()/hsla() 或颜色名称
+const colorPattern = `^(#[0-9a-fA-F]{3,8}|(rgb|rgba|hsl|hsla)\([^()]*\)|[a-zA-Z]+)$`
+
+// object 构造 object 类型的 schema，未声明的属性保留给前端扩展
+func object(props Schema) Schema {
+	return Schema{"type": []string{"object", "null"}, "properties": props}
+}
+
+func boolean() Schema { return Schema{"type": "boolean"} }
+
+func str() Schema { return Schema{"type": "string"} }
+
+func number(min float64) Schema { return Schema{"type": "number", "minimum": min} }
+
+func enum(values ...string) Schema { return Schema{"enum": values} }
+
+func colors() Schema {
+	return Schema{"type": []string{"array", "null"}, "items": Schema{"type": "string", "pattern": colorPattern}}
+}
+
+// settings 所有图表共用的展示配置，extra 为该类型特有的配置
+func settings(extra Schema) Schema {
+	props := Schema{
+		"title":          str(),
+		"showLegend":     boolean(),
+		"legendPosition": enum("top", "bottom", "left", "right"),
+		"showTooltip":    boolean(),
+		"showLabel":      boolean(),
+		"colors":         colors(),
+		"height":         number(0),
+	}
+	for k, v := range extra {
+		props[k] = v
+	}
+	return object(props)
+}
+
+// axes 直角坐标系图表的坐标轴配置
+func axes(extra Schema) Schema {
+	props := Schema{
+		"showGrid":   boolean(),
+		"xAxisLabel": str(),
+		"yAxisLabel": str(),
+	}
+	for k, v := range extra {
+		props[k] = v
+	}
+	return settings(props)
+}
+
+// visualMapSchema 颜色和大小映射配置
+func visualMapSchema() Schema {
+	s := object(Schema{
+		"colorField":  str(),
+		"colorRange":  colors(),
+		"sizeField":   str(),
+		"sizeRange":   Schema{"type": []string{"array", "null"}, "items": number(0), "maxItems": 2},
+		"labelFields": Schema{"type": []string{"array", "null"}, "items": str()},
+	})
+	s["additionalProperties"] = false
+	return s
+}
+
+// dualAxisSchema 双轴配置，types 为每个指标的图形
+func dualAxisSchema() Schema {
+	s := object(Schema{
+		"enabled": boolean(),
+		"types":   Schema{"type": []string{"array", "null"}, "items": enum("bar", "line", "area")},
+	})
+	s["additionalProperties"] = false
+	return s
+}
+
+func init() {
+	builtin := []Type{
+		{
+			Name: "bar", Label: "柱状图", MinDimensions: 1, MaxDimensions: 2, MinMetrics: 1,
+			Settings:  axes(Schema{"stacked": boolean(), "horizontal": boolean(), "barSize": number(0)}),
+			VisualMap: visualMapSchema(),
+			DualAxis:  dualAxisSchema(),
+		},
+		{
+			Name: "line", Label: "折线图", MinDimensions: 1, MaxDimensions: 2, MinMetrics: 1,
+			Settings:  axes(Schema{"smooth": boolean(), "showDots": boolean(), "strokeWidth": number(0)}),
+			VisualMap: visualMapSchema(),
+			DualAxis:  dualAxisSchema(),
+		},
+		{
+			Name: "area", Label: "面积图", MinDimensions: 1, MaxDimensions: 2, MinMetrics: 1,
+			Settings: axes(Schema{"stacked": boolean(), "smooth": boolean()}),
+		},
+		{
+			Name: "dual_axis", Label: "双轴图", MinDimensions: 1, MaxDimensions: 1, MinMetrics: 2,
+			Settings: axes(Schema{"leftAxisLabel": str(), "rightAxisLabel": str()}),
+			DualAxis: dualAxisSchema(),
+		},
+		{
+			Name: "pie", Label: "饼图", MinDimensions: 1, MaxDimensions: 1, MinMetrics: 1, MaxMetrics: 1,
+			Settings: settings(Schema{"innerRadius": number(0), "outerRadius": number(0), "showPercent": boolean()}),
+		},
+		{
+			Name: "scatter", Label: "散点图", MinDimensions: 1, MaxDimensions: 1, MinMetrics: 2, MaxMetrics: 3,
+			Settings:  axes(Schema{"pointSize": number(0)}),
+			VisualMap: visualMapSchema(),
+		},
+		{
+			Name: "heatmap", Label: "热力图", MinDimensions: 2, MaxDimensions: 2, MinMetrics: 1, MaxMetrics: 1,
+			Settings:  axes(Schema{"showValues": boolean()}),
+			VisualMap: visualMapSchema(),
+		},
+		{
+			Name: "table", Label: "明细表", MinDimensions: 0, MinMetrics: 0,
+			Settings: settings(Schema{"pageSize": number(1), "showTotals": boolean()}),
+		},
+		{
+			Name: "pivot", Label: "透视表", MinDimensions: 0, MaxDimensions: 0, MinMetrics: 1,
+			Settings: settings(Schema{"showTotals": boolean()}),
+		},
+	}
+	for _, t := range builtin {
+		if err := Register(t); err != nil {
+			panic(fmt.Sprintf("register chart type %s: %v", t.Name, err))
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// charttype/registry.go
+package charttype
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"bi-backend/models"
+	"bi-backend/query"
+)
+
+// Schema JSON Schema 文档
+type Schema map[string]interface{}
+
+// Type 图表类型，声明需要的维度、指标数量以及 settings、visualMap、dualAxis 的 JSON Schema；
+// VisualMap 或 DualAxis 为空表示该类型不支持对应配置
+type Type struct {
+	Name          string `json:"name"`
+	Label         string `json:"label"`
+	MinDimensions int    `json:"min_dimensions"`
+	MaxDimensions int    `json:"max_dimensions"` // 0 表示不限
+	MinMetrics    int    `json:"min_metrics"`
+	MaxMetrics    int    `json:"max_metrics"` // 0 表示不限
+	Settings      Schema `json:"settings_schema"`
+	VisualMap     Schema `json:"visual_map_schema,omitempty"`
+	DualAxis      Schema `json:"dual_axis_schema,omitempty"`
+
+	settings, visualMap, dualAxis *jsonschema.Schema
+}
+
+var (
+	mu    sync.RWMutex
+	types = map[string]*Type{}
+	order []string
+)
+
+// Register 注册图表类型，编译其中的 JSON Schema，同名类型会被替换
+func Register(t Type) error {
+	if t.Name == "" {
+		return errors.New("chart type name is required")
+	}
+	var err error
+	if t.settings, err = compile(t.Name, "settings", t.Settings); err != nil {
+		return err
+	}
+	if t.visualMap, err = compile(t.Name, "visualMap", t.VisualMap); err != nil {
+		return err
+	}
+	if t.dualAxis, err = compile(t.Name, "dualAxis", t.DualAxis); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := types[t.Name]; !ok {
+		order = append(order, t.Name)
+	}
+	types[t.Name] = &t
+	return nil
+}
+
+// compile 编译 JSON Schema，schema 为空时返回空
+func compile(typeName, part string, s Schema) (*jsonschema.Schema, error) {
+	if s == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("chart-types/%s/%s.json", typeName, part)
+	compiled, err := jsonschema.CompileString(url, string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s schema for chart type %s: %w", part, typeName, err)
+	}
+	return compiled, nil
+}
+
+// Lookup 按名称查找图表类型
+func Lookup(name string) (*Type, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := types[name]
+	return t, ok
+}
+
+// All 按注册顺序返回所有图表类型
+func All() []*Type {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]*Type, 0, len(order))
+	for _, name := range order {
+		out = append(out, types[name])
+	}
+	return out
+}
+
+// Validate 按图表类型检查维度和指标数量，以及 settings、visualMap、dualAxis 是否符合 JSON Schema，
+// 返回所有字段级错误
+func Validate(chartType string, cfg models.ChartConfig) []*query.Error {
+	t, ok := Lookup(chartType)
+	if !ok {
+		return []*query.Error{{Field: "type", Message: fmt.Sprintf("unsupported chart type %q", chartType)}}
+	}
+
+	var errs []*query.Error
+	if msg := countError(len(cfg.Dimensions), t.MinDimensions, t.MaxDimensions, "dimension"); msg != "" {
+		errs = append(errs, &query.Error{Field: "dimensions", Message: msg})
+	}
+	if msg := countError(len(cfg.Metrics), t.MinMetrics, t.MaxMetrics, "metric"); msg != "" {
+		errs = append(errs, &query.Error{Field: "metrics", Message: msg})
+	}
+
+	errs = append(errs, validatePart(t.settings, "settings", cfg.Settings)...)
+
+	if v := cfg.VisualMap; v != nil && (v.ColorField != "" || v.SizeField != "" || len(v.ColorRange) > 0 || len(v.SizeRange) > 0 || len(v.LabelFields) > 0) {
+		if t.visualMap == nil {
+			errs = append(errs, &query.Error{Field: "visualMap", Message: fmt.Sprintf("%s charts do not support visualMap", t.Name)})
+		} else {
+			errs = append(errs, validatePart(t.visualMap, "visualMap", v)...)
+		}
+	}
+
+	if d := cfg.DualAxis; d != nil && d.Enabled {
+		switch {
+		case t.dualAxis == nil:
+			errs = append(errs, &query.Error{Field: "dualAxis", Message: fmt.Sprintf("%s charts do not support dualAxis", t.Name)})
+		case len(d.Types) > 0 && len(d.Types) != len(cfg.Metrics):
+			errs = append(errs, &query.Error{Field: "dualAxis.types", Message: "dualAxis types must match the number of metrics"})
+		default:
+			errs = append(errs, validatePart(t.dualAxis, "dualAxis", d)...)
+		}
+	}
+	return errs
+}
+
+// countError 数量超出范围时返回错误信息
+func countError(n, min, max int, noun string) string {
+	switch {
+	case n < min && (max == min):
+		return fmt.Sprintf("requires exactly %d %s(s)", min, noun)
+	case n < min:
+		return fmt.Sprintf("requires at least %d %s(s)", min, noun)
+	case max > 0 && n > max:
+		return fmt.Sprintf("allows at most %d %s(s)", max, noun)
+	}
+	return ""
+}
+
+// validatePart 按 JSON 序列化后的值检查 schema，错误位置转换为 settings.legend.position 形式的字段名
+func validatePart(s *jsonschema.Schema, field string, v interface{}) []*query.Error {
+	if s == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return []*query.Error{{Field: field, Message: "value is not valid JSON"}}
+	}
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return []*query.Error{{Field: field, Message: "value is not valid JSON"}}
+	}
+
+	err = s.Validate(doc)
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		if err != nil {
+			return []*query.Error{{Field: field, Message: err.Error()}}
+		}
+		return nil
+	}
+
+	var errs []*query.Error
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+		name := field
+		if loc := strings.Trim(e.InstanceLocation, "/"); loc != "" {
+			name += "." + strings.ReplaceAll(loc, "/", ".")
+		}
+		errs = append(errs, &query.Error{Field: name, Message: e.Message})
+	}
+	walk(verr)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
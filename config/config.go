@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -13,11 +14,21 @@ type Config struct {
 	JWT      JWTConfig
 	Email    EmailConfig
 	Frontend FrontendConfig
+	Storage  StorageConfig
+	Upload   UploadConfig
+	Ingest   IngestConfig
+	Quota    QuotaConfig
+	Query    QueryConfig
+	Cache    CacheConfig
+	Render   RenderConfig
+	Report   ReportConfig
+	Alert    AlertConfig
 }
 
 type ServerConfig struct {
-	Port string
-	Mode string
+	Port         string
+	Mode         string
+	MaxBodyBytes int64 // 非上传接口的请求体大小上限
 }
 
 type DatabaseConfig struct {
@@ -30,6 +41,8 @@ type JWTConfig struct {
 	Secret        string
 	ExpireDays    int
 	RefreshSecret string
+	EmbedSecret   string        // 嵌入令牌的签名密钥，为空时不允许嵌入
+	EmbedMaxTTL   time.Duration // 嵌入令牌的最长有效期
 }
 
 type EmailConfig struct {
@@ -40,12 +53,99 @@ type EmailConfig struct {
 	From     string
 }
 
+// UploadConfig 上传文件限制
+type UploadConfig struct {
+	MaxSize map[string]int64 // 各文件类型（excel/csv/json）的最大字节数
+
+	// XLSX 本质是 zip 压缩包，以下限制用于防御 zip 炸弹
+	XLSXMaxEntries      int     // 压缩包内最大文件数
+	XLSXMaxUncompressed int64   // 解压后总大小上限
+	XLSXMaxRatio        float64 // 单个文件的最大压缩比
+
+	ChunkSize  int64         // 分片上传的分片大小
+	SessionTTL time.Duration // 分片上传会话的有效期
+}
+
+// IngestConfig 异步导入任务配置
+type IngestConfig struct {
+	Workers   int    // 并发处理导入任务的 worker 数量
+	QueueSize int    // 等待处理的任务队列长度，队列满时拒绝新的上传
+	TempDir   string // 上传文件写入存储前的临时目录
+}
+
+// QuotaConfig 用户默认配额，0 表示不限制，管理员可以为单个用户单独调整
+type QuotaConfig struct {
+	MaxStorageBytes         int64 // 数据源原始文件总大小
+	MaxUploadBytes          int64 // 单次上传的文件大小，同时受各文件类型的上传限制约束
+	MaxDataSources          int64
+	MaxRowsPerDataSource    int64
+	MaxColumnsPerDataSource int64
+	MaxDashboards           int64
+	MaxCharts               int64
+	MaxMLModels             int64
+}
+
+// QueryConfig 图表查询限制
+type QueryConfig struct {
+	MaxRows int           // 单次查询返回的最大行数
+	Timeout time.Duration // 单次查询的最长执行时间
+}
+
+// CacheConfig 查询结果缓存配置，Driver 可选 memory / redis / none
+type CacheConfig struct {
+	Driver        string
+	TTL           time.Duration // 缓存有效期，数据源变化时会提前失效
+	MaxEntries    int           // memory 驱动的最大条目数
+	MaxBytes      int64         // memory 驱动的最大总字节数
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisPrefix   string // redis 驱动的 key 前缀，多个环境共用 Redis 时区分
+}
+
+// RenderConfig 服务端图表渲染配置
+type RenderConfig struct {
+	FontPath  string // PNG 使用的 TrueType 字体文件，渲染中文需要配置，为空时使用内置英文字体
+	MaxWidth  int    // 图片的最大宽度（像素）
+	MaxHeight int    // 图片的最大高度（像素）
+}
+
+// ReportConfig 定时邮件报表配置
+type ReportConfig struct {
+	CheckInterval time.Duration // 检查到期订阅的间隔，0 表示不发送定时报表
+}
+
+// AlertConfig 指标告警配置
+type AlertConfig struct {
+	CheckInterval  time.Duration // 检查到期告警规则的间隔，0 表示不定时检查
+	WebhookTimeout time.Duration // 调用告警 webhook 的超时时间
+}
+
 var GlobalConfig Config
 
 type FrontendConfig struct {
 	URL string
 }
 
+// StorageConfig 文件存储配置，Driver 可选 local / s3 / oss
+type StorageConfig struct {
+	Driver          string
+	LocalDir        string // local 驱动的根目录
+	LocalBaseURL    string // local 驱动对外访问地址，例如 http://localhost:8080
+	LocalSecret     string // local 驱动预签名链接的签名密钥
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	AccessKeySecret string
+	UseSSL          bool
+	PathStyle       bool // s3 驱动是否使用 path-style 访问（MinIO 需要）
+
+	PresignTTL        time.Duration // 预签名下载链接有效期
+	ReconcileInterval time.Duration // 孤儿文件清理间隔，0 表示不自动清理
+	OrphanMinAge      time.Duration // 未被引用超过该时长的文件才会被清理
+}
+
 func Init() error {
 	// 设置默认值并从环境变量加载
 	port := os.Getenv("PORT")
@@ -63,15 +163,156 @@ func Init() error {
 		expireDays = 7
 	}
 
+	embedMaxTTL, _ := time.ParseDuration(os.Getenv("EMBED_TOKEN_MAX_TTL"))
+	if embedMaxTTL <= 0 {
+		embedMaxTTL = time.Hour
+	}
+
 	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
 	if smtpPort == 0 {
 		smtpPort = 587
 	}
 
+	// 未显式指定存储驱动时，配置了 OSS 则沿用 OSS，否则使用本地磁盘
+	storageDriver := os.Getenv("STORAGE_DRIVER")
+	if storageDriver == "" {
+		if os.Getenv("OSS_BUCKET") != "" {
+			storageDriver = "oss"
+		} else {
+			storageDriver = "local"
+		}
+	}
+
+	localDir := os.Getenv("STORAGE_LOCAL_DIR")
+	if localDir == "" {
+		localDir = "./data/storage"
+	}
+
+	localBaseURL := os.Getenv("STORAGE_LOCAL_BASE_URL")
+	if localBaseURL == "" {
+		localBaseURL = "http://localhost:" + port
+	}
+
+	localSecret := os.Getenv("STORAGE_LOCAL_SECRET")
+	if localSecret == "" {
+		localSecret = os.Getenv("JWT_SECRET")
+	}
+
+	useSSL := true
+	if v, err := strconv.ParseBool(os.Getenv("S3_USE_SSL")); err == nil {
+		useSSL = v
+	}
+	pathStyle, _ := strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+
+	presignTTL, _ := time.ParseDuration(os.Getenv("STORAGE_PRESIGN_TTL"))
+	if presignTTL == 0 {
+		presignTTL = 15 * time.Minute
+	}
+	reconcileInterval, _ := time.ParseDuration(os.Getenv("STORAGE_RECONCILE_INTERVAL"))
+	orphanMinAge, _ := time.ParseDuration(os.Getenv("STORAGE_ORPHAN_MIN_AGE"))
+	if orphanMinAge == 0 {
+		orphanMinAge = 24 * time.Hour
+	}
+
+	// 上传大小限制，单位 MB
+	maxSizeMB := func(env string, def int64) int64 {
+		v, _ := strconv.ParseInt(os.Getenv(env), 10, 64)
+		if v <= 0 {
+			v = def
+		}
+		return v << 20
+	}
+
+	xlsxMaxEntries, _ := strconv.Atoi(os.Getenv("UPLOAD_XLSX_MAX_ENTRIES"))
+	if xlsxMaxEntries == 0 {
+		xlsxMaxEntries = 10000
+	}
+	xlsxMaxRatio, _ := strconv.ParseFloat(os.Getenv("UPLOAD_XLSX_MAX_RATIO"), 64)
+	if xlsxMaxRatio == 0 {
+		xlsxMaxRatio = 200
+	}
+
+	// S3 要求除最后一个分片外每个分片不小于 5MB
+	chunkSize := maxSizeMB("UPLOAD_CHUNK_SIZE_MB", 5)
+	if chunkSize < 5<<20 {
+		chunkSize = 5 << 20
+	}
+	sessionTTL, _ := time.ParseDuration(os.Getenv("UPLOAD_SESSION_TTL"))
+	if sessionTTL == 0 {
+		sessionTTL = 24 * time.Hour
+	}
+
+	ingestWorkers, _ := strconv.Atoi(os.Getenv("INGEST_WORKERS"))
+	if ingestWorkers <= 0 {
+		ingestWorkers = 2
+	}
+	ingestQueueSize, _ := strconv.Atoi(os.Getenv("INGEST_QUEUE_SIZE"))
+	if ingestQueueSize <= 0 {
+		ingestQueueSize = 100
+	}
+	ingestTempDir := os.Getenv("INGEST_TEMP_DIR")
+	if ingestTempDir == "" {
+		ingestTempDir = "./data/ingest"
+	}
+
+	// 配额，未设置时使用默认值，设置为 0 表示不限制
+	quota := func(env string, def int64) int64 {
+		v, err := strconv.ParseInt(os.Getenv(env), 10, 64)
+		if err != nil || v < 0 {
+			return def
+		}
+		return v
+	}
+
+	queryMaxRows, _ := strconv.Atoi(os.Getenv("QUERY_MAX_ROWS"))
+	if queryMaxRows <= 0 {
+		queryMaxRows = 10000
+	}
+	queryTimeout, _ := time.ParseDuration(os.Getenv("QUERY_TIMEOUT"))
+	if queryTimeout == 0 {
+		queryTimeout = 30 * time.Second
+	}
+
+	cacheDriver := os.Getenv("CACHE_DRIVER")
+	if cacheDriver == "" {
+		cacheDriver = "memory"
+	}
+	cacheTTL, _ := time.ParseDuration(os.Getenv("CACHE_TTL"))
+	if cacheTTL == 0 {
+		cacheTTL = 10 * time.Minute
+	}
+	cacheMaxEntries, _ := strconv.Atoi(os.Getenv("CACHE_MAX_ENTRIES"))
+	if cacheMaxEntries <= 0 {
+		cacheMaxEntries = 1000
+	}
+	cacheRedisDB, _ := strconv.Atoi(os.Getenv("CACHE_REDIS_DB"))
+	cacheRedisPrefix := os.Getenv("CACHE_REDIS_PREFIX")
+	if cacheRedisPrefix == "" {
+		cacheRedisPrefix = "bi:"
+	}
+
+	renderMaxSize, _ := strconv.Atoi(os.Getenv("RENDER_MAX_SIZE"))
+	if renderMaxSize <= 0 {
+		renderMaxSize = 4000
+	}
+	reportCheckInterval := time.Minute
+	if v := os.Getenv("REPORT_CHECK_INTERVAL"); v != "" {
+		reportCheckInterval, _ = time.ParseDuration(v)
+	}
+	alertCheckInterval := time.Minute
+	if v := os.Getenv("ALERT_CHECK_INTERVAL"); v != "" {
+		alertCheckInterval, _ = time.ParseDuration(v)
+	}
+	alertWebhookTimeout, _ := time.ParseDuration(os.Getenv("ALERT_WEBHOOK_TIMEOUT"))
+	if alertWebhookTimeout <= 0 {
+		alertWebhookTimeout = 10 * time.Second
+	}
+
 	GlobalConfig = Config{
 		Server: ServerConfig{
-			Port: port,
-			Mode: os.Getenv("GIN_MODE"),
+			Port:         port,
+			Mode:         os.Getenv("GIN_MODE"),
+			MaxBodyBytes: maxSizeMB("REQUEST_MAX_BODY_MB", 10),
 		},
 		Database: DatabaseConfig{
 			URI:      os.Getenv("MONGODB_URI"),
@@ -82,6 +323,8 @@ func Init() error {
 			Secret:        os.Getenv("JWT_SECRET"),
 			ExpireDays:    expireDays,
 			RefreshSecret: os.Getenv("JWT_REFRESH_SECRET"),
+			EmbedSecret:   os.Getenv("EMBED_JWT_SECRET"),
+			EmbedMaxTTL:   embedMaxTTL,
 		},
 		Email: EmailConfig{
 			Host:     os.Getenv("EMAIL_SMTP_HOST"),
@@ -93,6 +336,89 @@ func Init() error {
 		Frontend: FrontendConfig{
 			URL: os.Getenv("FRONTEND_URL"),
 		},
+		Storage: StorageConfig{
+			Driver:       storageDriver,
+			LocalDir:     localDir,
+			LocalBaseURL: localBaseURL,
+			LocalSecret:  localSecret,
+			UseSSL:       useSSL,
+			PathStyle:    pathStyle,
+
+			PresignTTL:        presignTTL,
+			ReconcileInterval: reconcileInterval,
+			OrphanMinAge:      orphanMinAge,
+		},
+		Upload: UploadConfig{
+			MaxSize: map[string]int64{
+				"excel": maxSizeMB("UPLOAD_MAX_SIZE_EXCEL_MB", 20),
+				"csv":   maxSizeMB("UPLOAD_MAX_SIZE_CSV_MB", 50),
+				"json":  maxSizeMB("UPLOAD_MAX_SIZE_JSON_MB", 50),
+			},
+			XLSXMaxEntries:      xlsxMaxEntries,
+			XLSXMaxUncompressed: maxSizeMB("UPLOAD_XLSX_MAX_UNCOMPRESSED_MB", 500),
+			XLSXMaxRatio:        xlsxMaxRatio,
+			ChunkSize:           chunkSize,
+			SessionTTL:          sessionTTL,
+		},
+		Ingest: IngestConfig{
+			Workers:   ingestWorkers,
+			QueueSize: ingestQueueSize,
+			TempDir:   ingestTempDir,
+		},
+		Quota: QuotaConfig{
+			MaxStorageBytes:         quota("QUOTA_MAX_STORAGE_MB", 1024) << 20,
+			MaxUploadBytes:          quota("QUOTA_MAX_UPLOAD_MB", 0) << 20,
+			MaxDataSources:          quota("QUOTA_MAX_DATA_SOURCES", 50),
+			MaxRowsPerDataSource:    quota("QUOTA_MAX_ROWS_PER_DATA_SOURCE", 1000000),
+			MaxColumnsPerDataSource: quota("QUOTA_MAX_COLUMNS_PER_DATA_SOURCE", 500),
+			MaxDashboards:           quota("QUOTA_MAX_DASHBOARDS", 50),
+			MaxCharts:               quota("QUOTA_MAX_CHARTS", 500),
+			MaxMLModels:             quota("QUOTA_MAX_ML_MODELS", 50),
+		},
+		Query: QueryConfig{
+			MaxRows: queryMaxRows,
+			Timeout: queryTimeout,
+		},
+		Cache: CacheConfig{
+			Driver:        cacheDriver,
+			TTL:           cacheTTL,
+			MaxEntries:    cacheMaxEntries,
+			MaxBytes:      maxSizeMB("CACHE_MAX_MB", 256),
+			RedisAddr:     os.Getenv("CACHE_REDIS_ADDR"),
+			RedisPassword: os.Getenv("CACHE_REDIS_PASSWORD"),
+			RedisDB:       cacheRedisDB,
+			RedisPrefix:   cacheRedisPrefix,
+		},
+		Render: RenderConfig{
+			FontPath:  os.Getenv("RENDER_FONT_PATH"),
+			MaxWidth:  renderMaxSize,
+			MaxHeight: renderMaxSize,
+		},
+		Report: ReportConfig{
+			CheckInterval: reportCheckInterval,
+		},
+		Alert: AlertConfig{
+			CheckInterval:  alertCheckInterval,
+			WebhookTimeout: alertWebhookTimeout,
+		},
+	}
+
+	// 根据驱动读取对应的凭证
+	switch storageDriver {
+	case "oss":
+		GlobalConfig.Storage.Endpoint = os.Getenv("OSS_ENDPOINT")
+		GlobalConfig.Storage.Bucket = os.Getenv("OSS_BUCKET")
+		GlobalConfig.Storage.AccessKeyID = os.Getenv("OSS_ACCESS_KEY_ID")
+		GlobalConfig.Storage.AccessKeySecret = os.Getenv("OSS_ACCESS_KEY_SECRET")
+	case "s3":
+		GlobalConfig.Storage.Endpoint = os.Getenv("S3_ENDPOINT")
+		GlobalConfig.Storage.Region = os.Getenv("S3_REGION")
+		GlobalConfig.Storage.Bucket = os.Getenv("S3_BUCKET")
+		GlobalConfig.Storage.AccessKeyID = os.Getenv("S3_ACCESS_KEY_ID")
+		GlobalConfig.Storage.AccessKeySecret = os.Getenv("S3_SECRET_ACCESS_KEY")
+		if GlobalConfig.Storage.Region == "" {
+			GlobalConfig.Storage.Region = "us-east-1"
+		}
 	}
 
 	// 验证必需的配置
@@ -132,6 +132,93 @@ func createIndexes(ctx context.Context) error {
 			Keys: bson.D{{"data_source_id", 1}},
 		},
 	})
+	if err != nil {
+		return err
+	}
+
+	// 导入任务集合索引
+	_, err = db.Collection("ingest_jobs").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"created_by", 1}, {"created_at", -1}},
+		},
+		{
+			Keys: bson.D{{"status", 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 通知集合索引
+	_, err = db.Collection("notifications").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"user_id", 1}, {"created_at", -1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 报表订阅集合索引
+	_, err = db.Collection("report_subscriptions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"created_by", 1}, {"dashboard_id", 1}},
+		},
+		{
+			Keys: bson.D{{"enabled", 1}, {"next_run_at", 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 报表发送记录集合索引
+	_, err = db.Collection("report_deliveries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"subscription_id", 1}, {"started_at", -1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 分享链接集合索引
+	_, err = db.Collection("dashboard_shares").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"token", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{"dashboard_id", 1}, {"created_at", -1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 告警规则集合索引
+	_, err = db.Collection("alert_rules").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"created_by", 1}, {"chart_id", 1}},
+		},
+		{
+			Keys: bson.D{{"enabled", 1}, {"next_eval_at", 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// 告警记录集合索引，保留 90 天
+	_, err = db.Collection("alert_history").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{"rule_id", 1}, {"evaluated_at", -1}},
+		},
+		{
+			Keys:    bson.D{{"evaluated_at", 1}},
+			Options: options.Index().SetExpireAfterSeconds(90 * 24 * 3600),
+		},
+	})
 
 	return err
 }
@@ -0,0 +1,66 @@
+// events/events.go
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 事件类型
+const (
+	IngestSucceeded = "ingest.succeeded" // 数据导入完成
+	IngestFailed    = "ingest.failed"    // 数据导入失败
+
+	DataSourceUpdated = "datasource.updated" // 数据源内容或预处理变化（更新、追加、重新处理）
+	DataSourceDeleted = "datasource.deleted" // 数据源已删除
+
+	AlertTriggered = "alert.triggered" // 指标告警触发
+)
+
+// Event 进程内事件
+type Event struct {
+	Type      string                 `json:"type"`
+	UserID    primitive.ObjectID     `json:"user_id"`
+	Payload   map[string]interface{} `json:"payload,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// Handler 事件处理函数
+type Handler func(Event)
+
+var (
+	mu       sync.RWMutex
+	handlers = map[string][]Handler{}
+)
+
+// Subscribe 订阅指定类型的事件
+func Subscribe(eventType string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[eventType] = append(handlers[eventType], handler)
+}
+
+// Publish 发布事件，订阅者在独立的 goroutine 中执行，不阻塞发布方
+func Publish(e Event) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	mu.RLock()
+	subscribers := append([]Handler(nil), handlers[e.Type]...)
+	mu.RUnlock()
+
+	for _, h := range subscribers {
+		go func(h Handler) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Event handler for %s panicked: %v", e.Type, r)
+				}
+			}()
+			h(e)
+		}(h)
+	}
+}
@@ -0,0 +1,41 @@
+// handlers/admin.go
+package handlers
+
+import (
+	"bi-backend/config"
+	"bi-backend/services"
+	"bi-backend/utils"
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReconcileStorage 手动触发孤儿文件清理，dry_run=true 时只返回待清理列表
+func ReconcileStorage(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	minAge := config.GlobalConfig.Storage.OrphanMinAge
+	if v := c.Query("min_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			utils.Error(c, 400, "Invalid min_age")
+			return
+		}
+		minAge = d
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	report, err := services.ReconcileStorage(ctx, minAge, dryRun)
+	if err != nil {
+		log.Printf("Storage reconcile failed: %v", err)
+		utils.Error(c, 500, "Failed to reconcile storage")
+		return
+	}
+
+	utils.Success(c, report)
+}
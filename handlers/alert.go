@@ -0,0 +1,382 @@
+// handlers/alert.go
+package handlers
+
+import (
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/services"
+	"bi-backend/utils"
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// alertRuleInput 创建和更新告警规则的请求体，更新时只修改传入的字段
+type alertRuleInput struct {
+	ChartID         *primitive.ObjectID     `json:"chart_id"`
+	Name            *string                 `json:"name"`
+	Metric          *string                 `json:"metric"`
+	Reduce          *string                 `json:"reduce"`
+	Condition       *string                 `json:"condition"`
+	Operator        *string                 `json:"operator"`
+	Threshold       *float64                `json:"threshold"`
+	CompareDays     *int                    `json:"compare_days"`
+	Parameters      *map[string]interface{} `json:"parameters"`
+	IntervalMinutes *int                    `json:"interval_minutes"`
+	OnRefresh       *bool                   `json:"on_refresh"`
+	Channels        *models.AlertChannels   `json:"channels"`
+	Enabled         *bool                   `json:"enabled"`
+	Muted           *bool                   `json:"muted"`
+}
+
+// apply 将传入的字段写入规则
+func (in *alertRuleInput) apply(r *models.AlertRule) {
+	if in.Name != nil {
+		r.Name = *in.Name
+	}
+	if in.Metric != nil {
+		r.Metric = *in.Metric
+	}
+	if in.Reduce != nil {
+		r.Reduce = *in.Reduce
+	}
+	if in.Condition != nil {
+		r.Condition = *in.Condition
+	}
+	if in.Operator != nil {
+		r.Operator = *in.Operator
+	}
+	if in.Threshold != nil {
+		r.Threshold = *in.Threshold
+	}
+	if in.CompareDays != nil {
+		r.CompareDays = *in.CompareDays
+	}
+	if in.Parameters != nil {
+		r.Parameters = *in.Parameters
+	}
+	if in.IntervalMinutes != nil {
+		r.IntervalMinutes = *in.IntervalMinutes
+	}
+	if in.OnRefresh != nil {
+		r.OnRefresh = *in.OnRefresh
+	}
+	if in.Channels != nil {
+		r.Channels = *in.Channels
+	}
+	if in.Enabled != nil {
+		r.Enabled = *in.Enabled
+	}
+	if in.Muted != nil {
+		r.Muted = *in.Muted
+	}
+}
+
+// validateAlertRule 检查告警规则是否与图表的指标匹配
+func validateAlertRule(c *gin.Context, r *models.AlertRule) bool {
+	var chart models.Chart
+	err := db.GetCollection("charts").FindOne(context.TODO(), bson.M{
+		"_id":        r.ChartID,
+		"created_by": r.CreatedBy,
+	}).Decode(&chart)
+	if err != nil {
+		utils.Error(c, 404, "Chart not found")
+		return false
+	}
+	if err := services.ValidateAlertRule(r, &chart); err != nil {
+		utils.Error(c, 400, err.Error())
+		return false
+	}
+	return true
+}
+
+// findAlertRule 获取当前用户的告警规则
+func findAlertRule(c *gin.Context) (*models.AlertRule, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "Invalid alert ID")
+		return nil, false
+	}
+
+	var rule models.AlertRule
+	err = db.GetCollection("alert_rules").FindOne(context.TODO(), bson.M{
+		"_id":        id,
+		"created_by": c.MustGet("user_id").(primitive.ObjectID),
+	}).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			utils.Error(c, 404, "Alert not found")
+			return nil, false
+		}
+		utils.Error(c, 500, "Failed to fetch alert")
+		return nil, false
+	}
+	return &rule, true
+}
+
+// updateAlertFields 修改规则的部分字段并返回修改后的规则
+func updateAlertFields(c *gin.Context, rule *models.AlertRule, update bson.M) {
+	update["updated_at"] = time.Now()
+	var updated models.AlertRule
+	err := db.GetCollection("alert_rules").FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": rule.ID}, bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		utils.Error(c, 500, "Failed to update alert")
+		return
+	}
+	utils.Success(c, updated)
+}
+
+// CreateAlertRule 在图表指标上创建阈值告警
+func CreateAlertRule(c *gin.Context) {
+	var input alertRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "Invalid request data")
+		return
+	}
+	if input.ChartID == nil {
+		utils.Error(c, 400, "chart_id is required")
+		return
+	}
+	if input.Operator == nil || input.Threshold == nil {
+		utils.Error(c, 400, "operator and threshold are required")
+		return
+	}
+
+	now := time.Now()
+	rule := models.AlertRule{
+		ChartID:         *input.ChartID,
+		IntervalMinutes: 60,
+		Enabled:         true,
+		CreatedBy:       c.MustGet("user_id").(primitive.ObjectID),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	input.apply(&rule)
+	if !validateAlertRule(c, &rule) {
+		return
+	}
+
+	result, err := db.GetCollection("alert_rules").InsertOne(context.TODO(), rule)
+	if err != nil {
+		utils.Error(c, 500, "Failed to create alert")
+		return
+	}
+	rule.ID = result.InsertedID.(primitive.ObjectID)
+
+	utils.Success(c, rule)
+}
+
+// GetAlertRules 获取当前用户的告警规则，可按 chart_id 过滤
+func GetAlertRules(c *gin.Context) {
+	filter := bson.M{"created_by": c.MustGet("user_id").(primitive.ObjectID)}
+	if v := c.Query("chart_id"); v != "" {
+		chartID, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			utils.Error(c, 400, "Invalid chart ID")
+			return
+		}
+		filter["chart_id"] = chartID
+	}
+
+	cursor, err := db.GetCollection("alert_rules").Find(context.TODO(), filter,
+		options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		utils.Error(c, 500, "Failed to fetch alerts")
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	rules := []models.AlertRule{}
+	if err := cursor.All(context.TODO(), &rules); err != nil {
+		utils.Error(c, 500, "Failed to decode alerts")
+		return
+	}
+
+	utils.Success(c, rules)
+}
+
+// GetAlertRule 获取单个告警规则
+func GetAlertRule(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+	utils.Success(c, rule)
+}
+
+// UpdateAlertRule 修改告警规则，修改检查间隔后重新计算下一次检查时间
+func UpdateAlertRule(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+
+	var input alertRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "Invalid request data")
+		return
+	}
+	if input.ChartID != nil && *input.ChartID != rule.ChartID {
+		utils.Error(c, 400, "chart_id cannot be changed")
+		return
+	}
+	input.apply(rule)
+	if !validateAlertRule(c, rule) {
+		return
+	}
+	rule.UpdatedAt = time.Now()
+
+	_, err := db.GetCollection("alert_rules").ReplaceOne(context.TODO(), bson.M{"_id": rule.ID}, rule)
+	if err != nil {
+		utils.Error(c, 500, "Failed to update alert")
+		return
+	}
+
+	utils.Success(c, rule)
+}
+
+// DeleteAlertRule 删除告警规则及其检查记录
+func DeleteAlertRule(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+
+	if _, err := db.GetCollection("alert_rules").DeleteOne(context.TODO(), bson.M{"_id": rule.ID}); err != nil {
+		utils.Error(c, 500, "Failed to delete alert")
+		return
+	}
+	if _, err := db.GetCollection("alert_history").DeleteMany(context.TODO(), bson.M{"rule_id": rule.ID}); err != nil {
+		utils.Error(c, 500, "Failed to delete alert history")
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "Alert deleted successfully"})
+}
+
+// EvaluateAlertRule 立即检查一次告警规则，返回本次检查记录
+func EvaluateAlertRule(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+
+	event, err := services.EvaluateAlert(c.Request.Context(), rule, services.AlertTriggerManual)
+	if err != nil {
+		utils.Error(c, 500, "Failed to record alert evaluation")
+		return
+	}
+
+	utils.Success(c, event)
+}
+
+// GetAlertHistory 获取告警规则最近的检查记录，triggered=true 时只返回触发的记录
+func GetAlertHistory(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"rule_id": rule.ID}
+	if triggered, _ := strconv.ParseBool(c.Query("triggered")); triggered {
+		filter["state"] = models.AlertStateTriggered
+	}
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "100"), 10, 64)
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	cursor, err := db.GetCollection("alert_history").Find(context.TODO(), filter,
+		options.Find().SetSort(bson.M{"evaluated_at": -1}).SetLimit(limit))
+	if err != nil {
+		utils.Error(c, 500, "Failed to fetch alert history")
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	history := []models.AlertEvent{}
+	if err := cursor.All(context.TODO(), &history); err != nil {
+		utils.Error(c, 500, "Failed to decode alert history")
+		return
+	}
+
+	utils.Success(c, history)
+}
+
+// MuteAlertRule 静音告警规则，继续检查和记录但不发送通知
+func MuteAlertRule(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+	updateAlertFields(c, rule, bson.M{"muted": true})
+}
+
+// UnmuteAlertRule 取消静音
+func UnmuteAlertRule(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+	updateAlertFields(c, rule, bson.M{"muted": false})
+}
+
+// SnoozeAlertRule 在指定时间之前暂停通知，可传入 until 或 minutes
+func SnoozeAlertRule(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Until   *time.Time `json:"until"`
+		Minutes int        `json:"minutes"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "Invalid request data")
+		return
+	}
+	var until time.Time
+	switch {
+	case input.Until != nil:
+		until = *input.Until
+	case input.Minutes > 0:
+		until = time.Now().Add(time.Duration(input.Minutes) * time.Minute)
+	default:
+		utils.Error(c, 400, "until or minutes is required")
+		return
+	}
+	if !until.After(time.Now()) {
+		utils.Error(c, 400, "until must be in the future")
+		return
+	}
+
+	updateAlertFields(c, rule, bson.M{"snoozed_until": until})
+}
+
+// UnsnoozeAlertRule 恢复通知
+func UnsnoozeAlertRule(c *gin.Context) {
+	rule, ok := findAlertRule(c)
+	if !ok {
+		return
+	}
+
+	var updated models.AlertRule
+	err := db.GetCollection("alert_rules").FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": rule.ID},
+		bson.M{"$unset": bson.M{"snoozed_until": ""}, "$set": bson.M{"updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		utils.Error(c, 500, "Failed to update alert")
+		return
+	}
+	utils.Success(c, updated)
+}
@@ -3,11 +3,12 @@ package handlers
 import (
 	"bi-backend/db"     // 导入数据库操作相关的包
 	"bi-backend/models" // 导入数据模型相关的包
-	"bi-backend/utils"  // 导入工具函数相关的包
-	"context"           // 导入上下文操作相关的包
-	"log"               // 导入日志相关的包
-	"strings"           // 导入字符串操作相关的包
-	"time"              // 导入时间操作相关的包
+	"bi-backend/services"
+	"bi-backend/utils" // 导入工具函数相关的包
+	"context"          // 导入上下文操作相关的包
+	"log"              // 导入日志相关的包
+	"strings"          // 导入字符串操作相关的包
+	"time"             // 导入时间操作相关的包
 
 	"github.com/gin-gonic/gin"                   // 导入 Gin 框架相关的包
 	"go.mongodb.org/mongo-driver/bson"           // 导入 MongoDB BSON 相关的包
@@ -24,6 +25,11 @@ func CreateChart(c *gin.Context) {
 		return
 	}
 
+	// 检查图表数量配额
+	if quotaExceeded(c, services.CheckCountQuota(context.TODO(), c.MustGet("user_id").(primitive.ObjectID), services.QuotaCharts)) {
+		return
+	}
+
 	// 获取数据源集合
 	dsCollection := db.GetClient().Database("bi_platform").Collection("data_sources")
 	var dataSource models.DataSource // 定义一个 DataSource 结构体变量
@@ -38,6 +44,11 @@ func CreateChart(c *gin.Context) {
 		return
 	}
 
+	// 检查图表配置中的字段和聚合方式是否与数据源匹配
+	if invalidChartConfig(c, &dataSource, chart.Type, chart.Config) {
+		return
+	}
+
 	// 设置图表的创建者和创建、更新时间
 	chart.CreatedBy = c.MustGet("user_id").(primitive.ObjectID) // 从上下文中获取当前用户 ID，并设置为图表的创建者
 	chart.CreatedAt = time.Now()                                // 设置图表的创建时间为当前时间
@@ -94,6 +105,11 @@ func UpdateChartConfig(c *gin.Context) {
 		return
 	}
 
+	// 检查新配置中的字段和聚合方式是否与图表的数据源匹配
+	if !checkChartConfig(c, "", config) {
+		return
+	}
+
 	// 获取图表集合
 	collection := db.GetClient().Database("bi_platform").Collection("charts")
 	// 更新图表配置
@@ -237,6 +253,11 @@ func UpdateChart(c *gin.Context) {
 		return
 	}
 
+	// 检查新配置中的字段和聚合方式是否与图表的数据源匹配
+	if !checkChartConfig(c, updateData.Type, updateData.Config) {
+		return
+	}
+
 	// 获取图表集合
 	collection := db.GetClient().Database("bi_platform").Collection("charts")
 	// 更新图表
@@ -318,5 +339,10 @@ func DeleteChart(c *gin.Context) {
 		log.Printf("Error updating dashboards after chart deletion: %v", err)
 	}
 
+	// 删除图表上的告警规则
+	if err := services.DeleteChartAlerts(context.TODO(), []primitive.ObjectID{id}); err != nil {
+		log.Printf("Error deleting alerts after chart deletion: %v", err)
+	}
+
 	utils.Success(c, gin.H{"message": "Chart deleted successfully"}) // 返回成功响应，并提示 "Chart deleted successfully"
 }
@@ -0,0 +1,223 @@
+// handlers/chart_query.go
+package handlers
+
+import (
+	"bi-backend/charttype"
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/query"
+	"bi-backend/render"
+	"bi-backend/services"
+	"bi-backend/utils"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// queryFailed 查询失败时写入错误响应，配置错误返回 400，超时返回 504
+func queryFailed(c *gin.Context, err error) {
+	var queryErr *query.Error
+	switch {
+	case errors.As(err, &queryErr):
+		utils.Error(c, 400, queryErr.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		utils.Error(c, 504, "Query timed out")
+	case errors.Is(err, services.ErrDataSourceNotFound):
+		utils.Error(c, 404, "Data source not found")
+	default:
+		log.Printf("Chart query failed: %v", err)
+		utils.Error(c, 500, "Failed to run query")
+	}
+}
+
+// findChart 获取当前用户的图表
+func findChart(c *gin.Context) (*models.Chart, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "Invalid chart ID")
+		return nil, false
+	}
+
+	collection := db.GetClient().Database("bi_platform").Collection("charts")
+	var chart models.Chart
+	err = collection.FindOne(context.TODO(), bson.M{
+		"_id":        id,
+		"created_by": c.MustGet("user_id").(primitive.ObjectID),
+	}).Decode(&chart)
+	if err != nil {
+		utils.Error(c, 404, "Chart not found")
+		return nil, false
+	}
+	return &chart, true
+}
+
+// invalidChartConfig 图表配置不符合图表类型的要求或与数据源不匹配时返回 400 和字段级错误，
+// 返回 true 表示请求已结束
+func invalidChartConfig(c *gin.Context, ds *models.DataSource, chartType string, cfg models.ChartConfig) bool {
+	errs := append(charttype.Validate(chartType, cfg), services.ValidateChartConfig(ds, chartType, cfg)...)
+	if len(errs) == 0 {
+		return false
+	}
+	utils.ErrorWithData(c, 400, "Invalid chart config", gin.H{"errors": errs})
+	return true
+}
+
+// checkChartConfig 按已保存图表的数据源检查新的配置，chartType 为空时使用图表原来的类型，
+// 返回 false 表示已写入错误响应
+func checkChartConfig(c *gin.Context, chartType string, cfg models.ChartConfig) bool {
+	chart, ok := findChart(c)
+	if !ok {
+		return false
+	}
+	ds, err := services.LoadDataSourceSchema(context.TODO(), chart.DataSourceID, chart.CreatedBy)
+	if err != nil {
+		queryFailed(c, err)
+		return false
+	}
+	if chartType == "" {
+		chartType = chart.Type
+	}
+	return !invalidChartConfig(c, ds, chartType, cfg)
+}
+
+// GetChartTypes 返回图表类型注册表，包括每种类型需要的维度、指标数量和配置的 JSON Schema
+func GetChartTypes(c *gin.Context) {
+	utils.Success(c, charttype.All())
+}
+
+// GetBrokenCharts 列出配置与数据源不再匹配的图表，例如数据源变化后字段被删除或类型改变
+func GetBrokenCharts(c *gin.Context) {
+	broken, err := services.FindBrokenCharts(context.TODO(), c.MustGet("user_id").(primitive.ObjectID), nil)
+	if err != nil {
+		log.Printf("Failed to check charts: %v", err)
+		utils.Error(c, 500, "Failed to check charts")
+		return
+	}
+	utils.Success(c, gin.H{"charts": broken, "total": len(broken)})
+}
+
+// GetChartData 在服务端执行图表查询，返回过滤、聚合、排序后的结果，pivot 类型返回交叉表，
+// 图表配置中引用的参数通过 URL 传入（?p.<name>=value）
+func GetChartData(c *gin.Context) {
+	chart, ok := findChart(c)
+	if !ok {
+		return
+	}
+
+	ds, err := services.LoadDataSource(context.TODO(), chart.DataSourceID, chart.CreatedBy)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	opts := query.Options{Parameters: services.URLParameters(c.Request.URL.Query())}
+	result, err := services.RunChart(c.Request.Context(), ds, chart.Type, chart.Config, opts)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	utils.Success(c, result)
+}
+
+// RenderChart 在服务端将图表渲染为图片，?format=png|svg&width=&height=，参数同样通过 p.<name> 传入
+func RenderChart(c *gin.Context) {
+	chart, ok := findChart(c)
+	if !ok {
+		return
+	}
+
+	opts := render.Options{Format: c.DefaultQuery("format", render.FormatPNG)}
+	for name, dst := range map[string]*int{"width": &opts.Width, "height": &opts.Height} {
+		if v := c.Query(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				utils.Error(c, 400, "Invalid "+name)
+				return
+			}
+			*dst = n
+		}
+	}
+
+	image, err := services.RenderChart(c.Request.Context(), chart, opts, services.URLParameters(c.Request.URL.Query()))
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+	c.Data(200, render.ContentType(opts.Format), image)
+}
+
+// DrillChart 按下钻路径查询图表下一层级的聚合结果，路径为上层各级选中的取值，参数通过 URL 传入（?p.<name>=value）
+func DrillChart(c *gin.Context) {
+	chart, ok := findChart(c)
+	if !ok {
+		return
+	}
+	if chart.Type == services.ChartTypePivot {
+		utils.Error(c, 400, "Drill-down is not supported for pivot charts")
+		return
+	}
+
+	var input struct {
+		Path []interface{} `json:"path"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.Error(c, 400, "Invalid request data")
+			return
+		}
+	}
+
+	cfg, level, err := query.Drill(chart.Config, input.Path)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	ds, err := services.LoadDataSource(context.TODO(), chart.DataSourceID, chart.CreatedBy)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	opts := query.Options{Parameters: services.URLParameters(c.Request.URL.Query())}
+	result, err := services.RunChartQuery(c.Request.Context(), ds, cfg, opts)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{"drill": level, "data": result})
+}
+
+// QueryChart 按未保存的图表配置预览查询结果
+func QueryChart(c *gin.Context) {
+	var input struct {
+		DataSourceID primitive.ObjectID `json:"data_source_id" binding:"required"`
+		Type         string             `json:"type"`
+		Config       models.ChartConfig `json:"config"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "Invalid request data")
+		return
+	}
+
+	ds, err := services.LoadDataSource(context.TODO(), input.DataSourceID, c.MustGet("user_id").(primitive.ObjectID))
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	result, err := services.RunChart(c.Request.Context(), ds, input.Type, input.Config, query.Options{})
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	utils.Success(c, result)
+}
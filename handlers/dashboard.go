@@ -4,6 +4,7 @@ package handlers
 import (
 	"bi-backend/db"
 	"bi-backend/models"
+	"bi-backend/services"
 	"bi-backend/utils"
 	"context"
 	"log"
@@ -53,7 +54,19 @@ func CreateDashboard(c *gin.Context) {
 		return
 	}
 
+	if err := services.ValidateDashboardFilters(dashboard.Filters); err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+	if err := services.ValidateDashboardParameters(dashboard.Parameters); err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
 	dashboard.CreatedBy = c.MustGet("user_id").(primitive.ObjectID)
+	if quotaExceeded(c, services.CheckCountQuota(context.TODO(), dashboard.CreatedBy, services.QuotaDashboards)) {
+		return
+	}
 	dashboard.CreatedAt = time.Now()
 	dashboard.UpdatedAt = time.Now()
 	dashboard.EditCount = 1 // 创建时初始化编辑次数为1
@@ -167,6 +180,8 @@ func GetDashboard(c *gin.Context) {
 			"name":        dashboard.Name,
 			"description": dashboard.Description,
 			"layout":      fullLayout,
+			"filters":     dashboard.Filters,
+			"parameters":  dashboard.Parameters,
 			"created_by":  dashboard.CreatedBy,
 			"created_at":  dashboard.CreatedAt,
 			"updated_at":  dashboard.UpdatedAt,
@@ -190,9 +205,11 @@ func UpdateDashboard(c *gin.Context) {
 	}
 
 	var updateData struct {
-		Name        string               `json:"name"`
-		Description string               `json:"description"`
-		Layout      []models.ChartLayout `json:"layout"`
+		Name        string                       `json:"name"`
+		Description string                       `json:"description"`
+		Layout      []models.ChartLayout         `json:"layout"`
+		Filters     *[]models.DashboardFilter    `json:"filters"`    // 未传时保留原有筛选器
+		Parameters  *[]models.DashboardParameter `json:"parameters"` // 未传时保留原有参数
 	}
 
 	if err := c.ShouldBindJSON(&updateData); err != nil {
@@ -201,6 +218,26 @@ func UpdateDashboard(c *gin.Context) {
 		utils.Error(c, 400, "Invalid request data")
 		return
 	}
+	update := bson.M{
+		"name":        updateData.Name,
+		"description": updateData.Description,
+		"layout":      updateData.Layout,
+		"updated_at":  time.Now(),
+	}
+	if updateData.Filters != nil {
+		if err := services.ValidateDashboardFilters(*updateData.Filters); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		update["filters"] = *updateData.Filters
+	}
+	if updateData.Parameters != nil {
+		if err := services.ValidateDashboardParameters(*updateData.Parameters); err != nil {
+			utils.Error(c, 400, err.Error())
+			return
+		}
+		update["parameters"] = *updateData.Parameters
+	}
 
 	// 打印接收到的数据
 	log.Printf("Received update data: %+v", updateData)
@@ -213,14 +250,7 @@ func UpdateDashboard(c *gin.Context) {
 			"_id":        id,
 			"created_by": c.MustGet("user_id").(primitive.ObjectID),
 		},
-		bson.M{
-			"$set": bson.M{
-				"name":        updateData.Name,
-				"description": updateData.Description,
-				"layout":      updateData.Layout,
-				"updated_at":  time.Now(),
-			},
-		},
+		bson.M{"$set": update},
 	)
 
 	if err != nil {
@@ -277,6 +307,10 @@ func DeleteDashboard(c *gin.Context) {
 			utils.Error(c, 500, "Failed to delete charts")
 			return
 		}
+		if err := services.DeleteChartAlerts(context.TODO(), chartIDs); err != nil {
+			utils.Error(c, 500, "Failed to delete chart alerts")
+			return
+		}
 	}
 
 	// 3. 删除仪表盘
@@ -295,5 +329,17 @@ func DeleteDashboard(c *gin.Context) {
 		return
 	}
 
+	// 4. 删除仪表盘的报表订阅和分享链接
+	_, err = db.GetCollection("report_subscriptions").DeleteMany(context.TODO(), bson.M{"dashboard_id": id})
+	if err != nil {
+		utils.Error(c, 500, "Failed to delete report subscriptions")
+		return
+	}
+	_, err = db.GetCollection("dashboard_shares").DeleteMany(context.TODO(), bson.M{"dashboard_id": id})
+	if err != nil {
+		utils.Error(c, 500, "Failed to delete share links")
+		return
+	}
+
 	utils.Success(c, gin.H{"message": "Dashboard and related charts deleted successfully"})
 }
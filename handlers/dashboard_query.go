@@ -0,0 +1,135 @@
+// handlers/dashboard_query.go
+package handlers
+
+import (
+	"bi-backend/config"
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/services"
+	"bi-backend/storage"
+	"bi-backend/utils"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// findDashboard 获取当前用户的仪表盘
+func findDashboard(c *gin.Context) (*models.Dashboard, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "Invalid dashboard ID")
+		return nil, false
+	}
+
+	collection := db.GetClient().Database("bi_platform").Collection("dashboards")
+	var dashboard models.Dashboard
+	err = collection.FindOne(context.TODO(), bson.M{
+		"_id":        id,
+		"created_by": c.MustGet("user_id").(primitive.ObjectID),
+	}).Decode(&dashboard)
+	if err != nil {
+		utils.Error(c, 404, "Dashboard not found")
+		return nil, false
+	}
+	return &dashboard, true
+}
+
+// bindDashboardQuery 读取可选的查询请求体，并合并 URL 中的参数（?p.<name>=value），请求体中的同名参数优先
+func bindDashboardQuery(c *gin.Context) (services.DashboardQuery, bool) {
+	var input services.DashboardQuery
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.Error(c, 400, "Invalid request data")
+			return input, false
+		}
+	}
+
+	params := services.URLParameters(c.Request.URL.Query())
+	for name, v := range input.Parameters {
+		params[name] = v
+	}
+	input.Parameters = params
+	return input, true
+}
+
+// QueryDashboard 按全局筛选、交叉筛选和仪表盘参数查询仪表盘上的图表，
+// 参数也可以通过 URL 传入（?p.<name>=value），请求体中的同名参数优先
+func QueryDashboard(c *gin.Context) {
+	dashboard, ok := findDashboard(c)
+	if !ok {
+		return
+	}
+	input, ok := bindDashboardQuery(c)
+	if !ok {
+		return
+	}
+
+	results, err := services.RunDashboard(c.Request.Context(), dashboard, input)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{"charts": results})
+}
+
+// ExportDashboardPDF 按布局导出仪表盘 PDF，筛选和参数与 QueryDashboard 相同；
+// 默认直接下载，?store=true 时保存到文件存储并返回临时下载链接
+func ExportDashboardPDF(c *gin.Context) {
+	dashboard, ok := findDashboard(c)
+	if !ok {
+		return
+	}
+	input, ok := bindDashboardQuery(c)
+	if !ok {
+		return
+	}
+
+	pdf, err := services.ExportDashboardPDF(c.Request.Context(), dashboard, input)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	filename := fmt.Sprintf("dashboard_%s_%s.pdf", dashboard.ID.Hex(), time.Now().Format("20060102150405"))
+	if c.Query("store") != "true" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Data(200, "application/pdf", pdf)
+		return
+	}
+
+	if storage.Default() == nil {
+		utils.Error(c, 503, "File storage is not configured")
+		return
+	}
+	// 下载链接过期后删除；服务重启导致未删除的文件由存储清理删除
+	objectKey := services.ExportPrefix + filename
+	if err := storage.Default().Put(c.Request.Context(), objectKey, bytes.NewReader(pdf), int64(len(pdf)), "application/pdf"); err != nil {
+		log.Printf("Failed to store dashboard export %s: %v", objectKey, err)
+		utils.Error(c, 500, "Failed to store PDF")
+		return
+	}
+	ttl := config.GlobalConfig.Storage.PresignTTL
+	time.AfterFunc(ttl, func() {
+		if err := storage.DeleteObject(context.Background(), objectKey); err != nil {
+			log.Printf("Failed to delete expired dashboard export %s: %v", objectKey, err)
+		}
+	})
+	signedURL, err := storage.Default().PresignGet(c.Request.Context(), objectKey, ttl)
+	if err != nil {
+		log.Printf("Failed to presign dashboard export %s: %v", objectKey, err)
+		utils.Error(c, 500, "Failed to create download link")
+		return
+	}
+	utils.Success(c, gin.H{
+		"key":        objectKey,
+		"url":        signedURL,
+		"expires_at": time.Now().Add(ttl),
+	})
+}
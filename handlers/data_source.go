@@ -2,24 +2,25 @@
 package handlers
 
 import (
+	"bi-backend/config"
 	"bi-backend/db"
+	"bi-backend/events"
 	"bi-backend/models"
+	"bi-backend/services"
 	"bi-backend/storage"
 	"bi-backend/utils"
 	"context"
-	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
+	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
-	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/gin-gonic/gin"
 	"github.com/xuri/excelize/v2"
 	"go.mongodb.org/mongo-driver/bson"
@@ -27,12 +28,6 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// CloudStorage 结构体定义
-type CloudStorage struct {
-	client *oss.Client
-	bucket *oss.Bucket
-}
-
 // ParseExcelFile 解析Excel文件
 func ParseExcelFile(file multipart.File) ([][]string, []string, error) {
 	xlsx, err := excelize.OpenReader(file)
@@ -96,159 +91,46 @@ func ParseJSONFile(file multipart.File) ([][]string, []string, error) {
 	return content, headers, nil
 }
 
-// 全局变量
-var cloudStorage *CloudStorage
-
-// InitCloudStorage 初始化云存储
-func InitCloudStorage() error {
-	required := []string{"OSS_ACCESS_KEY_ID", "OSS_ACCESS_KEY_SECRET", "OSS_ENDPOINT", "OSS_BUCKET"}
-	for _, env := range required {
-		if os.Getenv(env) == "" {
-			return fmt.Errorf("missing required environment variable: %s", env)
-		}
-	}
-
-	log.Printf("Initializing OSS client with endpoint: %s, bucket: %s",
-		os.Getenv("OSS_ENDPOINT"),
-		os.Getenv("OSS_BUCKET"))
-
-	client, err := oss.New(
-		os.Getenv("OSS_ENDPOINT"),
-		os.Getenv("OSS_ACCESS_KEY_ID"),
-		os.Getenv("OSS_ACCESS_KEY_SECRET"),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create OSS client: %v", err)
-	}
-
-	bucket, err := client.Bucket(os.Getenv("OSS_BUCKET"))
-	if err != nil {
-		return fmt.Errorf("failed to get bucket: %v", err)
-	}
-
-	cloudStorage = &CloudStorage{
-		client: client,
-		bucket: bucket,
-	}
-
-	log.Println("Cloud storage initialized successfully")
-	return nil
-}
-
-// 解析 Excel 文件
-func parseExcel(file io.Reader) ([][]string, []string, error) {
-	f, err := excelize.OpenReader(file)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open excel file: %v", err)
-	}
-	defer f.Close()
-
-	// 获取第一个 Sheet
-	firstSheet := f.GetSheetList()[0]
-
-	// 读取所有行
-	rows, err := f.GetRows(firstSheet)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read excel rows: %v", err)
-	}
-
-	if len(rows) == 0 {
-		return nil, nil, fmt.Errorf("empty excel file")
-	}
-
-	// 第一行作为表头
-	headers := rows[0]
-	// 其余行作为数据
-	data := rows[1:]
-
-	return data, headers, nil
-}
-
-// 解析 CSV 文件
-func parseCSV(file io.Reader) ([][]string, []string, error) {
-	reader := csv.NewReader(file)
+// UploadDataSource 校验上传文件并创建导入任务，立即返回任务ID，解析进度通过 /api/jobs/:id 查询
+func UploadDataSource(c *gin.Context) {
+	log.Println("Starting file upload...")
 
-	// 读取所有记录
-	records, err := reader.ReadAll()
+	userID := c.MustGet("user_id").(primitive.ObjectID)
+	quota, err := services.GetQuota(context.TODO(), userID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read CSV: %v", err)
-	}
-
-	if len(records) == 0 {
-		return nil, nil, fmt.Errorf("empty CSV file")
-	}
-
-	// 第一行作为表头
-	headers := records[0]
-	// 其余行作为数据
-	data := records[1:]
-
-	return data, headers, nil
-}
-
-// 解析 JSON 文件
-func parseJSON(file io.Reader) ([][]string, []string, error) {
-	var jsonData []map[string]interface{}
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&jsonData); err != nil {
-		return nil, nil, fmt.Errorf("failed to decode JSON: %v", err)
-	}
-
-	if len(jsonData) == 0 {
-		return nil, nil, fmt.Errorf("empty JSON file")
-	}
-
-	// 从第一条记录获取表头
-	headers := make([]string, 0)
-	for key := range jsonData[0] {
-		headers = append(headers, key)
+		utils.Error(c, 500, "获取配额失败")
+		return
 	}
 
-	// 转换数据
-	var data [][]string
-	for _, item := range jsonData {
-		row := make([]string, len(headers))
-		for i, header := range headers {
-			if val, ok := item[header]; ok {
-				row[i] = fmt.Sprint(val)
-			}
-		}
-		data = append(data, row)
+	// 限制请求体大小，超出限制时读取表单会直接失败
+	maxBody := utils.MaxUploadSizeAll()
+	if quota.MaxUploadBytes > 0 && quota.MaxUploadBytes < maxBody {
+		maxBody = quota.MaxUploadBytes
 	}
-
-	return data, headers, nil
-}
-
-func UploadDataSource(c *gin.Context) {
-	log.Println("Starting file upload...")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody+(1<<20))
 
 	// 1. 获取文件
 	file, err := c.FormFile("file")
 	if err != nil {
 		log.Printf("Error getting form file: %v", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.Error(c, 413, "文件大小超过限制")
+			return
+		}
 		utils.Error(c, 400, "No file uploaded")
 		return
 	}
 
 	// 2. 获取文件类型
 	fileType := c.PostForm("type")
-	log.Printf("File type: %s, filename: %s", fileType, file.Filename)
-
-	// 3. 生成带时间戳的唯一文件名
-	timestamp := time.Now().Format("20060102150405")
-	fileName := fmt.Sprintf("%s_%s", timestamp, file.Filename)
+	log.Printf("File type: %s, filename: %s, size: %d", fileType, file.Filename, file.Size)
 
-	// 4. 上传到 OSS
-	cloudURL, err := storage.UploadFile(file)
-	if err != nil {
-		log.Printf("Error uploading to OSS: %v", err)
-		utils.Error(c, 500, "Failed to upload file")
+	// 3. 检查存储空间、数据源数量和单次上传大小配额
+	if quotaExceeded(c, services.CheckUploadQuota(context.TODO(), userID, file.Size)) {
 		return
 	}
 
-	log.Printf("File uploaded successfully. URL: %s", cloudURL)
-
-	// 5. 解析文件内容
 	src, err := file.Open()
 	if err != nil {
 		log.Printf("Error opening file for parsing: %v", err)
@@ -257,58 +139,48 @@ func UploadDataSource(c *gin.Context) {
 	}
 	defer src.Close()
 
-	var data [][]string
-	var headers []string
-
-	switch fileType {
-	case "excel":
-		data, headers, err = parseExcel(src)
-	case "csv":
-		data, headers, err = parseCSV(src)
-	case "json":
-		data, headers, err = parseJSON(src)
-	default:
-		utils.Error(c, 400, "Unsupported file type")
+	// 4. 校验大小和文件内容，不信任客户端声明的类型
+	if err := utils.ValidateUpload(src, file.Size, fileType); err != nil {
+		log.Printf("Rejected upload %s: %v", file.Filename, err)
+		var uploadErr *utils.UploadError
+		if errors.As(err, &uploadErr) {
+			utils.Error(c, uploadErr.Status, uploadErr.Msg)
+			return
+		}
+		utils.Error(c, 500, "Failed to process file")
 		return
 	}
 
+	// 5. 写入临时文件，解析成功后才会写入存储
+	tempPath, err := services.SaveIngestTempFile(io.NewSectionReader(src, 0, file.Size))
 	if err != nil {
-		log.Printf("Error parsing file: %v", err)
-		utils.Error(c, 400, "Failed to parse file")
-		return
-	}
-
-	// 6. 创建数据源记录
-	userID, exists := c.Get("user_id")
-	if !exists {
-		utils.Error(c, 401, "Unauthorized")
+		log.Printf("Error saving temp file: %v", err)
+		utils.Error(c, 500, "Failed to process file")
 		return
 	}
 
-	dataSource := models.DataSource{
-		Name:      fileName, // 使用带时间戳的文件名
-		Type:      fileType,
-		FileURL:   cloudURL,
-		Content:   data,
-		Headers:   headers,
-		CreatedBy: userID.(primitive.ObjectID),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	// 6. 创建导入任务，由后台解析文件并创建数据源
+	job := models.IngestJob{
+		FileName:  filepath.Base(file.Filename),
+		FileType:  fileType,
+		FileSize:  file.Size,
+		TempPath:  tempPath,
+		CreatedBy: userID,
 	}
-
-	// 7. 保存到数据库
-	collection := db.GetClient().Database("bi_platform").Collection("data_sources")
-	result, err := collection.InsertOne(context.TODO(), dataSource)
-	if err != nil {
-		log.Printf("Error saving to database: %v", err)
-		utils.Error(c, 500, "Failed to save data source")
+	if err := services.EnqueueIngestJob(context.TODO(), &job); err != nil {
+		log.Printf("Error enqueueing ingest job: %v", err)
+		os.Remove(tempPath)
+		if errors.Is(err, services.ErrIngestQueueFull) {
+			utils.Error(c, 503, "导入任务过多，请稍后重试")
+			return
+		}
+		utils.Error(c, 500, "Failed to create ingest job")
 		return
 	}
 
-	dataSource.ID = result.InsertedID.(primitive.ObjectID)
-	log.Printf("Data source created successfully: %v", dataSource.ID)
+	log.Printf("Ingest job created: %s", job.ID.Hex())
 
-	utils.Success(c, dataSource)
+	utils.Success(c, job)
 }
 
 // 创建数据源
@@ -326,7 +198,7 @@ func CreateDataSource(c *gin.Context) {
 	// 根据文件类型选择解析方法
 	switch filepath.Ext(header.Filename) {
 	case ".csv":
-		content, headers, err = parseCSV(file)
+		content, headers, err = services.ParseFile(file, "csv")
 	case ".xlsx", ".xls":
 		content, headers, err = utils.ParseExcelFile(file)
 	case ".json":
@@ -346,6 +218,7 @@ func CreateDataSource(c *gin.Context) {
 		Type:      filepath.Ext(header.Filename)[1:],
 		Content:   content,
 		Headers:   headers,
+		Schema:    utils.InferSchema(headers, content),
 		CreatedBy: c.MustGet("user_id").(primitive.ObjectID),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -406,6 +279,57 @@ func GetDataSource(c *gin.Context) {
 	utils.Success(c, dataSource)
 }
 
+// GetDataSourceFile 下载数据源的原始文件，校验归属后跳转到短期有效的预签名地址
+// redirect=false 时以 JSON 返回地址，便于前端在携带 Authorization 头的请求中获取
+func GetDataSourceFile(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "无效的数据源ID")
+		return
+	}
+
+	collection := db.GetClient().Database("bi_platform").Collection("data_sources")
+	var dataSource models.DataSource
+	err = collection.FindOne(context.TODO(), bson.M{
+		"_id":        id,
+		"created_by": c.MustGet("user_id").(primitive.ObjectID),
+	}).Decode(&dataSource)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			utils.Error(c, 404, "数据源不存在")
+			return
+		}
+		utils.Error(c, 500, "获取数据源信息失败")
+		return
+	}
+
+	objectKey := dataSource.ObjectKey
+	if objectKey == "" {
+		objectKey = storage.KeyFromURL(dataSource.FileURL)
+	}
+	if objectKey == "" || storage.Default() == nil {
+		utils.Error(c, 404, "原始文件不存在")
+		return
+	}
+
+	ttl := config.GlobalConfig.Storage.PresignTTL
+	signedURL, err := storage.Default().PresignGet(c.Request.Context(), objectKey, ttl)
+	if err != nil {
+		log.Printf("Failed to presign file %s: %v", objectKey, err)
+		utils.Error(c, 500, "生成下载链接失败")
+		return
+	}
+
+	if c.Query("redirect") == "false" {
+		utils.Success(c, gin.H{
+			"url":        signedURL,
+			"expires_at": time.Now().Add(ttl),
+		})
+		return
+	}
+	c.Redirect(302, signedURL)
+}
+
 // 更新数据源
 func UpdateDataSource(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
@@ -447,11 +371,12 @@ func UpdateDataSource(c *gin.Context) {
 		utils.Error(c, 404, "数据源不存在")
 		return
 	}
+	publishDataSourceEvent(c, events.DataSourceUpdated, id)
 
 	utils.Success(c, gin.H{"message": "更新成功"})
 }
 
-// DeleteDataSource 删除数据源及其关联的图表、机器学习模型和 OSS 文件
+// DeleteDataSource 删除数据源及其关联的图表、机器学习模型和存储文件
 func DeleteDataSource(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
@@ -476,21 +401,20 @@ func DeleteDataSource(c *gin.Context) {
 		return
 	}
 
-	// 1. 删除 OSS 中的文件
-	if dataSource.FileURL != "" {
-		// 从 FileURL 中提取文件名
-		// 假设 FileURL 格式为 "https://bucket-name.endpoint/object-key"
-		urlParts := strings.Split(dataSource.FileURL, "/")
-		objectKey := urlParts[len(urlParts)-1]
-
-		if cloudStorage != nil && cloudStorage.bucket != nil {
-			err := cloudStorage.bucket.DeleteObject(objectKey)
-			if err != nil {
-				// 记录错误但继续执行
-				log.Printf("Failed to delete file from OSS: %v", err)
-			} else {
-				log.Printf("Successfully deleted file from OSS: %s", objectKey)
-			}
+	// 1. 删除存储中的文件，历史数据没有 object_key 时从 FileURL 还原
+	objectKey := dataSource.ObjectKey
+	if objectKey == "" {
+		objectKey = storage.KeyFromURL(dataSource.FileURL)
+	}
+	fileStatus := "无关联文件"
+	if objectKey != "" {
+		if err := storage.DeleteObject(context.TODO(), objectKey); err != nil {
+			// 记录错误但继续执行，残留文件由定期清理任务处理
+			log.Printf("Failed to delete file from storage: %v", err)
+			fileStatus = "存储文件删除失败，将由清理任务处理"
+		} else {
+			log.Printf("Successfully deleted file from storage: %s", objectKey)
+			fileStatus = "存储文件已清理"
 		}
 	}
 
@@ -506,6 +430,9 @@ func DeleteDataSource(c *gin.Context) {
 		} else {
 			chartsDeleted = result.DeletedCount
 		}
+		if err := services.DeleteChartAlerts(context.TODO(), dataSource.LinkedCharts); err != nil {
+			log.Printf("Failed to delete alerts of linked charts: %v", err)
+		}
 
 		// 更新包含这些图表的仪表盘
 		dashboardCollection := db.GetClient().Database("bi_platform").Collection("dashboards")
@@ -552,13 +479,14 @@ func DeleteDataSource(c *gin.Context) {
 		utils.Error(c, 404, "数据源不存在")
 		return
 	}
+	publishDataSourceEvent(c, events.DataSourceDeleted, id)
 
 	// 返回详细的删除结果
 	utils.Success(c, gin.H{
 		"message": "删除成功",
 		"details": fmt.Sprintf(
-			"已删除数据源及其关联的 %d 个图表和 %d 个机器学习模型，OSS 文件已清理",
-			chartsDeleted, modelsDeleted),
+			"已删除数据源及其关联的 %d 个图表和 %d 个机器学习模型，%s",
+			chartsDeleted, modelsDeleted, fileStatus),
 	})
 }
 
@@ -591,6 +519,8 @@ func UpdatePreprocessing(c *gin.Context) {
 				"preprocessing": input.Preprocessing,
 				"updated_at":    time.Now(),
 			},
+			// 预处理改变了查询结果，递增版本使缓存失效
+			"$inc": bson.M{"version": 1},
 		},
 	)
 
@@ -603,5 +533,15 @@ func UpdatePreprocessing(c *gin.Context) {
 		utils.Error(c, 404, "数据源不存在")
 		return
 	}
+	publishDataSourceEvent(c, events.DataSourceUpdated, id)
 	utils.Success(c, gin.H{"message": "更新成功"})
 }
+
+// publishDataSourceEvent 通知数据源已变化，用于清除查询缓存等
+func publishDataSourceEvent(c *gin.Context, eventType string, id primitive.ObjectID) {
+	events.Publish(events.Event{
+		Type:    eventType,
+		UserID:  c.MustGet("user_id").(primitive.ObjectID),
+		Payload: map[string]interface{}{"data_source_id": id.Hex()},
+	})
+}
@@ -0,0 +1,227 @@
+// handlers/embed.go
+package handlers
+
+import (
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/services"
+	"bi-backend/utils"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateEmbedToken 为仪表盘签发嵌入令牌，便于在不持有嵌入密钥时调试嵌入页面；
+// 业务系统也可以直接使用嵌入密钥签发相同格式的令牌
+func CreateEmbedToken(c *gin.Context) {
+	dashboard, ok := findDashboard(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ChartID          *primitive.ObjectID    `json:"chart_id"`
+		Filters          []models.EmbedFilter   `json:"filters"`
+		Parameters       map[string]interface{} `json:"parameters"`
+		ExpiresInSeconds int                    `json:"expires_in_seconds"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.Error(c, 400, "Invalid request data")
+			return
+		}
+	}
+
+	claims := &models.EmbedClaims{
+		DashboardID: dashboard.ID.Hex(),
+		Filters:     input.Filters,
+		Parameters:  input.Parameters,
+	}
+	if input.ChartID != nil {
+		if !onDashboard(dashboard, *input.ChartID) {
+			utils.Error(c, 400, "Chart is not on this dashboard")
+			return
+		}
+		claims.ChartID = input.ChartID.Hex()
+	}
+	known := map[string]bool{}
+	for _, f := range dashboard.Filters {
+		known[f.ID] = true
+	}
+	for _, f := range input.Filters {
+		if !known[f.FilterID] {
+			utils.Error(c, 400, "Unknown dashboard filter: "+f.FilterID)
+			return
+		}
+	}
+	if _, err := services.ResolveParameters(dashboard.Parameters, input.Parameters); err != nil {
+		utils.Error(c, 400, err.Error())
+		return
+	}
+
+	token, err := utils.GenerateEmbedToken(claims, time.Duration(input.ExpiresInSeconds)*time.Second)
+	if err != nil {
+		if errors.Is(err, utils.ErrEmbedDisabled) {
+			utils.Error(c, 503, "Embedding is not configured")
+			return
+		}
+		utils.Error(c, 500, "Failed to generate embed token")
+		return
+	}
+
+	utils.Success(c, gin.H{"token": token, "expires_at": claims.ExpiresAt.Time})
+}
+
+// onDashboard 图表是否在仪表盘布局中
+func onDashboard(dashboard *models.Dashboard, chartID primitive.ObjectID) bool {
+	for _, item := range dashboard.Layout {
+		if item.ChartID == chartID {
+			return true
+		}
+	}
+	return false
+}
+
+// findEmbeddedDashboard 校验 X-Embed-Token 请求头或 token 参数中的嵌入令牌，返回令牌指定的仪表盘
+func findEmbeddedDashboard(c *gin.Context) (*models.EmbedClaims, *models.Dashboard, bool) {
+	token := c.GetHeader("X-Embed-Token")
+	if token == "" {
+		token = c.Query("token")
+	}
+	claims, err := utils.ParseEmbedToken(token)
+	if err != nil {
+		if errors.Is(err, utils.ErrEmbedDisabled) {
+			utils.Error(c, 503, "Embedding is not configured")
+			return nil, nil, false
+		}
+		utils.Error(c, 401, err.Error())
+		return nil, nil, false
+	}
+
+	id, err := primitive.ObjectIDFromHex(claims.DashboardID)
+	if err != nil {
+		utils.Error(c, 401, "Invalid dashboard ID in embed token")
+		return nil, nil, false
+	}
+	var dashboard models.Dashboard
+	if err := db.GetCollection("dashboards").FindOne(context.TODO(), bson.M{"_id": id}).Decode(&dashboard); err != nil {
+		utils.Error(c, 404, "Dashboard not found")
+		return nil, nil, false
+	}
+	if claims.ChartID != "" {
+		chartID, err := primitive.ObjectIDFromHex(claims.ChartID)
+		if err != nil || !onDashboard(&dashboard, chartID) {
+			utils.Error(c, 404, "Chart not found")
+			return nil, nil, false
+		}
+	}
+	return claims, &dashboard, true
+}
+
+// lockEmbedQuery 用令牌中锁定的筛选器取值和参数覆盖访客传入的同名取值，单图表嵌入时只查询该图表
+func lockEmbedQuery(claims *models.EmbedClaims, q *services.DashboardQuery) {
+	locked := map[string]bool{}
+	for _, f := range claims.Filters {
+		locked[f.FilterID] = true
+	}
+	filters := make([]services.DashboardFilterValue, 0, len(q.Filters)+len(claims.Filters))
+	for _, v := range q.Filters {
+		if !locked[v.FilterID] {
+			filters = append(filters, v)
+		}
+	}
+	for _, f := range claims.Filters {
+		filters = append(filters, services.DashboardFilterValue{FilterID: f.FilterID, Values: f.Values, Relative: f.Relative})
+	}
+	q.Filters = filters
+
+	if len(claims.Parameters) > 0 {
+		if q.Parameters == nil {
+			q.Parameters = map[string]interface{}{}
+		}
+		for name, v := range claims.Parameters {
+			q.Parameters[name] = v
+		}
+	}
+
+	if claims.ChartID != "" {
+		chartID, _ := primitive.ObjectIDFromHex(claims.ChartID)
+		q.ChartIDs = []primitive.ObjectID{chartID}
+	}
+}
+
+// GetEmbeddedDashboard 获取嵌入的仪表盘及图表配置，锁定的筛选器在 locked_filters 中列出，前端不应允许修改
+func GetEmbeddedDashboard(c *gin.Context) {
+	claims, dashboard, ok := findEmbeddedDashboard(c)
+	if !ok {
+		return
+	}
+
+	charts, err := services.LoadDashboardCharts(c.Request.Context(), dashboard)
+	if err != nil {
+		utils.Error(c, 500, "Failed to fetch charts")
+		return
+	}
+	embedded := make([]gin.H, 0, len(charts))
+	for _, chart := range charts {
+		if claims.ChartID != "" && chart.ID.Hex() != claims.ChartID {
+			continue
+		}
+		embedded = append(embedded, gin.H{
+			"id":     chart.ID,
+			"name":   chart.Name,
+			"type":   chart.Type,
+			"config": chart.Config,
+		})
+	}
+	lockedFilters := make([]string, 0, len(claims.Filters))
+	for _, f := range claims.Filters {
+		lockedFilters = append(lockedFilters, f.FilterID)
+	}
+	lockedParameters := make([]string, 0, len(claims.Parameters))
+	for name := range claims.Parameters {
+		lockedParameters = append(lockedParameters, name)
+	}
+
+	utils.Success(c, gin.H{
+		"dashboard": gin.H{
+			"id":          dashboard.ID,
+			"name":        dashboard.Name,
+			"description": dashboard.Description,
+			"layout":      dashboard.Layout,
+			"filters":     dashboard.Filters,
+			"parameters":  dashboard.Parameters,
+			"updated_at":  dashboard.UpdatedAt,
+		},
+		"charts":            embedded,
+		"chart_id":          claims.ChartID,
+		"locked_filters":    lockedFilters,
+		"locked_parameters": lockedParameters,
+		"expires_at":        claims.ExpiresAt.Time,
+	})
+}
+
+// QueryEmbeddedDashboard 查询嵌入的仪表盘上的图表数据，令牌中锁定的筛选器和参数优先于请求中的取值
+func QueryEmbeddedDashboard(c *gin.Context) {
+	claims, dashboard, ok := findEmbeddedDashboard(c)
+	if !ok {
+		return
+	}
+	input, ok := bindDashboardQuery(c)
+	if !ok {
+		return
+	}
+	lockEmbedQuery(claims, &input)
+
+	results, err := services.RunDashboard(c.Request.Context(), dashboard, input)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{"charts": results})
+}
@@ -0,0 +1,63 @@
+// handlers/job.go
+package handlers
+
+import (
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/utils"
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetJob 获取导入任务的阶段、进度和行数
+func GetJob(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "无效的任务ID")
+		return
+	}
+
+	collection := db.GetClient().Database("bi_platform").Collection("ingest_jobs")
+	var job models.IngestJob
+	err = collection.FindOne(context.TODO(), bson.M{
+		"_id":        id,
+		"created_by": c.MustGet("user_id").(primitive.ObjectID),
+	}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		utils.Error(c, 404, "任务不存在")
+		return
+	}
+	if err != nil {
+		utils.Error(c, 500, "获取任务失败")
+		return
+	}
+
+	utils.Success(c, job)
+}
+
+// GetJobs 获取当前用户最近的导入任务
+func GetJobs(c *gin.Context) {
+	collection := db.GetClient().Database("bi_platform").Collection("ingest_jobs")
+	cursor, err := collection.Find(context.TODO(),
+		bson.M{"created_by": c.MustGet("user_id").(primitive.ObjectID)},
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(50),
+	)
+	if err != nil {
+		utils.Error(c, 500, "获取任务失败")
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	jobs := []models.IngestJob{}
+	if err := cursor.All(context.TODO(), &jobs); err != nil {
+		utils.Error(c, 500, "解析数据失败")
+		return
+	}
+
+	utils.Success(c, jobs)
+}
@@ -4,6 +4,7 @@ package handlers
 import (
 	"bi-backend/db"
 	"bi-backend/models"
+	"bi-backend/services"
 	"bi-backend/utils"
 	"bytes"
 	"context"
@@ -45,6 +46,11 @@ func CreateMLModel(c *gin.Context) {
 		return
 	}
 
+	// 检查模型数量配额
+	if quotaExceeded(c, services.CheckCountQuota(context.TODO(), userID, services.QuotaMLModels)) {
+		return
+	}
+
 	// 设置创建信息
 	now := time.Now()
 	model.CreatedAt = now
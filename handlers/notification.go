@@ -0,0 +1,61 @@
+// handlers/notification.go
+package handlers
+
+import (
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/utils"
+	"context"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetNotifications 获取当前用户的通知，unread=true 时只返回未读通知
+func GetNotifications(c *gin.Context) {
+	filter := bson.M{"user_id": c.MustGet("user_id").(primitive.ObjectID)}
+	if unread, _ := strconv.ParseBool(c.Query("unread")); unread {
+		filter["read"] = false
+	}
+
+	collection := db.GetClient().Database("bi_platform").Collection("notifications")
+	cursor, err := collection.Find(context.TODO(), filter,
+		options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(100))
+	if err != nil {
+		utils.Error(c, 500, "获取通知失败")
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	notifications := []models.Notification{}
+	if err := cursor.All(context.TODO(), &notifications); err != nil {
+		utils.Error(c, 500, "解析数据失败")
+		return
+	}
+
+	utils.Success(c, notifications)
+}
+
+// MarkNotificationRead 将通知标记为已读，id 为 all 时标记全部
+func MarkNotificationRead(c *gin.Context) {
+	filter := bson.M{"user_id": c.MustGet("user_id").(primitive.ObjectID)}
+	if c.Param("id") != "all" {
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			utils.Error(c, 400, "无效的通知ID")
+			return
+		}
+		filter["_id"] = id
+	}
+
+	collection := db.GetClient().Database("bi_platform").Collection("notifications")
+	if _, err := collection.UpdateMany(context.TODO(), filter, bson.M{"$set": bson.M{"read": true}}); err != nil {
+		utils.Error(c, 500, "更新通知失败")
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "已标记为已读"})
+}
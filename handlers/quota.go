@@ -0,0 +1,130 @@
+// handlers/quota.go
+package handlers
+
+import (
+	"bi-backend/db"
+	"bi-backend/services"
+	"bi-backend/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// quotaExceeded 配额检查失败时写入错误响应，返回 true 表示请求已结束
+func quotaExceeded(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	var quotaErr *services.QuotaError
+	if errors.As(err, &quotaErr) {
+		utils.Error(c, quotaErr.Status, quotaErr.Error())
+		return true
+	}
+	log.Printf("Failed to check quota: %v", err)
+	utils.Error(c, 500, "检查配额失败")
+	return true
+}
+
+// quotaResponse 配额与当前用量
+func quotaResponse(c *gin.Context, userID primitive.ObjectID) {
+	quota, err := services.GetQuota(context.TODO(), userID)
+	if err != nil {
+		utils.Error(c, 500, "获取配额失败")
+		return
+	}
+	usage, err := services.GetQuotaUsage(context.TODO(), userID)
+	if err != nil {
+		utils.Error(c, 500, "获取用量失败")
+		return
+	}
+	utils.Success(c, gin.H{"quota": quota, "usage": usage})
+}
+
+// GetQuota 获取当前用户的配额和用量
+func GetQuota(c *gin.Context) {
+	quotaResponse(c, c.MustGet("user_id").(primitive.ObjectID))
+}
+
+// GetUserQuota 获取指定用户的配额和用量(管理员)
+func GetUserQuota(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "无效的用户ID")
+		return
+	}
+	quotaResponse(c, userID)
+}
+
+// UpdateUserQuota 调整指定用户的配额(管理员)，只修改请求中出现的字段，0 表示不限制
+func UpdateUserQuota(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "无效的用户ID")
+		return
+	}
+
+	quota, err := services.GetQuota(context.TODO(), userID)
+	if err != nil {
+		utils.Error(c, 500, "获取配额失败")
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil || json.Unmarshal(body, &quota) != nil {
+		utils.Error(c, 400, "无效的请求数据")
+		return
+	}
+	for _, v := range []int64{quota.MaxStorageBytes, quota.MaxUploadBytes, quota.MaxDataSources,
+		quota.MaxRowsPerDataSource, quota.MaxColumnsPerDataSource, quota.MaxDashboards, quota.MaxCharts, quota.MaxMLModels} {
+		if v < 0 {
+			utils.Error(c, 400, "配额不能为负数")
+			return
+		}
+	}
+
+	collection := db.GetClient().Database("bi_platform").Collection("users")
+	result, err := collection.UpdateOne(context.TODO(),
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"quota": quota}},
+	)
+	if err != nil {
+		utils.Error(c, 500, "更新配额失败")
+		return
+	}
+	if result.MatchedCount == 0 {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+
+	utils.Success(c, quota)
+}
+
+// ResetUserQuota 恢复指定用户的默认配额(管理员)
+func ResetUserQuota(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "无效的用户ID")
+		return
+	}
+
+	collection := db.GetClient().Database("bi_platform").Collection("users")
+	result, err := collection.UpdateOne(context.TODO(),
+		bson.M{"_id": userID},
+		bson.M{"$unset": bson.M{"quota": ""}},
+	)
+	if err != nil {
+		utils.Error(c, 500, "更新配额失败")
+		return
+	}
+	if result.MatchedCount == 0 {
+		utils.Error(c, 404, "用户不存在")
+		return
+	}
+
+	utils.Success(c, services.DefaultQuota())
+}
@@ -0,0 +1,283 @@
+// handlers/report.go
+package handlers
+
+import (
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/services"
+	"bi-backend/utils"
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reportSubscriptionInput 创建和更新报表订阅的请求体，更新时只修改传入的字段
+type reportSubscriptionInput struct {
+	DashboardID *primitive.ObjectID     `json:"dashboard_id"`
+	Name        *string                 `json:"name"`
+	Recipients  *[]string               `json:"recipients"`
+	Schedule    *string                 `json:"schedule"`
+	Hour        *int                    `json:"hour"`
+	Minute      *int                    `json:"minute"`
+	Weekday     *int                    `json:"weekday"`
+	Cron        *string                 `json:"cron"`
+	Timezone    *string                 `json:"timezone"`
+	Format      *string                 `json:"format"`
+	Parameters  *map[string]interface{} `json:"parameters"`
+	Enabled     *bool                   `json:"enabled"`
+}
+
+// apply 将传入的字段写入订阅
+func (in *reportSubscriptionInput) apply(s *models.ReportSubscription) {
+	if in.Name != nil {
+		s.Name = *in.Name
+	}
+	if in.Recipients != nil {
+		s.Recipients = *in.Recipients
+	}
+	if in.Schedule != nil {
+		s.Schedule = *in.Schedule
+	}
+	if in.Hour != nil {
+		s.Hour = *in.Hour
+	}
+	if in.Minute != nil {
+		s.Minute = *in.Minute
+	}
+	if in.Weekday != nil {
+		s.Weekday = *in.Weekday
+	}
+	if in.Cron != nil {
+		s.Cron = *in.Cron
+	}
+	if in.Timezone != nil {
+		s.Timezone = *in.Timezone
+	}
+	if in.Format != nil {
+		s.Format = *in.Format
+	}
+	if in.Parameters != nil {
+		s.Parameters = *in.Parameters
+	}
+	if in.Enabled != nil {
+		s.Enabled = *in.Enabled
+	}
+}
+
+// validateReportSubscription 检查订阅配置以及订阅参数是否与仪表盘的参数定义匹配
+func validateReportSubscription(c *gin.Context, s *models.ReportSubscription) bool {
+	var dashboard models.Dashboard
+	err := db.GetCollection("dashboards").FindOne(context.TODO(), bson.M{
+		"_id":        s.DashboardID,
+		"created_by": s.CreatedBy,
+	}).Decode(&dashboard)
+	if err != nil {
+		utils.Error(c, 404, "Dashboard not found")
+		return false
+	}
+	if err := services.ValidateReportSubscription(s); err != nil {
+		utils.Error(c, 400, err.Error())
+		return false
+	}
+	if _, err := services.ResolveParameters(dashboard.Parameters, s.Parameters); err != nil {
+		utils.Error(c, 400, err.Error())
+		return false
+	}
+	return true
+}
+
+// findReportSubscription 获取当前用户的报表订阅
+func findReportSubscription(c *gin.Context) (*models.ReportSubscription, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "Invalid subscription ID")
+		return nil, false
+	}
+
+	var sub models.ReportSubscription
+	err = db.GetCollection("report_subscriptions").FindOne(context.TODO(), bson.M{
+		"_id":        id,
+		"created_by": c.MustGet("user_id").(primitive.ObjectID),
+	}).Decode(&sub)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			utils.Error(c, 404, "Subscription not found")
+			return nil, false
+		}
+		utils.Error(c, 500, "Failed to fetch subscription")
+		return nil, false
+	}
+	return &sub, true
+}
+
+// CreateReportSubscription 订阅仪表盘的定时邮件报表，未指定收件人时发送给自己
+func CreateReportSubscription(c *gin.Context) {
+	var input reportSubscriptionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "Invalid request data")
+		return
+	}
+	if input.DashboardID == nil {
+		utils.Error(c, 400, "dashboard_id is required")
+		return
+	}
+
+	now := time.Now()
+	sub := models.ReportSubscription{
+		DashboardID: *input.DashboardID,
+		Schedule:    models.ReportScheduleWeekly,
+		Hour:        9,
+		Weekday:     int(time.Monday),
+		Enabled:     true,
+		CreatedBy:   c.MustGet("user_id").(primitive.ObjectID),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	input.apply(&sub)
+	if !validateReportSubscription(c, &sub) {
+		return
+	}
+
+	result, err := db.GetCollection("report_subscriptions").InsertOne(context.TODO(), sub)
+	if err != nil {
+		utils.Error(c, 500, "Failed to create subscription")
+		return
+	}
+	sub.ID = result.InsertedID.(primitive.ObjectID)
+
+	utils.Success(c, sub)
+}
+
+// GetReportSubscriptions 获取当前用户的报表订阅，可按 dashboard_id 过滤
+func GetReportSubscriptions(c *gin.Context) {
+	filter := bson.M{"created_by": c.MustGet("user_id").(primitive.ObjectID)}
+	if v := c.Query("dashboard_id"); v != "" {
+		dashboardID, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			utils.Error(c, 400, "Invalid dashboard ID")
+			return
+		}
+		filter["dashboard_id"] = dashboardID
+	}
+
+	cursor, err := db.GetCollection("report_subscriptions").Find(context.TODO(), filter,
+		options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		utils.Error(c, 500, "Failed to fetch subscriptions")
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	subs := []models.ReportSubscription{}
+	if err := cursor.All(context.TODO(), &subs); err != nil {
+		utils.Error(c, 500, "Failed to decode subscriptions")
+		return
+	}
+
+	utils.Success(c, subs)
+}
+
+// GetReportSubscription 获取单个报表订阅
+func GetReportSubscription(c *gin.Context) {
+	sub, ok := findReportSubscription(c)
+	if !ok {
+		return
+	}
+	utils.Success(c, sub)
+}
+
+// UpdateReportSubscription 修改报表订阅，修改发送计划后重新计算下一次发送时间
+func UpdateReportSubscription(c *gin.Context) {
+	sub, ok := findReportSubscription(c)
+	if !ok {
+		return
+	}
+
+	var input reportSubscriptionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "Invalid request data")
+		return
+	}
+	if input.DashboardID != nil && *input.DashboardID != sub.DashboardID {
+		utils.Error(c, 400, "dashboard_id cannot be changed")
+		return
+	}
+	input.apply(sub)
+	if !validateReportSubscription(c, sub) {
+		return
+	}
+	sub.UpdatedAt = time.Now()
+
+	_, err := db.GetCollection("report_subscriptions").ReplaceOne(context.TODO(), bson.M{"_id": sub.ID}, sub)
+	if err != nil {
+		utils.Error(c, 500, "Failed to update subscription")
+		return
+	}
+
+	utils.Success(c, sub)
+}
+
+// DeleteReportSubscription 删除报表订阅及其发送记录
+func DeleteReportSubscription(c *gin.Context) {
+	sub, ok := findReportSubscription(c)
+	if !ok {
+		return
+	}
+
+	if _, err := db.GetCollection("report_subscriptions").DeleteOne(context.TODO(), bson.M{"_id": sub.ID}); err != nil {
+		utils.Error(c, 500, "Failed to delete subscription")
+		return
+	}
+	if _, err := db.GetCollection("report_deliveries").DeleteMany(context.TODO(), bson.M{"subscription_id": sub.ID}); err != nil {
+		utils.Error(c, 500, "Failed to delete delivery history")
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "Subscription deleted successfully"})
+}
+
+// SendReportNow 立即发送一次报表，不影响定时计划，返回本次发送记录
+func SendReportNow(c *gin.Context) {
+	sub, ok := findReportSubscription(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := services.SendReport(c.Request.Context(), sub, services.ReportTriggerManual)
+	if err != nil {
+		utils.Error(c, 500, "Failed to record delivery")
+		return
+	}
+
+	utils.Success(c, delivery)
+}
+
+// GetReportDeliveries 获取报表订阅最近的发送记录
+func GetReportDeliveries(c *gin.Context) {
+	sub, ok := findReportSubscription(c)
+	if !ok {
+		return
+	}
+
+	cursor, err := db.GetCollection("report_deliveries").Find(context.TODO(),
+		bson.M{"subscription_id": sub.ID},
+		options.Find().SetSort(bson.M{"started_at": -1}).SetLimit(50))
+	if err != nil {
+		utils.Error(c, 500, "Failed to fetch delivery history")
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	deliveries := []models.ReportDelivery{}
+	if err := cursor.All(context.TODO(), &deliveries); err != nil {
+		utils.Error(c, 500, "Failed to decode delivery history")
+		return
+	}
+
+	utils.Success(c, deliveries)
+}
@@ -0,0 +1,406 @@
+// handlers/share.go
+package handlers
+
+import (
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/services"
+	"bi-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	shareAccessTTL         = 12 * time.Hour   // 输入密码后访问令牌的有效期
+	minSharePasswordLength = 8                // 分享密码的最短长度
+	maxShareUnlockAttempts = 5                // 连续输错该次数后暂停解锁
+	shareUnlockLockout     = 15 * time.Minute // 暂停解锁的时长
+)
+
+// CreateDashboardShare 为仪表盘创建公开分享链接，可设置访问密码和过期时间
+func CreateDashboardShare(c *gin.Context) {
+	dashboard, ok := findDashboard(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Password       string     `json:"password"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+		ExpiresInHours int        `json:"expires_in_hours"`
+		AllowRawData   bool       `json:"allow_raw_data"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			utils.Error(c, 400, "Invalid request data")
+			return
+		}
+	}
+
+	now := time.Now()
+	share := models.DashboardShare{
+		DashboardID:  dashboard.ID,
+		Token:        utils.GenerateRandomToken(),
+		AllowRawData: input.AllowRawData,
+		CreatedBy:    dashboard.CreatedBy,
+		CreatedAt:    now,
+	}
+	switch {
+	case input.ExpiresAt != nil:
+		share.ExpiresAt = input.ExpiresAt
+	case input.ExpiresInHours > 0:
+		expiresAt := now.Add(time.Duration(input.ExpiresInHours) * time.Hour)
+		share.ExpiresAt = &expiresAt
+	}
+	if share.ExpiresAt != nil && !share.ExpiresAt.After(now) {
+		utils.Error(c, 400, "expires_at must be in the future")
+		return
+	}
+	if input.Password != "" {
+		if len(input.Password) < minSharePasswordLength {
+			utils.Error(c, 400, fmt.Sprintf("Password must be at least %d characters", minSharePasswordLength))
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			utils.Error(c, 500, "Failed to hash password")
+			return
+		}
+		share.PasswordHash = string(hash)
+		share.Protected = true
+	}
+
+	result, err := db.GetCollection("dashboard_shares").InsertOne(context.TODO(), share)
+	if err != nil {
+		utils.Error(c, 500, "Failed to create share link")
+		return
+	}
+	share.ID = result.InsertedID.(primitive.ObjectID)
+
+	utils.Success(c, share)
+}
+
+// GetDashboardShares 获取仪表盘的分享链接，包括已撤销和已过期的链接
+func GetDashboardShares(c *gin.Context) {
+	dashboard, ok := findDashboard(c)
+	if !ok {
+		return
+	}
+
+	cursor, err := db.GetCollection("dashboard_shares").Find(context.TODO(),
+		bson.M{"dashboard_id": dashboard.ID, "created_by": dashboard.CreatedBy},
+		options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		utils.Error(c, 500, "Failed to fetch share links")
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	shares := []models.DashboardShare{}
+	if err := cursor.All(context.TODO(), &shares); err != nil {
+		utils.Error(c, 500, "Failed to decode share links")
+		return
+	}
+
+	utils.Success(c, shares)
+}
+
+// RevokeDashboardShare 撤销分享链接，撤销后链接立即失效
+func RevokeDashboardShare(c *gin.Context) {
+	dashboard, ok := findDashboard(c)
+	if !ok {
+		return
+	}
+	shareID, err := primitive.ObjectIDFromHex(c.Param("shareId"))
+	if err != nil {
+		utils.Error(c, 400, "Invalid share ID")
+		return
+	}
+
+	result, err := db.GetCollection("dashboard_shares").UpdateOne(context.TODO(), bson.M{
+		"_id":          shareID,
+		"dashboard_id": dashboard.ID,
+		"created_by":   dashboard.CreatedBy,
+		"revoked_at":   bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		utils.Error(c, 500, "Failed to revoke share link")
+		return
+	}
+	if result.MatchedCount == 0 {
+		utils.Error(c, 404, "Share link not found")
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "Share link revoked successfully"})
+}
+
+// loadShare 按 URL 中的 token 读取有效的分享链接，已撤销或过期时返回 410
+func loadShare(c *gin.Context) (*models.DashboardShare, bool) {
+	var share models.DashboardShare
+	err := db.GetCollection("dashboard_shares").FindOne(context.TODO(), bson.M{"token": c.Param("token")}).Decode(&share)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			utils.Error(c, 404, "Share link not found")
+			return nil, false
+		}
+		utils.Error(c, 500, "Failed to fetch share link")
+		return nil, false
+	}
+	if share.RevokedAt != nil {
+		utils.Error(c, 410, "Share link has been revoked")
+		return nil, false
+	}
+	if share.ExpiresAt != nil && time.Now().After(*share.ExpiresAt) {
+		utils.Error(c, 410, "Share link has expired")
+		return nil, false
+	}
+	return &share, true
+}
+
+// findSharedDashboard 校验分享链接和访问令牌，返回分享的仪表盘。
+// 有密码的链接需要通过 X-Share-Token 请求头或 access_token 参数传入解锁后得到的访问令牌
+func findSharedDashboard(c *gin.Context) (*models.DashboardShare, *models.Dashboard, bool) {
+	share, ok := loadShare(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if share.Protected {
+		accessToken := c.GetHeader("X-Share-Token")
+		if accessToken == "" {
+			accessToken = c.Query("access_token")
+		}
+		shareID, err := utils.ParseShareAccessToken(accessToken)
+		if err != nil || shareID != share.ID.Hex() {
+			utils.ErrorWithData(c, 401, "Password required", gin.H{"password_required": true})
+			return nil, nil, false
+		}
+	}
+
+	var dashboard models.Dashboard
+	err := db.GetCollection("dashboards").FindOne(context.TODO(), bson.M{
+		"_id":        share.DashboardID,
+		"created_by": share.CreatedBy,
+	}).Decode(&dashboard)
+	if err != nil {
+		utils.Error(c, 404, "Dashboard not found")
+		return nil, nil, false
+	}
+	return share, &dashboard, true
+}
+
+// UnlockSharedDashboard 校验分享密码，返回访问令牌；连续输错多次后一段时间内拒绝解锁
+func UnlockSharedDashboard(c *gin.Context) {
+	share, ok := loadShare(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "Invalid request data")
+		return
+	}
+	if !share.Protected {
+		utils.Error(c, 400, "Share link is not password protected")
+		return
+	}
+	// 先在数据库中原子地记录一次尝试再校验密码，并发请求和多个实例共享同一计数
+	collection := db.GetCollection("dashboard_shares")
+	now := time.Now()
+	var attempt models.DashboardShare
+	err := collection.FindOneAndUpdate(context.TODO(), bson.M{
+		"_id": share.ID,
+		"$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lte": now}},
+		},
+	}, bson.M{"$inc": bson.M{"failed_attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&attempt)
+	if err != nil && err != mongo.ErrNoDocuments {
+		utils.Error(c, 500, "Failed to verify password")
+		return
+	}
+	if err == mongo.ErrNoDocuments || attempt.FailedAttempts > maxShareUnlockAttempts {
+		shareUnlockLocked(c, share)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(input.Password)) != nil {
+		if attempt.FailedAttempts == maxShareUnlockAttempts {
+			shareUnlockLocked(c, share)
+			return
+		}
+		utils.Error(c, 401, "Incorrect password")
+		return
+	}
+	_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": share.ID}, bson.M{"$set": bson.M{"failed_attempts": 0}})
+	if err != nil {
+		log.Printf("Failed to reset share unlock attempts %s: %v", share.ID.Hex(), err)
+	}
+
+	expiresAt := time.Now().Add(shareAccessTTL)
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(expiresAt) {
+		expiresAt = *share.ExpiresAt
+	}
+	token, err := utils.GenerateShareAccessToken(share.ID.Hex(), expiresAt)
+	if err != nil {
+		utils.Error(c, 500, "Failed to generate access token")
+		return
+	}
+
+	utils.Success(c, gin.H{"access_token": token, "expires_at": expiresAt})
+}
+
+// shareUnlockLocked 输错次数达到上限，暂停解锁一段时间并返回 429
+func shareUnlockLocked(c *gin.Context, share *models.DashboardShare) {
+	lockedUntil := time.Now().Add(shareUnlockLockout)
+	_, err := db.GetCollection("dashboard_shares").UpdateOne(context.TODO(), bson.M{
+		"_id": share.ID,
+		"$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lte": time.Now()}},
+		},
+	}, bson.M{"$set": bson.M{"failed_attempts": 0, "locked_until": lockedUntil}})
+	if err != nil {
+		log.Printf("Failed to lock share unlock %s: %v", share.ID.Hex(), err)
+	}
+	c.Header("Retry-After", strconv.Itoa(int(shareUnlockLockout.Seconds())))
+	utils.Error(c, 429, "Too many incorrect passwords, please try again later")
+}
+
+// GetSharedDashboard 只读获取分享的仪表盘及图表配置，不包含数据源信息
+func GetSharedDashboard(c *gin.Context) {
+	share, dashboard, ok := findSharedDashboard(c)
+	if !ok {
+		return
+	}
+
+	charts, err := services.LoadDashboardCharts(c.Request.Context(), dashboard)
+	if err != nil {
+		utils.Error(c, 500, "Failed to fetch charts")
+		return
+	}
+	sharedCharts := make([]gin.H, 0, len(charts))
+	for _, chart := range charts {
+		item := gin.H{
+			"id":     chart.ID,
+			"name":   chart.Name,
+			"type":   chart.Type,
+			"config": chart.Config,
+		}
+		if share.AllowRawData {
+			item["data_source_id"] = chart.DataSourceID
+		}
+		sharedCharts = append(sharedCharts, item)
+	}
+
+	_, err = db.GetCollection("dashboard_shares").UpdateOne(context.TODO(), bson.M{"_id": share.ID}, bson.M{
+		"$inc": bson.M{"access_count": 1},
+		"$set": bson.M{"last_accessed_at": time.Now()},
+	})
+	if err != nil {
+		log.Printf("Failed to record share access %s: %v", share.ID.Hex(), err)
+	}
+
+	utils.Success(c, gin.H{
+		"dashboard": gin.H{
+			"id":          dashboard.ID,
+			"name":        dashboard.Name,
+			"description": dashboard.Description,
+			"layout":      dashboard.Layout,
+			"filters":     dashboard.Filters,
+			"parameters":  dashboard.Parameters,
+			"updated_at":  dashboard.UpdatedAt,
+		},
+		"charts":         sharedCharts,
+		"allow_raw_data": share.AllowRawData,
+	})
+}
+
+// QuerySharedDashboard 查询分享的仪表盘上的图表数据，支持与 QueryDashboard 相同的筛选和参数
+func QuerySharedDashboard(c *gin.Context) {
+	_, dashboard, ok := findSharedDashboard(c)
+	if !ok {
+		return
+	}
+	input, ok := bindDashboardQuery(c)
+	if !ok {
+		return
+	}
+
+	results, err := services.RunDashboard(c.Request.Context(), dashboard, input)
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	utils.Success(c, gin.H{"charts": results})
+}
+
+// GetSharedDataSource 获取分享的仪表盘上图表使用的数据源原始数据，仅在分享时允许了原始数据才可访问
+func GetSharedDataSource(c *gin.Context) {
+	share, dashboard, ok := findSharedDashboard(c)
+	if !ok {
+		return
+	}
+	if !share.AllowRawData {
+		utils.Error(c, 403, "Raw data is not shared")
+		return
+	}
+	dataSourceID, err := primitive.ObjectIDFromHex(c.Param("dataSourceId"))
+	if err != nil {
+		utils.Error(c, 400, "Invalid data source ID")
+		return
+	}
+
+	// 只允许访问仪表盘上图表使用的数据源
+	charts, err := services.LoadDashboardCharts(c.Request.Context(), dashboard)
+	if err != nil {
+		utils.Error(c, 500, "Failed to fetch charts")
+		return
+	}
+	used := false
+	for _, chart := range charts {
+		if chart.DataSourceID == dataSourceID {
+			used = true
+			break
+		}
+	}
+	if !used {
+		utils.Error(c, 404, "Data source not found")
+		return
+	}
+
+	ds, err := services.LoadDataSource(c.Request.Context(), dataSourceID, share.CreatedBy)
+	if err != nil {
+		if errors.Is(err, services.ErrDataSourceNotFound) {
+			utils.Error(c, 404, "Data source not found")
+			return
+		}
+		utils.Error(c, 500, "Failed to fetch data source")
+		return
+	}
+
+	utils.Success(c, gin.H{
+		"id":      ds.ID,
+		"name":    ds.Name,
+		"headers": ds.Headers,
+		"schema":  ds.Schema,
+		"content": ds.Content,
+	})
+}
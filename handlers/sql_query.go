@@ -0,0 +1,29 @@
+// handlers/sql_query.go
+package handlers
+
+import (
+	"bi-backend/services"
+	"bi-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RunSQLQuery 在自己的数据源上执行只读的即席 SQL 查询，FROM 为数据源 ID 或名称
+func RunSQLQuery(c *gin.Context) {
+	var input struct {
+		SQL string `json:"sql" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "Invalid request data")
+		return
+	}
+
+	result, err := services.RunSQLQuery(c.Request.Context(), input.SQL, c.MustGet("user_id").(primitive.ObjectID))
+	if err != nil {
+		queryFailed(c, err)
+		return
+	}
+
+	utils.Success(c, result)
+}
@@ -0,0 +1,326 @@
+// handlers/upload.go
+package handlers
+
+import (
+	"bi-backend/config"
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/services"
+	"bi-backend/storage"
+	"bi-backend/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func uploadSessions() *mongo.Collection {
+	return db.GetClient().Database("bi_platform").Collection("upload_sessions")
+}
+
+// findUploadSession 获取当前用户的上传会话
+func findUploadSession(c *gin.Context) (*models.UploadSession, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.Error(c, 400, "无效的上传ID")
+		return nil, false
+	}
+
+	var session models.UploadSession
+	err = uploadSessions().FindOne(context.TODO(), bson.M{
+		"_id":        id,
+		"created_by": c.MustGet("user_id").(primitive.ObjectID),
+	}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		utils.Error(c, 404, "上传任务不存在")
+		return nil, false
+	}
+	if err != nil {
+		utils.Error(c, 500, "获取上传任务失败")
+		return nil, false
+	}
+	return &session, true
+}
+
+// expectedPartSize 指定编号分片应有的大小，最后一个分片为剩余部分
+func expectedPartSize(session *models.UploadSession, number int) int64 {
+	if number == session.TotalParts {
+		return session.FileSize - int64(session.TotalParts-1)*session.ChunkSize
+	}
+	return session.ChunkSize
+}
+
+// InitUpload 创建分片上传会话，返回分片大小和分片数量
+func InitUpload(c *gin.Context) {
+	var input struct {
+		FileName string `json:"file_name" binding:"required"`
+		Type     string `json:"type" binding:"required"`
+		Size     int64  `json:"size" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.Error(c, 400, "无效的请求数据")
+		return
+	}
+
+	maxSize := utils.MaxUploadSize(input.Type)
+	if maxSize == 0 {
+		utils.Error(c, 400, "Unsupported file type")
+		return
+	}
+	if input.Size <= 0 {
+		utils.Error(c, 400, "文件为空")
+		return
+	}
+	if input.Size > maxSize {
+		utils.Error(c, 413, fmt.Sprintf("文件大小超过限制（最大 %d MB）", maxSize>>20))
+		return
+	}
+
+	userID := c.MustGet("user_id").(primitive.ObjectID)
+	if quotaExceeded(c, services.CheckUploadQuota(context.TODO(), userID, input.Size)) {
+		return
+	}
+
+	mp, ok := storage.Multipart()
+	if !ok {
+		utils.Error(c, 501, "当前存储不支持分片上传")
+		return
+	}
+
+	fileName := filepath.Base(input.FileName)
+	objectKey := storage.NewObjectKey(userID.Hex(), fileName)
+	uploadID, err := mp.InitMultipart(context.TODO(), objectKey, "")
+	if err != nil {
+		log.Printf("Failed to init multipart upload: %v", err)
+		utils.Error(c, 500, "创建上传任务失败")
+		return
+	}
+
+	chunkSize := config.GlobalConfig.Upload.ChunkSize
+	now := time.Now()
+	session := models.UploadSession{
+		ID:         primitive.NewObjectID(),
+		FileName:   fileName,
+		FileType:   input.Type,
+		FileSize:   input.Size,
+		ChunkSize:  chunkSize,
+		TotalParts: int((input.Size + chunkSize - 1) / chunkSize),
+		Parts:      map[string]models.UploadPart{},
+		ObjectKey:  objectKey,
+		UploadID:   uploadID,
+		Status:     models.UploadStatusUploading,
+		CreatedBy:  userID,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		ExpiresAt:  now.Add(config.GlobalConfig.Upload.SessionTTL),
+	}
+
+	if _, err := uploadSessions().InsertOne(context.TODO(), session); err != nil {
+		log.Printf("Failed to save upload session: %v", err)
+		mp.AbortMultipart(context.TODO(), objectKey, uploadID)
+		utils.Error(c, 500, "创建上传任务失败")
+		return
+	}
+
+	utils.Success(c, session)
+}
+
+// UploadPart 上传单个分片，请求体为分片的原始字节，重复上传同一编号会覆盖
+func UploadPart(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	if session.Status != models.UploadStatusUploading {
+		utils.Error(c, 409, "上传任务已结束")
+		return
+	}
+	if time.Now().After(session.ExpiresAt) {
+		utils.Error(c, 410, "上传任务已过期")
+		return
+	}
+
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil || number < 1 || number > session.TotalParts {
+		utils.Error(c, 400, "无效的分片编号")
+		return
+	}
+
+	// 分片大小必须与会话约定一致，多读一个字节用于判断是否超长
+	expected := expectedPartSize(session, number)
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, expected+1))
+	if err != nil {
+		utils.Error(c, 400, "读取分片失败")
+		return
+	}
+	if int64(len(data)) != expected {
+		utils.Error(c, 400, fmt.Sprintf("分片大小不正确，应为 %d 字节", expected))
+		return
+	}
+
+	mp, ok := storage.Multipart()
+	if !ok {
+		utils.Error(c, 501, "当前存储不支持分片上传")
+		return
+	}
+	etag, err := mp.PutPart(context.TODO(), session.ObjectKey, session.UploadID, number, bytes.NewReader(data), expected)
+	if err != nil {
+		log.Printf("Failed to upload part %d of %s: %v", number, session.ID.Hex(), err)
+		utils.Error(c, 500, "上传分片失败")
+		return
+	}
+
+	part := models.UploadPart{Number: number, Size: expected, ETag: etag}
+	_, err = uploadSessions().UpdateOne(context.TODO(),
+		bson.M{"_id": session.ID, "status": models.UploadStatusUploading},
+		bson.M{"$set": bson.M{
+			"parts." + strconv.Itoa(number): part,
+			"updated_at":                    time.Now(),
+		}},
+	)
+	if err != nil {
+		utils.Error(c, 500, "保存分片信息失败")
+		return
+	}
+
+	utils.Success(c, part)
+}
+
+// GetUpload 获取上传会话状态，客户端据此续传缺失的分片或轮询处理进度
+func GetUpload(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	utils.Success(c, session)
+}
+
+// CompleteUpload 合并所有分片，并创建导入任务在后台解析文件、创建数据源
+func CompleteUpload(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	if session.Status != models.UploadStatusUploading {
+		utils.Error(c, 409, "上传任务已结束")
+		return
+	}
+	if time.Now().After(session.ExpiresAt) {
+		utils.Error(c, 410, "上传任务已过期")
+		return
+	}
+
+	parts := make([]storage.Part, 0, session.TotalParts)
+	for i := 1; i <= session.TotalParts; i++ {
+		p, ok := session.Parts[strconv.Itoa(i)]
+		if !ok {
+			utils.Error(c, 400, fmt.Sprintf("缺少分片 %d", i))
+			return
+		}
+		parts = append(parts, storage.Part{Number: p.Number, ETag: p.ETag})
+	}
+
+	// 先抢占状态，避免重复提交导致重复合并
+	result, err := uploadSessions().UpdateOne(context.TODO(),
+		bson.M{"_id": session.ID, "status": models.UploadStatusUploading},
+		bson.M{"$set": bson.M{"status": models.UploadStatusProcessing, "progress": 0, "updated_at": time.Now()}},
+	)
+	if err != nil || result.ModifiedCount == 0 {
+		utils.Error(c, 409, "上传任务已结束")
+		return
+	}
+
+	mp, _ := storage.Multipart()
+	// 创建会话后可能又有其他上传占用了配额，合并前再次检查
+	if err := services.CheckUploadSessionQuota(context.TODO(), session); err != nil {
+		if err := mp.AbortMultipart(context.TODO(), session.ObjectKey, session.UploadID); err != nil {
+			log.Printf("Failed to abort multipart upload %s: %v", session.ID.Hex(), err)
+		}
+		failUpload(session, "超出配额")
+		quotaExceeded(c, err)
+		return
+	}
+	if err := mp.CompleteMultipart(context.TODO(), session.ObjectKey, session.UploadID, parts); err != nil {
+		log.Printf("Failed to complete multipart upload %s: %v", session.ID.Hex(), err)
+		failUpload(session, "合并分片失败")
+		utils.Error(c, 500, "合并分片失败")
+		return
+	}
+
+	job := models.IngestJob{
+		FileName:        session.FileName,
+		FileType:        session.FileType,
+		FileSize:        session.FileSize,
+		ObjectKey:       session.ObjectKey,
+		UploadSessionID: session.ID,
+		CreatedBy:       session.CreatedBy,
+	}
+	if err := services.EnqueueIngestJob(context.TODO(), &job); err != nil {
+		log.Printf("Failed to enqueue ingest job for upload %s: %v", session.ID.Hex(), err)
+		failUpload(session, "创建导入任务失败")
+		if errors.Is(err, services.ErrIngestQueueFull) {
+			utils.Error(c, 503, "导入任务过多，请稍后重试")
+			return
+		}
+		utils.Error(c, 500, "创建导入任务失败")
+		return
+	}
+	uploadSessions().UpdateOne(context.TODO(),
+		bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"job_id": job.ID}},
+	)
+
+	session.Status = models.UploadStatusProcessing
+	session.JobID = job.ID
+	utils.Success(c, session)
+}
+
+// AbortUpload 取消上传并清理已上传的分片
+func AbortUpload(c *gin.Context) {
+	session, ok := findUploadSession(c)
+	if !ok {
+		return
+	}
+	if session.Status != models.UploadStatusUploading {
+		utils.Error(c, 409, "上传任务已结束")
+		return
+	}
+
+	if mp, ok := storage.Multipart(); ok {
+		if err := mp.AbortMultipart(context.TODO(), session.ObjectKey, session.UploadID); err != nil {
+			log.Printf("Failed to abort multipart upload %s: %v", session.ID.Hex(), err)
+		}
+	}
+
+	_, err := uploadSessions().UpdateOne(context.TODO(),
+		bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"status": models.UploadStatusAborted, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		utils.Error(c, 500, "取消上传失败")
+		return
+	}
+
+	utils.Success(c, gin.H{"message": "上传已取消"})
+}
+
+// failUpload 标记处理失败，合并后的文件不再保留
+func failUpload(session *models.UploadSession, msg string) {
+	if err := storage.DeleteObject(context.TODO(), session.ObjectKey); err != nil {
+		log.Printf("Failed to delete file %s: %v", session.ObjectKey, err)
+	}
+	uploadSessions().UpdateOne(context.TODO(),
+		bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"status": models.UploadStatusFailed, "error": msg, "updated_at": time.Now()}},
+	)
+}
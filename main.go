@@ -3,16 +3,20 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
+	"bi-backend/cache"
 	"bi-backend/config"
 	"bi-backend/db"
 	"bi-backend/handlers"
 	"bi-backend/middleware"
+	"bi-backend/render"
+	"bi-backend/services"
 	"bi-backend/storage"
 )
 
@@ -41,16 +45,21 @@ func setupRouter() *gin.Engine {
 		})
 	})
 
+	// 本地存储的预签名文件访问
+	if h := storage.LocalFileHandler(); h != nil {
+		r.GET("/files/*key", gin.WrapH(http.StripPrefix("/files", h)))
+	}
+
 	// API路由
 	api := r.Group("/api")
 	{
 		// 添加测试路由
 		api.GET("/test-oss", func(c *gin.Context) {
-			if storage.GetCloudStorage() == nil {
+			if storage.Default() == nil {
 				c.JSON(500, gin.H{"error": "Storage not initialized"})
 				return
 			}
-			c.JSON(200, gin.H{"status": "OSS connection OK"})
+			c.JSON(200, gin.H{"status": "Storage connection OK", "driver": config.GlobalConfig.Storage.Driver})
 		})
 		// 认证相关
 		auth := api.Group("/auth")
@@ -62,52 +71,144 @@ func setupRouter() *gin.Engine {
 			auth.POST("/reset-password", handlers.ResetPassword)
 		}
 
+		// 公开分享的仪表盘，只读访问，不需要登录
+		public := api.Group("/public/dashboards/:token", middleware.BodyLimit(config.GlobalConfig.Server.MaxBodyBytes))
+		{
+			public.GET("", handlers.GetSharedDashboard)
+			public.POST("/unlock", handlers.UnlockSharedDashboard) // 输入分享密码，获取访问令牌
+			public.GET("/data", handlers.QuerySharedDashboard)
+			public.POST("/data", handlers.QuerySharedDashboard)
+			public.GET("/datasources/:dataSourceId", handlers.GetSharedDataSource) // 仅在允许原始数据时可用
+		}
+
+		// 嵌入的仪表盘和图表，使用业务系统签发的嵌入令牌访问
+		embed := api.Group("/embed/dashboard", middleware.BodyLimit(config.GlobalConfig.Server.MaxBodyBytes))
+		{
+			embed.GET("", handlers.GetEmbeddedDashboard)
+			embed.GET("/data", handlers.QueryEmbeddedDashboard)
+			embed.POST("/data", handlers.QueryEmbeddedDashboard)
+		}
+
 		// 需要认证的路由
 		authorized := api.Group("")
 		authorized.Use(middleware.Auth())
 		{
+			// 上传接口有各自的大小限制，其余接口统一限制请求体大小
+			bodyLimit := middleware.BodyLimit(config.GlobalConfig.Server.MaxBodyBytes)
+
 			// 用户相关
-			user := authorized.Group("/user")
+			user := authorized.Group("/user", bodyLimit)
 			{
 				user.GET("/profile", handlers.GetProfile)
 				user.PUT("/profile", handlers.UpdateProfile)
 				user.PUT("/password", handlers.UpdatePassword)
 				user.GET("/stats", handlers.GetUserStats) // 添加新的统计接口
+				user.GET("/quota", handlers.GetQuota)     // 配额与当前用量
 			}
 
 			// 数据源相关
 			datasource := authorized.Group("/datasources")
 			{
 				// 使用正确的 UploadDataSource 处理函数
-				datasource.POST("", handlers.UploadDataSource)                     // 文件上传
-				datasource.GET("", handlers.GetDataSources)                        // 获取列表
-				datasource.GET("/:id", handlers.GetDataSource)                     // 获取单个
-				datasource.PUT("/:id", handlers.UpdateDataSource)                  // 更新
-				datasource.DELETE("/:id", handlers.DeleteDataSource)               // 删除
-				datasource.PUT("/:id/preprocessing", handlers.UpdatePreprocessing) //预处理
+				datasource.POST("", handlers.UploadDataSource)                                // 文件上传
+				datasource.GET("", handlers.GetDataSources)                                   // 获取列表
+				datasource.GET("/:id", handlers.GetDataSource)                                // 获取单个
+				datasource.GET("/:id/file", handlers.GetDataSourceFile)                       // 下载原始文件
+				datasource.PUT("/:id", bodyLimit, handlers.UpdateDataSource)                  // 更新
+				datasource.DELETE("/:id", handlers.DeleteDataSource)                          // 删除
+				datasource.PUT("/:id/preprocessing", bodyLimit, handlers.UpdatePreprocessing) //预处理
+			}
+			// 分片上传相关
+			upload := authorized.Group("/uploads")
+			{
+				upload.POST("", handlers.InitUpload)                  // 创建上传会话
+				upload.GET("/:id", handlers.GetUpload)                // 查询上传状态与处理进度
+				upload.PUT("/:id/parts/:number", handlers.UploadPart) // 上传分片
+				upload.POST("/:id/complete", handlers.CompleteUpload) // 合并分片并开始处理
+				upload.DELETE("/:id", handlers.AbortUpload)           // 取消上传
+			}
+			// 导入任务相关
+			job := authorized.Group("/jobs")
+			{
+				job.GET("", handlers.GetJobs)    // 最近的导入任务
+				job.GET("/:id", handlers.GetJob) // 查询导入阶段与进度
+			}
+			// 通知相关
+			notification := authorized.Group("/notifications", bodyLimit)
+			{
+				notification.GET("", handlers.GetNotifications)
+				notification.PUT("/:id/read", handlers.MarkNotificationRead)
 			}
 			// 仪表盘相关
-			dashboard := authorized.Group("/dashboards")
+			dashboard := authorized.Group("/dashboards", bodyLimit)
 			{
 				dashboard.POST("", handlers.CreateDashboard)
 				dashboard.GET("", handlers.GetDashboards)
 				dashboard.GET("/:id", handlers.GetDashboard)
+				dashboard.GET("/:id/data", handlers.QueryDashboard)            // 仅通过 URL 参数查询，便于分享带参数的链接
+				dashboard.POST("/:id/data", handlers.QueryDashboard)           // 按全局筛选和交叉筛选查询所有图表
+				dashboard.POST("/:id/export/pdf", handlers.ExportDashboardPDF) // 按布局导出 PDF
+				dashboard.POST("/:id/shares", handlers.CreateDashboardShare)   // 创建公开分享链接
+				dashboard.GET("/:id/shares", handlers.GetDashboardShares)
+				dashboard.DELETE("/:id/shares/:shareId", handlers.RevokeDashboardShare) // 撤销分享链接
+				dashboard.POST("/:id/embed-token", handlers.CreateEmbedToken)           // 签发嵌入令牌
 				dashboard.PUT("/:id", handlers.UpdateDashboard)
 				dashboard.DELETE("/:id", handlers.DeleteDashboard)
 			}
 
+			// 仪表盘定时邮件报表
+			report := authorized.Group("/report-subscriptions", bodyLimit)
+			{
+				report.POST("", handlers.CreateReportSubscription)
+				report.GET("", handlers.GetReportSubscriptions) // 可按 dashboard_id 过滤
+				report.GET("/:id", handlers.GetReportSubscription)
+				report.PUT("/:id", handlers.UpdateReportSubscription)
+				report.DELETE("/:id", handlers.DeleteReportSubscription)
+				report.POST("/:id/send", handlers.SendReportNow)            // 立即发送一次
+				report.GET("/:id/deliveries", handlers.GetReportDeliveries) // 发送记录
+			}
+
+			// 图表指标告警
+			alert := authorized.Group("/alerts", bodyLimit)
+			{
+				alert.POST("", handlers.CreateAlertRule)
+				alert.GET("", handlers.GetAlertRules) // 可按 chart_id 过滤
+				alert.GET("/:id", handlers.GetAlertRule)
+				alert.PUT("/:id", handlers.UpdateAlertRule)
+				alert.DELETE("/:id", handlers.DeleteAlertRule)
+				alert.POST("/:id/evaluate", handlers.EvaluateAlertRule) // 立即检查一次
+				alert.GET("/:id/history", handlers.GetAlertHistory)     // 检查和触发记录
+				alert.POST("/:id/mute", handlers.MuteAlertRule)         // 静音，继续检查但不通知
+				alert.DELETE("/:id/mute", handlers.UnmuteAlertRule)
+				alert.POST("/:id/snooze", handlers.SnoozeAlertRule) // 暂停通知到指定时间
+				alert.DELETE("/:id/snooze", handlers.UnsnoozeAlertRule)
+			}
+
+			// 图表类型注册表
+			authorized.GET("/chart-types", handlers.GetChartTypes)
+
 			// 图表相关
-			chart := authorized.Group("/charts")
+			chart := authorized.Group("/charts", bodyLimit)
 			{
 				chart.POST("", handlers.CreateChart)
+				chart.POST("/query", handlers.QueryChart)      // 按未保存的配置预览查询结果
+				chart.GET("/broken", handlers.GetBrokenCharts) // 配置与数据源不再匹配的图表
+				chart.GET("/:id/data", handlers.GetChartData)  // 服务端查询图表数据
+				chart.POST("/:id/drill", handlers.DrillChart)  // 按下钻路径查询下一层级
+				chart.GET("/:id/render", handlers.RenderChart) // 服务端渲染为 PNG 或 SVG 图片
 				chart.GET("/:id", handlers.GetChart)
 				chart.GET("", handlers.GetCharts)
 				chart.PUT("/:id", handlers.UpdateChart)
 				chart.PUT("/:id/config", handlers.UpdateChartConfig)
 				chart.DELETE("/:id", handlers.DeleteChart)
 			}
+			// 即席查询
+			sqlQuery := authorized.Group("/query", bodyLimit)
+			{
+				sqlQuery.POST("/sql", handlers.RunSQLQuery) // 只读 SQL 子集，编译为图表查询执行
+			}
 			// 机器学习模型相关
-			mlmodel := authorized.Group("/mlmodels")
+			mlmodel := authorized.Group("/mlmodels", bodyLimit)
 			{
 				mlmodel.POST("", handlers.CreateMLModel)
 				mlmodel.GET("", handlers.GetMLModels)
@@ -116,6 +217,16 @@ func setupRouter() *gin.Engine {
 				mlmodel.PUT("/:id/result", handlers.UpdateMLModelResult)
 				mlmodel.DELETE("/:id", handlers.DeleteMLModel)
 			}
+
+			// 管理员相关
+			admin := authorized.Group("/admin", bodyLimit)
+			admin.Use(middleware.RequireRole("admin"))
+			{
+				admin.POST("/storage/reconcile", handlers.ReconcileStorage) // 清理孤儿文件
+				admin.GET("/users/:id/quota", handlers.GetUserQuota)        // 用户配额与用量
+				admin.PUT("/users/:id/quota", handlers.UpdateUserQuota)     // 调整用户配额
+				admin.DELETE("/users/:id/quota", handlers.ResetUserQuota)   // 恢复默认配额
+			}
 		}
 	}
 	return r
@@ -138,14 +249,50 @@ func main() {
 	}
 	defer db.Close(ctx)
 
+	// 执行数据迁移
+	migrateCtx, migrateCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	err := services.RunMigrations(migrateCtx)
+	migrateCancel()
+	if err != nil {
+		log.Fatal("Failed to run migrations:", err)
+	}
+
 	// 加载环境变量
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Error loading .env file: %v", err)
 	}
-	// 初始化 OSS
-	if err := storage.InitCloudStorage(); err != nil {
-		log.Fatalf("Failed to initialize cloud storage: %v", err)
+	// 初始化文件存储
+	if err := storage.Init(config.GlobalConfig.Storage); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	// 定期清理存储中的孤儿文件
+	services.StartStorageReconciler(
+		config.GlobalConfig.Storage.ReconcileInterval,
+		config.GlobalConfig.Storage.OrphanMinAge,
+	)
+
+	// 初始化查询结果缓存
+	if err := cache.Init(config.GlobalConfig.Cache); err != nil {
+		log.Fatalf("Failed to initialize query cache: %v", err)
+	}
+	services.RegisterQueryCacheHandlers()
+
+	// 加载服务端图表渲染使用的字体
+	if err := render.Init(config.GlobalConfig.Render); err != nil {
+		log.Fatalf("Failed to initialize chart renderer: %v", err)
 	}
+
+	// 启动导入任务工作池
+	services.RegisterNotificationHandlers()
+	services.StartIngestWorkers(config.GlobalConfig.Ingest)
+
+	// 启动定时邮件报表
+	services.StartReportScheduler(config.GlobalConfig.Report.CheckInterval)
+
+	// 启动指标告警检查，数据源更新后也会检查相关告警
+	services.RegisterAlertHandlers()
+	services.StartAlertScheduler(config.GlobalConfig.Alert.CheckInterval)
+
 	// 初始化路由
 	router := setupRouter()
 	// 打印所有注册的路由
@@ -0,0 +1,26 @@
+// middleware/body_limit.go
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit 限制请求体大小，声明的长度超限时直接返回 413，未声明长度时读取超限会失败
+func BodyLimit(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes <= 0 {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > maxBytes {
+			c.JSON(413, gin.H{"error": fmt.Sprintf("请求体过大（最大 %d MB）", maxBytes>>20)})
+			c.Abort()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
@@ -34,6 +34,8 @@ func Cors() gin.HandlerFunc {
 			"Cache-Control",
 			"Accept",
 			"X-Requested-With",
+			"X-Share-Token", // 公开分享仪表盘的访问令牌
+			"X-Embed-Token", // 嵌入仪表盘的签名令牌
 		},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -9,14 +9,43 @@ import (
 )
 
 type Dashboard struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name        string             `bson:"name" json:"name"`
-	Description string             `bson:"description" json:"description"`
-	Layout      []ChartLayout      `bson:"layout" json:"layout"`
-	EditCount   int                `bson:"edit_count" json:"edit_count"` // 添加编辑次数字段
-	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at,omitempty"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at,omitempty"`
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name        string               `bson:"name" json:"name"`
+	Description string               `bson:"description" json:"description"`
+	Layout      []ChartLayout        `bson:"layout" json:"layout"`
+	Filters     []DashboardFilter    `bson:"filters,omitempty" json:"filters,omitempty"`       // 作用于仪表盘上所有图表的全局筛选器
+	Parameters  []DashboardParameter `bson:"parameters,omitempty" json:"parameters,omitempty"` // 图表中以 {{name}} 引用的参数
+	EditCount   int                  `bson:"edit_count" json:"edit_count"`                     // 添加编辑次数字段
+	CreatedBy   primitive.ObjectID   `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at,omitempty"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at,omitempty"`
+}
+
+// DashboardFilter 仪表盘全局筛选器，通过 Bindings 绑定到各数据源的字段
+type DashboardFilter struct {
+	ID              string             `bson:"id" json:"id"` // 查询时按 ID 传入筛选值
+	Label           string             `bson:"label" json:"label"`
+	Type            string             `bson:"type" json:"type"` // date_range, category, number_range
+	Bindings        []FilterBinding    `bson:"bindings" json:"bindings"`
+	DefaultValues   []interface{}      `bson:"default_values,omitempty" json:"defaultValues,omitempty"`     // 未传入筛选值时使用的默认值
+	DefaultRelative *RelativeDateRange `bson:"default_relative,omitempty" json:"defaultRelative,omitempty"` // date_range 的默认相对日期
+}
+
+// DashboardParameter 仪表盘参数，可以在图表过滤值、计算列和指标表达式中以 {{name}} 引用
+type DashboardParameter struct {
+	Name     string        `bson:"name" json:"name"`
+	Label    string        `bson:"label" json:"label"`
+	Type     string        `bson:"type" json:"type"`                             // select, number, date
+	Options  []interface{} `bson:"options,omitempty" json:"options,omitempty"`   // select 的可选值，为空表示不限制
+	Multiple bool          `bson:"multiple,omitempty" json:"multiple,omitempty"` // select 是否允许多选
+	Default  interface{}   `bson:"default,omitempty" json:"default,omitempty"`
+}
+
+// FilterBinding 筛选器在某个数据源上对应的字段
+type FilterBinding struct {
+	DataSourceID primitive.ObjectID   `bson:"data_source_id" json:"data_source_id"`
+	Field        string               `bson:"field" json:"field"`
+	ChartIDs     []primitive.ObjectID `bson:"chart_ids,omitempty" json:"chart_ids,omitempty"` // 只作用于这些图表，为空表示该数据源的全部图表
 }
 
 type ChartLayout struct {
@@ -44,6 +73,54 @@ type ChartConfig struct {
 	Settings   interface{}      `bson:"settings" json:"settings"`
 	VisualMap  *VisualMapConfig `bson:"visual_map" json:"visualMap,omitempty"` // 添加 visualMap 配置
 	DualAxis   *DualAxisConfig  `bson:"dual_axis" json:"dualAxis,omitempty"`   // 添加 dualAxis 配置
+
+	Filters     []ChartFilter `bson:"filters,omitempty" json:"filters,omitempty"`
+	Sort        []ChartSort   `bson:"sort,omitempty" json:"sort,omitempty"`
+	Limit       int           `bson:"limit,omitempty" json:"limit,omitempty"`              // 只保留第一个维度排名前 N 的值
+	OtherBucket bool          `bson:"other_bucket,omitempty" json:"otherBucket,omitempty"` // 超出 Limit 的值合并为“其他”
+	Timezone    string        `bson:"timezone,omitempty" json:"timezone,omitempty"`        // 日期维度分组使用的时区，例如 Asia/Shanghai
+	Pivot       *PivotConfig  `bson:"pivot,omitempty" json:"pivot,omitempty"`              // pivot 类型图表的行列维度
+	// 下钻层级，例如 country → province → city 或同一日期字段的 year → quarter → month，
+	// 下钻查询时当前层级替换第一个维度
+	Hierarchy []ChartDimension `bson:"hierarchy,omitempty" json:"hierarchy,omitempty"`
+	// 计算列，按表达式由同一行的其他列计算，可以像普通字段一样用作维度、指标和过滤条件
+	CalculatedColumns []CalculatedColumn `bson:"calculated_columns,omitempty" json:"calculatedColumns,omitempty"`
+}
+
+// CalculatedColumn 计算列，例如 {name: "profit", expression: "revenue - cost"}
+type CalculatedColumn struct {
+	Name       string `bson:"name" json:"name"`
+	Expression string `bson:"expression" json:"expression"`
+}
+
+// PivotConfig 透视表配置，指标使用 ChartConfig.Metrics
+type PivotConfig struct {
+	Rows        []ChartDimension `bson:"rows" json:"rows"`
+	Columns     []ChartDimension `bson:"columns" json:"columns"`
+	Subtotals   bool             `bson:"subtotals" json:"subtotals"`
+	GrandTotals bool             `bson:"grand_totals" json:"grandTotals"`
+	Percent     string           `bson:"percent,omitempty" json:"percent,omitempty"` // row, column, total：显示为占行、列或总计的比例
+}
+
+// ChartFilter 查询过滤条件
+type ChartFilter struct {
+	Field    string             `bson:"field" json:"field"`
+	Operator string             `bson:"operator" json:"operator"` // eq, neq, in, not_in, gt, gte, lt, lte, between, contains, not_contains, starts_with, ends_with, is_null, not_null, relative
+	Values   []interface{}      `bson:"values,omitempty" json:"values,omitempty"`
+	Relative *RelativeDateRange `bson:"relative,omitempty" json:"relative,omitempty"` // operator 为 relative 时使用
+}
+
+// RelativeDateRange 相对日期范围，例如最近 30 天：{unit: day, last: 30, includeCurrent: true}
+type RelativeDateRange struct {
+	Unit           string `bson:"unit" json:"unit"` // day, week, month, quarter, year
+	Last           int    `bson:"last" json:"last"`
+	IncludeCurrent bool   `bson:"include_current" json:"includeCurrent"` // 是否包含当前周期（例如今天、本月）
+}
+
+// ChartSort 排序，Field 为维度字段或指标名称（别名或 aggregator(field)）
+type ChartSort struct {
+	Field string `bson:"field" json:"field"`
+	Order string `bson:"order" json:"order"` // asc, desc
 }
 
 type VisualMapConfig struct {
@@ -61,15 +138,38 @@ type DualAxisConfig struct {
 }
 
 type ChartDimension struct {
-	Field  string `bson:"field" json:"field"`
-	Type   string `bson:"type" json:"type"` // date, category etc.
-	Format string `bson:"format" json:"format,omitempty"`
+	Field  string     `bson:"field" json:"field"`
+	Type   string     `bson:"type" json:"type"` // date, category etc.
+	Format string     `bson:"format" json:"format,omitempty"`
+	Grain  string     `bson:"grain,omitempty" json:"grain,omitempty"` // 日期维度的时间粒度：hour, day, week, month, quarter, year
+	Fill   string     `bson:"fill,omitempty" json:"fill,omitempty"`   // 日期维度缺失周期的补齐方式：zero, null
+	Bin    *BinConfig `bson:"bin,omitempty" json:"bin,omitempty"`     // type 为 bin 时数值字段的分箱方式
+}
+
+// BinConfig 数值分箱，Width、Count、Breakpoints 三选一
+type BinConfig struct {
+	Width       float64   `bson:"width,omitempty" json:"width,omitempty"`             // 固定宽度
+	Origin      float64   `bson:"origin,omitempty" json:"origin,omitempty"`           // 固定宽度分箱的起点，默认 0
+	Count       int       `bson:"count,omitempty" json:"count,omitempty"`             // 按最小值到最大值等分的箱数
+	Breakpoints []float64 `bson:"breakpoints,omitempty" json:"breakpoints,omitempty"` // 自定义分界点
 }
 
 type ChartMetric struct {
 	Field      string `bson:"field" json:"field"`
 	Aggregator string `bson:"aggregator" json:"aggregator"` // sum, avg, count etc.
 	Alias      string `bson:"alias" json:"alias,omitempty"`
+	// 指标表达式，设置后忽略 Field 和 Aggregator，例如 sum(revenue) / count(*) * {{rate}}
+	Expression string `bson:"expression,omitempty" json:"expression,omitempty"`
+	// 在聚合结果上进行的累计、移动平均、占比、排名或同比环比计算
+	Calculation *MetricCalculation `bson:"calculation,omitempty" json:"calculation,omitempty"`
+}
+
+// MetricCalculation 指标的二次计算
+type MetricCalculation struct {
+	Type   string `bson:"type" json:"type"`                         // cumulative_sum, moving_avg, percent_of_total, rank, delta, ratio
+	Window int    `bson:"window,omitempty" json:"window,omitempty"` // moving_avg 的周期数
+	Period string `bson:"period,omitempty" json:"period,omitempty"` // delta、ratio 的对比周期：previous, wow, mom, qoq, yoy
+	Order  string `bson:"order,omitempty" json:"order,omitempty"`   // rank 的排序方向，默认 desc
 }
 
 type User struct {
@@ -85,6 +185,28 @@ type User struct {
 	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
 	LastLoginAt    time.Time          `bson:"last_login_at" json:"last_login_at"`
 	Preferences    UserPreferences    `bson:"preferences" json:"preferences"`
+	Quota          *UserQuota         `bson:"quota,omitempty" json:"quota,omitempty"` // 管理员单独设置的配额，为空时使用默认配额
+}
+
+// UserQuota 用户配额，0 表示不限制
+type UserQuota struct {
+	MaxStorageBytes         int64 `bson:"max_storage_bytes" json:"max_storage_bytes"`
+	MaxUploadBytes          int64 `bson:"max_upload_bytes" json:"max_upload_bytes"`
+	MaxDataSources          int64 `bson:"max_data_sources" json:"max_data_sources"`
+	MaxRowsPerDataSource    int64 `bson:"max_rows_per_data_source" json:"max_rows_per_data_source"`
+	MaxColumnsPerDataSource int64 `bson:"max_columns_per_data_source" json:"max_columns_per_data_source"`
+	MaxDashboards           int64 `bson:"max_dashboards" json:"max_dashboards"`
+	MaxCharts               int64 `bson:"max_charts" json:"max_charts"`
+	MaxMLModels             int64 `bson:"max_ml_models" json:"max_ml_models"`
+}
+
+// QuotaUsage 用户当前的资源用量，包含排队和处理中的导入任务
+type QuotaUsage struct {
+	StorageBytes int64 `json:"storage_bytes"`
+	DataSources  int64 `json:"data_sources"`
+	Dashboards   int64 `json:"dashboards"`
+	Charts       int64 `json:"charts"`
+	MLModels     int64 `json:"ml_models"`
 }
 
 // UserStats 用户统计数据结构
@@ -144,11 +266,275 @@ type DataSource struct {
 	CreatedBy     primitive.ObjectID    `bson:"created_by" json:"created_by"`
 	CreatedAt     time.Time             `bson:"created_at" json:"created_at"`
 	UpdatedAt     time.Time             `bson:"updated_at" json:"updated_at"`
+	Version       int64                 `bson:"version" json:"version"` // 数据或预处理每次变化时递增，用于查询缓存失效
 	FileURL       string                `bson:"file_url" json:"file_url"`
+	ObjectKey     string                `bson:"object_key,omitempty" json:"object_key,omitempty"` // 原始文件在存储后端中的 key
+	FileSize      int64                 `bson:"file_size" json:"file_size"`                       // 原始文件大小（字节）
+	Schema        []ColumnSchema        `bson:"schema,omitempty" json:"schema,omitempty"`         // 导入时推断的列类型
 	Preprocessing []PreprocessingConfig `bson:"preprocessing" json:"preprocessing"`
 	LinkedCharts  []primitive.ObjectID  `bson:"linked_charts,omitempty" json:"linked_charts,omitempty"`
 }
 
+// ColumnSchema 数据源的列类型，导入时根据数据推断
+type ColumnSchema struct {
+	Name     string `bson:"name" json:"name"`
+	Type     string `bson:"type" json:"type"`                         // number, date, boolean, string
+	Format   string `bson:"format,omitempty" json:"format,omitempty"` // 日期列的解析格式（Go layout）
+	Nullable bool   `bson:"nullable" json:"nullable"`                 // 是否存在空值
+}
+
+// 导入任务状态
+const (
+	IngestStatusQueued    = "queued"
+	IngestStatusRunning   = "running"
+	IngestStatusSucceeded = "succeeded"
+	IngestStatusFailed    = "failed"
+)
+
+// 导入任务阶段
+const (
+	IngestPhaseQueued     = "queued"
+	IngestPhaseValidating = "validating"
+	IngestPhaseParsing    = "parsing"
+	IngestPhaseInferring  = "inferring_schema"
+	IngestPhaseStoring    = "storing"
+	IngestPhaseSaving     = "saving"
+	IngestPhaseDone       = "done"
+)
+
+// IngestJob 异步导入任务，由后台工作池解析文件、推断列类型并创建数据源
+type IngestJob struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FileName        string             `bson:"file_name" json:"file_name"`
+	FileType        string             `bson:"file_type" json:"file_type"`
+	FileSize        int64              `bson:"file_size" json:"file_size"`
+	TempPath        string             `bson:"temp_path,omitempty" json:"-"`  // 尚未写入存储的本地临时文件
+	Instance        string             `bson:"instance,omitempty" json:"-"`   // 保存临时文件的实例，只有该实例能读取临时文件
+	ObjectKey       string             `bson:"object_key,omitempty" json:"-"` // 已写入存储的原始文件
+	UploadSessionID primitive.ObjectID `bson:"upload_session_id,omitempty" json:"upload_session_id,omitempty"`
+	Status          string             `bson:"status" json:"status"`   // queued, running, succeeded, failed
+	Phase           string             `bson:"phase" json:"phase"`     // queued, validating, parsing, inferring_schema, storing, saving, done
+	Percent         float64            `bson:"percent" json:"percent"` // 0-100
+	RowsTotal       int                `bson:"rows_total" json:"rows_total"`
+	RowsProcessed   int                `bson:"rows_processed" json:"rows_processed"`
+	Columns         int                `bson:"columns" json:"columns"`
+	Error           string             `bson:"error,omitempty" json:"error,omitempty"`
+	DataSourceID    primitive.ObjectID `bson:"data_source_id,omitempty" json:"data_source_id,omitempty"`
+	CreatedBy       primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	StartedAt       *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt      *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// Notification 站内通知
+type Notification struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID     `bson:"user_id" json:"user_id"`
+	Type      string                 `bson:"type" json:"type"`
+	Title     string                 `bson:"title" json:"title"`
+	Message   string                 `bson:"message" json:"message"`
+	Data      map[string]interface{} `bson:"data,omitempty" json:"data,omitempty"`
+	Read      bool                   `bson:"read" json:"read"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// 报表订阅的发送频率
+const (
+	ReportScheduleDaily  = "daily"
+	ReportScheduleWeekly = "weekly"
+	ReportScheduleCron   = "cron"
+)
+
+// 报表的发送格式
+const (
+	ReportFormatPDF    = "pdf"    // 仪表盘 PDF 作为附件
+	ReportFormatImages = "images" // 图表图片内嵌在邮件正文中
+)
+
+// 报表发送状态
+const (
+	ReportStatusSent   = "sent"
+	ReportStatusFailed = "failed"
+)
+
+// ReportSubscription 仪表盘定时邮件报表订阅
+type ReportSubscription struct {
+	ID          primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	DashboardID primitive.ObjectID     `bson:"dashboard_id" json:"dashboard_id"`
+	Name        string                 `bson:"name" json:"name"`
+	Recipients  []string               `bson:"recipients" json:"recipients"` // 为空时发送给订阅者本人
+	Schedule    string                 `bson:"schedule" json:"schedule"`     // daily, weekly, cron
+	Hour        int                    `bson:"hour" json:"hour"`
+	Minute      int                    `bson:"minute" json:"minute"`
+	Weekday     int                    `bson:"weekday" json:"weekday"`                 // weekly 时的星期，0 为周日
+	Cron        string                 `bson:"cron,omitempty" json:"cron,omitempty"`   // cron 时的五段式表达式
+	Timezone    string                 `bson:"timezone" json:"timezone"`               // IANA 时区，为空时使用服务器时区
+	Format      string                 `bson:"format" json:"format"`                   // pdf, images
+	Parameters  map[string]interface{} `bson:"parameters,omitempty" json:"parameters"` // 查询仪表盘使用的参数
+	Enabled     bool                   `bson:"enabled" json:"enabled"`
+	NextRunAt   time.Time              `bson:"next_run_at" json:"next_run_at"`
+	LastRunAt   *time.Time             `bson:"last_run_at,omitempty" json:"last_run_at,omitempty"`
+	LastStatus  string                 `bson:"last_status,omitempty" json:"last_status,omitempty"`
+	LastError   string                 `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedBy   primitive.ObjectID     `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time              `bson:"updated_at" json:"updated_at"`
+}
+
+// ReportDelivery 一次报表发送记录
+type ReportDelivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SubscriptionID primitive.ObjectID `bson:"subscription_id" json:"subscription_id"`
+	DashboardID    primitive.ObjectID `bson:"dashboard_id" json:"dashboard_id"`
+	Recipients     []string           `bson:"recipients" json:"recipients"`
+	Trigger        string             `bson:"trigger" json:"trigger"` // schedule, manual
+	Status         string             `bson:"status" json:"status"`   // sent, failed
+	Error          string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedBy      primitive.ObjectID `bson:"created_by" json:"created_by"`
+	StartedAt      time.Time          `bson:"started_at" json:"started_at"`
+	FinishedAt     time.Time          `bson:"finished_at" json:"finished_at"`
+}
+
+// DashboardShare 仪表盘的公开分享链接，持有 Token 的访客可以只读查看仪表盘和图表数据
+type DashboardShare struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DashboardID    primitive.ObjectID `bson:"dashboard_id" json:"dashboard_id"`
+	Token          string             `bson:"token" json:"token"`
+	PasswordHash   string             `bson:"password_hash,omitempty" json:"-"`
+	Protected      bool               `bson:"protected" json:"protected"`           // 是否需要密码
+	AllowRawData   bool               `bson:"allow_raw_data" json:"allow_raw_data"` // 是否允许访客查看图表数据源的原始数据
+	ExpiresAt      *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	RevokedAt      *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	AccessCount    int64              `bson:"access_count" json:"access_count"`
+	LastAccessedAt *time.Time         `bson:"last_accessed_at,omitempty" json:"last_accessed_at,omitempty"`
+	FailedAttempts int                `bson:"failed_attempts" json:"-"`        // 连续输错密码的次数
+	LockedUntil    *time.Time         `bson:"locked_until,omitempty" json:"-"` // 输错次数过多时暂停解锁
+	CreatedBy      primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// EmbedClaims 嵌入令牌的 Claims，由业务系统使用嵌入密钥签发。
+// Filters 和 Parameters 为锁定的取值，访客无法修改，用于行级数据限制
+type EmbedClaims struct {
+	DashboardID string                 `json:"dashboard_id"`
+	ChartID     string                 `json:"chart_id,omitempty"` // 只嵌入仪表盘上的单个图表
+	Filters     []EmbedFilter          `json:"filters,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// EmbedFilter 锁定的仪表盘筛选器取值，格式与仪表盘查询的筛选器取值相同
+type EmbedFilter struct {
+	FilterID string             `json:"filter_id"`
+	Values   []interface{}      `json:"values"`
+	Relative *RelativeDateRange `json:"relative,omitempty"`
+}
+
+// 告警条件类型
+const (
+	AlertConditionValue  = "value"  // 指标值与阈值比较
+	AlertConditionChange = "change" // 指标值相对 CompareDays 天前的变化百分比与阈值比较
+)
+
+// 告警状态
+const (
+	AlertStateOK        = "ok"
+	AlertStateTriggered = "triggered"
+	AlertStateNoData    = "no_data" // 查询结果为空或缺少对比基准
+	AlertStateError     = "error"
+)
+
+// AlertRule 图表指标的阈值告警规则
+type AlertRule struct {
+	ID              primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	ChartID         primitive.ObjectID     `bson:"chart_id" json:"chart_id"`
+	Name            string                 `bson:"name" json:"name"`
+	Metric          string                 `bson:"metric" json:"metric"`                     // 图表结果中的指标列，为空时使用第一个指标
+	Reduce          string                 `bson:"reduce" json:"reduce"`                     // 多行结果取值方式：last, first, sum, avg, min, max
+	Condition       string                 `bson:"condition" json:"condition"`               // value, change
+	Operator        string                 `bson:"operator" json:"operator"`                 // gt, gte, lt, lte
+	Threshold       float64                `bson:"threshold" json:"threshold"`               // change 时为百分比，例如 20 表示 20%
+	CompareDays     int                    `bson:"compare_days" json:"compare_days"`         // change 时对比多少天前的值，默认 7
+	Parameters      map[string]interface{} `bson:"parameters,omitempty" json:"parameters"`   // 查询图表使用的参数
+	IntervalMinutes int                    `bson:"interval_minutes" json:"interval_minutes"` // 定时检查间隔，0 表示不定时检查
+	OnRefresh       bool                   `bson:"on_refresh" json:"on_refresh"`             // 数据源更新后检查
+	Channels        AlertChannels          `bson:"channels" json:"channels"`
+	Enabled         bool                   `bson:"enabled" json:"enabled"`
+	Muted           bool                   `bson:"muted" json:"muted"`                                     // 静音后继续检查和记录，但不发送通知
+	SnoozedUntil    *time.Time             `bson:"snoozed_until,omitempty" json:"snoozed_until,omitempty"` // 暂停通知到指定时间
+	State           string                 `bson:"state" json:"state"`
+	LastValue       *float64               `bson:"last_value,omitempty" json:"last_value,omitempty"`
+	LastEvaluatedAt *time.Time             `bson:"last_evaluated_at,omitempty" json:"last_evaluated_at,omitempty"`
+	LastTriggeredAt *time.Time             `bson:"last_triggered_at,omitempty" json:"last_triggered_at,omitempty"`
+	NextEvalAt      *time.Time             `bson:"next_eval_at,omitempty" json:"next_eval_at,omitempty"`
+	CreatedBy       primitive.ObjectID     `bson:"created_by" json:"created_by"`
+	CreatedAt       time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time              `bson:"updated_at" json:"updated_at"`
+}
+
+// AlertChannels 告警的通知方式，站内通知总是发送
+type AlertChannels struct {
+	Email      bool     `bson:"email" json:"email"`
+	Recipients []string `bson:"recipients,omitempty" json:"recipients,omitempty"` // 为空时发送给规则创建者
+	WebhookURL string   `bson:"webhook_url,omitempty" json:"webhook_url,omitempty"`
+}
+
+// AlertEvent 一次告警检查的记录
+type AlertEvent struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RuleID      primitive.ObjectID `bson:"rule_id" json:"rule_id"`
+	ChartID     primitive.ObjectID `bson:"chart_id" json:"chart_id"`
+	Trigger     string             `bson:"trigger" json:"trigger"` // schedule, refresh, manual
+	State       string             `bson:"state" json:"state"`
+	Value       *float64           `bson:"value,omitempty" json:"value,omitempty"`
+	Baseline    *float64           `bson:"baseline,omitempty" json:"baseline,omitempty"` // change 条件的对比值
+	Change      *float64           `bson:"change,omitempty" json:"change,omitempty"`     // 变化百分比
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	Notified    bool               `bson:"notified" json:"notified"`
+	NotifyError string             `bson:"notify_error,omitempty" json:"notify_error,omitempty"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	EvaluatedAt time.Time          `bson:"evaluated_at" json:"evaluated_at"`
+}
+
+// 分片上传会话状态
+const (
+	UploadStatusUploading  = "uploading"
+	UploadStatusProcessing = "processing"
+	UploadStatusCompleted  = "completed"
+	UploadStatusFailed     = "failed"
+	UploadStatusAborted    = "aborted"
+)
+
+// UploadSession 分片上传会话，支持断点续传
+type UploadSession struct {
+	ID           primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	FileName     string                `bson:"file_name" json:"file_name"`
+	FileType     string                `bson:"file_type" json:"file_type"`
+	FileSize     int64                 `bson:"file_size" json:"file_size"`
+	ChunkSize    int64                 `bson:"chunk_size" json:"chunk_size"`
+	TotalParts   int                   `bson:"total_parts" json:"total_parts"`
+	Parts        map[string]UploadPart `bson:"parts" json:"parts"` // 已上传的分片，key 为分片编号
+	ObjectKey    string                `bson:"object_key" json:"-"`
+	UploadID     string                `bson:"upload_id" json:"-"`
+	Status       string                `bson:"status" json:"status"`     // uploading, processing, completed, failed, aborted
+	Progress     float64               `bson:"progress" json:"progress"` // 处理进度 0-100
+	Error        string                `bson:"error,omitempty" json:"error,omitempty"`
+	JobID        primitive.ObjectID    `bson:"job_id,omitempty" json:"job_id,omitempty"` // 合并后对应的导入任务
+	DataSourceID primitive.ObjectID    `bson:"data_source_id,omitempty" json:"data_source_id,omitempty"`
+	CreatedBy    primitive.ObjectID    `bson:"created_by" json:"created_by"`
+	CreatedAt    time.Time             `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time             `bson:"updated_at" json:"updated_at"`
+	ExpiresAt    time.Time             `bson:"expires_at" json:"expires_at"`
+}
+
+// UploadPart 已上传的分片信息
+type UploadPart struct {
+	Number int    `bson:"number" json:"number"`
+	Size   int64  `bson:"size" json:"size"`
+	ETag   string `bson:"etag" json:"-"`
+}
+
 type PreprocessingConfig struct {
 	Field      string `bson:"field" json:"field"`           // 字段名称
 	Type       string `bson:"type" json:"type"`             // 预处理类型：number/date/text
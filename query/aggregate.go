@@ -0,0 +1,153 @@
+// query/aggregate.go
+package query
+
+import (
+	"sort"
+	"strings"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// Aggregators 支持的聚合方式，值表示是否要求数值列
+var Aggregators = map[string]bool{
+	"sum":            true,
+	"avg":            true,
+	"min":            true,
+	"max":            true,
+	"median":         true,
+	"count":          false,
+	"count_distinct": false,
+}
+
+// MetricName 指标在结果中的列名，未设置别名时为 aggregator(field) 或指标表达式，
+// 有二次计算时外层再包一层计算类型，例如 cumulative_sum(sum(amount))、delta_yoy(sum(amount))
+func MetricName(m models.ChartMetric) string {
+	if m.Alias != "" {
+		return m.Alias
+	}
+	field := m.Field
+	if field == "" {
+		field = "*"
+	}
+	name := m.Aggregator + "(" + field + ")"
+	if m.Expression != "" {
+		name = strings.TrimSpace(m.Expression)
+	}
+	if c := m.Calculation; c != nil {
+		calc := c.Type
+		if c.Period != "" && c.Period != PeriodPrevious {
+			calc += "_" + c.Period
+		}
+		name = calc + "(" + name + ")"
+	}
+	return name
+}
+
+// accumulator 聚合计算的中间状态
+type accumulator interface {
+	add(row []string)
+	result() interface{}
+}
+
+// newAccumulator 按聚合方式创建累加器，col 为空表示 count(*)
+func newAccumulator(aggregator string, col *column) accumulator {
+	switch aggregator {
+	case "count":
+		return &countAcc{col: col}
+	case "count_distinct":
+		return &distinctAcc{col: col, seen: map[string]bool{}}
+	case "median":
+		return &medianAcc{col: col}
+	default:
+		return &numericAcc{col: col, kind: aggregator}
+	}
+}
+
+type countAcc struct {
+	col *column
+	n   int
+}
+
+func (a *countAcc) add(row []string) {
+	if a.col == nil || !utils.IsNullValue(a.col.cell(row)) {
+		a.n++
+	}
+}
+
+func (a *countAcc) result() interface{} { return float64(a.n) }
+
+type distinctAcc struct {
+	col  *column
+	seen map[string]bool
+}
+
+func (a *distinctAcc) add(row []string) {
+	if v := a.col.cell(row); !utils.IsNullValue(v) {
+		a.seen[v] = true
+	}
+}
+
+func (a *distinctAcc) result() interface{} { return float64(len(a.seen)) }
+
+// numericAcc sum/avg/min/max，忽略空值和无法解析为数值的单元格
+type numericAcc struct {
+	col      *column
+	kind     string
+	n        int
+	sum      float64
+	min, max float64
+}
+
+func (a *numericAcc) add(row []string) {
+	v, ok := a.col.number(row)
+	if !ok {
+		return
+	}
+	if a.n == 0 || v < a.min {
+		a.min = v
+	}
+	if a.n == 0 || v > a.max {
+		a.max = v
+	}
+	a.n++
+	a.sum += v
+}
+
+func (a *numericAcc) result() interface{} {
+	if a.n == 0 {
+		return nil
+	}
+	switch a.kind {
+	case "avg":
+		return a.sum / float64(a.n)
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	}
+	return a.sum
+}
+
+type medianAcc struct {
+	col    *column
+	values []float64
+}
+
+func (a *medianAcc) add(row []string) {
+	if v, ok := a.col.number(row); ok {
+		a.values = append(a.values, v)
+	}
+}
+
+func (a *medianAcc) result() interface{} {
+	n := len(a.values)
+	if n == 0 {
+		return nil
+	}
+	sort.Float64s(a.values)
+	if n%2 == 1 {
+		return a.values[n/2]
+	}
+	return (a.values[n/2-1] + a.values[n/2]) / 2
+}
@@ -0,0 +1,150 @@
+// query/bin.go
+package query
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"bi-backend/utils"
+)
+
+// DimensionTypeBin 数值分箱维度类型
+const DimensionTypeBin = "bin"
+
+// maxBins 分箱数量上限
+const maxBins = 1000
+
+// binner 数值分箱计算
+type binner struct {
+	width       float64
+	origin      float64
+	count       int
+	breakpoints []float64
+
+	// 按箱数分箱时根据数据范围确定
+	min, max float64
+	ready    bool
+}
+
+// compileBin 检查分箱配置
+func (d *dimension) compileBin() error {
+	b := d.def.Bin
+	if d.col.Type != utils.ColumnTypeNumber {
+		return errorf(d.def.Field, "bin dimension requires a numeric field")
+	}
+	if b == nil {
+		return errorf(d.def.Field, "bin dimension requires bin config")
+	}
+
+	modes := 0
+	if b.Width > 0 {
+		modes++
+	}
+	if b.Count > 0 {
+		modes++
+	}
+	if len(b.Breakpoints) > 0 {
+		modes++
+	}
+	if modes != 1 || b.Width < 0 || b.Count < 0 {
+		return errorf(d.def.Field, "bin requires exactly one of a positive width, count or breakpoints")
+	}
+	if b.Count > maxBins || len(b.Breakpoints) > maxBins {
+		return errorf(d.def.Field, "bin count must not exceed %d", maxBins)
+	}
+
+	breakpoints := append([]float64{}, b.Breakpoints...)
+	sort.Float64s(breakpoints)
+	d.bin = &binner{width: b.Width, origin: b.Origin, count: b.Count, breakpoints: breakpoints}
+	return nil
+}
+
+// prepare 按箱数分箱时扫描数据确定取值范围
+func (b *binner) prepare(col *column, rows [][]string) {
+	if b.count == 0 {
+		return
+	}
+	b.ready = false
+	for _, row := range rows {
+		v, ok := col.number(row)
+		if !ok {
+			continue
+		}
+		if !b.ready || v < b.min {
+			b.min = v
+		}
+		if !b.ready || v > b.max {
+			b.max = v
+		}
+		b.ready = true
+	}
+}
+
+// value 计算数值所在的箱，标签为左闭右开区间
+func (b *binner) value(v float64) dimValue {
+	var low, high float64
+	switch {
+	case b.width > 0:
+		low = math.Floor((v-b.origin)/b.width)*b.width + b.origin
+		high = low + b.width
+
+	case b.count > 0:
+		if !b.ready || b.max == b.min {
+			low, high = b.min, b.max
+			break
+		}
+		width := (b.max - b.min) / float64(b.count)
+		i := int((v - b.min) / width)
+		// 最大值归入最后一个箱
+		if i >= b.count {
+			i = b.count - 1
+		}
+		low = b.min + float64(i)*width
+		high = low + width
+		if i == b.count-1 {
+			return dimValue{Label: "[" + b.formatBound(low) + ", " + b.formatBound(b.max) + "]", Num: low, Ordered: true}
+		}
+
+	default:
+		i := sort.Search(len(b.breakpoints), func(i int) bool { return b.breakpoints[i] > v })
+		switch {
+		case i == 0:
+			return dimValue{Label: "< " + b.formatBound(b.breakpoints[0]), Num: math.Inf(-1), Ordered: true}
+		case i == len(b.breakpoints):
+			return dimValue{Label: ">= " + b.formatBound(b.breakpoints[i-1]), Num: b.breakpoints[i-1], Ordered: true}
+		}
+		low, high = b.breakpoints[i-1], b.breakpoints[i]
+	}
+	return dimValue{Label: "[" + b.formatBound(low) + ", " + b.formatBound(high) + ")", Num: low, Ordered: true}
+}
+
+// step 相邻边界的最小间距，无法确定时返回 0
+func (b *binner) step() float64 {
+	switch {
+	case b.width > 0:
+		return b.width
+	case b.count > 0:
+		return (b.max - b.min) / float64(b.count)
+	}
+	step := 0.0
+	for i := 1; i < len(b.breakpoints); i++ {
+		if d := b.breakpoints[i] - b.breakpoints[i-1]; d > 0 && (step == 0 || d < step) {
+			step = d
+		}
+	}
+	return step
+}
+
+// formatBound 格式化分箱边界，按箱宽决定保留的小数位数，既去掉浮点运算的误差，又保证相邻边界可以区分
+func (b *binner) formatBound(v float64) string {
+	decimals := 6
+	if step := b.step(); step > 0 {
+		decimals = int(math.Max(0, math.Ceil(-math.Log10(step)))) + 1
+		if decimals > 15 {
+			decimals = 15
+		}
+	}
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'f', decimals, 64), 64)
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
@@ -0,0 +1,75 @@
+// query/crossfilter.go
+package query
+
+import (
+	"fmt"
+	"time"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// ValueFilters 将图表维度上选中的取值（例如点击的柱子）转换为过滤条件。
+// 按时间粒度分组的维度转换为对应周期的日期范围，只能选择一个周期；分箱维度不支持
+func ValueFilters(dim models.ChartDimension, values []interface{}, loc *time.Location) ([]models.ChartFilter, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) == 1 && values[0] == nil {
+		return []models.ChartFilter{{Field: dim.Field, Operator: OpIsNull}}, nil
+	}
+	if dim.Type == DimensionTypeBin {
+		return nil, errorf(dim.Field, "cross-filtering on binned dimensions is not supported")
+	}
+	if dim.Grain == "" {
+		if len(values) == 1 {
+			return []models.ChartFilter{{Field: dim.Field, Operator: OpEq, Values: values}}, nil
+		}
+		return []models.ChartFilter{{Field: dim.Field, Operator: OpIn, Values: values}}, nil
+	}
+
+	if len(values) != 1 {
+		return nil, errorf(dim.Field, "only one %s can be selected on a time grain dimension", dim.Grain)
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	label := fmt.Sprint(values[0])
+	start, ok := parseGrainLabel(label, dim, loc)
+	if !ok {
+		return nil, errorf(dim.Field, "%q is not a valid %s", label, dim.Grain)
+	}
+	end := addGrain(start, dim.Grain, 1)
+	return []models.ChartFilter{
+		{Field: dim.Field, Operator: OpGte, Values: []interface{}{start.Format(time.RFC3339)}},
+		{Field: dim.Field, Operator: OpLt, Values: []interface{}{end.Format(time.RFC3339)}},
+	}, nil
+}
+
+// parseGrainLabel 将时间粒度标签还原为周期起点，是 grainLabel 的逆过程
+func parseGrainLabel(label string, dim models.ChartDimension, loc *time.Location) (time.Time, bool) {
+	var layout string
+	switch {
+	case dim.Format != "":
+		layout = utils.NormalizeDateLayout(dim.Format)
+	case dim.Grain == GrainQuarter:
+		var year, quarter int
+		if _, err := fmt.Sscanf(label, "%d-Q%d", &year, &quarter); err != nil || quarter < 1 || quarter > 4 {
+			return time.Time{}, false
+		}
+		return time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, loc), true
+	case dim.Grain == GrainHour:
+		layout = "2006-01-02 15:00"
+	case dim.Grain == GrainMonth:
+		layout = "2006-01"
+	case dim.Grain == GrainYear:
+		layout = "2006"
+	default:
+		layout = "2006-01-02"
+	}
+	t, err := time.ParseInLocation(layout, label, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return truncateTime(t, dim.Grain), true
+}
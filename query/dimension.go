@@ -0,0 +1,139 @@
+// query/dimension.go
+package query
+
+import (
+	"time"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// dimValue 维度取值，Label 用于展示和分组，Num 用于排序
+type dimValue struct {
+	Label   string
+	Num     float64 // 数值或时间戳
+	Ordered bool    // Num 是否有效
+	Null    bool
+	Other   bool // 超出 Limit 后合并的“其他”
+}
+
+// dimension 编译后的维度
+type dimension struct {
+	def  models.ChartDimension
+	col  *column
+	Type string // 结果列类型：string, number, date
+	opts *Options
+	bin  *binner // type 为 bin 时的分箱计算
+}
+
+// compileDimension 检查维度字段并确定取值方式
+func compileDimension(t *Table, d models.ChartDimension, opts *Options) (*dimension, error) {
+	col, err := t.column(d.Field)
+	if err != nil {
+		return nil, err
+	}
+
+	dim := &dimension{def: d, col: col, Type: utils.ColumnTypeString, opts: opts}
+	if d.Type == DimensionTypeBin {
+		if err := dim.compileBin(); err != nil {
+			return nil, err
+		}
+		return dim, nil
+	}
+	switch {
+	case d.Type == "date" || col.Type == utils.ColumnTypeDate:
+		dim.Type = utils.ColumnTypeDate
+	case col.Type == utils.ColumnTypeNumber:
+		dim.Type = utils.ColumnTypeNumber
+	}
+
+	if d.Grain != "" {
+		if dim.Type != utils.ColumnTypeDate {
+			return nil, errorf(d.Field, "time grain requires a date field")
+		}
+		if !validGrain(d.Grain) {
+			return nil, errorf(d.Field, "unsupported time grain %q", d.Grain)
+		}
+	}
+	switch d.Fill {
+	case "", FillZero, FillNull:
+	default:
+		return nil, errorf(d.Field, "fill must be zero or null")
+	}
+	if d.Fill != "" && d.Grain == "" {
+		return nil, errorf(d.Field, "gap filling requires a time grain")
+	}
+	return dim, nil
+}
+
+// value 计算一行数据在该维度上的取值
+func (d *dimension) value(row []string) dimValue {
+	raw := d.col.cell(row)
+	if utils.IsNullValue(raw) {
+		return dimValue{Null: true}
+	}
+
+	if d.bin != nil {
+		n, ok := d.col.number(row)
+		if !ok {
+			return dimValue{Null: true}
+		}
+		return d.bin.value(n)
+	}
+
+	v := dimValue{Label: raw}
+	switch d.Type {
+	case utils.ColumnTypeNumber:
+		v.Num, v.Ordered = d.col.number(row)
+	case utils.ColumnTypeDate:
+		t, ok := d.col.date(row, d.opts.location())
+		if !ok {
+			// 无法解析的日期归入空值，避免在时间序列中形成单独的分组
+			return dimValue{Null: true}
+		}
+		if d.def.Grain != "" {
+			return d.timeValue(truncateTime(t.In(d.opts.location()), d.def.Grain))
+		}
+		v.Num, v.Ordered = float64(t.Unix()), true
+	}
+	return v
+}
+
+// timeValue 按时间粒度生成维度取值，Format 不为空时按其格式化标签
+func (d *dimension) timeValue(t time.Time) dimValue {
+	label := grainLabel(t, d.def.Grain)
+	if d.def.Format != "" {
+		label = t.Format(utils.NormalizeDateLayout(d.def.Format))
+	}
+	return dimValue{Label: label, Num: float64(t.Unix()), Ordered: true}
+}
+
+// prepare 在分组前根据过滤后的数据初始化维度，例如按箱数分箱时的取值范围
+func (d *dimension) prepare(rows [][]string) {
+	if d.bin != nil {
+		d.bin.prepare(d.col, rows)
+	}
+}
+
+// compareDimValues 比较两个维度取值，空值和“其他”排在最后
+func compareDimValues(a, b dimValue) int {
+	switch {
+	case a.Other != b.Other:
+		if a.Other {
+			return 1
+		}
+		return -1
+	case a.Null != b.Null:
+		if a.Null {
+			return 1
+		}
+		return -1
+	case a.Ordered && b.Ordered:
+		return compareFloat(a.Num, b.Num)
+	case a.Label < b.Label:
+		return -1
+	case a.Label > b.Label:
+		return 1
+	}
+	return 0
+}
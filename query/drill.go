@@ -0,0 +1,81 @@
+// query/drill.go
+package query
+
+import (
+	"time"
+
+	"bi-backend/models"
+)
+
+// DrillLevel 下钻查询所在的层级
+type DrillLevel struct {
+	Level   int           `json:"level"`
+	Field   string        `json:"field"`
+	Grain   string        `json:"grain,omitempty"`
+	Path    []interface{} `json:"path"`
+	HasNext bool          `json:"has_next"`
+}
+
+// Drill 按下钻路径生成下一层级的图表配置：路径上每一层选中的取值转换为过滤条件，
+// 下一层级的维度替换第一个维度，其余维度（例如系列）保留
+func Drill(cfg models.ChartConfig, path []interface{}) (models.ChartConfig, *DrillLevel, error) {
+	h := cfg.Hierarchy
+	if len(h) == 0 {
+		return cfg, nil, errorf("hierarchy", "chart has no drill hierarchy")
+	}
+	if len(path) >= len(h) {
+		return cfg, nil, errorf("path", "drill path is deeper than the hierarchy (%d levels)", len(h))
+	}
+	var loc *time.Location
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return cfg, nil, errorf("timezone", "unknown timezone %q", cfg.Timezone)
+		}
+	}
+
+	out := cfg
+	out.Filters = append([]models.ChartFilter{}, cfg.Filters...)
+	for i, v := range path {
+		filters, err := ValueFilters(h[i], []interface{}{v}, loc)
+		if err != nil {
+			return cfg, nil, err
+		}
+		out.Filters = append(out.Filters, filters...)
+	}
+
+	level := h[len(path)]
+	out.Dimensions = []models.ChartDimension{level}
+	if len(cfg.Dimensions) > 1 {
+		out.Dimensions = append(out.Dimensions, cfg.Dimensions[1:]...)
+	}
+
+	// 排序规则中不再出现在维度里的层级字段需要去掉，指标排序保留
+	dropped := map[string]bool{}
+	for _, d := range h {
+		dropped[d.Field] = true
+	}
+	if len(cfg.Dimensions) > 0 {
+		dropped[cfg.Dimensions[0].Field] = true
+	}
+	for _, d := range out.Dimensions {
+		delete(dropped, d.Field)
+	}
+	out.Sort = nil
+	for _, s := range cfg.Sort {
+		if !dropped[s.Field] {
+			out.Sort = append(out.Sort, s)
+		}
+	}
+
+	if path == nil {
+		path = []interface{}{}
+	}
+	return out, &DrillLevel{
+		Level:   len(path),
+		Field:   level.Field,
+		Grain:   level.Grain,
+		Path:    path,
+		HasNext: len(path)+1 < len(h),
+	}, nil
+}
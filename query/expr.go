@@ -0,0 +1,425 @@
+// query/expr.go
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// exprNode 编译后的表达式节点，row 为数据行，aggs 为指标表达式中各聚合的结果，ok 为 false 表示空值
+type exprNode func(row []string, aggs []interface{}) (v float64, ok bool)
+
+// expression 编译后的数值表达式，支持 + - * /、括号、abs/round/coalesce 函数和 {{参数}}，
+// 指标表达式中还可以使用 sum(field)、count(*) 等聚合函数
+type expression struct {
+	root exprNode
+	aggs []*metric // 指标表达式引用的聚合
+}
+
+// exprParser 表达式解析器，复用 SQL 的词法分析
+type exprParser struct {
+	*sqlParser
+	table  *Table
+	field  string // 出错时报告的字段
+	metric bool   // 是否为指标表达式
+	params map[string]interface{}
+	aggs   []*metric
+}
+
+// compileExpression 编译数值表达式，metric 为 true 时只能通过聚合函数引用列
+func compileExpression(t *Table, field, text string, metric bool, params map[string]interface{}) (*expression, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, errorf(field, "invalid expression: %s", err.(*Error).Message)
+	}
+	p := &exprParser{sqlParser: &sqlParser{tokens: tokens}, table: t, field: field, metric: metric, params: params}
+	root, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q at position %d", tok.text, tok.pos+1)
+	}
+	return &expression{root: root, aggs: p.aggs}, nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) *Error {
+	return errorf(p.field, "invalid expression: %s", fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) sum() (exprNode, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.acceptSymbol("+"):
+			op = "+"
+		case p.acceptSymbol("-"):
+			op = "-"
+		default:
+			return left, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode(op, left, right)
+	}
+}
+
+func (p *exprParser) product() (exprNode, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.acceptSymbol("*"):
+			op = "*"
+		case p.acceptSymbol("/"):
+			op = "/"
+		default:
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode(op, left, right)
+	}
+}
+
+// binaryNode 四则运算，任一操作数为空或除数为 0 时结果为空
+func binaryNode(op string, left, right exprNode) exprNode {
+	return func(row []string, aggs []interface{}) (float64, bool) {
+		a, ok := left(row, aggs)
+		if !ok {
+			return 0, false
+		}
+		b, ok := right(row, aggs)
+		if !ok {
+			return 0, false
+		}
+		switch op {
+		case "+":
+			return a + b, true
+		case "-":
+			return a - b, true
+		case "*":
+			return a * b, true
+		}
+		if b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.acceptSymbol("-") {
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(row []string, aggs []interface{}) (float64, bool) {
+			v, ok := inner(row, aggs)
+			return -v, ok
+		}, nil
+	}
+	return p.primary()
+}
+
+func constNode(v float64) exprNode {
+	return func([]string, []interface{}) (float64, bool) { return v, true }
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokNumber:
+		p.next()
+		v, _ := strconv.ParseFloat(t.text, 64)
+		return constNode(v), nil
+
+	case p.acceptSymbol("("):
+		inner, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptSymbol(")") {
+			return nil, p.errorf("missing )")
+		}
+		return inner, nil
+
+	case p.acceptSymbol("{"):
+		if !p.acceptSymbol("{") {
+			return nil, p.errorf("parameters are written as {{name}}")
+		}
+		name := p.next()
+		if (name.kind != tokIdent && name.kind != tokQuotedIdent) || !p.acceptSymbol("}") || !p.acceptSymbol("}") {
+			return nil, p.errorf("parameters are written as {{name}}")
+		}
+		v, err := p.parameter(name.text)
+		if err != nil {
+			return nil, err
+		}
+		return constNode(v), nil
+
+	case t.kind == tokIdent && p.tokens[p.pos+1].kind == tokSymbol && p.tokens[p.pos+1].text == "(":
+		p.pos += 2
+		return p.function(strings.ToLower(t.text))
+
+	case t.kind == tokIdent || t.kind == tokQuotedIdent:
+		p.next()
+		if p.metric {
+			return nil, p.errorf("column %s must be used inside an aggregate function", t.text)
+		}
+		col, err := p.table.column(t.text)
+		if err != nil {
+			return nil, err
+		}
+		if col.Type != utils.ColumnTypeNumber {
+			return nil, p.errorf("column %s is not numeric", t.text)
+		}
+		return func(row []string, _ []interface{}) (float64, bool) { return col.number(row) }, nil
+	}
+	return nil, p.errorf("unexpected %q at position %d", t.text, t.pos+1)
+}
+
+// parameter 参数值转换为数值
+func (p *exprParser) parameter(name string) (float64, error) {
+	v, ok := p.params[name]
+	if !ok || v == nil {
+		return 0, p.errorf("parameter %s has no value", name)
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case string:
+		if f, ok := utils.ParseNumber(n); ok {
+			return f, nil
+		}
+	}
+	return 0, p.errorf("parameter %s is not a number", name)
+}
+
+// args 解析函数参数直到右括号
+func (p *exprParser) args() ([]exprNode, error) {
+	var args []exprNode
+	if p.acceptSymbol(")") {
+		return args, nil
+	}
+	for {
+		arg, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.acceptSymbol(")") {
+			return args, nil
+		}
+		if !p.acceptSymbol(",") {
+			return nil, p.errorf("missing )")
+		}
+	}
+}
+
+func (p *exprParser) function(name string) (exprNode, error) {
+	if _, ok := Aggregators[name]; ok {
+		return p.aggregate(name)
+	}
+
+	args, err := p.args()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "abs":
+		if len(args) != 1 {
+			return nil, p.errorf("abs takes one argument")
+		}
+		return func(row []string, aggs []interface{}) (float64, bool) {
+			v, ok := args[0](row, aggs)
+			return math.Abs(v), ok
+		}, nil
+	case "round":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, p.errorf("round takes one or two arguments")
+		}
+		return func(row []string, aggs []interface{}) (float64, bool) {
+			v, ok := args[0](row, aggs)
+			if !ok {
+				return 0, false
+			}
+			digits := 0.0
+			if len(args) == 2 {
+				if digits, ok = args[1](row, aggs); !ok {
+					return 0, false
+				}
+			}
+			scale := math.Pow(10, math.Round(digits))
+			return math.Round(v*scale) / scale, true
+		}, nil
+	case "coalesce":
+		if len(args) == 0 {
+			return nil, p.errorf("coalesce takes at least one argument")
+		}
+		return func(row []string, aggs []interface{}) (float64, bool) {
+			for _, arg := range args {
+				if v, ok := arg(row, aggs); ok {
+					return v, true
+				}
+			}
+			return 0, false
+		}, nil
+	}
+	return nil, p.errorf("unsupported function %s", name)
+}
+
+// aggregate 指标表达式中的聚合函数，例如 sum(amount)、count(*)
+func (p *exprParser) aggregate(name string) (exprNode, error) {
+	if !p.metric {
+		return nil, p.errorf("aggregate function %s is not allowed in a calculated column", name)
+	}
+	field := ""
+	if !p.acceptSymbol("*") {
+		t := p.next()
+		if t.kind != tokIdent && t.kind != tokQuotedIdent {
+			return nil, p.errorf("%s requires a column name", name)
+		}
+		field = t.text
+	}
+	if !p.acceptSymbol(")") {
+		return nil, p.errorf("missing )")
+	}
+
+	m, err := compileMetric(p.table, models.ChartMetric{Field: field, Aggregator: name}, nil)
+	if err != nil {
+		return nil, err
+	}
+	index := len(p.aggs)
+	p.aggs = append(p.aggs, m)
+	return func(_ []string, aggs []interface{}) (float64, bool) {
+		v, ok := aggs[index].(float64)
+		return v, ok
+	}, nil
+}
+
+// exprAcc 指标表达式的累加器，分别累加引用的聚合后计算表达式
+type exprAcc struct {
+	expr *expression
+	accs []accumulator
+}
+
+func (a *exprAcc) add(row []string) {
+	for _, acc := range a.accs {
+		acc.add(row)
+	}
+}
+
+func (a *exprAcc) result() interface{} {
+	values := make([]interface{}, len(a.accs))
+	for i, acc := range a.accs {
+		values[i] = acc.result()
+	}
+	if v, ok := a.expr.root(nil, values); ok && !math.IsInf(v, 0) && !math.IsNaN(v) {
+		return v
+	}
+	return nil
+}
+
+// withCalculatedColumns 返回追加了计算列的数据表，计算列可以引用之前定义的计算列
+func (t *Table) withCalculatedColumns(ctx context.Context, defs []models.CalculatedColumn, opts *Options) (*Table, error) {
+	if len(defs) == 0 {
+		return t, nil
+	}
+
+	out := &Table{
+		Headers:       append([]string{}, t.Headers...),
+		Schema:        append([]models.ColumnSchema{}, t.Schema...),
+		Preprocessing: t.Preprocessing,
+	}
+	exprs := make([]*expression, len(defs))
+	for i, def := range defs {
+		if def.Name == "" {
+			return nil, errorf("calculatedColumns", "calculated column name is required")
+		}
+		if _, err := out.column(def.Name); err == nil {
+			return nil, errorf(def.Name, "calculated column conflicts with an existing field")
+		}
+		expr, err := compileExpression(out, def.Name, def.Expression, false, opts.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = expr
+		out.Headers = append(out.Headers, def.Name)
+		out.Schema = append(out.Schema, models.ColumnSchema{Name: def.Name, Type: utils.ColumnTypeNumber, Nullable: true})
+	}
+
+	width := len(t.Headers)
+	out.Rows = make([][]string, len(t.Rows))
+	for i, row := range t.Rows {
+		if i%checkEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		extended := make([]string, width+len(defs))
+		copy(extended, row)
+		for j, expr := range exprs {
+			if v, ok := expr.root(extended, nil); ok && !math.IsInf(v, 0) && !math.IsNaN(v) {
+				extended[width+j] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		out.Rows[i] = extended
+	}
+	return out, nil
+}
+
+// parameterRef 过滤值中对参数的引用
+var parameterRef = regexp.MustCompile(`^\{\{\s*([^{}\s]+)\s*\}\}$`)
+
+// bindParameters 将过滤值中的 {{name}} 替换为参数值，多选参数展开为多个值；
+// 引用了未赋值参数的过滤条件被忽略，例如参数为空时不按地区过滤
+func bindParameters(filters []models.ChartFilter, params map[string]interface{}) []models.ChartFilter {
+	out := make([]models.ChartFilter, 0, len(filters))
+	for _, f := range filters {
+		bound, skip := f, false
+		bound.Values = nil
+		for _, v := range f.Values {
+			s, ok := v.(string)
+			m := parameterRef.FindStringSubmatch(strings.TrimSpace(s))
+			if !ok || m == nil {
+				bound.Values = append(bound.Values, v)
+				continue
+			}
+			value, ok := params[m[1]]
+			if list, isList := value.([]interface{}); isList && len(list) > 0 {
+				bound.Values = append(bound.Values, list...)
+			} else if n, isNumber := value.(float64); isNumber {
+				bound.Values = append(bound.Values, strconv.FormatFloat(n, 'f', -1, 64))
+			} else if ok && value != nil && !isList && value != "" {
+				bound.Values = append(bound.Values, value)
+			} else {
+				skip = true
+			}
+		}
+		if !skip {
+			out = append(out, bound)
+		}
+	}
+	return out
+}
@@ -0,0 +1,178 @@
+// query/expr_test.go
+package query
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// exprTestTable 表达式测试使用的数据表，price/qty/discount 为数值列，name 为文本列
+func exprTestTable() *Table {
+	return NewTable(&models.DataSource{
+		Headers: []string{"price", "qty", "discount", "name"},
+		Content: [][]string{{"10", "3", "", "apple"}},
+		Schema: []models.ColumnSchema{
+			{Name: "price", Type: utils.ColumnTypeNumber},
+			{Name: "qty", Type: utils.ColumnTypeNumber},
+			{Name: "discount", Type: utils.ColumnTypeNumber},
+			{Name: "name", Type: utils.ColumnTypeString},
+		},
+	})
+}
+
+func TestCalculatedColumnExpression(t *testing.T) {
+	params := map[string]interface{}{"rate": 0.5, "bonus": "2", "count": 4}
+	tests := []struct {
+		name string
+		expr string
+		row  []string
+		want float64
+		null bool
+	}{
+		{"constant", "42", nil, 42, false},
+		{"precedence", "1 + 2 * 3", nil, 7, false},
+		{"parentheses", "(1 + 2) * 3", nil, 9, false},
+		{"left associative", "10 - 4 - 3", nil, 3, false},
+		{"division", "7 / 2", nil, 3.5, false},
+		{"unary minus", "-price + 1", []string{"10", "3", "", ""}, -9, false},
+		{"double unary minus", "- -price", []string{"10", "3", "", ""}, 10, false},
+		{"minus negative literal", "qty - -2", []string{"10", "3", "", ""}, 5, false},
+		{"columns", "price * qty", []string{"10", "3", "", ""}, 30, false},
+		{"quoted column", "\"price\" * 2", []string{"10", "3", "", ""}, 20, false},
+		{"padded number", "price + 1", []string{" 1000 ", "3", "", ""}, 1001, false},
+		{"scientific literal", "1e-3 * 1000", nil, 1, false},
+		{"abs", "abs(-2.5)", nil, 2.5, false},
+		{"round", "round(2.5)", nil, 3, false},
+		{"round digits", "round(price / qty, 2)", []string{"10", "3", "", ""}, 3.33, false},
+		{"coalesce", "coalesce(discount, 0) + price", []string{"10", "3", "", ""}, 10, false},
+		{"coalesce first value", "coalesce(discount, 0)", []string{"10", "3", "1.5", ""}, 1.5, false},
+		{"parameter", "price * {{rate}}", []string{"10", "3", "", ""}, 5, false},
+		{"string parameter", "{{bonus}} + 1", nil, 3, false},
+		{"int parameter", "{{count}} * 2", nil, 8, false},
+		{"null column", "discount * 2", []string{"10", "3", "", ""}, 0, true},
+		{"null marker", "discount + 1", []string{"10", "3", "N/A", ""}, 0, true},
+		{"missing cell", "qty + 1", []string{"10"}, 0, true},
+		{"division by zero", "price / 0", []string{"10", "3", "", ""}, 0, true},
+		{"division by zero column", "price / discount", []string{"10", "3", "0", ""}, 0, true},
+		{"null propagates through abs", "abs(discount)", []string{"10", "3", "", ""}, 0, true},
+		{"coalesce all null", "coalesce(discount)", []string{"10", "3", "", ""}, 0, true},
+	}
+
+	table := exprTestTable()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := compileExpression(table, "calc", tt.expr, false, params)
+			if err != nil {
+				t.Fatalf("compileExpression(%q) error: %v", tt.expr, err)
+			}
+			v, ok := e.root(tt.row, nil)
+			if ok == tt.null {
+				t.Fatalf("%q: ok = %v, want %v", tt.expr, ok, !tt.null)
+			}
+			if ok && math.Abs(v-tt.want) > 1e-9 {
+				t.Errorf("%q = %v, want %v", tt.expr, v, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricExpression(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		names []string      // 引用的聚合
+		aggs  []interface{} // 聚合结果
+		want  float64
+		null  bool
+	}{
+		{"ratio", "sum(price) / count(*)", []string{"sum(price)", "count(*)"}, []interface{}{30.0, 3.0}, 10, false},
+		{"margin", "(sum(price) - sum(qty)) / sum(price) * 100", []string{"sum(price)", "sum(qty)", "sum(price)"},
+			[]interface{}{50.0, 10.0, 50.0}, 80, false},
+		{"quoted column", "max(\"price\") - min(price)", []string{"max(price)", "min(price)"}, []interface{}{9.0, 2.0}, 7, false},
+		{"function of aggregate", "round(avg(qty), 1)", []string{"avg(qty)"}, []interface{}{2.345}, 2.3, false},
+		{"empty aggregate", "sum(discount) + 1", []string{"sum(discount)"}, []interface{}{nil}, 0, true},
+		{"zero denominator", "sum(price) / sum(qty)", []string{"sum(price)", "sum(qty)"}, []interface{}{5.0, 0.0}, 0, true},
+	}
+
+	table := exprTestTable()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := compileExpression(table, "metric", tt.expr, true, nil)
+			if err != nil {
+				t.Fatalf("compileExpression(%q) error: %v", tt.expr, err)
+			}
+			var names []string
+			for _, m := range e.aggs {
+				names = append(names, m.name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.names, ",") {
+				t.Errorf("aggregates = %q, want %q", names, tt.names)
+			}
+			v, ok := e.root(nil, tt.aggs)
+			if ok == tt.null {
+				t.Fatalf("%q: ok = %v, want %v", tt.expr, ok, !tt.null)
+			}
+			if ok && math.Abs(v-tt.want) > 1e-9 {
+				t.Errorf("%q = %v, want %v", tt.expr, v, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	params := map[string]interface{}{"label": "abc", "empty": nil}
+	tests := []struct {
+		name   string
+		expr   string
+		metric bool
+		want   string
+	}{
+		{"empty", "", false, "unexpected \"\""},
+		{"trailing operator", "price +", false, "unexpected \"\""},
+		{"missing operand", "* price", false, "unexpected \"*\""},
+		{"missing operator", "price qty", false, "unexpected \"qty\" at position 7"},
+		{"unclosed parenthesis", "(price + 1", false, "missing )"},
+		{"extra parenthesis", "price + 1)", false, "unexpected \")\""},
+		{"unclosed function", "abs(price", false, "missing )"},
+		{"invalid character", "price % 2", false, "unexpected character '%'"},
+		{"unterminated quote", "\"price * 2", false, "unterminated quoted text"},
+		{"string literal", "price + 'a'", false, "unexpected \"a\""},
+		{"unknown column", "cost * 2", false, "field does not exist"},
+		{"text column", "name + 1", false, "column name is not numeric"},
+		{"unknown function", "sqrt(price)", false, "unsupported function sqrt"},
+		{"abs arity", "abs(price, qty)", false, "abs takes one argument"},
+		{"round arity", "round()", false, "round takes one or two arguments"},
+		{"coalesce arity", "coalesce()", false, "coalesce takes at least one argument"},
+		{"aggregate in calculated column", "sum(price)", false, "aggregate function sum is not allowed in a calculated column"},
+		{"bare column in metric", "sum(price) / qty", true, "column qty must be used inside an aggregate function"},
+		{"aggregate without column", "sum()", true, "sum requires a column name"},
+		{"aggregate of expression", "sum(price * 2)", true, "missing )"},
+		{"aggregate of text column", "sum(name)", true, "requires a numeric field"},
+		{"missing parameter", "{{rate}} * 2", false, "parameter rate has no value"},
+		{"null parameter", "{{empty}} * 2", false, "parameter empty has no value"},
+		{"text parameter", "{{label}} * 2", false, "parameter label is not a number"},
+		{"single brace parameter", "{rate} * 2", false, "parameters are written as {{name}}"},
+		{"unclosed parameter", "{{rate} * 2", false, "parameters are written as {{name}}"},
+	}
+
+	table := exprTestTable()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileExpression(table, "calc", tt.expr, tt.metric, params)
+			if err == nil {
+				t.Fatalf("compileExpression(%q) succeeded, want error containing %q", tt.expr, tt.want)
+			}
+			qerr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("error type = %T, want *Error", err)
+			}
+			if !strings.Contains(qerr.Message, tt.want) {
+				t.Errorf("error = %q, want it to contain %q", qerr.Message, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// query/filter.go
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// 过滤操作符
+const (
+	OpEq          = "eq"
+	OpNeq         = "neq"
+	OpIn          = "in"
+	OpNotIn       = "not_in"
+	OpGt          = "gt"
+	OpGte         = "gte"
+	OpLt          = "lt"
+	OpLte         = "lte"
+	OpBetween     = "between"
+	OpContains    = "contains"
+	OpNotContains = "not_contains"
+	OpStartsWith  = "starts_with"
+	OpEndsWith    = "ends_with"
+	OpIsNull      = "is_null"
+	OpNotNull     = "not_null"
+	OpRelative    = "relative"
+)
+
+// predicate 判断一行数据是否满足条件
+type predicate func(row []string) bool
+
+// compileFilters 编译过滤条件，多个条件之间为且的关系
+func compileFilters(t *Table, filters []models.ChartFilter, opts *Options) (predicate, error) {
+	preds := make([]predicate, 0, len(filters))
+	for _, f := range filters {
+		p, err := compileFilter(t, f, opts)
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, p)
+	}
+	if len(preds) == 0 {
+		return nil, nil
+	}
+	return func(row []string) bool {
+		for _, p := range preds {
+			if !p(row) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// filterValues 将过滤值统一转换为字符串
+func filterValues(values []interface{}) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		out = append(out, fmt.Sprint(v))
+	}
+	return out
+}
+
+func compileFilter(t *Table, f models.ChartFilter, opts *Options) (predicate, error) {
+	col, err := t.column(f.Field)
+	if err != nil {
+		return nil, err
+	}
+	values := filterValues(f.Values)
+	loc := opts.location()
+
+	switch f.Operator {
+	case OpIsNull:
+		return func(row []string) bool { return utils.IsNullValue(col.cell(row)) }, nil
+	case OpNotNull:
+		return func(row []string) bool { return !utils.IsNullValue(col.cell(row)) }, nil
+
+	case OpRelative:
+		if f.Relative == nil || !validGrain(f.Relative.Unit) || f.Relative.Unit == GrainHour {
+			return nil, errorf(f.Field, "relative filter requires unit day, week, month, quarter or year")
+		}
+		start, end := RelativeRange(*f.Relative, opts.now().In(loc))
+		return func(row []string) bool {
+			d, ok := col.date(row, loc)
+			return ok && !d.Before(start) && d.Before(end)
+		}, nil
+
+	case OpContains, OpNotContains, OpStartsWith, OpEndsWith:
+		if len(values) != 1 {
+			return nil, errorf(f.Field, "operator %s requires exactly one value", f.Operator)
+		}
+		needle := strings.ToLower(values[0])
+		match := map[string]func(string, string) bool{
+			OpContains:    strings.Contains,
+			OpNotContains: strings.Contains,
+			OpStartsWith:  strings.HasPrefix,
+			OpEndsWith:    strings.HasSuffix,
+		}[f.Operator]
+		negate := f.Operator == OpNotContains
+		return func(row []string) bool {
+			v := col.cell(row)
+			if utils.IsNullValue(v) {
+				return false
+			}
+			return match(strings.ToLower(v), needle) != negate
+		}, nil
+
+	case OpEq, OpNeq, OpIn, OpNotIn:
+		if len(values) == 0 || ((f.Operator == OpEq || f.Operator == OpNeq) && len(values) != 1) {
+			return nil, errorf(f.Field, "operator %s requires values", f.Operator)
+		}
+		comparers, err := col.comparers(values, loc)
+		if err != nil {
+			return nil, err
+		}
+		negate := f.Operator == OpNeq || f.Operator == OpNotIn
+		return func(row []string) bool {
+			for _, cmp := range comparers {
+				c, ok := cmp(row)
+				if !ok {
+					return false
+				}
+				if c == 0 {
+					return !negate
+				}
+			}
+			return negate
+		}, nil
+
+	case OpGt, OpGte, OpLt, OpLte:
+		if len(values) != 1 {
+			return nil, errorf(f.Field, "operator %s requires exactly one value", f.Operator)
+		}
+		comparers, err := col.comparers(values, loc)
+		if err != nil {
+			return nil, err
+		}
+		cmp, op := comparers[0], f.Operator
+		return func(row []string) bool {
+			c, ok := cmp(row)
+			if !ok {
+				return false
+			}
+			switch op {
+			case OpGt:
+				return c > 0
+			case OpGte:
+				return c >= 0
+			case OpLt:
+				return c < 0
+			default:
+				return c <= 0
+			}
+		}, nil
+
+	case OpBetween:
+		if len(values) != 2 {
+			return nil, errorf(f.Field, "operator between requires two values")
+		}
+		comparers, err := col.comparers(values, loc)
+		if err != nil {
+			return nil, err
+		}
+		return func(row []string) bool {
+			low, ok := comparers[0](row)
+			if !ok || low < 0 {
+				return false
+			}
+			high, ok := comparers[1](row)
+			return ok && high <= 0
+		}, nil
+	}
+
+	return nil, errorf(f.Field, "unsupported filter operator %q", f.Operator)
+}
+
+// comparer 比较单元格与过滤值，单元格为空或无法解析时 ok 为 false
+type comparer func(row []string) (c int, ok bool)
+
+// comparers 按列类型为每个过滤值生成比较函数
+func (c *column) comparers(values []string, loc *time.Location) ([]comparer, error) {
+	out := make([]comparer, 0, len(values))
+	for _, value := range values {
+		switch c.Type {
+		case utils.ColumnTypeNumber:
+			target, ok := utils.ParseNumber(value)
+			if !ok {
+				return nil, errorf(c.Name, "%q is not a number", value)
+			}
+			out = append(out, func(row []string) (int, bool) {
+				v, ok := c.number(row)
+				return compareFloat(v, target), ok
+			})
+		case utils.ColumnTypeDate:
+			target, ok := utils.ParseDate(value, "", loc)
+			if !ok {
+				return nil, errorf(c.Name, "%q is not a date", value)
+			}
+			out = append(out, func(row []string) (int, bool) {
+				v, ok := c.date(row, loc)
+				return v.Compare(target), ok
+			})
+		default:
+			target := value
+			out = append(out, func(row []string) (int, bool) {
+				v := c.cell(row)
+				if utils.IsNullValue(v) {
+					return 0, false
+				}
+				return strings.Compare(v, target), true
+			})
+		}
+	}
+	return out, nil
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// RelativeRange 计算相对日期范围，返回左闭右开区间
+func RelativeRange(r models.RelativeDateRange, now time.Time) (start, end time.Time) {
+	last := r.Last
+	if last < 1 {
+		last = 1
+	}
+	current := truncateTime(now, r.Unit)
+	if r.IncludeCurrent {
+		end = addGrain(current, r.Unit, 1)
+		return addGrain(current, r.Unit, 1-last), end
+	}
+	return addGrain(current, r.Unit, -last), current
+}
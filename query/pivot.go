@@ -0,0 +1,304 @@
+// query/pivot.go
+package query
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"bi-backend/models"
+)
+
+// 透视表比例显示方式
+const (
+	PercentOfRow    = "row"
+	PercentOfColumn = "column"
+	PercentOfTotal  = "total"
+)
+
+// maxPivotColumns 透视表允许的最大列数
+const maxPivotColumns = 1000
+
+// PivotHeader 透视表的行头或列头，小计和总计只包含前 Level 个维度的取值
+type PivotHeader struct {
+	Values []interface{} `json:"values"`
+	Level  int           `json:"level"`
+	Total  bool          `json:"total"` // 小计或总计
+}
+
+// PivotRow 透视表的一行，Cells 与 Columns 一一对应，每个单元格包含各指标的值
+type PivotRow struct {
+	PivotHeader
+	Cells [][]interface{} `json:"cells"`
+}
+
+// PivotResult 透视表查询结果，Percent 不为空时单元格的值为 0-1 的比例
+type PivotResult struct {
+	RowFields    []string      `json:"row_fields"`
+	ColumnFields []string      `json:"column_fields"`
+	Metrics      []string      `json:"metrics"`
+	Percent      string        `json:"percent,omitempty"`
+	Columns      []PivotHeader `json:"columns"`
+	Rows         []PivotRow    `json:"rows"`
+	Truncated    bool          `json:"truncated"`
+}
+
+// pivotEntry 按层级排列后的行头或列头
+type pivotEntry struct {
+	values []dimValue
+	level  int
+}
+
+// dimKey 维度取值组合的分组键
+func dimKey(values []dimValue) string {
+	var b strings.Builder
+	for _, v := range values {
+		if v.Null {
+			b.WriteString("\x01")
+		}
+		b.WriteString(v.Label)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// hierarchy 将完整的维度组合排序，并在每组之后插入小计，最后追加总计
+func hierarchy(full [][]dimValue, depth int, subtotals, grandTotal bool) []pivotEntry {
+	sort.SliceStable(full, func(i, j int) bool {
+		for k := range full[i] {
+			if c := compareDimValues(full[i][k], full[j][k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	entries := make([]pivotEntry, 0, len(full))
+	for i, cur := range full {
+		entries = append(entries, pivotEntry{values: cur, level: depth})
+		if !subtotals {
+			continue
+		}
+		for level := depth - 1; level >= 1; level-- {
+			if i+1 < len(full) && dimKey(full[i+1][:level]) == dimKey(cur[:level]) {
+				break
+			}
+			entries = append(entries, pivotEntry{values: cur[:level], level: level})
+		}
+	}
+	if grandTotal {
+		entries = append(entries, pivotEntry{level: 0})
+	}
+	return entries
+}
+
+func (e pivotEntry) header(depth int) PivotHeader {
+	h := PivotHeader{Values: make([]interface{}, len(e.values)), Level: e.level, Total: e.level < depth}
+	for i, v := range e.values {
+		if !v.Null {
+			h.Values[i] = v.Label
+		}
+	}
+	return h
+}
+
+// checkPivot 检查透视表配置的结构，不涉及字段是否存在
+func checkPivot(cfg models.ChartConfig) *Error {
+	p := cfg.Pivot
+	if p == nil {
+		return errorf("pivot", "pivot config is required")
+	}
+	if len(p.Rows)+len(p.Columns) == 0 {
+		return errorf("pivot", "pivot requires at least one row or column dimension")
+	}
+	if len(cfg.Metrics) == 0 {
+		return errorf("metrics", "pivot requires at least one metric")
+	}
+	for _, m := range cfg.Metrics {
+		if m.Calculation != nil {
+			return errorf(m.Field, "metric calculations are not supported in pivot tables")
+		}
+	}
+	switch p.Percent {
+	case "", PercentOfRow, PercentOfColumn, PercentOfTotal:
+	default:
+		return errorf("pivot", "percent must be row, column or total")
+	}
+	return nil
+}
+
+// Pivot 按透视表配置计算交叉表，小计和总计直接按原始数据聚合，非可加指标同样准确
+func Pivot(ctx context.Context, t *Table, cfg models.ChartConfig, opts Options) (*PivotResult, error) {
+	if err := checkPivot(cfg); err != nil {
+		return nil, err
+	}
+	p := cfg.Pivot
+	if err := applyTimezone(cfg, &opts); err != nil {
+		return nil, err
+	}
+	t, err := t.withCalculatedColumns(ctx, cfg.CalculatedColumns, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	base := cfg
+	base.Dimensions = nil
+	q, err := compile(t, base, &opts)
+	if err != nil {
+		return nil, err
+	}
+	compileDims := func(defs []models.ChartDimension) ([]*dimension, error) {
+		dims := make([]*dimension, 0, len(defs))
+		for _, d := range defs {
+			dim, err := compileDimension(t, d, &opts)
+			if err != nil {
+				return nil, err
+			}
+			dims = append(dims, dim)
+		}
+		return dims, nil
+	}
+	rowDims, err := compileDims(p.Rows)
+	if err != nil {
+		return nil, err
+	}
+	colDims, err := compileDims(p.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.filterRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range append(append([]*dimension{}, rowDims...), colDims...) {
+		d.prepare(rows)
+	}
+
+	// 需要计算的行、列层级，比例需要用到总计
+	levels := func(depth int, subtotals, grand bool) []int {
+		out := []int{depth}
+		if subtotals {
+			for l := depth - 1; l >= 1; l-- {
+				out = append(out, l)
+			}
+		}
+		if depth > 0 && (grand || p.Percent != "") {
+			out = append(out, 0)
+		}
+		return out
+	}
+	rowLevels := levels(len(rowDims), p.Subtotals, p.GrandTotals)
+	colLevels := levels(len(colDims), p.Subtotals, p.GrandTotals)
+
+	// cells[行层级][列层级][行键][列键] = 指标值
+	type cellKey struct{ row, col int }
+	cells := map[cellKey]map[string]map[string][]interface{}{}
+	var fullRows, fullCols [][]dimValue
+	for _, rl := range rowLevels {
+		for _, cl := range colLevels {
+			dims := append(append([]*dimension{}, rowDims[:rl]...), colDims[:cl]...)
+			groups, err := q.aggregate(ctx, rows, dims, nil)
+			if err != nil {
+				return nil, err
+			}
+			table := map[string]map[string][]interface{}{}
+			for _, g := range groups {
+				rk, ck := dimKey(g.dims[:rl]), dimKey(g.dims[rl:])
+				if table[rk] == nil {
+					table[rk] = map[string][]interface{}{}
+				}
+				table[rk][ck] = g.metrics
+			}
+			cells[cellKey{rl, cl}] = table
+
+			// 完整的行、列组合从只含行维度或列维度的聚合中取得
+			if rl == len(rowDims) && cl == 0 {
+				for _, g := range groups {
+					fullRows = append(fullRows, g.dims)
+				}
+			}
+			if rl == 0 && cl == len(colDims) {
+				for _, g := range groups {
+					fullCols = append(fullCols, g.dims)
+				}
+			}
+		}
+	}
+	if len(rowDims) == 0 {
+		fullRows = [][]dimValue{{}}
+	}
+	if len(colDims) == 0 {
+		fullCols = [][]dimValue{{}}
+	}
+	if len(fullCols) > maxPivotColumns {
+		return nil, errorf("pivot", "pivot has %d columns, the limit is %d", len(fullCols), maxPivotColumns)
+	}
+
+	rowEntries := hierarchy(fullRows, len(rowDims), p.Subtotals, p.GrandTotals && len(rowDims) > 0)
+	colEntries := hierarchy(fullCols, len(colDims), p.Subtotals, p.GrandTotals && len(colDims) > 0)
+
+	lookup := func(r, c pivotEntry) []interface{} {
+		return cells[cellKey{r.level, c.level}][dimKey(r.values)][dimKey(c.values)]
+	}
+	rowTotal := pivotEntry{level: 0}
+	colTotal := pivotEntry{level: 0}
+
+	res := &PivotResult{Percent: p.Percent, Rows: []PivotRow{}}
+	for _, d := range rowDims {
+		res.RowFields = append(res.RowFields, d.def.Field)
+	}
+	for _, d := range colDims {
+		res.ColumnFields = append(res.ColumnFields, d.def.Field)
+	}
+	for _, m := range q.metrics {
+		res.Metrics = append(res.Metrics, m.name)
+	}
+	for _, c := range colEntries {
+		res.Columns = append(res.Columns, c.header(len(colDims)))
+	}
+
+	for _, r := range rowEntries {
+		if opts.MaxRows > 0 && len(res.Rows) >= opts.MaxRows {
+			res.Truncated = true
+			break
+		}
+		row := PivotRow{PivotHeader: r.header(len(rowDims)), Cells: make([][]interface{}, len(colEntries))}
+		for i, c := range colEntries {
+			values := lookup(r, c)
+			if p.Percent != "" {
+				var denom []interface{}
+				switch p.Percent {
+				case PercentOfRow:
+					denom = lookup(r, colTotal)
+				case PercentOfColumn:
+					denom = lookup(rowTotal, c)
+				default:
+					denom = lookup(rowTotal, colTotal)
+				}
+				values = ratios(values, denom)
+			}
+			if values == nil {
+				values = make([]interface{}, len(q.metrics))
+			}
+			row.Cells[i] = values
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res, nil
+}
+
+// ratios 逐个指标计算比例，分母为空或为 0 时结果为空
+func ratios(values, denom []interface{}) []interface{} {
+	if values == nil {
+		return nil
+	}
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		if v == nil || i >= len(denom) || denom[i] == nil || denom[i].(float64) == 0 {
+			continue
+		}
+		out[i] = v.(float64) / denom[i].(float64)
+	}
+	return out
+}
@@ -0,0 +1,498 @@
+// query/query.go
+package query
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// OtherLabel 超出 Limit 的维度值合并后的名称
+const OtherLabel = "Other"
+
+// checkEvery 每处理多少行检查一次是否超时
+const checkEvery = 4096
+
+// Options 查询选项
+type Options struct {
+	Filters  []models.ChartFilter // 图表之外附加的过滤条件，例如仪表盘筛选
+	Location *time.Location       // 解析日期和计算相对日期使用的时区，默认 UTC
+	Now      time.Time            // 计算相对日期的当前时间，默认 time.Now()
+	MaxRows  int                  // 返回的最大行数，0 表示不限制
+
+	// Parameters 仪表盘参数，过滤值、计算列和指标表达式中以 {{name}} 引用；
+	// 取值为 float64、string 或多选时的 []interface{}
+	Parameters map[string]interface{}
+}
+
+func (o *Options) location() *time.Location {
+	if o.Location == nil {
+		return time.UTC
+	}
+	return o.Location
+}
+
+func (o *Options) now() time.Time {
+	if o.Now.IsZero() {
+		return time.Now()
+	}
+	return o.Now
+}
+
+// Column 结果列
+type Column struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`                  // string, number, date
+	Role        string `json:"role"`                  // dimension, metric
+	Calculation string `json:"calculation,omitempty"` // 指标的二次计算类型
+}
+
+// Result 查询结果，Rows 中每一行与 Columns 一一对应
+type Result struct {
+	Columns     []Column        `json:"columns"`
+	Rows        [][]interface{} `json:"rows"`
+	TotalGroups int             `json:"total_groups"`
+	Truncated   bool            `json:"truncated"`
+}
+
+// metric 编译后的指标
+type metric struct {
+	def  models.ChartMetric
+	name string
+	col  *column     // count(*) 时为空
+	expr *expression // 指标表达式
+}
+
+func compileMetric(t *Table, m models.ChartMetric, opts *Options) (*metric, error) {
+	if m.Expression != "" {
+		var params map[string]interface{}
+		if opts != nil {
+			params = opts.Parameters
+		}
+		expr, err := compileExpression(t, MetricName(m), m.Expression, true, params)
+		if err != nil {
+			return nil, err
+		}
+		return &metric{def: m, name: MetricName(m), expr: expr}, nil
+	}
+
+	numeric, ok := Aggregators[m.Aggregator]
+	if !ok {
+		return nil, errorf(m.Field, "unsupported aggregator %q", m.Aggregator)
+	}
+
+	out := &metric{def: m, name: MetricName(m)}
+	if m.Aggregator == "count" && (m.Field == "" || m.Field == "*") {
+		return out, nil
+	}
+	col, err := t.column(m.Field)
+	if err != nil {
+		return nil, err
+	}
+	if numeric && col.Type != utils.ColumnTypeNumber {
+		return nil, errorf(m.Field, "aggregator %s requires a numeric field", m.Aggregator)
+	}
+	out.col = col
+	return out, nil
+}
+
+// newAccumulator 创建指标的累加器
+func (m *metric) newAccumulator() accumulator {
+	if m.expr == nil {
+		return newAccumulator(m.def.Aggregator, m.col)
+	}
+	acc := &exprAcc{expr: m.expr, accs: make([]accumulator, len(m.expr.aggs))}
+	for i, agg := range m.expr.aggs {
+		acc.accs[i] = agg.newAccumulator()
+	}
+	return acc
+}
+
+// group 一个分组的维度取值和聚合状态
+type group struct {
+	dims    []dimValue
+	accs    []accumulator
+	metrics []interface{}
+}
+
+// compiled 编译后的查询
+type compiled struct {
+	table   *Table
+	cfg     models.ChartConfig
+	opts    *Options
+	dims    []*dimension
+	metrics []*metric
+	filters []models.ChartFilter
+	filter  predicate
+	top     map[string]bool // Top N 保留的第一个维度取值
+	order   int             // 二次计算的排序维度，-1 表示没有
+}
+
+func compile(t *Table, cfg models.ChartConfig, opts *Options) (*compiled, error) {
+	q := &compiled{table: t, cfg: cfg, opts: opts, order: -1}
+	for _, d := range cfg.Dimensions {
+		dim, err := compileDimension(t, d, opts)
+		if err != nil {
+			return nil, err
+		}
+		q.dims = append(q.dims, dim)
+	}
+	for _, m := range cfg.Metrics {
+		met, err := compileMetric(t, m, opts)
+		if err != nil {
+			return nil, err
+		}
+		q.metrics = append(q.metrics, met)
+	}
+
+	q.filters = bindParameters(append(append([]models.ChartFilter{}, cfg.Filters...), opts.Filters...), opts.Parameters)
+	filter, err := compileFilters(t, q.filters, opts)
+	if err != nil {
+		return nil, err
+	}
+	q.filter = filter
+	return q, nil
+}
+
+// applyTimezone 未指定时区时使用图表配置的时区
+func applyTimezone(cfg models.ChartConfig, opts *Options) error {
+	if opts.Location != nil || cfg.Timezone == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return errorf("timezone", "unknown timezone %q", cfg.Timezone)
+	}
+	opts.Location = loc
+	return nil
+}
+
+// Run 按图表配置对数据表进行过滤、分组聚合、Top N 和排序
+func Run(ctx context.Context, t *Table, cfg models.ChartConfig, opts Options) (*Result, error) {
+	if err := applyTimezone(cfg, &opts); err != nil {
+		return nil, err
+	}
+	t, err := t.withCalculatedColumns(ctx, cfg.CalculatedColumns, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := compile(t, cfg, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.compileCalculations(); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.filterRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range q.dims {
+		d.prepare(rows)
+	}
+
+	// Top N 作用于第一个维度的取值
+	if cfg.Limit > 0 && len(q.dims) > 0 {
+		q.top, err = q.topValues(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rows, keep := q.restrict(rows)
+
+	groups, err := q.aggregate(ctx, rows, q.dims, keep)
+	if err != nil {
+		return nil, err
+	}
+	if groups, err = q.fillGaps(groups); err != nil {
+		return nil, err
+	}
+	if err := q.calculate(ctx, groups); err != nil {
+		return nil, err
+	}
+	if err := q.sortGroups(groups); err != nil {
+		return nil, err
+	}
+
+	return q.result(groups), nil
+}
+
+// filterRows 返回满足过滤条件的数据行
+func (q *compiled) filterRows(ctx context.Context) ([][]string, error) {
+	return q.selectRows(ctx, q.filter)
+}
+
+// selectRows 返回满足指定过滤条件的数据行
+func (q *compiled) selectRows(ctx context.Context, filter predicate) ([][]string, error) {
+	if filter == nil {
+		return q.table.Rows, nil
+	}
+	rows := make([][]string, 0, len(q.table.Rows))
+	for i, row := range q.table.Rows {
+		if i%checkEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if filter(row) {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// restrict 应用 Top N 的结果，未开启“其他”时直接过滤掉排名之外的行，否则返回需要保留的取值
+func (q *compiled) restrict(rows [][]string) ([][]string, map[string]bool) {
+	if q.top == nil || q.cfg.OtherBucket {
+		return rows, q.top
+	}
+	filtered := rows[:0:0]
+	for _, row := range rows {
+		if q.top[q.dims[0].value(row).Label] {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered, nil
+}
+
+// aggregate 按维度分组并计算指标，keep 不为空时第一个维度中不在 keep 内的值合并为“其他”
+func (q *compiled) aggregate(ctx context.Context, rows [][]string, dims []*dimension, keep map[string]bool) ([]*group, error) {
+	index := map[string]*group{}
+	groups := []*group{}
+	var key strings.Builder
+
+	for i, row := range rows {
+		if i%checkEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		values := make([]dimValue, len(dims))
+		key.Reset()
+		for j, d := range dims {
+			v := d.value(row)
+			if j == 0 && keep != nil && !keep[v.Label] {
+				v = dimValue{Label: OtherLabel, Other: true}
+			}
+			values[j] = v
+			if v.Null {
+				key.WriteString("\x01")
+			}
+			key.WriteString(v.Label)
+			key.WriteByte(0)
+		}
+
+		g, ok := index[key.String()]
+		if !ok {
+			g = &group{dims: values, accs: make([]accumulator, len(q.metrics))}
+			for j, m := range q.metrics {
+				g.accs[j] = m.newAccumulator()
+			}
+			index[key.String()] = g
+			groups = append(groups, g)
+		}
+		for _, acc := range g.accs {
+			acc.add(row)
+		}
+	}
+
+	// 没有维度时即使没有数据也返回一行汇总
+	if len(dims) == 0 && len(groups) == 0 {
+		g := &group{accs: make([]accumulator, len(q.metrics))}
+		for j, m := range q.metrics {
+			g.accs[j] = m.newAccumulator()
+		}
+		groups = append(groups, g)
+	}
+
+	for _, g := range groups {
+		g.metrics = make([]interface{}, len(g.accs))
+		for j, acc := range g.accs {
+			g.metrics[j] = acc.result()
+		}
+		g.accs = nil
+	}
+	return groups, nil
+}
+
+// topValues 按排序规则选出第一个维度排名前 Limit 的取值
+func (q *compiled) topValues(ctx context.Context, rows [][]string) (map[string]bool, error) {
+	groups, err := q.aggregate(ctx, rows, q.dims[:1], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// 优先使用作用于第一个维度或指标的排序规则，默认按第一个指标降序
+	ranking := []models.ChartSort{}
+	for _, s := range q.cfg.Sort {
+		if s.Field == q.dims[0].def.Field || q.metricIndex(s.Field) >= 0 {
+			ranking = append(ranking, s)
+			break
+		}
+	}
+	if len(ranking) == 0 && len(q.metrics) > 0 {
+		ranking = append(ranking, models.ChartSort{Field: q.metrics[0].name, Order: "desc"})
+	}
+	if err := q.sortBy(groups, q.dims[:1], ranking); err != nil {
+		return nil, err
+	}
+
+	keep := map[string]bool{}
+	for i, g := range groups {
+		if i >= q.cfg.Limit {
+			break
+		}
+		keep[g.dims[0].Label] = true
+	}
+	return keep, nil
+}
+
+// metricIndex 按名称查找指标，也允许使用唯一的基础指标名（不含高级计算，例如 sum(x)）或唯一的指标字段名
+func (q *compiled) metricIndex(name string) int {
+	for i, m := range q.metrics {
+		if m.name == name {
+			return i
+		}
+	}
+	unique := func(match func(m *metric) bool) int {
+		found := -1
+		for i, m := range q.metrics {
+			if match(m) {
+				if found >= 0 {
+					return -1
+				}
+				found = i
+			}
+		}
+		return found
+	}
+	if i := unique(func(m *metric) bool {
+		if m.def.Calculation == nil {
+			return false
+		}
+		base := m.def
+		base.Calculation = nil
+		return MetricName(base) == name
+	}); i >= 0 {
+		return i
+	}
+	return unique(func(m *metric) bool { return m.def.Field == name })
+}
+
+// sortGroups 按配置排序，未配置时日期和数值维度按升序排列，其余保持出现顺序
+func (q *compiled) sortGroups(groups []*group) error {
+	sorts := q.cfg.Sort
+	if len(sorts) == 0 {
+		for _, d := range q.dims {
+			if d.Type == utils.ColumnTypeDate || d.Type == utils.ColumnTypeNumber || d.bin != nil {
+				sorts = append(sorts, models.ChartSort{Field: d.def.Field, Order: "asc"})
+			}
+		}
+	}
+	return q.sortBy(groups, q.dims, sorts)
+}
+
+// sortBy 按排序规则稳定排序，指标为空的分组排在最后
+func (q *compiled) sortBy(groups []*group, dims []*dimension, sorts []models.ChartSort) error {
+	type key struct {
+		dim    int
+		metric int
+		desc   bool
+	}
+	keys := make([]key, 0, len(sorts))
+	for _, s := range sorts {
+		k := key{dim: -1, metric: -1, desc: strings.EqualFold(s.Order, "desc")}
+		if s.Order != "" && !k.desc && !strings.EqualFold(s.Order, "asc") {
+			return errorf(s.Field, "sort order must be asc or desc")
+		}
+		for i, d := range dims {
+			if d.def.Field == s.Field {
+				k.dim = i
+				break
+			}
+		}
+		if k.dim < 0 {
+			k.metric = q.metricIndex(s.Field)
+		}
+		if k.dim < 0 && k.metric < 0 {
+			return errorf(s.Field, "sort field is not a dimension or metric of the chart")
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		// “其他”始终排在最后
+		if len(dims) > 0 && a.dims[0].Other != b.dims[0].Other {
+			return b.dims[0].Other
+		}
+		for _, k := range keys {
+			var c int
+			if k.dim >= 0 {
+				c = compareDimValues(a.dims[k.dim], b.dims[k.dim])
+			} else {
+				va, vb := a.metrics[k.metric], b.metrics[k.metric]
+				switch {
+				case va == nil && vb == nil:
+					continue
+				case va == nil:
+					return false
+				case vb == nil:
+					return true
+				}
+				c = compareFloat(va.(float64), vb.(float64))
+			}
+			if k.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// result 将分组转换为查询结果
+func (q *compiled) result(groups []*group) *Result {
+	res := &Result{TotalGroups: len(groups), Rows: [][]interface{}{}}
+	for _, d := range q.dims {
+		res.Columns = append(res.Columns, Column{Name: d.def.Field, Type: d.Type, Role: "dimension"})
+	}
+	for _, m := range q.metrics {
+		col := Column{Name: m.name, Type: utils.ColumnTypeNumber, Role: "metric"}
+		if m.def.Calculation != nil {
+			col.Calculation = m.def.Calculation.Type
+		}
+		res.Columns = append(res.Columns, col)
+	}
+
+	for _, g := range groups {
+		if q.opts.MaxRows > 0 && len(res.Rows) >= q.opts.MaxRows {
+			res.Truncated = true
+			break
+		}
+		row := make([]interface{}, 0, len(g.dims)+len(g.metrics))
+		for _, v := range g.dims {
+			if v.Null {
+				row = append(row, nil)
+				continue
+			}
+			row = append(row, v.Label)
+		}
+		row = append(row, g.metrics...)
+		res.Rows = append(res.Rows, row)
+	}
+	return res
+}
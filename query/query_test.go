@@ -0,0 +1,364 @@
+// query/query_test.go
+package query
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// salesTable 引擎测试使用的销售数据，按地区汇总金额为 east 130、west 90、north 20、south 10
+func salesTable() *Table {
+	return NewTable(&models.DataSource{
+		Headers: []string{"date", "region", "product", "amount"},
+		Content: [][]string{
+			{"2026-01-05", "east", "Apple", "100"},
+			{"2026-01-20", "west", "Banana", "50"},
+			{"2026-02-03", "east", "Banana", "30"},
+			{"2026-02-14", "north", "Apple", "20"},
+			{"2026-03-01", "south", "Cherry", "10"},
+			{"2026-03-15", "east", "Apple", ""},
+			{"2026-04-02", "west", "Cherry", "40"},
+		},
+		Schema: []models.ColumnSchema{
+			{Name: "date", Type: utils.ColumnTypeDate},
+			{Name: "region", Type: utils.ColumnTypeString},
+			{Name: "product", Type: utils.ColumnTypeString},
+			{Name: "amount", Type: utils.ColumnTypeNumber},
+		},
+	})
+}
+
+var (
+	sumAmount = models.ChartMetric{Field: "amount", Aggregator: "sum"}
+	countAll  = models.ChartMetric{Aggregator: "count"}
+)
+
+func TestRun(t *testing.T) {
+	byRegion := []models.ChartDimension{{Field: "region"}}
+	tests := []struct {
+		name    string
+		cfg     models.ChartConfig
+		opts    Options
+		columns []string
+		rows    [][]interface{}
+		total   int
+	}{
+		{
+			name:    "no dimensions",
+			cfg:     models.ChartConfig{Metrics: []models.ChartMetric{sumAmount, countAll}},
+			columns: []string{"sum(amount)", "count(*)"},
+			rows:    [][]interface{}{{250.0, 7.0}},
+			total:   1,
+		},
+		{
+			name: "in and comparison filters",
+			cfg: models.ChartConfig{
+				Dimensions: byRegion,
+				Metrics:    []models.ChartMetric{sumAmount},
+				Filters: []models.ChartFilter{
+					{Field: "region", Operator: OpIn, Values: []interface{}{"east", "west"}},
+					{Field: "amount", Operator: OpGte, Values: []interface{}{"40"}},
+				},
+			},
+			columns: []string{"region", "sum(amount)"},
+			rows:    [][]interface{}{{"east", 100.0}, {"west", 90.0}},
+			total:   2,
+		},
+		{
+			name: "negative and text filters",
+			cfg: models.ChartConfig{
+				Dimensions: []models.ChartDimension{{Field: "product"}},
+				Metrics:    []models.ChartMetric{countAll},
+				Filters: []models.ChartFilter{
+					{Field: "amount", Operator: OpGt, Values: []interface{}{"-5"}},
+					{Field: "product", Operator: OpNotContains, Values: []interface{}{"NAN"}},
+					{Field: "region", Operator: OpNotIn, Values: []interface{}{"south"}},
+				},
+			},
+			columns: []string{"product", "count(*)"},
+			rows:    [][]interface{}{{"Apple", 2.0}, {"Cherry", 1.0}},
+			total:   2,
+		},
+		{
+			name: "null filter",
+			cfg: models.ChartConfig{
+				Dimensions: byRegion,
+				Metrics:    []models.ChartMetric{countAll},
+				Filters:    []models.ChartFilter{{Field: "amount", Operator: OpIsNull}},
+			},
+			columns: []string{"region", "count(*)"},
+			rows:    [][]interface{}{{"east", 1.0}},
+			total:   1,
+		},
+		{
+			name: "date between",
+			cfg: models.ChartConfig{
+				Metrics: []models.ChartMetric{sumAmount},
+				Filters: []models.ChartFilter{{Field: "date", Operator: OpBetween, Values: []interface{}{"2026-01-20", "2026-02-14"}}},
+			},
+			columns: []string{"sum(amount)"},
+			rows:    [][]interface{}{{100.0}},
+			total:   1,
+		},
+		{
+			name: "relative date filter",
+			cfg: models.ChartConfig{
+				Metrics: []models.ChartMetric{sumAmount, countAll},
+				Filters: []models.ChartFilter{{
+					Field:    "date",
+					Operator: OpRelative,
+					Relative: &models.RelativeDateRange{Unit: GrainMonth, Last: 2, IncludeCurrent: true},
+				}},
+			},
+			opts:    Options{Now: time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)},
+			columns: []string{"sum(amount)", "count(*)"},
+			rows:    [][]interface{}{{60.0, 4.0}},
+			total:   1,
+		},
+		{
+			name: "dashboard filters",
+			cfg: models.ChartConfig{
+				Dimensions: byRegion,
+				Metrics:    []models.ChartMetric{sumAmount},
+			},
+			opts:    Options{Filters: []models.ChartFilter{{Field: "product", Operator: OpStartsWith, Values: []interface{}{"ch"}}}},
+			columns: []string{"region", "sum(amount)"},
+			rows:    [][]interface{}{{"south", 10.0}, {"west", 40.0}},
+			total:   2,
+		},
+		{
+			name: "top n",
+			cfg: models.ChartConfig{
+				Dimensions: byRegion,
+				Metrics:    []models.ChartMetric{sumAmount},
+				Limit:      2,
+			},
+			columns: []string{"region", "sum(amount)"},
+			rows:    [][]interface{}{{"east", 130.0}, {"west", 90.0}},
+			total:   2,
+		},
+		{
+			name: "top n with other",
+			cfg: models.ChartConfig{
+				Dimensions:  byRegion,
+				Metrics:     []models.ChartMetric{sumAmount},
+				Sort:        []models.ChartSort{{Field: "sum(amount)", Order: "asc"}},
+				Limit:       2,
+				OtherBucket: true,
+			},
+			columns: []string{"region", "sum(amount)"},
+			// 按升序取前两名，其余合并为“其他”并排在最后
+			rows:  [][]interface{}{{"south", 10.0}, {"north", 20.0}, {OtherLabel, 220.0}},
+			total: 3,
+		},
+		{
+			name: "top n with other and second dimension",
+			cfg: models.ChartConfig{
+				Dimensions:  []models.ChartDimension{{Field: "region"}, {Field: "product"}},
+				Metrics:     []models.ChartMetric{sumAmount},
+				Sort:        []models.ChartSort{{Field: "sum(amount)", Order: "desc"}},
+				Limit:       1,
+				OtherBucket: true,
+			},
+			columns: []string{"region", "product", "sum(amount)"},
+			rows: [][]interface{}{
+				{"east", "Apple", 100.0},
+				{"east", "Banana", 30.0},
+				{OtherLabel, "Banana", 50.0},
+				{OtherLabel, "Cherry", 50.0},
+				{OtherLabel, "Apple", 20.0},
+			},
+			total: 5,
+		},
+		{
+			name: "top n when limit exceeds groups",
+			cfg: models.ChartConfig{
+				Dimensions:  byRegion,
+				Metrics:     []models.ChartMetric{sumAmount},
+				Sort:        []models.ChartSort{{Field: "region", Order: "asc"}},
+				Limit:       10,
+				OtherBucket: true,
+			},
+			columns: []string{"region", "sum(amount)"},
+			rows:    [][]interface{}{{"east", 130.0}, {"north", 20.0}, {"south", 10.0}, {"west", 90.0}},
+			total:   4,
+		},
+		{
+			name: "max rows",
+			cfg: models.ChartConfig{
+				Dimensions: byRegion,
+				Metrics:    []models.ChartMetric{sumAmount},
+				Sort:       []models.ChartSort{{Field: "amount", Order: "desc"}},
+			},
+			opts:    Options{MaxRows: 1},
+			columns: []string{"region", "sum(amount)"},
+			rows:    [][]interface{}{{"east", 130.0}},
+			total:   4,
+		},
+		{
+			name: "month grain",
+			cfg: models.ChartConfig{
+				Dimensions: []models.ChartDimension{{Field: "date", Grain: GrainMonth}},
+				Metrics:    []models.ChartMetric{sumAmount},
+			},
+			columns: []string{"date", "sum(amount)"},
+			rows:    [][]interface{}{{"2026-01", 150.0}, {"2026-02", 50.0}, {"2026-03", 10.0}, {"2026-04", 40.0}},
+			total:   4,
+		},
+		{
+			name: "quarter grain descending",
+			cfg: models.ChartConfig{
+				Dimensions: []models.ChartDimension{{Field: "date", Grain: GrainQuarter}},
+				Metrics:    []models.ChartMetric{countAll},
+				Sort:       []models.ChartSort{{Field: "date", Order: "desc"}},
+			},
+			columns: []string{"date", "count(*)"},
+			rows:    [][]interface{}{{"2026-Q2", 1.0}, {"2026-Q1", 6.0}},
+			total:   2,
+		},
+		{
+			name: "week grain starts on monday",
+			cfg: models.ChartConfig{
+				Dimensions: []models.ChartDimension{{Field: "date", Grain: GrainWeek}},
+				Metrics:    []models.ChartMetric{countAll},
+				Filters:    []models.ChartFilter{{Field: "date", Operator: OpLt, Values: []interface{}{"2026-02-15"}}},
+			},
+			columns: []string{"date", "count(*)"},
+			rows:    [][]interface{}{{"2026-01-05", 1.0}, {"2026-01-19", 1.0}, {"2026-02-02", 1.0}, {"2026-02-09", 1.0}},
+			total:   4,
+		},
+		{
+			name: "year grain",
+			cfg: models.ChartConfig{
+				Dimensions: []models.ChartDimension{{Field: "date", Grain: GrainYear}},
+				Metrics:    []models.ChartMetric{sumAmount},
+			},
+			columns: []string{"date", "sum(amount)"},
+			rows:    [][]interface{}{{"2026", 250.0}},
+			total:   1,
+		},
+		{
+			name: "month grain with zero fill",
+			cfg: models.ChartConfig{
+				Dimensions: []models.ChartDimension{{Field: "date", Grain: GrainMonth, Fill: FillZero}},
+				Metrics:    []models.ChartMetric{sumAmount},
+				Filters:    []models.ChartFilter{{Field: "region", Operator: OpEq, Values: []interface{}{"west"}}},
+			},
+			columns: []string{"date", "sum(amount)"},
+			rows:    [][]interface{}{{"2026-01", 50.0}, {"2026-02", 0.0}, {"2026-03", 0.0}, {"2026-04", 40.0}},
+			total:   4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Run(context.Background(), salesTable(), tt.cfg, tt.opts)
+			if err != nil {
+				t.Fatalf("Run error: %v", err)
+			}
+			var columns []string
+			for _, c := range res.Columns {
+				columns = append(columns, c.Name)
+			}
+			if !reflect.DeepEqual(columns, tt.columns) {
+				t.Errorf("columns = %q, want %q", columns, tt.columns)
+			}
+			if !reflect.DeepEqual(res.Rows, tt.rows) {
+				t.Errorf("rows = %v, want %v", res.Rows, tt.rows)
+			}
+			if res.TotalGroups != tt.total {
+				t.Errorf("TotalGroups = %d, want %d", res.TotalGroups, tt.total)
+			}
+		})
+	}
+}
+
+func TestRunTimezone(t *testing.T) {
+	table := NewTable(&models.DataSource{
+		Headers: []string{"time", "amount"},
+		Content: [][]string{{"2026-01-31T23:30:00Z", "1"}, {"2026-02-01T08:00:00Z", "2"}},
+		Schema: []models.ColumnSchema{
+			{Name: "time", Type: utils.ColumnTypeDate},
+			{Name: "amount", Type: utils.ColumnTypeNumber},
+		},
+	})
+	cfg := models.ChartConfig{
+		Dimensions: []models.ChartDimension{{Field: "time", Grain: GrainMonth}},
+		Metrics:    []models.ChartMetric{sumAmount},
+	}
+
+	res, err := Run(context.Background(), table, cfg, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{"2026-01", 1.0}, {"2026-02", 2.0}}
+	if !reflect.DeepEqual(res.Rows, want) {
+		t.Errorf("UTC rows = %v, want %v", res.Rows, want)
+	}
+
+	// 东八区的 1 月 31 日 23:30 UTC 已是 2 月
+	cfg.Timezone = "Asia/Shanghai"
+	res, err = Run(context.Background(), table, cfg, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [][]interface{}{{"2026-02", 3.0}}
+	if !reflect.DeepEqual(res.Rows, want) {
+		t.Errorf("Asia/Shanghai rows = %v, want %v", res.Rows, want)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  models.ChartConfig
+		want string
+	}{
+		{"unknown dimension", models.ChartConfig{Dimensions: []models.ChartDimension{{Field: "city"}}}, "field does not exist"},
+		{"grain on text field", models.ChartConfig{Dimensions: []models.ChartDimension{{Field: "region", Grain: GrainMonth}}}, "time grain requires a date field"},
+		{"unknown grain", models.ChartConfig{Dimensions: []models.ChartDimension{{Field: "date", Grain: "decade"}}}, "unsupported time grain"},
+		{"fill without grain", models.ChartConfig{Dimensions: []models.ChartDimension{{Field: "date", Fill: FillZero}}}, "gap filling requires a time grain"},
+		{"unknown aggregator", models.ChartConfig{Metrics: []models.ChartMetric{{Field: "amount", Aggregator: "mode"}}}, "unsupported aggregator"},
+		{"sum of text field", models.ChartConfig{Metrics: []models.ChartMetric{{Field: "region", Aggregator: "sum"}}}, "requires a numeric field"},
+		{"unknown filter field", models.ChartConfig{
+			Metrics: []models.ChartMetric{countAll},
+			Filters: []models.ChartFilter{{Field: "city", Operator: OpEq, Values: []interface{}{"x"}}},
+		}, "field does not exist"},
+		{"relative filter by hour", models.ChartConfig{
+			Metrics: []models.ChartMetric{countAll},
+			Filters: []models.ChartFilter{{Field: "date", Operator: OpRelative, Relative: &models.RelativeDateRange{Unit: GrainHour, Last: 1}}},
+		}, "relative filter requires unit"},
+		{"bad sort order", models.ChartConfig{
+			Dimensions: []models.ChartDimension{{Field: "region"}},
+			Metrics:    []models.ChartMetric{sumAmount},
+			Sort:       []models.ChartSort{{Field: "region", Order: "up"}},
+		}, "sort order must be asc or desc"},
+		{"sort by unknown field", models.ChartConfig{
+			Dimensions: []models.ChartDimension{{Field: "region"}},
+			Metrics:    []models.ChartMetric{sumAmount},
+			Sort:       []models.ChartSort{{Field: "product", Order: "asc"}},
+		}, "sort field is not a dimension or metric"},
+		{"unknown timezone", models.ChartConfig{Metrics: []models.ChartMetric{countAll}, Timezone: "Mars/Olympus"}, "unknown timezone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Run(context.Background(), salesTable(), tt.cfg, Options{})
+			if err == nil {
+				t.Fatalf("Run succeeded, want error containing %q", tt.want)
+			}
+			if _, ok := err.(*Error); !ok {
+				t.Errorf("error type = %T, want *Error", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,680 @@
+// query/sql.go
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// MaxSQLLength 即席查询语句的最大长度
+const MaxSQLLength = 10000
+
+// SQLQuery 解析后的即席查询，只支持单表的 SELECT 聚合查询，编译为图表配置后由查询引擎执行
+type SQLQuery struct {
+	From   string             // 数据源 ID 或名称
+	Config models.ChartConfig // 对应的图表配置
+	Limit  int                // LIMIT，0 表示不限制
+	output []sqlOutput        // SELECT 列表在引擎结果中的位置
+}
+
+// sqlOutput SELECT 列表中的一列
+type sqlOutput struct {
+	name  string
+	index int // 在引擎结果中的列下标
+}
+
+// sqlExpr SELECT、GROUP BY、ORDER BY 中的表达式：字段、DATE_TRUNC 或聚合函数
+type sqlExpr struct {
+	field      string
+	grain      string // DATE_TRUNC 的时间粒度
+	aggregator string // 为空表示非聚合表达式
+	alias      string
+	text       string // 原始文本，用作默认列名
+}
+
+// token 词法单元
+type token struct {
+	kind  int
+	text  string
+	pos   int
+	upper string // 未加引号的标识符的大写形式，用于匹配关键字
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+// sqlKeywords 不能作为未加引号的别名使用的关键字
+var sqlKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "BY": true, "ORDER": true,
+	"LIMIT": true, "AND": true, "OR": true, "NOT": true, "IN": true, "BETWEEN": true, "IS": true,
+	"NULL": true, "LIKE": true, "AS": true, "ASC": true, "DESC": true, "DISTINCT": true, "HAVING": true,
+	"JOIN": true, "UNION": true, "OFFSET": true,
+}
+
+// sqlAggregators SQL 聚合函数与查询引擎聚合方式的对应关系
+var sqlAggregators = map[string]string{
+	"SUM": "sum", "AVG": "avg", "MIN": "min", "MAX": "max", "MEDIAN": "median", "COUNT": "count",
+}
+
+func sqlErrorf(pos int, format string, args ...interface{}) *Error {
+	return errorf("sql", "syntax error at position %d: %s", pos+1, fmt.Sprintf(format, args...))
+}
+
+// tokenize 将语句切分为词法单元，字符串使用单引号，标识符可以使用双引号或反引号
+func tokenize(sql string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(sql)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '\'' || r == '"' || r == '`':
+			start := i
+			var b strings.Builder
+			closed := false
+			for i++; i < len(runes); i++ {
+				if runes[i] == r {
+					// 连续两个引号表示引号本身
+					if i+1 < len(runes) && runes[i+1] == r {
+						b.WriteRune(r)
+						i++
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				b.WriteRune(runes[i])
+			}
+			if !closed {
+				return nil, sqlErrorf(start, "unterminated quoted text")
+			}
+			kind := tokQuotedIdent
+			if r == '\'' {
+				kind = tokString
+			}
+			tokens = append(tokens, token{kind: kind, text: b.String(), pos: start})
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			text := string(runes[start:i])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, sqlErrorf(start, "invalid number %s", text)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: text, pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			text := string(runes[start:i])
+			tokens = append(tokens, token{kind: tokIdent, text: text, pos: start, upper: strings.ToUpper(text)})
+		default:
+			start := i
+			text := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "<=", ">=", "<>", "!=":
+					text = two
+				}
+			}
+			if !strings.Contains("(),*=<>;-+/{}", string(r)) && text == string(r) {
+				return nil, sqlErrorf(start, "unexpected character %q", r)
+			}
+			i += len([]rune(text))
+			tokens = append(tokens, token{kind: tokSymbol, text: text, pos: start})
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(runes)}), nil
+}
+
+// sqlParser 递归下降解析器
+type sqlParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *sqlParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword 当前词法单元是否为指定关键字
+func (p *sqlParser) isKeyword(words ...string) bool {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return false
+	}
+	for _, w := range words {
+		if t.upper == w {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *sqlParser) acceptKeyword(word string) bool {
+	if p.isKeyword(word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectKeyword(word string) error {
+	if !p.acceptKeyword(word) {
+		return sqlErrorf(p.peek().pos, "expected %s", word)
+	}
+	return nil
+}
+
+func (p *sqlParser) acceptSymbol(sym string) bool {
+	if t := p.peek(); t.kind == tokSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectSymbol(sym string) error {
+	if !p.acceptSymbol(sym) {
+		return sqlErrorf(p.peek().pos, "expected %q", sym)
+	}
+	return nil
+}
+
+// identifier 读取字段名或数据源名，关键字需要加引号
+func (p *sqlParser) identifier() (string, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokQuotedIdent:
+		p.pos++
+		return t.text, nil
+	case t.kind == tokIdent && !sqlKeywords[t.upper]:
+		p.pos++
+		return t.text, nil
+	}
+	return "", sqlErrorf(t.pos, "expected a column name")
+}
+
+// literal 读取字符串或数值字面量，数值保留原始文本以便与文本列比较
+func (p *sqlParser) literal() (string, error) {
+	t := p.peek()
+	if t.kind == tokString || t.kind == tokNumber {
+		p.pos++
+		return t.text, nil
+	}
+	if t.kind == tokSymbol && t.text == "-" && p.tokens[p.pos+1].kind == tokNumber {
+		p.pos += 2
+		return "-" + p.tokens[p.pos-1].text, nil
+	}
+	return "", sqlErrorf(t.pos, "expected a string or number literal")
+}
+
+// expr 解析字段、DATE_TRUNC('month', field) 或聚合函数
+func (p *sqlParser) expr() (sqlExpr, error) {
+	start := p.peek()
+	if start.kind == tokIdent && p.tokens[p.pos+1].kind == tokSymbol && p.tokens[p.pos+1].text == "(" {
+		p.pos += 2
+		var e sqlExpr
+		switch fn := start.upper; {
+		case fn == "DATE_TRUNC":
+			grain, err := p.literal()
+			if err != nil {
+				return e, err
+			}
+			e.grain = strings.ToLower(grain)
+			if !validGrain(e.grain) {
+				return e, sqlErrorf(start.pos, "unsupported DATE_TRUNC unit %q", grain)
+			}
+			if err := p.expectSymbol(","); err != nil {
+				return e, err
+			}
+			if e.field, err = p.identifier(); err != nil {
+				return e, err
+			}
+			e.text = fmt.Sprintf("date_trunc(%s, %s)", e.grain, e.field)
+		case sqlAggregators[fn] != "":
+			e.aggregator = sqlAggregators[fn]
+			switch {
+			case fn == "COUNT" && p.acceptSymbol("*"):
+			case fn == "COUNT" && p.acceptKeyword("DISTINCT"):
+				e.aggregator = "count_distinct"
+				fallthrough
+			default:
+				field, err := p.identifier()
+				if err != nil {
+					return e, err
+				}
+				e.field = field
+			}
+			e.text = MetricName(models.ChartMetric{Field: e.field, Aggregator: e.aggregator})
+		default:
+			return e, sqlErrorf(start.pos, "unsupported function %s", start.text)
+		}
+		return e, p.expectSymbol(")")
+	}
+
+	field, err := p.identifier()
+	if err != nil {
+		return sqlExpr{}, err
+	}
+	return sqlExpr{field: field, text: field}, nil
+}
+
+// condition 解析 WHERE 中的单个条件
+func (p *sqlParser) condition() (models.ChartFilter, error) {
+	if p.peek().kind == tokIdent && p.tokens[p.pos+1].kind == tokSymbol && p.tokens[p.pos+1].text == "(" {
+		return models.ChartFilter{}, sqlErrorf(p.peek().pos, "functions are not supported in WHERE")
+	}
+	field, err := p.identifier()
+	if err != nil {
+		return models.ChartFilter{}, err
+	}
+	f := models.ChartFilter{Field: field}
+	opPos := p.peek().pos
+
+	if p.acceptKeyword("IS") {
+		f.Operator = OpIsNull
+		if p.acceptKeyword("NOT") {
+			f.Operator = OpNotNull
+		}
+		return f, p.expectKeyword("NULL")
+	}
+
+	negate := p.acceptKeyword("NOT")
+	switch {
+	case p.acceptKeyword("IN"):
+		f.Operator = OpIn
+		if negate {
+			f.Operator = OpNotIn
+		}
+		if err := p.expectSymbol("("); err != nil {
+			return f, err
+		}
+		for {
+			v, err := p.literal()
+			if err != nil {
+				return f, err
+			}
+			f.Values = append(f.Values, v)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		return f, p.expectSymbol(")")
+
+	case p.acceptKeyword("BETWEEN"):
+		if negate {
+			return f, sqlErrorf(opPos, "NOT BETWEEN is not supported")
+		}
+		low, err := p.literal()
+		if err != nil {
+			return f, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return f, err
+		}
+		high, err := p.literal()
+		if err != nil {
+			return f, err
+		}
+		f.Operator, f.Values = OpBetween, []interface{}{low, high}
+		return f, nil
+
+	case p.acceptKeyword("LIKE"):
+		pattern, err := p.literal()
+		if err != nil {
+			return f, err
+		}
+		// 只支持前缀、后缀和包含匹配
+		inner := strings.Trim(pattern, "%")
+		if inner == "" || strings.ContainsAny(inner, "%_") {
+			return f, sqlErrorf(opPos, "LIKE only supports 'abc%%', '%%abc' and '%%abc%%' patterns")
+		}
+		prefix, suffix := strings.HasPrefix(pattern, "%"), strings.HasSuffix(pattern, "%")
+		switch {
+		case prefix && suffix:
+			f.Operator = OpContains
+		case suffix:
+			f.Operator = OpStartsWith
+		case prefix:
+			f.Operator = OpEndsWith
+		default:
+			f.Operator = OpEq
+		}
+		if negate {
+			if f.Operator != OpContains && f.Operator != OpEq {
+				return f, sqlErrorf(opPos, "NOT LIKE only supports '%%abc%%' patterns")
+			}
+			f.Operator = map[string]string{OpContains: OpNotContains, OpEq: OpNeq}[f.Operator]
+		}
+		f.Values = []interface{}{inner}
+		return f, nil
+	}
+	if negate {
+		return f, sqlErrorf(opPos, "expected IN, BETWEEN or LIKE after NOT")
+	}
+
+	ops := map[string]string{"=": OpEq, "!=": OpNeq, "<>": OpNeq, ">": OpGt, ">=": OpGte, "<": OpLt, "<=": OpLte}
+	t := p.next()
+	op, ok := ops[t.text]
+	if t.kind != tokSymbol || !ok {
+		return f, sqlErrorf(t.pos, "expected a comparison operator")
+	}
+	v, err := p.literal()
+	if err != nil {
+		return f, err
+	}
+	f.Operator, f.Values = op, []interface{}{v}
+	return f, nil
+}
+
+// ParseSQL 解析即席查询语句：
+// SELECT 列 [AS 别名], ... FROM 数据源 [WHERE 条件 AND ...] [GROUP BY ...] [ORDER BY ... ASC|DESC] [LIMIT n]。
+// 列可以是字段、DATE_TRUNC('month', 字段) 或 SUM/AVG/MIN/MAX/MEDIAN/COUNT 聚合，条件之间只支持 AND
+func ParseSQL(sql string) (*SQLQuery, error) {
+	if len(sql) > MaxSQLLength {
+		return nil, errorf("sql", "query is longer than %d characters", MaxSQLLength)
+	}
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &sqlParser{tokens: tokens}
+
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("DISTINCT") {
+		return nil, sqlErrorf(p.peek().pos, "SELECT DISTINCT is not supported, use GROUP BY")
+	}
+	var selects []sqlExpr
+	for {
+		if p.acceptSymbol("*") {
+			return nil, sqlErrorf(p.tokens[p.pos-1].pos, "SELECT * is not supported, list the columns to aggregate")
+		}
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("AS") {
+			if e.alias, err = p.identifier(); err != nil {
+				return nil, err
+			}
+		} else if t := p.peek(); t.kind == tokQuotedIdent || (t.kind == tokIdent && !sqlKeywords[t.upper]) {
+			e.alias, _ = p.identifier()
+		}
+		selects = append(selects, e)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+
+	q := &SQLQuery{}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokString {
+		p.pos++
+		q.From = t.text
+	} else if q.From, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("JOIN") || p.peek().text == "," {
+		return nil, sqlErrorf(p.peek().pos, "only a single data source is supported")
+	}
+
+	if p.acceptKeyword("WHERE") {
+		for {
+			f, err := p.condition()
+			if err != nil {
+				return nil, err
+			}
+			q.Config.Filters = append(q.Config.Filters, f)
+			if p.isKeyword("OR") {
+				return nil, sqlErrorf(p.peek().pos, "OR is not supported, use IN for alternatives")
+			}
+			if !p.acceptKeyword("AND") {
+				break
+			}
+		}
+	}
+
+	// GROUP BY 和 ORDER BY 可以引用 SELECT 中的别名或序号
+	resolve := func() (sqlExpr, error) {
+		t := p.peek()
+		if t.kind == tokNumber {
+			p.pos++
+			n, err := strconv.Atoi(t.text)
+			if err != nil || n < 1 || n > len(selects) {
+				return sqlExpr{}, sqlErrorf(t.pos, "position %s is not in the select list", t.text)
+			}
+			return selects[n-1], nil
+		}
+		e, err := p.expr()
+		if err != nil {
+			return e, err
+		}
+		if e.aggregator == "" && e.grain == "" {
+			for _, s := range selects {
+				if s.alias == e.field {
+					return s, nil
+				}
+			}
+		}
+		return e, nil
+	}
+
+	var groupBy []sqlExpr
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			pos := p.peek().pos
+			e, err := resolve()
+			if err != nil {
+				return nil, err
+			}
+			if e.aggregator != "" {
+				return nil, sqlErrorf(pos, "aggregate functions are not allowed in GROUP BY")
+			}
+			groupBy = append(groupBy, e)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.isKeyword("HAVING") {
+		return nil, sqlErrorf(p.peek().pos, "HAVING is not supported")
+	}
+
+	type orderItem struct {
+		expr sqlExpr
+		desc bool
+	}
+	var orderBy []orderItem
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := resolve()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{expr: e}
+			if p.acceptKeyword("DESC") {
+				item.desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			orderBy = append(orderBy, item)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokNumber || err != nil || n < 1 {
+			return nil, sqlErrorf(t.pos, "LIMIT requires a positive integer")
+		}
+		q.Limit = n
+	}
+	p.acceptSymbol(";")
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, sqlErrorf(t.pos, "unexpected %q", t.text)
+	}
+
+	if err := q.build(selects, groupBy); err != nil {
+		return nil, err
+	}
+	for _, o := range orderBy {
+		s := models.ChartSort{Field: o.expr.field, Order: "asc"}
+		if o.desc {
+			s.Order = "desc"
+		}
+		if o.expr.aggregator != "" {
+			s.Field = q.metricFor(o.expr)
+		} else if i := q.dimensionIndex(o.expr); i < 0 {
+			return nil, errorf(o.expr.field, "ORDER BY column must appear in GROUP BY or be an aggregate")
+		}
+		q.Config.Sort = append(q.Config.Sort, s)
+	}
+	return q, nil
+}
+
+// build 将 SELECT 和 GROUP BY 转换为图表的维度和指标
+func (q *SQLQuery) build(selects, groupBy []sqlExpr) error {
+	hasAggregate := false
+	for _, s := range selects {
+		hasAggregate = hasAggregate || s.aggregator != ""
+	}
+	if len(groupBy) == 0 {
+		if !hasAggregate {
+			return errorf("sql", "query must use GROUP BY or an aggregate function")
+		}
+		// 未写 GROUP BY 时按 SELECT 中的非聚合列分组
+		for _, s := range selects {
+			if s.aggregator == "" {
+				groupBy = append(groupBy, s)
+			}
+		}
+	}
+
+	for _, g := range groupBy {
+		if q.dimensionIndex(g) >= 0 {
+			continue
+		}
+		d := models.ChartDimension{Field: g.field, Grain: g.grain}
+		if g.grain != "" {
+			d.Type = utils.ColumnTypeDate
+		}
+		q.Config.Dimensions = append(q.Config.Dimensions, d)
+	}
+
+	for _, s := range selects {
+		out := sqlOutput{name: s.text}
+		if s.alias != "" {
+			out.name = s.alias
+		}
+		if s.aggregator != "" {
+			q.metricFor(s)
+			out.index = len(q.Config.Dimensions) + q.metricIndex(s)
+		} else {
+			i := q.dimensionIndex(s)
+			if i < 0 {
+				return errorf(s.field, "column must appear in GROUP BY or be used in an aggregate function")
+			}
+			out.index = i
+		}
+		q.output = append(q.output, out)
+	}
+	return nil
+}
+
+func (q *SQLQuery) dimensionIndex(e sqlExpr) int {
+	for i, d := range q.Config.Dimensions {
+		if d.Field == e.field && d.Grain == e.grain {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *SQLQuery) metricIndex(e sqlExpr) int {
+	for i, m := range q.Config.Metrics {
+		if m.Field == e.field && m.Aggregator == e.aggregator {
+			return i
+		}
+	}
+	return -1
+}
+
+// metricFor 返回聚合表达式对应的指标名称，不存在时追加指标（只用于排序时不出现在结果中）
+func (q *SQLQuery) metricFor(e sqlExpr) string {
+	if i := q.metricIndex(e); i >= 0 {
+		return MetricName(q.Config.Metrics[i])
+	}
+	m := models.ChartMetric{Field: e.field, Aggregator: e.aggregator}
+	q.Config.Metrics = append(q.Config.Metrics, m)
+	return MetricName(m)
+}
+
+// Project 按 SELECT 列表的顺序和别名整理引擎结果，数值维度转换为数字
+func (q *SQLQuery) Project(res *Result) *Result {
+	out := &Result{
+		Columns:     make([]Column, len(q.output)),
+		Rows:        make([][]interface{}, len(res.Rows)),
+		TotalGroups: res.TotalGroups,
+		Truncated:   res.Truncated,
+	}
+	for i, o := range q.output {
+		out.Columns[i] = res.Columns[o.index]
+		out.Columns[i].Name = o.name
+	}
+	for r, row := range res.Rows {
+		values := make([]interface{}, len(q.output))
+		for i, o := range q.output {
+			v := row[o.index]
+			if s, ok := v.(string); ok && out.Columns[i].Type == utils.ColumnTypeNumber {
+				if n, ok := utils.ParseNumber(s); ok {
+					v = n
+				}
+			}
+			values[i] = v
+		}
+		out.Rows[r] = values
+	}
+	return out
+}
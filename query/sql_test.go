@@ -0,0 +1,290 @@
+// query/sql_test.go
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"bi-backend/models"
+)
+
+func TestParseSQL(t *testing.T) {
+	tests := []struct {
+		name       string
+		sql        string
+		from       string
+		dimensions []models.ChartDimension
+		metrics    []models.ChartMetric
+		filters    []models.ChartFilter
+		sort       []models.ChartSort
+		limit      int
+		columns    []string // SELECT 列表的输出列名
+	}{
+		{
+			name:       "group by column",
+			sql:        "SELECT region, SUM(amount) FROM sales GROUP BY region",
+			from:       "sales",
+			dimensions: []models.ChartDimension{{Field: "region"}},
+			metrics:    []models.ChartMetric{{Field: "amount", Aggregator: "sum"}},
+			columns:    []string{"region", "sum(amount)"},
+		},
+		{
+			name:    "aggregate only",
+			sql:     "select count(*) from sales;",
+			from:    "sales",
+			metrics: []models.ChartMetric{{Aggregator: "count"}},
+			columns: []string{"count(*)"},
+		},
+		{
+			name:       "implicit group by",
+			sql:        "SELECT region, AVG(amount) total FROM sales",
+			from:       "sales",
+			dimensions: []models.ChartDimension{{Field: "region"}},
+			metrics:    []models.ChartMetric{{Field: "amount", Aggregator: "avg"}},
+			columns:    []string{"region", "total"},
+		},
+		{
+			name:    "quoted data source and columns",
+			sql:     "SELECT COUNT(DISTINCT \"customer id\") FROM 'Q1 sales'",
+			from:    "Q1 sales",
+			metrics: []models.ChartMetric{{Field: "customer id", Aggregator: "count_distinct"}},
+			columns: []string{"count_distinct(customer id)"},
+		},
+		{
+			name:       "quoted keyword alias",
+			sql:        "SELECT region AS \"from\", SUM(amount) AS `select` FROM sales GROUP BY 1",
+			from:       "sales",
+			dimensions: []models.ChartDimension{{Field: "region"}},
+			metrics:    []models.ChartMetric{{Field: "amount", Aggregator: "sum"}},
+			columns:    []string{"from", "select"},
+		},
+		{
+			name:       "date_trunc",
+			sql:        "SELECT DATE_TRUNC('Month', created_at) AS m, SUM(amount) FROM sales GROUP BY m ORDER BY m",
+			from:       "sales",
+			dimensions: []models.ChartDimension{{Field: "created_at", Type: "date", Grain: GrainMonth}},
+			metrics:    []models.ChartMetric{{Field: "amount", Aggregator: "sum"}},
+			sort:       []models.ChartSort{{Field: "created_at", Order: "asc"}},
+			columns:    []string{"m", "sum(amount)"},
+		},
+		{
+			name:       "group and order by position",
+			sql:        "SELECT region, product, MAX(amount) FROM sales GROUP BY 1, 2 ORDER BY 3 DESC, 1 LIMIT 10",
+			from:       "sales",
+			dimensions: []models.ChartDimension{{Field: "region"}, {Field: "product"}},
+			metrics:    []models.ChartMetric{{Field: "amount", Aggregator: "max"}},
+			sort:       []models.ChartSort{{Field: "max(amount)", Order: "desc"}, {Field: "region", Order: "asc"}},
+			limit:      10,
+			columns:    []string{"region", "product", "max(amount)"},
+		},
+		{
+			name:       "order by alias",
+			sql:        "SELECT region AS r, SUM(amount) AS total FROM sales GROUP BY r ORDER BY total DESC LIMIT 5",
+			from:       "sales",
+			dimensions: []models.ChartDimension{{Field: "region"}},
+			metrics:    []models.ChartMetric{{Field: "amount", Aggregator: "sum"}},
+			sort:       []models.ChartSort{{Field: "sum(amount)", Order: "desc"}},
+			limit:      5,
+			columns:    []string{"r", "total"},
+		},
+		{
+			name:       "order by aggregate not selected",
+			sql:        "SELECT region FROM sales GROUP BY region ORDER BY COUNT(*) DESC",
+			from:       "sales",
+			dimensions: []models.ChartDimension{{Field: "region"}},
+			metrics:    []models.ChartMetric{{Aggregator: "count"}},
+			sort:       []models.ChartSort{{Field: "count(*)", Order: "desc"}},
+			columns:    []string{"region"},
+		},
+		{
+			name: "where conditions",
+			sql: "SELECT SUM(amount) FROM sales WHERE region IN ('east', 'west') AND amount > -5 " +
+				"AND profit BETWEEN -1.5 AND 10 AND note IS NOT NULL AND city NOT IN (1, 2)",
+			from:    "sales",
+			metrics: []models.ChartMetric{{Field: "amount", Aggregator: "sum"}},
+			filters: []models.ChartFilter{
+				{Field: "region", Operator: OpIn, Values: []interface{}{"east", "west"}},
+				{Field: "amount", Operator: OpGt, Values: []interface{}{"-5"}},
+				{Field: "profit", Operator: OpBetween, Values: []interface{}{"-1.5", "10"}},
+				{Field: "note", Operator: OpNotNull},
+				{Field: "city", Operator: OpNotIn, Values: []interface{}{"1", "2"}},
+			},
+			columns: []string{"sum(amount)"},
+		},
+		{
+			name:    "comparison operators",
+			sql:     "SELECT COUNT(*) FROM sales WHERE a = 'x' AND b <> 'y' AND c != 'z' AND d <= 1e-3 AND e >= 2 AND f < 3 AND g IS NULL",
+			from:    "sales",
+			metrics: []models.ChartMetric{{Aggregator: "count"}},
+			filters: []models.ChartFilter{
+				{Field: "a", Operator: OpEq, Values: []interface{}{"x"}},
+				{Field: "b", Operator: OpNeq, Values: []interface{}{"y"}},
+				{Field: "c", Operator: OpNeq, Values: []interface{}{"z"}},
+				{Field: "d", Operator: OpLte, Values: []interface{}{"1e-3"}},
+				{Field: "e", Operator: OpGte, Values: []interface{}{"2"}},
+				{Field: "f", Operator: OpLt, Values: []interface{}{"3"}},
+				{Field: "g", Operator: OpIsNull},
+			},
+			columns: []string{"count(*)"},
+		},
+		{
+			name:    "like patterns",
+			sql:     "SELECT COUNT(*) FROM sales WHERE a LIKE 'ab%' AND b LIKE '%cd' AND c LIKE '%e''f%' AND d NOT LIKE '%g%' AND e LIKE 'h'",
+			from:    "sales",
+			metrics: []models.ChartMetric{{Aggregator: "count"}},
+			filters: []models.ChartFilter{
+				{Field: "a", Operator: OpStartsWith, Values: []interface{}{"ab"}},
+				{Field: "b", Operator: OpEndsWith, Values: []interface{}{"cd"}},
+				{Field: "c", Operator: OpContains, Values: []interface{}{"e'f"}},
+				{Field: "d", Operator: OpNotContains, Values: []interface{}{"g"}},
+				{Field: "e", Operator: OpEq, Values: []interface{}{"h"}},
+			},
+			columns: []string{"count(*)"},
+		},
+		{
+			name:       "comments",
+			sql:        "-- 按地区汇总\nSELECT region, SUM(amount) -- 金额\nFROM sales GROUP BY region",
+			from:       "sales",
+			dimensions: []models.ChartDimension{{Field: "region"}},
+			metrics:    []models.ChartMetric{{Field: "amount", Aggregator: "sum"}},
+			columns:    []string{"region", "sum(amount)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseSQL(tt.sql)
+			if err != nil {
+				t.Fatalf("ParseSQL(%q) error: %v", tt.sql, err)
+			}
+			if q.From != tt.from {
+				t.Errorf("From = %q, want %q", q.From, tt.from)
+			}
+			if !reflect.DeepEqual(q.Config.Dimensions, tt.dimensions) {
+				t.Errorf("Dimensions = %+v, want %+v", q.Config.Dimensions, tt.dimensions)
+			}
+			if !reflect.DeepEqual(q.Config.Metrics, tt.metrics) {
+				t.Errorf("Metrics = %+v, want %+v", q.Config.Metrics, tt.metrics)
+			}
+			if !reflect.DeepEqual(q.Config.Filters, tt.filters) {
+				t.Errorf("Filters = %+v, want %+v", q.Config.Filters, tt.filters)
+			}
+			if !reflect.DeepEqual(q.Config.Sort, tt.sort) {
+				t.Errorf("Sort = %+v, want %+v", q.Config.Sort, tt.sort)
+			}
+			if q.Limit != tt.limit {
+				t.Errorf("Limit = %d, want %d", q.Limit, tt.limit)
+			}
+			var columns []string
+			for _, o := range q.output {
+				columns = append(columns, o.name)
+			}
+			if !reflect.DeepEqual(columns, tt.columns) {
+				t.Errorf("columns = %q, want %q", columns, tt.columns)
+			}
+		})
+	}
+}
+
+func TestParseSQLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"empty", "", "syntax error at position 1"},
+		{"not a select", "DELETE FROM sales", "syntax error at position 1"},
+		{"select star", "SELECT * FROM sales", "SELECT * is not supported"},
+		{"distinct", "SELECT DISTINCT region FROM sales", "SELECT DISTINCT is not supported"},
+		{"missing from", "SELECT SUM(amount)", "syntax error"},
+		{"join", "SELECT COUNT(*) FROM a JOIN b", "only a single data source"},
+		{"comma join", "SELECT COUNT(*) FROM a, b", "only a single data source"},
+		{"or", "SELECT COUNT(*) FROM sales WHERE a = 1 OR b = 2", "OR is not supported"},
+		{"having", "SELECT region, COUNT(*) FROM sales GROUP BY region HAVING COUNT(*) > 1", "HAVING is not supported"},
+		{"not between", "SELECT COUNT(*) FROM sales WHERE a NOT BETWEEN 1 AND 2", "NOT BETWEEN is not supported"},
+		{"dangling not", "SELECT COUNT(*) FROM sales WHERE a NOT = 1", "expected IN, BETWEEN or LIKE after NOT"},
+		{"function in where", "SELECT COUNT(*) FROM sales WHERE lower(a) = 'x'", "functions are not supported in WHERE"},
+		{"unsupported function", "SELECT STDDEV(amount) FROM sales", "unsupported function STDDEV"},
+		{"bad date_trunc unit", "SELECT DATE_TRUNC('decade', d), COUNT(*) FROM sales GROUP BY 1", "unsupported DATE_TRUNC unit"},
+		{"unterminated string", "SELECT COUNT(*) FROM sales WHERE a = 'x", "unterminated quoted text"},
+		{"unterminated identifier", "SELECT COUNT(\"a) FROM sales", "unterminated quoted text"},
+		{"invalid character", "SELECT COUNT(*) FROM sales WHERE a = 1 # b", "unexpected character '#'"},
+		{"invalid number", "SELECT COUNT(*) FROM sales WHERE a = 1.2.3", "invalid number 1.2.3"},
+		{"missing value", "SELECT COUNT(*) FROM sales WHERE a =", "expected a string or number literal"},
+		{"comment swallows value", "SELECT COUNT(*) FROM sales WHERE a > --5", "expected a string or number literal"},
+		{"column compared to column", "SELECT COUNT(*) FROM sales WHERE a = b", "expected a string or number literal"},
+		{"missing operator", "SELECT COUNT(*) FROM sales WHERE a 1", "expected a comparison operator"},
+		{"keyword alias", "SELECT region AS from, COUNT(*) FROM sales GROUP BY 1", "expected a column name"},
+		{"keyword column", "SELECT SUM(select) FROM sales", "expected a column name"},
+		{"keyword data source", "SELECT COUNT(*) FROM where", "expected a column name"},
+		{"unbalanced parenthesis", "SELECT SUM(amount FROM sales", "syntax error"},
+		{"unclosed in list", "SELECT COUNT(*) FROM sales WHERE a IN (1, 2", "syntax error"},
+		{"no aggregate", "SELECT region FROM sales", "query must use GROUP BY or an aggregate function"},
+		{"aggregate in group by", "SELECT SUM(amount) FROM sales GROUP BY SUM(amount)", "aggregate functions are not allowed in GROUP BY"},
+		{"aggregate position in group by", "SELECT region, SUM(amount) FROM sales GROUP BY 2", "aggregate functions are not allowed in GROUP BY"},
+		{"column not grouped", "SELECT region, product, SUM(amount) FROM sales GROUP BY region", "column must appear in GROUP BY"},
+		{"order by not grouped", "SELECT region, SUM(amount) FROM sales GROUP BY region ORDER BY product", "ORDER BY column must appear in GROUP BY"},
+		{"group by position zero", "SELECT region, SUM(amount) FROM sales GROUP BY 0", "position 0 is not in the select list"},
+		{"order by position out of range", "SELECT region, SUM(amount) FROM sales GROUP BY 1 ORDER BY 3", "position 3 is not in the select list"},
+		{"order by fractional position", "SELECT region, SUM(amount) FROM sales GROUP BY 1 ORDER BY 1.5", "position 1.5 is not in the select list"},
+		{"limit zero", "SELECT COUNT(*) FROM sales LIMIT 0", "LIMIT requires a positive integer"},
+		{"negative limit", "SELECT COUNT(*) FROM sales LIMIT -1", "LIMIT requires a positive integer"},
+		{"fractional limit", "SELECT COUNT(*) FROM sales LIMIT 2.5", "LIMIT requires a positive integer"},
+		{"limit without value", "SELECT COUNT(*) FROM sales LIMIT", "LIMIT requires a positive integer"},
+		{"offset", "SELECT COUNT(*) FROM sales LIMIT 10 OFFSET 5", "unexpected \"OFFSET\""},
+		{"trailing tokens", "SELECT COUNT(*) FROM sales; DROP TABLE sales", "unexpected \"DROP\""},
+		{"like wildcard inside", "SELECT COUNT(*) FROM sales WHERE a LIKE 'a%b'", "LIKE only supports"},
+		{"like underscore", "SELECT COUNT(*) FROM sales WHERE a LIKE 'a_'", "LIKE only supports"},
+		{"like only wildcard", "SELECT COUNT(*) FROM sales WHERE a LIKE '%'", "LIKE only supports"},
+		{"not like prefix", "SELECT COUNT(*) FROM sales WHERE a NOT LIKE 'ab%'", "NOT LIKE only supports"},
+		{"too long", "SELECT COUNT(*) FROM sales WHERE a IN (" + strings.Repeat("1, ", MaxSQLLength/3) + "1)", "query is longer than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseSQL(tt.sql)
+			if err == nil {
+				t.Fatalf("ParseSQL(%q) = %+v, want error containing %q", tt.sql, q.Config, tt.want)
+			}
+			if _, ok := err.(*Error); !ok {
+				t.Errorf("error type = %T, want *Error", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLProject(t *testing.T) {
+	q, err := ParseSQL("SELECT SUM(amount) AS total, bucket AS b FROM sales GROUP BY bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 引擎结果中维度在前、指标在后，投影后恢复 SELECT 的顺序并把数值维度转换为数字
+	res := &Result{
+		Columns: []Column{
+			{Name: "bucket", Type: "number", Role: "dimension"},
+			{Name: "sum(amount)", Type: "number", Role: "metric"},
+		},
+		Rows:        [][]interface{}{{"10", 3.5}, {"", 1.0}},
+		TotalGroups: 2,
+	}
+	out := q.Project(res)
+
+	wantColumns := []Column{
+		{Name: "total", Type: "number", Role: "metric"},
+		{Name: "b", Type: "number", Role: "dimension"},
+	}
+	if !reflect.DeepEqual(out.Columns, wantColumns) {
+		t.Errorf("Columns = %+v, want %+v", out.Columns, wantColumns)
+	}
+	wantRows := [][]interface{}{{3.5, 10.0}, {1.0, ""}}
+	if !reflect.DeepEqual(out.Rows, wantRows) {
+		t.Errorf("Rows = %v, want %v", out.Rows, wantRows)
+	}
+	if out.TotalGroups != 2 {
+		t.Errorf("TotalGroups = %d, want 2", out.TotalGroups)
+	}
+}
@@ -0,0 +1,133 @@
+// query/table.go
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// Table 查询的数据表，来自数据源的表头、数据行和列类型
+type Table struct {
+	Headers       []string
+	Rows          [][]string
+	Schema        []models.ColumnSchema
+	Preprocessing []models.PreprocessingConfig
+}
+
+// NewTable 由数据源构造数据表，没有 schema 的历史数据源按数据推断列类型
+func NewTable(ds *models.DataSource) *Table {
+	schema := ds.Schema
+	if len(schema) == 0 && len(ds.Content) > 0 {
+		schema = utils.InferSchema(ds.Headers, ds.Content)
+	}
+	return &Table{
+		Headers:       ds.Headers,
+		Rows:          ds.Content,
+		Schema:        schema,
+		Preprocessing: ds.Preprocessing,
+	}
+}
+
+// Error 查询配置错误，Field 为出错的字段
+type Error struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func errorf(field, format string, args ...interface{}) *Error {
+	return &Error{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// column 数据表中的一列
+type column struct {
+	Name   string
+	Index  int
+	Type   string // number, date, boolean, string
+	Layout string // 日期列的解析格式，为空时自动识别
+}
+
+// column 查找列并确定列类型，预处理配置优先于导入时推断的类型
+func (t *Table) column(name string) (*column, error) {
+	index := -1
+	for i, h := range t.Headers {
+		if h == name {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, errorf(name, "field does not exist in data source")
+	}
+
+	col := &column{Name: name, Index: index, Type: utils.ColumnTypeString}
+	if index < len(t.Schema) && t.Schema[index].Name == name {
+		col.Type = t.Schema[index].Type
+		col.Layout = t.Schema[index].Format
+	} else {
+		for _, s := range t.Schema {
+			if s.Name == name {
+				col.Type, col.Layout = s.Type, s.Format
+				break
+			}
+		}
+	}
+
+	for _, p := range t.Preprocessing {
+		if p.Field != name {
+			continue
+		}
+		switch p.Type {
+		case "number":
+			col.Type = utils.ColumnTypeNumber
+		case "date":
+			col.Type = utils.ColumnTypeDate
+			if p.Format != "" {
+				col.Layout = utils.NormalizeDateLayout(p.Format)
+			}
+		case "text":
+			col.Type = utils.ColumnTypeString
+		}
+	}
+	return col, nil
+}
+
+// cell 取出单元格的原始值
+func (c *column) cell(row []string) string {
+	if c.Index >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[c.Index])
+}
+
+// number 将单元格解析为数值
+func (c *column) number(row []string) (float64, bool) {
+	v := c.cell(row)
+	if utils.IsNullValue(v) {
+		return 0, false
+	}
+	return utils.ParseNumber(v)
+}
+
+// date 将单元格解析为时间
+func (c *column) date(row []string, loc *time.Location) (time.Time, bool) {
+	v := c.cell(row)
+	if utils.IsNullValue(v) {
+		return time.Time{}, false
+	}
+	if t, ok := utils.ParseDate(v, c.Layout, loc); ok {
+		return t, true
+	}
+	// 格式不一致的值退回自动识别
+	return utils.ParseDate(v, "", loc)
+}
@@ -0,0 +1,179 @@
+// query/timegrain.go
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 时间粒度
+const (
+	GrainHour    = "hour"
+	GrainDay     = "day"
+	GrainWeek    = "week"
+	GrainMonth   = "month"
+	GrainQuarter = "quarter"
+	GrainYear    = "year"
+)
+
+// validGrain 判断是否为支持的时间粒度
+func validGrain(grain string) bool {
+	switch grain {
+	case GrainHour, GrainDay, GrainWeek, GrainMonth, GrainQuarter, GrainYear:
+		return true
+	}
+	return false
+}
+
+// truncateTime 截断到所在周期的起点，周从周一开始
+func truncateTime(t time.Time, grain string) time.Time {
+	loc := t.Location()
+	switch grain {
+	case GrainHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case GrainDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	case GrainWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, loc)
+	case GrainMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	case GrainQuarter:
+		month := (t.Month()-1)/3*3 + 1
+		return time.Date(t.Year(), month, 1, 0, 0, 0, 0, loc)
+	case GrainYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, loc)
+	}
+	return t
+}
+
+// addGrain 在周期起点上增加 n 个周期
+func addGrain(t time.Time, grain string, n int) time.Time {
+	switch grain {
+	case GrainHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+n, 0, 0, 0, t.Location())
+	case GrainDay:
+		return t.AddDate(0, 0, n)
+	case GrainWeek:
+		return t.AddDate(0, 0, 7*n)
+	case GrainMonth:
+		return t.AddDate(0, n, 0)
+	case GrainQuarter:
+		return t.AddDate(0, 3*n, 0)
+	case GrainYear:
+		return t.AddDate(n, 0, 0)
+	}
+	return t
+}
+
+// 缺失周期的补齐方式
+const (
+	FillZero = "zero"
+	FillNull = "null"
+)
+
+// maxFillPeriods 补齐缺失周期时允许生成的最大周期数
+const maxFillPeriods = 10000
+
+// grainLabel 时间粒度的默认标签格式
+func grainLabel(t time.Time, grain string) string {
+	switch grain {
+	case GrainHour:
+		return t.Format("2006-01-02 15:00")
+	case GrainMonth:
+		return t.Format("2006-01")
+	case GrainQuarter:
+		return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())-1)/3+1)
+	case GrainYear:
+		return t.Format("2006")
+	}
+	return t.Format("2006-01-02")
+}
+
+// fillGaps 为配置了补齐方式的日期维度补齐缺失的周期，其余维度的每种组合分别补齐
+func (q *compiled) fillGaps(groups []*group) ([]*group, error) {
+	target := -1
+	for i, d := range q.dims {
+		if d.def.Fill != "" {
+			target = i
+			break
+		}
+	}
+	if target < 0 || len(groups) == 0 {
+		return groups, nil
+	}
+	dim := q.dims[target]
+	loc := q.opts.location()
+
+	// 确定时间范围
+	var minT, maxT time.Time
+	found := false
+	for _, g := range groups {
+		v := g.dims[target]
+		if v.Null || v.Other || !v.Ordered {
+			continue
+		}
+		t := time.Unix(int64(v.Num), 0).In(loc)
+		if !found || t.Before(minT) {
+			minT = t
+		}
+		if !found || t.After(maxT) {
+			maxT = t
+		}
+		found = true
+	}
+	if !found {
+		return groups, nil
+	}
+
+	periods := []time.Time{}
+	for t := minT; !t.After(maxT); t = addGrain(t, dim.def.Grain, 1) {
+		if len(periods) >= maxFillPeriods {
+			return nil, errorf(dim.def.Field, "too many %s periods to fill, use a coarser time grain", dim.def.Grain)
+		}
+		periods = append(periods, t)
+	}
+
+	// 其余维度的组合及其已有的周期
+	type combo struct {
+		dims    []dimValue
+		periods map[int64]bool
+	}
+	combos := []*combo{}
+	index := map[string]*combo{}
+	for _, g := range groups {
+		var key strings.Builder
+		for i, v := range g.dims {
+			if i != target {
+				key.WriteString(v.Label)
+				key.WriteByte(0)
+			}
+		}
+		c, ok := index[key.String()]
+		if !ok {
+			c = &combo{dims: g.dims, periods: map[int64]bool{}}
+			index[key.String()] = c
+			combos = append(combos, c)
+		}
+		c.periods[int64(g.dims[target].Num)] = true
+	}
+
+	for _, c := range combos {
+		for _, t := range periods {
+			if c.periods[t.Unix()] {
+				continue
+			}
+			dims := append([]dimValue{}, c.dims...)
+			dims[target] = dim.timeValue(t)
+			g := &group{dims: dims, metrics: make([]interface{}, len(q.metrics))}
+			if dim.def.Fill == FillZero {
+				for i := range g.metrics {
+					g.metrics[i] = float64(0)
+				}
+			}
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
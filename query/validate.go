@@ -0,0 +1,124 @@
+// query/validate.go
+package query
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"bi-backend/models"
+)
+
+// parameterRefs 表达式中引用的参数
+var parameterRefs = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// Validate 按数据表的表头和列类型检查图表配置，返回所有字段级错误，配置有效时返回空；
+// pivot 为 true 时按透视表配置检查。只检查配置，不读取数据行
+func Validate(t *Table, cfg models.ChartConfig, pivot bool) []*Error {
+	var errs []*Error
+	add := func(err error) bool {
+		if err == nil {
+			return false
+		}
+		var queryErr *Error
+		if !errors.As(err, &queryErr) {
+			queryErr = &Error{Message: err.Error()}
+		}
+		errs = append(errs, queryErr)
+		return true
+	}
+
+	// 参数在写入时还没有取值，用占位值检查表达式的结构
+	opts := &Options{Parameters: map[string]interface{}{}}
+	for _, text := range expressions(cfg) {
+		for _, m := range parameterRefs.FindAllStringSubmatch(text, -1) {
+			opts.Parameters[m[1]] = float64(1)
+		}
+	}
+	add(applyTimezone(cfg, opts))
+
+	// 计算列逐个追加，出错的计算列不影响其余配置的检查
+	table := &Table{Headers: t.Headers, Schema: t.Schema, Preprocessing: t.Preprocessing}
+	for _, def := range cfg.CalculatedColumns {
+		next, err := table.withCalculatedColumns(context.Background(), []models.CalculatedColumn{def}, opts)
+		if !add(err) {
+			table = next
+		}
+	}
+
+	dimsOK := true
+	dims := cfg.Dimensions
+	if pivot {
+		if err := checkPivot(cfg); err != nil {
+			add(err)
+		} else {
+			dims = append(append([]models.ChartDimension{}, cfg.Pivot.Rows...), cfg.Pivot.Columns...)
+		}
+	}
+	for _, d := range dims {
+		if _, err := compileDimension(table, d, opts); add(err) {
+			dimsOK = false
+		}
+	}
+	for _, d := range cfg.Hierarchy {
+		_, err := compileDimension(table, d, opts)
+		add(err)
+	}
+
+	metricsOK := true
+	for _, m := range cfg.Metrics {
+		if _, err := compileMetric(table, m, opts); add(err) {
+			metricsOK = false
+		}
+	}
+
+	for _, f := range cfg.Filters {
+		if referencesParameter(f) {
+			// 参数值在查询时才确定，这里只检查字段
+			_, err := table.column(f.Field)
+			add(err)
+			continue
+		}
+		_, err := compileFilter(table, f, opts)
+		add(err)
+	}
+
+	// 排序和二次计算依赖维度和指标，二者都有效时才检查
+	if !dimsOK || !metricsOK || pivot {
+		return errs
+	}
+	base := cfg
+	base.Filters = nil
+	q, err := compile(table, base, opts)
+	if add(err) {
+		return errs
+	}
+	add(q.compileCalculations())
+	add(q.sortBy(nil, q.dims, cfg.Sort))
+	return errs
+}
+
+// expressions 配置中所有的计算列和指标表达式
+func expressions(cfg models.ChartConfig) []string {
+	var out []string
+	for _, c := range cfg.CalculatedColumns {
+		out = append(out, c.Expression)
+	}
+	for _, m := range cfg.Metrics {
+		if m.Expression != "" {
+			out = append(out, m.Expression)
+		}
+	}
+	return out
+}
+
+// referencesParameter 过滤值中是否引用了参数
+func referencesParameter(f models.ChartFilter) bool {
+	for _, v := range f.Values {
+		if s, ok := v.(string); ok && parameterRef.MatchString(strings.TrimSpace(s)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,341 @@
+// query/window.go
+package query
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-backend/models"
+	"bi-backend/utils"
+)
+
+// 指标的二次计算类型
+const (
+	CalcCumulativeSum  = "cumulative_sum"
+	CalcMovingAvg      = "moving_avg"
+	CalcPercentOfTotal = "percent_of_total"
+	CalcRank           = "rank"
+	CalcDelta          = "delta" // 与对比周期的差值
+	CalcRatio          = "ratio" // 与对比周期相比的变化率 (当前-对比)/对比
+)
+
+// 对比周期
+const (
+	PeriodPrevious = "previous" // 排序维度上的上一个分组
+	PeriodWoW      = "wow"
+	PeriodMoM      = "mom"
+	PeriodQoQ      = "qoq"
+	PeriodYoY      = "yoy"
+)
+
+// periodShift 对比周期相对当前周期的偏移，coarsest 为可用的最粗时间粒度
+type periodShift struct {
+	years, months, days int
+	coarsest            string
+}
+
+var periodShifts = map[string]periodShift{
+	PeriodWoW: {days: 7, coarsest: GrainWeek},
+	PeriodMoM: {months: 1, coarsest: GrainMonth},
+	PeriodQoQ: {months: 3, coarsest: GrainQuarter},
+	PeriodYoY: {years: 1, coarsest: GrainYear},
+}
+
+// grainOrder 时间粒度从细到粗的顺序
+var grainOrder = map[string]int{
+	GrainHour: 0, GrainDay: 1, GrainWeek: 2, GrainMonth: 3, GrainQuarter: 4, GrainYear: 5,
+}
+
+// compileCalculations 检查指标的二次计算，并选出排序维度：优先第一个日期维度，否则为第一个维度
+func (q *compiled) compileCalculations() error {
+	for i, d := range q.dims {
+		if d.Type == utils.ColumnTypeDate && d.bin == nil {
+			q.order = i
+			break
+		}
+	}
+	if q.order < 0 && len(q.dims) > 0 {
+		q.order = 0
+	}
+
+	for _, m := range q.metrics {
+		c := m.def.Calculation
+		if c == nil {
+			continue
+		}
+		switch c.Type {
+		case CalcCumulativeSum, CalcPercentOfTotal:
+		case CalcMovingAvg:
+			if c.Window < 1 {
+				return errorf(m.def.Field, "moving_avg requires a window of at least 1")
+			}
+		case CalcRank:
+			if c.Order != "" && !strings.EqualFold(c.Order, "asc") && !strings.EqualFold(c.Order, "desc") {
+				return errorf(m.def.Field, "rank order must be asc or desc")
+			}
+		case CalcDelta, CalcRatio:
+			if c.Period == "" || c.Period == PeriodPrevious {
+				break
+			}
+			shift, ok := periodShifts[c.Period]
+			if !ok {
+				return errorf(m.def.Field, "unsupported comparison period %q", c.Period)
+			}
+			if q.order < 0 || q.dims[q.order].Type != utils.ColumnTypeDate || q.dims[q.order].def.Grain == "" {
+				return errorf(m.def.Field, "period %s requires a date dimension with a time grain", c.Period)
+			}
+			if grain := q.dims[q.order].def.Grain; grainOrder[grain] > grainOrder[shift.coarsest] {
+				return errorf(m.def.Field, "period %s requires a time grain of %s or finer", c.Period, shift.coarsest)
+			}
+		default:
+			return errorf(m.def.Field, "unsupported calculation %q", c.Type)
+		}
+	}
+	return nil
+}
+
+// calculate 在聚合结果上计算二次指标，所有计算都基于原始聚合值。
+// 累计、移动平均和与上一分组的对比按排序维度之外的维度分区，占比和排名作用于全部分组
+func (q *compiled) calculate(ctx context.Context, groups []*group) error {
+	needed := false
+	for _, m := range q.metrics {
+		needed = needed || m.def.Calculation != nil
+	}
+	if !needed || len(groups) == 0 {
+		return nil
+	}
+
+	base := make([][]interface{}, len(groups))
+	for i, g := range groups {
+		base[i] = append([]interface{}{}, g.metrics...)
+	}
+	partitions, err := q.partitions(groups)
+	if err != nil {
+		return err
+	}
+	var previous map[string][]interface{}
+
+	for j, m := range q.metrics {
+		c := m.def.Calculation
+		if c == nil {
+			continue
+		}
+		values := make([]interface{}, len(groups))
+		switch c.Type {
+		case CalcPercentOfTotal:
+			total, any := 0.0, false
+			for _, row := range base {
+				if v, ok := row[j].(float64); ok {
+					total, any = total+v, true
+				}
+			}
+			for i, row := range base {
+				if v, ok := row[j].(float64); ok && any && total != 0 {
+					values[i] = v / total
+				}
+			}
+		case CalcRank:
+			rankValues(base, j, !strings.EqualFold(c.Order, "asc"), values)
+		case CalcCumulativeSum:
+			for _, p := range partitions {
+				sum, started := 0.0, false
+				for _, i := range p {
+					if v, ok := base[i][j].(float64); ok {
+						sum, started = sum+v, true
+					}
+					if started {
+						values[i] = sum
+					}
+				}
+			}
+		case CalcMovingAvg:
+			for _, p := range partitions {
+				for k, i := range p {
+					sum, n := 0.0, 0
+					for w := k - c.Window + 1; w <= k; w++ {
+						if w < 0 {
+							continue
+						}
+						if v, ok := base[p[w]][j].(float64); ok {
+							sum, n = sum+v, n+1
+						}
+					}
+					if n > 0 {
+						values[i] = sum / float64(n)
+					}
+				}
+			}
+		case CalcDelta, CalcRatio:
+			if c.Period == "" || c.Period == PeriodPrevious {
+				for _, p := range partitions {
+					for k := 1; k < len(p); k++ {
+						values[p[k]] = compareValues(c.Type, base[p[k]][j], base[p[k-1]][j])
+					}
+				}
+				break
+			}
+			if previous == nil {
+				if previous, err = q.periodIndex(ctx, groups); err != nil {
+					return err
+				}
+			}
+			for i, g := range groups {
+				key, ok := q.shiftedKey(g, periodShifts[c.Period])
+				if !ok {
+					continue
+				}
+				if prev := previous[key]; prev != nil {
+					values[i] = compareValues(c.Type, base[i][j], prev[j])
+				}
+			}
+		}
+		for i, g := range groups {
+			g.metrics[j] = values[i]
+		}
+	}
+	return nil
+}
+
+// compareValues 计算当前值与对比值的差值或变化率，任一为空或对比值为 0 时变化率为空
+func compareValues(calc string, cur, prev interface{}) interface{} {
+	a, ok1 := cur.(float64)
+	b, ok2 := prev.(float64)
+	if !ok1 || !ok2 {
+		return nil
+	}
+	if calc == CalcDelta {
+		return a - b
+	}
+	if b == 0 {
+		return nil
+	}
+	return (a - b) / b
+}
+
+// rankValues 计算排名，相同的值名次相同，空值不参与排名
+func rankValues(base [][]interface{}, j int, desc bool, values []interface{}) {
+	idx := []int{}
+	for i, row := range base {
+		if _, ok := row[j].(float64); ok {
+			idx = append(idx, i)
+		}
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		c := compareFloat(base[idx[a]][j].(float64), base[idx[b]][j].(float64))
+		if desc {
+			c = -c
+		}
+		return c < 0
+	})
+	rank := 0
+	for k, i := range idx {
+		if k == 0 || base[i][j].(float64) != base[idx[k-1]][j].(float64) {
+			rank = k + 1
+		}
+		values[i] = float64(rank)
+	}
+}
+
+// partitionKey 排序维度之外的维度取值组合
+func (q *compiled) partitionKey(g *group) string {
+	var key strings.Builder
+	for i, v := range g.dims {
+		if i == q.order {
+			continue
+		}
+		if v.Null {
+			key.WriteString("\x01")
+		}
+		key.WriteString(v.Label)
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+// partitions 按分区返回分组下标，分区内按排序维度排列；
+// 排序维度为文本时沿用图表的排序规则（此时指标仍为原始聚合值），便于计算帕累托累计
+func (q *compiled) partitions(groups []*group) ([][]int, error) {
+	sorted := append([]*group{}, groups...)
+	if q.order >= 0 {
+		d := q.dims[q.order]
+		if d.Type == utils.ColumnTypeString && d.bin == nil {
+			if err := q.sortBy(sorted, q.dims, q.cfg.Sort); err != nil {
+				return nil, err
+			}
+		} else {
+			sort.SliceStable(sorted, func(i, j int) bool {
+				return compareDimValues(sorted[i].dims[q.order], sorted[j].dims[q.order]) < 0
+			})
+		}
+	}
+
+	pos := make(map[*group]int, len(groups))
+	for i, g := range groups {
+		pos[g] = i
+	}
+	index := map[string]int{}
+	partitions := [][]int{}
+	for _, g := range sorted {
+		key := q.partitionKey(g)
+		p, ok := index[key]
+		if !ok {
+			p = len(partitions)
+			index[key] = p
+			partitions = append(partitions, nil)
+		}
+		partitions[p] = append(partitions[p], pos[g])
+	}
+	return partitions, nil
+}
+
+// periodIndex 按分区和周期索引对比用的聚合值。
+// 对比周期通常落在日期过滤范围之外，因此忽略作用于排序维度字段的过滤条件重新聚合
+func (q *compiled) periodIndex(ctx context.Context, groups []*group) (map[string][]interface{}, error) {
+	field := q.dims[q.order].def.Field
+	relaxed := make([]models.ChartFilter, 0, len(q.filters))
+	for _, f := range q.filters {
+		if f.Field != field {
+			relaxed = append(relaxed, f)
+		}
+	}
+
+	source := groups
+	if len(relaxed) < len(q.filters) {
+		filter, err := compileFilters(q.table, relaxed, q.opts)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := q.selectRows(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		rows, keep := q.restrict(rows)
+		if source, err = q.aggregate(ctx, rows, q.dims, keep); err != nil {
+			return nil, err
+		}
+	}
+
+	index := make(map[string][]interface{}, len(source))
+	for _, g := range source {
+		v := g.dims[q.order]
+		if v.Null || v.Other || !v.Ordered {
+			continue
+		}
+		index[q.partitionKey(g)+strconv.FormatInt(int64(v.Num), 10)] = g.metrics
+	}
+	return index, nil
+}
+
+// shiftedKey 分组对应的对比周期在 periodIndex 中的键
+func (q *compiled) shiftedKey(g *group, shift periodShift) (string, bool) {
+	v := g.dims[q.order]
+	if v.Null || v.Other || !v.Ordered {
+		return "", false
+	}
+	t := time.Unix(int64(v.Num), 0).In(q.opts.location())
+	prev := truncateTime(t.AddDate(-shift.years, -shift.months, -shift.days), q.dims[q.order].def.Grain)
+	return q.partitionKey(g) + strconv.FormatInt(prev.Unix(), 10), true
+}
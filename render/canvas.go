@@ -0,0 +1,259 @@
+// render/canvas.go
+package render
+
+import (
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// 文本对齐方式
+const (
+	alignStart  = 0.0
+	alignMiddle = 0.5
+	alignEnd    = 1.0
+)
+
+type point struct{ x, y float64 }
+
+// canvas 绘图后端，PNG 和 SVG 共用同一套图表绘制逻辑，坐标原点在左上角
+type canvas interface {
+	rect(x, y, w, h float64, fill color.Color)
+	line(x1, y1, x2, y2, width float64, stroke color.Color)
+	polyline(points []point, width float64, stroke color.Color)
+	polygon(points []point, fill color.Color)
+	circle(x, y, r float64, fill color.Color)
+	wedge(cx, cy, r, start, end float64, fill color.Color) // 角度为弧度，0 指向正上方，顺时针
+	text(x, y float64, s string, size, align float64, c color.Color)
+	measure(s string, size float64) float64
+	encode(w io.Writer) error
+}
+
+// wedgePoints 扇形的轮廓点，SVG 和 PNG 都用多边形近似圆弧
+func wedgePoints(cx, cy, r, start, end float64) []point {
+	steps := int(math.Ceil((end-start)/(math.Pi/90))) + 1
+	points := []point{{cx, cy}}
+	for i := 0; i <= steps; i++ {
+		a := start + (end-start)*float64(i)/float64(steps)
+		points = append(points, point{cx + r*math.Sin(a), cy - r*math.Cos(a)})
+	}
+	return points
+}
+
+// approxWidth 估算文本宽度，中日韩字符按全角计算
+func approxWidth(s string, size float64) float64 {
+	w := 0.0
+	for _, r := range s {
+		if r > 0x2e80 {
+			w += size
+		} else {
+			w += size * 0.6
+		}
+	}
+	return w
+}
+
+// pngCanvas 基于 gg 的位图后端
+type pngCanvas struct {
+	ctx *gg.Context
+}
+
+var (
+	fontTTF *truetype.Font
+	faceMu  sync.Mutex
+	faces   = map[float64]font.Face{}
+)
+
+// setFont 设置 PNG 渲染使用的 TrueType 字体，未设置时使用内置的等宽字体（不支持中文）
+func setFont(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := truetype.Parse(data)
+	if err != nil {
+		return fmt.Errorf("parse font %s: %w", path, err)
+	}
+	faceMu.Lock()
+	fontTTF = f
+	faces = map[float64]font.Face{}
+	faceMu.Unlock()
+	return nil
+}
+
+// face 按字号取字体，没有配置字体时返回空，使用 gg 的默认字体
+func face(size float64) font.Face {
+	faceMu.Lock()
+	defer faceMu.Unlock()
+	if fontTTF == nil {
+		return nil
+	}
+	if f, ok := faces[size]; ok {
+		return f
+	}
+	f := truetype.NewFace(fontTTF, &truetype.Options{Size: size})
+	faces[size] = f
+	return f
+}
+
+func newPNGCanvas(width, height int) *pngCanvas {
+	ctx := gg.NewContext(width, height)
+	ctx.SetColor(color.White)
+	ctx.Clear()
+	return &pngCanvas{ctx: ctx}
+}
+
+func (p *pngCanvas) rect(x, y, w, h float64, fill color.Color) {
+	p.ctx.DrawRectangle(x, y, w, h)
+	p.ctx.SetColor(fill)
+	p.ctx.Fill()
+}
+
+func (p *pngCanvas) line(x1, y1, x2, y2, width float64, stroke color.Color) {
+	p.ctx.SetLineWidth(width)
+	p.ctx.SetColor(stroke)
+	p.ctx.DrawLine(x1, y1, x2, y2)
+	p.ctx.Stroke()
+}
+
+func (p *pngCanvas) polyline(points []point, width float64, stroke color.Color) {
+	if len(points) == 0 {
+		return
+	}
+	p.ctx.SetLineWidth(width)
+	p.ctx.SetColor(stroke)
+	p.ctx.MoveTo(points[0].x, points[0].y)
+	for _, pt := range points[1:] {
+		p.ctx.LineTo(pt.x, pt.y)
+	}
+	p.ctx.Stroke()
+}
+
+func (p *pngCanvas) polygon(points []point, fill color.Color) {
+	if len(points) == 0 {
+		return
+	}
+	p.ctx.MoveTo(points[0].x, points[0].y)
+	for _, pt := range points[1:] {
+		p.ctx.LineTo(pt.x, pt.y)
+	}
+	p.ctx.ClosePath()
+	p.ctx.SetColor(fill)
+	p.ctx.Fill()
+}
+
+func (p *pngCanvas) circle(x, y, r float64, fill color.Color) {
+	p.ctx.DrawCircle(x, y, r)
+	p.ctx.SetColor(fill)
+	p.ctx.Fill()
+}
+
+func (p *pngCanvas) wedge(cx, cy, r, start, end float64, fill color.Color) {
+	p.polygon(wedgePoints(cx, cy, r, start, end), fill)
+}
+
+func (p *pngCanvas) useFace(size float64) {
+	if f := face(size); f != nil {
+		p.ctx.SetFontFace(f)
+	}
+}
+
+func (p *pngCanvas) text(x, y float64, s string, size, align float64, c color.Color) {
+	p.useFace(size)
+	p.ctx.SetColor(c)
+	p.ctx.DrawStringAnchored(s, x, y, align, 0.35)
+}
+
+func (p *pngCanvas) measure(s string, size float64) float64 {
+	p.useFace(size)
+	w, _ := p.ctx.MeasureString(s)
+	return w
+}
+
+func (p *pngCanvas) encode(w io.Writer) error {
+	return p.ctx.EncodePNG(w)
+}
+
+// svgCanvas 矢量后端，直接输出 SVG 文本
+type svgCanvas struct {
+	width, height int
+	b             strings.Builder
+}
+
+func newSVGCanvas(width, height int) *svgCanvas {
+	s := &svgCanvas{width: width, height: height}
+	s.rect(0, 0, float64(width), float64(height), color.White)
+	return s
+}
+
+// svgColor 颜色转换为 SVG 的 fill/stroke 属性值
+func svgColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("rgba(%d,%d,%d,%.3g)", n.R, n.G, n.B, float64(n.A)/0xff)
+}
+
+func svgPoints(points []point) string {
+	parts := make([]string, len(points))
+	for i, pt := range points {
+		parts[i] = fmt.Sprintf("%.2f,%.2f", pt.x, pt.y)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *svgCanvas) rect(x, y, w, h float64, fill color.Color) {
+	fmt.Fprintf(&s.b, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"/>`+"\n", x, y, w, h, svgColor(fill))
+}
+
+func (s *svgCanvas) line(x1, y1, x2, y2, width float64, stroke color.Color) {
+	fmt.Fprintf(&s.b, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="%s" stroke-width="%.2g"/>`+"\n", x1, y1, x2, y2, svgColor(stroke), width)
+}
+
+func (s *svgCanvas) polyline(points []point, width float64, stroke color.Color) {
+	fmt.Fprintf(&s.b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%.2g" stroke-linejoin="round"/>`+"\n", svgPoints(points), svgColor(stroke), width)
+}
+
+func (s *svgCanvas) polygon(points []point, fill color.Color) {
+	fmt.Fprintf(&s.b, `<polygon points="%s" fill="%s"/>`+"\n", svgPoints(points), svgColor(fill))
+}
+
+func (s *svgCanvas) circle(x, y, r float64, fill color.Color) {
+	fmt.Fprintf(&s.b, `<circle cx="%.2f" cy="%.2f" r="%.2f" fill="%s"/>`+"\n", x, y, r, svgColor(fill))
+}
+
+func (s *svgCanvas) wedge(cx, cy, r, start, end float64, fill color.Color) {
+	s.polygon(wedgePoints(cx, cy, r, start, end), fill)
+}
+
+func (s *svgCanvas) text(x, y float64, str string, size, align float64, c color.Color) {
+	anchor := "start"
+	switch align {
+	case alignMiddle:
+		anchor = "middle"
+	case alignEnd:
+		anchor = "end"
+	}
+	fmt.Fprintf(&s.b, `<text x="%.2f" y="%.2f" font-size="%.3g" text-anchor="%s" dominant-baseline="central" fill="%s">%s</text>`+"\n",
+		x, y, size, anchor, svgColor(c), html.EscapeString(str))
+}
+
+func (s *svgCanvas) measure(str string, size float64) float64 {
+	return approxWidth(str, size)
+}
+
+func (s *svgCanvas) encode(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif">`+"\n%s</svg>\n",
+		s.width, s.height, s.width, s.height, s.b.String())
+	return err
+}
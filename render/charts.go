@@ -0,0 +1,821 @@
+// render/charts.go
+package render
+
+import (
+	"image/color"
+	"math"
+	"strings"
+
+	"bi-backend/models"
+	"bi-backend/query"
+)
+
+const (
+	padding   = 16.0
+	titleSize = 16.0
+	fontSize  = 11.0
+	tickCount = 5
+)
+
+// chart 一次渲染的状态，top 为标题和图例之下可用区域的起点
+type chart struct {
+	c        canvas
+	typ      string
+	cfg      models.ChartConfig
+	res      *query.Result
+	settings settings
+	width    float64
+	height   float64
+	colors   []color.Color
+	top      float64
+}
+
+// series 直角坐标系中的一个系列，ok 为 false 的位置没有取值
+type series struct {
+	name   string
+	values []float64
+	ok     []bool
+	kind   string // bar, line, area
+	right  bool   // 使用右侧坐标轴
+}
+
+func (ch *chart) color(i int) color.Color {
+	return ch.colors[i%len(ch.colors)]
+}
+
+func (ch *chart) drawTitle(title string) {
+	ch.top = padding
+	if title != "" {
+		ch.c.text(ch.width/2, ch.top+titleSize/2, title, titleSize, alignMiddle, textColor)
+		ch.top += titleSize + 10
+	}
+}
+
+// roles 按角色返回结果列的下标
+func (ch *chart) roles(role string) []int {
+	var out []int
+	for i, col := range ch.res.Columns {
+		if col.Role == role {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// column 按名称查找结果列，也可以使用指标或维度的原始字段名
+func (ch *chart) column(field string) int {
+	if field == "" {
+		return -1
+	}
+	for i, col := range ch.res.Columns {
+		if col.Name == field {
+			return i
+		}
+	}
+	for _, m := range ch.cfg.Metrics {
+		if m.Field == field {
+			name := query.MetricName(m)
+			for i, col := range ch.res.Columns {
+				if col.Name == name {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// valueRange 列中数值的最小值和最大值
+func (ch *chart) valueRange(col int) (min, max float64, ok bool) {
+	for _, row := range ch.res.Rows {
+		v, valid := toFloat(row[col])
+		if !valid {
+			continue
+		}
+		if !ok || v < min {
+			min = v
+		}
+		if !ok || v > max {
+			max = v
+		}
+		ok = true
+	}
+	return min, max, ok
+}
+
+func (ch *chart) noData() error {
+	ch.c.text(ch.width/2, (ch.top+ch.height)/2, "No data", fontSize*1.4, alignMiddle, axisColor)
+	return nil
+}
+
+// truncate 截断超出宽度的文本
+func (ch *chart) truncate(s string, maxWidth float64) string {
+	if ch.c.measure(s, fontSize) <= maxWidth {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 1 {
+		runes = runes[:len(runes)-1]
+		if t := string(runes) + "…"; ch.c.measure(t, fontSize) <= maxWidth {
+			return t
+		}
+	}
+	return string(runes)
+}
+
+// showLegend settings.showLegend 未设置时显示图例
+func (ch *chart) showLegend() bool {
+	v, set := ch.settings["showLegend"].(bool)
+	return !set || v
+}
+
+// drawLegend 在标题下方横向绘制图例，放不下时换行
+func (ch *chart) drawLegend(names []string, colors []color.Color) {
+	if len(names) == 0 || !ch.showLegend() {
+		return
+	}
+	const box = 10.0
+	x, y := padding, ch.top
+	for i, name := range names {
+		name = ch.truncate(name, ch.width/3)
+		w := box + 4 + ch.c.measure(name, fontSize) + 14
+		if x+w > ch.width-padding && x > padding {
+			x, y = padding, y+fontSize+8
+		}
+		ch.c.rect(x, y+1, box, box, colors[i])
+		ch.c.text(x+box+4, y+box/2+1, name, fontSize, alignStart, textColor)
+		x += w
+	}
+	ch.top = y + fontSize + 12
+}
+
+// drawColorLegend 在 x 处绘制竖向色阶和最小、最大值
+func (ch *chart) drawColorLegend(scale *colorScale, x, y0, y1 float64) {
+	const steps = 40
+	h := (y1 - y0) / steps
+	for i := 0; i < steps; i++ {
+		v := scale.max - (scale.max-scale.min)*(float64(i)+0.5)/steps
+		ch.c.rect(x, y0+float64(i)*h, 12, h+0.5, scale.at(v))
+	}
+	ch.c.text(x+16, y0+fontSize/2, formatNumber(scale.max), fontSize, alignStart, textColor)
+	ch.c.text(x+16, y1-fontSize/2, formatNumber(scale.min), fontSize, alignStart, textColor)
+}
+
+// colorLegendWidth 色阶图例占用的宽度
+func (ch *chart) colorLegendWidth(scale *colorScale) float64 {
+	w := math.Max(ch.c.measure(formatNumber(scale.max), fontSize), ch.c.measure(formatNumber(scale.min), fontSize))
+	return 12 + 4 + w + padding
+}
+
+// visualColorScale 按 visualMap.colorField 对应列的取值构造色阶，未配置或找不到列时返回空
+func (ch *chart) visualColorScale() (*colorScale, int) {
+	vm := ch.cfg.VisualMap
+	if vm == nil {
+		return nil, -1
+	}
+	col := ch.column(vm.ColorField)
+	if col < 0 {
+		return nil, -1
+	}
+	min, max, ok := ch.valueRange(col)
+	if !ok {
+		return nil, -1
+	}
+	return newColorScale(vm.ColorRange, min, max), col
+}
+
+// categorySeries 将结果转换为类目和系列：两个维度一个指标时第二个维度的取值作为系列，
+// 否则每个指标一个系列，多个维度的取值拼接为类目
+func (ch *chart) categorySeries() ([]string, []*series, [][]int) {
+	dims, metrics := ch.roles("dimension"), ch.roles("metric")
+	var cats []string
+	catIndex := map[string]int{}
+	rowsByCat := [][]int{}
+	category := func(key string) int {
+		i, ok := catIndex[key]
+		if !ok {
+			i = len(cats)
+			catIndex[key] = i
+			cats = append(cats, key)
+			rowsByCat = append(rowsByCat, nil)
+		}
+		return i
+	}
+
+	var out []*series
+	if len(dims) == 2 && len(metrics) == 1 {
+		seriesIndex := map[string]*series{}
+		for r, row := range ch.res.Rows {
+			cat := category(label(row[dims[0]]))
+			rowsByCat[cat] = append(rowsByCat[cat], r)
+			name := label(row[dims[1]])
+			s, ok := seriesIndex[name]
+			if !ok {
+				s = &series{name: name, kind: ch.kind(0)}
+				seriesIndex[name] = s
+				out = append(out, s)
+			}
+			s.set(cat, row[metrics[0]])
+		}
+	} else {
+		for i, m := range metrics {
+			out = append(out, &series{name: ch.res.Columns[m].Name, kind: ch.kind(i), right: ch.dual() && i > 0})
+		}
+		for r, row := range ch.res.Rows {
+			parts := make([]string, len(dims))
+			for i, d := range dims {
+				parts[i] = label(row[d])
+			}
+			cat := category(strings.Join(parts, " / "))
+			rowsByCat[cat] = append(rowsByCat[cat], r)
+			for i, m := range metrics {
+				out[i].set(cat, row[m])
+			}
+		}
+	}
+	for _, s := range out {
+		s.set(len(cats)-1, nil)
+	}
+	return cats, out, rowsByCat
+}
+
+// set 设置第 i 个类目上的取值，必要时扩展切片
+func (s *series) set(i int, v interface{}) {
+	for len(s.values) <= i {
+		s.values = append(s.values, 0)
+		s.ok = append(s.ok, false)
+	}
+	if f, ok := toFloat(v); ok {
+		s.values[i], s.ok[i] = f, true
+	}
+}
+
+// dual 是否使用左右两个坐标轴
+func (ch *chart) dual() bool {
+	return ch.typ == "dual_axis" || (ch.cfg.DualAxis != nil && ch.cfg.DualAxis.Enabled)
+}
+
+// kind 第 i 个指标的图形，双轴图按 dualAxis.types 指定，默认第一个指标为柱形、其余为折线
+func (ch *chart) kind(i int) string {
+	if !ch.dual() {
+		return ch.typ
+	}
+	if d := ch.cfg.DualAxis; d != nil && i < len(d.Types) && d.Types[i] != "" {
+		return d.Types[i]
+	}
+	if i == 0 {
+		return "bar"
+	}
+	return "line"
+}
+
+// axisRange 系列在坐标轴上的取值范围，堆叠时按每个类目的正负值分别累加
+func axisRange(ss []*series, stacked bool) (min, max float64) {
+	if stacked {
+		n := 0
+		for _, s := range ss {
+			if len(s.values) > n {
+				n = len(s.values)
+			}
+		}
+		for i := 0; i < n; i++ {
+			pos, neg := 0.0, 0.0
+			for _, s := range ss {
+				if i < len(s.values) && s.ok[i] {
+					if s.values[i] >= 0 {
+						pos += s.values[i]
+					} else {
+						neg += s.values[i]
+					}
+				}
+			}
+			max, min = math.Max(max, pos), math.Min(min, neg)
+		}
+		return min, max
+	}
+	for _, s := range ss {
+		for i, v := range s.values {
+			if s.ok[i] {
+				max, min = math.Max(max, v), math.Min(min, v)
+			}
+		}
+	}
+	return min, max
+}
+
+// linear 线性比例尺，将 [lo, hi] 映射到 [a, b]
+func linear(lo, hi, a, b float64) func(float64) float64 {
+	return func(v float64) float64 {
+		if hi == lo {
+			return (a + b) / 2
+		}
+		return a + (v-lo)/(hi-lo)*(b-a)
+	}
+}
+
+// drawCartesian 绘制柱状图、折线图、面积图和双轴图
+func (ch *chart) drawCartesian() error {
+	cats, ss, rowsByCat := ch.categorySeries()
+	if len(cats) == 0 || len(ss) == 0 {
+		return ch.noData()
+	}
+	names := make([]string, len(ss))
+	colors := make([]color.Color, len(ss))
+	for i, s := range ss {
+		names[i], colors[i] = s.name, ch.color(i)
+	}
+	if len(ss) > 1 {
+		ch.drawLegend(names, colors)
+	}
+
+	stacked := ch.settings.boolean("stacked") && (ch.typ == "bar" || ch.typ == "area")
+	var left, right []*series
+	for _, s := range ss {
+		if s.right {
+			right = append(right, s)
+		} else {
+			left = append(left, s)
+		}
+	}
+	lo, hi := axisRange(left, stacked)
+	leftTicks := niceTicks(lo, hi, tickCount)
+	var rightTicks []float64
+	if len(right) > 0 {
+		lo, hi = axisRange(right, false)
+		rightTicks = niceTicks(lo, hi, tickCount)
+	}
+
+	// 绘图区域
+	tickWidth := func(ticks []float64) float64 {
+		w := 0.0
+		for _, t := range ticks {
+			w = math.Max(w, ch.c.measure(formatNumber(t), fontSize))
+		}
+		return w
+	}
+	x0 := padding + tickWidth(leftTicks) + 6
+	if ch.settings.str("yAxisLabel") != "" {
+		x0 += fontSize + 6
+	}
+	x1 := ch.width - padding
+	if len(rightTicks) > 0 {
+		x1 -= tickWidth(rightTicks) + 6
+	}
+	scale, colorCol := ch.visualColorScale()
+	if scale != nil {
+		x1 -= ch.colorLegendWidth(scale)
+	}
+	y0 := ch.top + fontSize/2
+	y1 := ch.height - padding - fontSize - 6
+	if ch.settings.str("xAxisLabel") != "" {
+		y1 -= fontSize + 6
+	}
+	if x1-x0 < 10 || y1-y0 < 10 {
+		return &query.Error{Field: "size", Message: "image is too small for the chart"}
+	}
+
+	yLeft := linear(leftTicks[0], leftTicks[len(leftTicks)-1], y1, y0)
+	for _, t := range leftTicks {
+		y := yLeft(t)
+		ch.c.line(x0, y, x1, y, 1, gridColor)
+		ch.c.text(x0-6, y, formatNumber(t), fontSize, alignEnd, textColor)
+	}
+	yRight := yLeft
+	if len(rightTicks) > 0 {
+		yRight = linear(rightTicks[0], rightTicks[len(rightTicks)-1], y1, y0)
+		for _, t := range rightTicks {
+			ch.c.text(x1+6, yRight(t), formatNumber(t), fontSize, alignStart, textColor)
+		}
+		ch.c.line(x1, y0, x1, y1, 1, axisColor)
+	}
+	ch.c.line(x0, y0, x0, y1, 1, axisColor)
+	zero := yLeft(math.Max(leftTicks[0], math.Min(0, leftTicks[len(leftTicks)-1])))
+	ch.c.line(x0, zero, x1, zero, 1, axisColor)
+	if label := ch.settings.str("yAxisLabel"); label != "" {
+		ch.c.text(padding, y0-fontSize/2+2, label, fontSize, alignStart, axisColor)
+	}
+	if label := ch.settings.str("xAxisLabel"); label != "" {
+		ch.c.text((x0+x1)/2, ch.height-padding-fontSize/2, label, fontSize, alignMiddle, axisColor)
+	}
+
+	// 类目轴，标签过密时间隔显示
+	band := (x1 - x0) / float64(len(cats))
+	labelWidth := 0.0
+	for _, cat := range cats {
+		labelWidth = math.Max(labelWidth, ch.c.measure(cat, fontSize))
+	}
+	every := int(math.Ceil((math.Min(labelWidth, 120) + 8) / band))
+	if every < 1 {
+		every = 1
+	}
+	for i, cat := range cats {
+		if i%every != 0 {
+			continue
+		}
+		x := x0 + band*(float64(i)+0.5)
+		ch.c.text(x, y1+fontSize/2+6, ch.truncate(cat, band*float64(every)-4), fontSize, alignMiddle, textColor)
+	}
+
+	showLabel := ch.settings.boolean("showLabel")
+	var bars []int
+	for i, s := range ss {
+		if s.kind == "bar" {
+			bars = append(bars, i)
+		}
+	}
+
+	// 柱形
+	groupWidth := band * 0.8
+	barWidth := groupWidth
+	if !stacked && len(bars) > 0 {
+		barWidth = groupWidth / float64(len(bars))
+	}
+	if w, ok := ch.settings.number("barSize"); ok && w > 0 && w < barWidth {
+		barWidth = w
+	}
+	posBase := make([]float64, len(cats))
+	negBase := make([]float64, len(cats))
+	for slot, si := range bars {
+		s := ss[si]
+		y := yLeft
+		if s.right {
+			y = yRight
+		}
+		for i, v := range s.values {
+			if !s.ok[i] {
+				continue
+			}
+			start := 0.0
+			if stacked {
+				if v >= 0 {
+					start = posBase[i]
+					posBase[i] += v
+				} else {
+					start = negBase[i]
+					negBase[i] += v
+				}
+			}
+			x := x0 + band*float64(i) + (band-groupWidth)/2
+			if stacked {
+				x += (groupWidth - barWidth) / 2
+			} else {
+				x += float64(slot)*(groupWidth/float64(len(bars))) + (groupWidth/float64(len(bars))-barWidth)/2
+			}
+			top, bottom := y(start+v), y(start)
+			fill := ch.color(si)
+			if scale != nil && len(ss) == 1 {
+				if rows := rowsByCat[i]; len(rows) > 0 {
+					if cv, ok := toFloat(ch.res.Rows[rows[0]][colorCol]); ok {
+						fill = scale.at(cv)
+					}
+				}
+			}
+			ch.c.rect(x, math.Min(top, bottom), barWidth, math.Abs(bottom-top), fill)
+			if showLabel {
+				ch.c.text(x+barWidth/2, math.Min(top, bottom)-fontSize/2-2, formatNumber(v), fontSize, alignMiddle, textColor)
+			}
+		}
+	}
+
+	// 面积和折线
+	areaBase := make([]float64, len(cats))
+	for si, s := range ss {
+		if s.kind != "line" && s.kind != "area" {
+			continue
+		}
+		y := yLeft
+		if s.right {
+			y = yRight
+		}
+		c := ch.color(si)
+		var segment []point
+		var baseline []point
+		flush := func() {
+			if len(segment) == 0 {
+				return
+			}
+			if s.kind == "area" {
+				poly := append([]point{}, segment...)
+				for i := len(baseline) - 1; i >= 0; i-- {
+					poly = append(poly, baseline[i])
+				}
+				ch.c.polygon(poly, withAlpha(c, 0.5))
+			}
+			width := 2.0
+			if w, ok := ch.settings.number("strokeWidth"); ok && w > 0 {
+				width = w
+			}
+			ch.c.polyline(segment, width, c)
+			segment, baseline = nil, nil
+		}
+		for i, v := range s.values {
+			if !s.ok[i] {
+				flush()
+				continue
+			}
+			x := x0 + band*(float64(i)+0.5)
+			start := 0.0
+			if stacked && s.kind == "area" {
+				start = areaBase[i]
+				areaBase[i] += v
+			}
+			segment = append(segment, point{x, y(start + v)})
+			baseline = append(baseline, point{x, y(start)})
+		}
+		flush()
+
+		dots, set := ch.settings["showDots"].(bool)
+		for i, v := range s.values {
+			if !s.ok[i] {
+				continue
+			}
+			x := x0 + band*(float64(i)+0.5)
+			if (!set || dots) && s.kind == "line" {
+				ch.c.circle(x, y(v), 2.5, c)
+			}
+			if showLabel && !stacked {
+				ch.c.text(x, y(v)-fontSize/2-4, formatNumber(v), fontSize, alignMiddle, textColor)
+			}
+		}
+	}
+
+	if scale != nil {
+		ch.drawColorLegend(scale, ch.width-ch.colorLegendWidth(scale)+padding/2, y0, y1)
+	}
+	return nil
+}
+
+// drawPie 绘制饼图和环形图，第一个维度为扇区，第一个指标为取值，非正数被忽略
+func (ch *chart) drawPie() error {
+	dims, metrics := ch.roles("dimension"), ch.roles("metric")
+	if len(metrics) == 0 {
+		return ch.noData()
+	}
+	var labels []string
+	var values []float64
+	total := 0.0
+	for _, row := range ch.res.Rows {
+		v, ok := toFloat(row[metrics[0]])
+		if !ok || v <= 0 {
+			continue
+		}
+		name := ch.res.Columns[metrics[0]].Name
+		if len(dims) > 0 {
+			name = label(row[dims[0]])
+		}
+		labels = append(labels, name)
+		values = append(values, v)
+		total += v
+	}
+	if total == 0 {
+		return ch.noData()
+	}
+
+	// 图例在右侧
+	legendWidth := 0.0
+	entries := make([]string, len(labels))
+	if ch.showLegend() {
+		for i, l := range labels {
+			entries[i] = ch.truncate(l, ch.width/4) + "  " + formatNumber(values[i]/total*100) + "%"
+			legendWidth = math.Max(legendWidth, ch.c.measure(entries[i], fontSize)+24)
+		}
+	}
+	areaW := ch.width - 2*padding - legendWidth
+	areaH := ch.height - ch.top - padding
+	r := math.Min(areaW, areaH) / 2
+	if r < 10 {
+		return &query.Error{Field: "size", Message: "image is too small for the chart"}
+	}
+	if outer, ok := ch.settings.number("outerRadius"); ok && outer > 0 && outer < r {
+		r = outer
+	}
+	cx, cy := padding+areaW/2, ch.top+areaH/2
+
+	angle := 0.0
+	for i, v := range values {
+		end := angle + v/total*2*math.Pi
+		ch.c.wedge(cx, cy, r, angle, end, ch.color(i))
+		angle = end
+	}
+	inner, _ := ch.settings.number("innerRadius")
+	if inner > 0 {
+		ch.c.circle(cx, cy, math.Min(inner, r*0.9), color.White)
+	}
+	if ch.settings.boolean("showLabel") || ch.settings.boolean("showPercent") {
+		angle = 0
+		labelR := r * 0.7
+		if inner > 0 {
+			labelR = (r + math.Min(inner, r*0.9)) / 2
+		}
+		for _, v := range values {
+			share := v / total
+			mid := angle + share*math.Pi
+			angle += share * 2 * math.Pi
+			if share < 0.04 {
+				continue
+			}
+			ch.c.text(cx+labelR*math.Sin(mid), cy-labelR*math.Cos(mid), formatNumber(share*100)+"%", fontSize, alignMiddle, color.White)
+		}
+	}
+
+	if legendWidth > 0 {
+		x := ch.width - padding - legendWidth + 8
+		y := cy - float64(len(entries))*(fontSize+6)/2
+		for i, e := range entries {
+			if y > ch.height-padding {
+				break
+			}
+			ch.c.rect(x, y, 10, 10, ch.color(i))
+			ch.c.text(x+14, y+5, e, fontSize, alignStart, textColor)
+			y += fontSize + 6
+		}
+	}
+	return nil
+}
+
+// drawScatter 绘制散点图：第一、二个指标为横纵坐标，第三个指标或 visualMap.sizeField 为点的大小，
+// visualMap.colorField 按色阶着色
+func (ch *chart) drawScatter() error {
+	metrics := ch.roles("metric")
+	if len(metrics) < 2 || len(ch.res.Rows) == 0 {
+		return ch.noData()
+	}
+	xCol, yCol := metrics[0], metrics[1]
+	sizeCol := -1
+	if len(metrics) > 2 {
+		sizeCol = metrics[2]
+	}
+	sizeRange := []float64{4, 14}
+	if vm := ch.cfg.VisualMap; vm != nil {
+		if col := ch.column(vm.SizeField); col >= 0 {
+			sizeCol = col
+		}
+		if len(vm.SizeRange) == 2 && vm.SizeRange[1] >= vm.SizeRange[0] {
+			sizeRange = vm.SizeRange
+		}
+	}
+
+	xMin, xMax, ok := ch.valueRange(xCol)
+	yMin, yMax, ok2 := ch.valueRange(yCol)
+	if !ok || !ok2 {
+		return ch.noData()
+	}
+	xTicks, yTicks := niceTicks(xMin, xMax, tickCount), niceTicks(yMin, yMax, tickCount)
+	scale, colorCol := ch.visualColorScale()
+
+	tickW := 0.0
+	for _, t := range yTicks {
+		tickW = math.Max(tickW, ch.c.measure(formatNumber(t), fontSize))
+	}
+	x0 := padding + tickW + 6
+	x1 := ch.width - padding - sizeRange[1]
+	if scale != nil {
+		x1 -= ch.colorLegendWidth(scale)
+	}
+	y0 := ch.top + fontSize/2 + sizeRange[1]
+	y1 := ch.height - padding - fontSize - 6
+	if x1-x0 < 10 || y1-y0 < 10 {
+		return &query.Error{Field: "size", Message: "image is too small for the chart"}
+	}
+	xs := linear(xTicks[0], xTicks[len(xTicks)-1], x0, x1)
+	ys := linear(yTicks[0], yTicks[len(yTicks)-1], y1, y0)
+	for _, t := range yTicks {
+		ch.c.line(x0, ys(t), x1, ys(t), 1, gridColor)
+		ch.c.text(x0-6, ys(t), formatNumber(t), fontSize, alignEnd, textColor)
+	}
+	for _, t := range xTicks {
+		ch.c.line(xs(t), y0, xs(t), y1, 1, gridColor)
+		ch.c.text(xs(t), y1+fontSize/2+6, formatNumber(t), fontSize, alignMiddle, textColor)
+	}
+	ch.c.line(x0, y0, x0, y1, 1, axisColor)
+	ch.c.line(x0, y1, x1, y1, 1, axisColor)
+
+	radius := func(row []interface{}) float64 {
+		if r, ok := ch.settings.number("pointSize"); ok && r > 0 && sizeCol < 0 {
+			return r
+		}
+		if sizeCol < 0 {
+			return sizeRange[0]
+		}
+		lo, hi, _ := ch.valueRange(sizeCol)
+		v, ok := toFloat(row[sizeCol])
+		if !ok {
+			return sizeRange[0]
+		}
+		return linear(lo, hi, sizeRange[0], sizeRange[1])(v)
+	}
+	for _, row := range ch.res.Rows {
+		x, okX := toFloat(row[xCol])
+		y, okY := toFloat(row[yCol])
+		if !okX || !okY {
+			continue
+		}
+		fill := ch.color(0)
+		if scale != nil {
+			if v, ok := toFloat(row[colorCol]); ok {
+				fill = scale.at(v)
+			}
+		}
+		ch.c.circle(xs(x), ys(y), radius(row), withAlpha(fill, 0.7))
+	}
+	if scale != nil {
+		ch.drawColorLegend(scale, ch.width-ch.colorLegendWidth(scale)+padding/2, y0, y1)
+	}
+	return nil
+}
+
+// drawHeatmap 绘制热力图：第一个维度为横轴，第二个维度为纵轴，第一个指标按 visualMap.colorRange 着色
+func (ch *chart) drawHeatmap() error {
+	dims, metrics := ch.roles("dimension"), ch.roles("metric")
+	if len(dims) < 2 || len(metrics) == 0 || len(ch.res.Rows) == 0 {
+		return ch.noData()
+	}
+	valueCol := metrics[0]
+	if vm := ch.cfg.VisualMap; vm != nil {
+		if col := ch.column(vm.ColorField); col >= 0 {
+			valueCol = col
+		}
+	}
+	min, max, ok := ch.valueRange(valueCol)
+	if !ok {
+		return ch.noData()
+	}
+	var colorRange []string
+	if ch.cfg.VisualMap != nil {
+		colorRange = ch.cfg.VisualMap.ColorRange
+	}
+	scale := newColorScale(colorRange, min, max)
+
+	index := func(values *[]string, seen map[string]int, v string) int {
+		i, ok := seen[v]
+		if !ok {
+			i = len(*values)
+			seen[v] = i
+			*values = append(*values, v)
+		}
+		return i
+	}
+	var xs, ys []string
+	xSeen, ySeen := map[string]int{}, map[string]int{}
+	type cell struct {
+		x, y int
+		v    float64
+	}
+	var cells []cell
+	for _, row := range ch.res.Rows {
+		x := index(&xs, xSeen, label(row[dims[0]]))
+		y := index(&ys, ySeen, label(row[dims[1]]))
+		if v, ok := toFloat(row[valueCol]); ok {
+			cells = append(cells, cell{x, y, v})
+		}
+	}
+
+	yLabelW := 0.0
+	for _, y := range ys {
+		yLabelW = math.Max(yLabelW, ch.c.measure(y, fontSize))
+	}
+	x0 := padding + math.Min(yLabelW, ch.width/4) + 6
+	x1 := ch.width - padding - ch.colorLegendWidth(scale)
+	y0 := ch.top
+	y1 := ch.height - padding - fontSize - 6
+	if x1-x0 < 10 || y1-y0 < 10 {
+		return &query.Error{Field: "size", Message: "image is too small for the chart"}
+	}
+	cw := (x1 - x0) / float64(len(xs))
+	rh := (y1 - y0) / float64(len(ys))
+	showValues := ch.settings.boolean("showValues") || ch.settings.boolean("showLabel")
+	for _, c := range cells {
+		fill := scale.at(c.v)
+		ch.c.rect(x0+float64(c.x)*cw, y0+float64(c.y)*rh, cw-1, rh-1, fill)
+		if showValues && cw > 24 && rh > fontSize {
+			ch.c.text(x0+(float64(c.x)+0.5)*cw, y0+(float64(c.y)+0.5)*rh, formatNumber(c.v), fontSize, alignMiddle, contrast(fill))
+		}
+	}
+	for i, y := range ys {
+		ch.c.text(x0-6, y0+(float64(i)+0.5)*rh, ch.truncate(y, ch.width/4), fontSize, alignEnd, textColor)
+	}
+	labelW := 0.0
+	for _, x := range xs {
+		labelW = math.Max(labelW, ch.c.measure(x, fontSize))
+	}
+	every := int(math.Ceil((math.Min(labelW, 120) + 8) / cw))
+	if every < 1 {
+		every = 1
+	}
+	for i, x := range xs {
+		if i%every == 0 {
+			ch.c.text(x0+(float64(i)+0.5)*cw, y1+fontSize/2+6, ch.truncate(x, cw*float64(every)-4), fontSize, alignMiddle, textColor)
+		}
+	}
+	ch.drawColorLegend(scale, x1+padding/2, y0, y1)
+	return nil
+}
+
+// contrast 在背景色上清晰可见的文字颜色
+func contrast(c color.Color) color.Color {
+	r, g, b, _ := c.RGBA()
+	if 0.299*float64(r>>8)+0.587*float64(g>>8)+0.114*float64(b>>8) > 150 {
+		return textColor
+	}
+	return color.White
+}
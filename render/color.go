@@ -0,0 +1,156 @@
+// render/color.go
+package render
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// defaultPalette 系列的默认颜色，与前端 recharts 图表的默认配色一致
+var defaultPalette = []string{
+	"#8884d8", "#82ca9d", "#ffc658", "#ff7300", "#0088fe",
+	"#00c49f", "#ffbb28", "#ff8042", "#a4de6c", "#d0ed57",
+}
+
+// defaultColorRange 未配置 visualMap 颜色范围时的连续色阶
+var defaultColorRange = []string{"#e0f3f8", "#4575b4"}
+
+var (
+	textColor = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	axisColor = color.RGBA{0x99, 0x99, 0x99, 0xff}
+	gridColor = color.RGBA{0xe5, 0xe5, 0xe5, 0xff}
+)
+
+// namedColors 常用的颜色名称
+var namedColors = map[string]color.RGBA{
+	"black":  {0, 0, 0, 0xff},
+	"white":  {0xff, 0xff, 0xff, 0xff},
+	"red":    {0xff, 0, 0, 0xff},
+	"green":  {0, 0x80, 0, 0xff},
+	"blue":   {0, 0, 0xff, 0xff},
+	"yellow": {0xff, 0xff, 0, 0xff},
+	"orange": {0xff, 0xa5, 0, 0xff},
+	"purple": {0x80, 0, 0x80, 0xff},
+	"gray":   {0x80, 0x80, 0x80, 0xff},
+	"grey":   {0x80, 0x80, 0x80, 0xff},
+	"pink":   {0xff, 0xc0, 0xcb, 0xff},
+	"cyan":   {0, 0xff, 0xff, 0xff},
+}
+
+// parseColor 解析 #RGB、#RRGGBB、#RRGGBBAA、rgb()、rgba() 和颜色名称
+func parseColor(s string) (color.RGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c, true
+	}
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if len(hex) != 8 || err != nil {
+			return color.RGBA{}, false
+		}
+		return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+	}
+	if strings.HasPrefix(s, "rgb") {
+		open, end := strings.Index(s, "("), strings.LastIndex(s, ")")
+		if open < 0 || end < open {
+			return color.RGBA{}, false
+		}
+		parts := strings.Split(s[open+1:end], ",")
+		if len(parts) < 3 {
+			return color.RGBA{}, false
+		}
+		var rgb [4]float64
+		rgb[3] = 1
+		for i := 0; i < len(parts) && i < 4; i++ {
+			v, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+			if err != nil {
+				return color.RGBA{}, false
+			}
+			rgb[i] = v
+		}
+		a := math.Max(0, math.Min(1, rgb[3]))
+		// image/color 使用预乘 alpha
+		return color.RGBA{
+			uint8(clamp255(rgb[0]) * a), uint8(clamp255(rgb[1]) * a), uint8(clamp255(rgb[2]) * a), uint8(a * 255),
+		}, true
+	}
+	return color.RGBA{}, false
+}
+
+func clamp255(v float64) float64 {
+	return math.Max(0, math.Min(255, v))
+}
+
+// palette 解析配置的颜色列表，无法解析的颜色被忽略，全部无效时使用默认配色
+func palette(configured []string) []color.Color {
+	var out []color.Color
+	for _, s := range configured {
+		if c, ok := parseColor(s); ok {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		for _, s := range defaultPalette {
+			c, _ := parseColor(s)
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// withAlpha 设置颜色的不透明度
+func withAlpha(c color.Color, a float64) color.Color {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = uint8(math.Round(a * 255))
+	return n
+}
+
+// colorScale 连续色阶，按取值在 [min, max] 中的位置在颜色之间线性插值
+type colorScale struct {
+	colors   []color.RGBA
+	min, max float64
+}
+
+func newColorScale(configured []string, min, max float64) *colorScale {
+	s := &colorScale{min: min, max: max}
+	for _, c := range configured {
+		if rgba, ok := parseColor(c); ok {
+			s.colors = append(s.colors, rgba)
+		}
+	}
+	if len(s.colors) < 2 {
+		s.colors = nil
+		for _, c := range defaultColorRange {
+			rgba, _ := parseColor(c)
+			s.colors = append(s.colors, rgba)
+		}
+	}
+	return s
+}
+
+// at 取值对应的颜色
+func (s *colorScale) at(v float64) color.Color {
+	t := 0.0
+	if s.max > s.min {
+		t = (v - s.min) / (s.max - s.min)
+	}
+	t = math.Max(0, math.Min(1, t))
+	pos := t * float64(len(s.colors)-1)
+	i := int(math.Floor(pos))
+	if i >= len(s.colors)-1 {
+		return s.colors[len(s.colors)-1]
+	}
+	f := pos - float64(i)
+	a, b := s.colors[i], s.colors[i+1]
+	mix := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + (float64(y)-float64(x))*f)) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
@@ -0,0 +1,270 @@
+// render/render.go
+package render
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bi-backend/config"
+	"bi-backend/models"
+	"bi-backend/query"
+)
+
+// 输出格式
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// 图片尺寸限制
+const (
+	DefaultWidth  = 800
+	DefaultHeight = 500
+	MinSize       = 100
+)
+
+// Options 渲染选项
+type Options struct {
+	Format string // png 或 svg
+	Width  int
+	Height int
+	Title  string // 标题，为空时使用 settings.title
+}
+
+var maxWidth, maxHeight = 4000, 4000
+
+// Init 加载渲染字体并设置图片尺寸上限
+func Init(cfg config.RenderConfig) error {
+	if cfg.MaxWidth > 0 {
+		maxWidth = cfg.MaxWidth
+	}
+	if cfg.MaxHeight > 0 {
+		maxHeight = cfg.MaxHeight
+	}
+	if cfg.FontPath == "" {
+		return nil
+	}
+	return setFont(cfg.FontPath)
+}
+
+// ContentType 输出格式对应的 MIME 类型
+func ContentType(format string) string {
+	if format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Supported 图表类型是否可以在服务端渲染
+func Supported(chartType string) bool {
+	switch chartType {
+	case "bar", "line", "area", "dual_axis", "pie", "scatter", "heatmap":
+		return true
+	}
+	return false
+}
+
+// Render 按图表类型和配置将查询结果绘制为 PNG 或 SVG 图片，配置错误返回 *query.Error
+func Render(w io.Writer, chartType string, cfg models.ChartConfig, res *query.Result, opts Options) error {
+	if opts.Format == "" {
+		opts.Format = FormatPNG
+	}
+	if opts.Format != FormatPNG && opts.Format != FormatSVG {
+		return &query.Error{Field: "format", Message: "format must be png or svg"}
+	}
+	if !Supported(chartType) {
+		return &query.Error{Field: "type", Message: fmt.Sprintf("%s charts cannot be rendered as images", chartType)}
+	}
+	if opts.Width == 0 {
+		opts.Width = DefaultWidth
+	}
+	if opts.Height == 0 {
+		opts.Height = DefaultHeight
+	}
+	if opts.Width < MinSize || opts.Height < MinSize {
+		return &query.Error{Field: "size", Message: fmt.Sprintf("width and height must be at least %d", MinSize)}
+	}
+	if opts.Width > maxWidth || opts.Height > maxHeight {
+		return &query.Error{Field: "size", Message: fmt.Sprintf("image must be at most %dx%d", maxWidth, maxHeight)}
+	}
+
+	var c canvas
+	if opts.Format == FormatSVG {
+		c = newSVGCanvas(opts.Width, opts.Height)
+	} else {
+		c = newPNGCanvas(opts.Width, opts.Height)
+	}
+
+	ch := &chart{
+		c:        c,
+		typ:      chartType,
+		cfg:      cfg,
+		res:      res,
+		settings: newSettings(cfg.Settings),
+		width:    float64(opts.Width),
+		height:   float64(opts.Height),
+	}
+	ch.colors = palette(ch.settings.strings("colors"))
+	title := opts.Title
+	if title == "" {
+		title = ch.settings.str("title")
+	}
+	ch.drawTitle(title)
+
+	var err error
+	switch chartType {
+	case "pie":
+		err = ch.drawPie()
+	case "scatter":
+		err = ch.drawScatter()
+	case "heatmap":
+		err = ch.drawHeatmap()
+	default:
+		err = ch.drawCartesian()
+	}
+	if err != nil {
+		return err
+	}
+	return c.encode(w)
+}
+
+// settings 图表的展示配置，来自请求时为 map，从 MongoDB 读取时为 primitive.D
+type settings map[string]interface{}
+
+func newSettings(v interface{}) settings {
+	switch s := v.(type) {
+	case map[string]interface{}:
+		return s
+	case primitive.M:
+		return settings(s)
+	case primitive.D:
+		return settings(s.Map())
+	}
+	return settings{}
+}
+
+func (s settings) boolean(key string) bool {
+	b, _ := s[key].(bool)
+	return b
+}
+
+func (s settings) number(key string) (float64, bool) {
+	switch v := s[key].(type) {
+	case float64:
+		return v, true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func (s settings) str(key string) string {
+	v, _ := s[key].(string)
+	return v
+}
+
+func (s settings) strings(key string) []string {
+	var list []interface{}
+	switch v := s[key].(type) {
+	case []interface{}:
+		list = v
+	case primitive.A:
+		list = v
+	case []string:
+		return v
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		if str, ok := item.(string); ok {
+			out = append(out, str)
+		}
+	}
+	return out
+}
+
+// label 维度取值的显示文本
+func label(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "(null)"
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// toFloat 指标取值转换为数值，空值返回 false
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, !math.IsNaN(x) && !math.IsInf(x, 0)
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	}
+	return 0, false
+}
+
+// formatNumber 坐标轴和数据标签的数值格式，大数使用 K/M/B 缩写
+func formatNumber(v float64) string {
+	abs := math.Abs(v)
+	trim := func(s string) string {
+		if strings.Contains(s, ".") {
+			s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+		}
+		return s
+	}
+	switch {
+	case abs >= 1e9:
+		return trim(strconv.FormatFloat(v/1e9, 'f', 1, 64)) + "B"
+	case abs >= 1e6:
+		return trim(strconv.FormatFloat(v/1e6, 'f', 1, 64)) + "M"
+	case abs >= 1e4:
+		return trim(strconv.FormatFloat(v/1e3, 'f', 1, 64)) + "K"
+	case abs >= 100:
+		return trim(strconv.FormatFloat(v, 'f', 0, 64))
+	}
+	return trim(strconv.FormatFloat(v, 'f', 2, 64))
+}
+
+// niceTicks 覆盖 [min, max] 的整齐刻度，首尾刻度即坐标轴的范围
+func niceTicks(min, max float64, count int) []float64 {
+	if min == max {
+		if min == 0 {
+			max = 1
+		} else {
+			min, max = math.Min(0, min), math.Max(0, max)
+			if min == max {
+				max = min + 1
+			}
+		}
+	}
+	rawStep := (max - min) / float64(count)
+	mag := math.Pow(10, math.Floor(math.Log10(rawStep)))
+	step := mag
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		step = m * mag
+		if step >= rawStep {
+			break
+		}
+	}
+	start := math.Floor(min/step) * step
+	end := math.Ceil(max/step) * step
+	var ticks []float64
+	for v := start; v <= end+step/2; v += step {
+		ticks = append(ticks, math.Round(v/step)*step)
+	}
+	return ticks
+}
@@ -0,0 +1,612 @@
+// services/alert.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bi-backend/config"
+	"bi-backend/db"
+	"bi-backend/events"
+	"bi-backend/models"
+	"bi-backend/query"
+	"bi-backend/utils"
+)
+
+// 告警检查的触发方式
+const (
+	AlertTriggerSchedule = "schedule"
+	AlertTriggerRefresh  = "refresh"
+	AlertTriggerManual   = "manual"
+)
+
+const (
+	minAlertInterval   = 5  // 定时检查的最小间隔（分钟）
+	defaultCompareDays = 7  // change 条件默认对比一周前
+	maxCompareDays     = 90 // 与告警记录的保留时间一致
+)
+
+// alertOperators 比较运算符及其显示符号
+var alertOperators = map[string]struct {
+	symbol string
+	match  func(v, threshold float64) bool
+}{
+	"gt":  {">", func(v, t float64) bool { return v > t }},
+	"gte": {">=", func(v, t float64) bool { return v >= t }},
+	"lt":  {"<", func(v, t float64) bool { return v < t }},
+	"lte": {"<=", func(v, t float64) bool { return v <= t }},
+}
+
+// ValidateAlertRule 检查告警规则并补全默认值，指标必须是图表配置中的指标
+func ValidateAlertRule(r *models.AlertRule, chart *models.Chart) error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("alert name is required")
+	}
+	if chart.Type == ChartTypePivot {
+		return errors.New("alerts are not supported on pivot charts")
+	}
+
+	var metrics []string
+	for _, m := range chart.Config.Metrics {
+		metrics = append(metrics, query.MetricName(m))
+	}
+	if len(metrics) == 0 {
+		return errors.New("chart has no metrics")
+	}
+	if r.Metric == "" {
+		r.Metric = metrics[0]
+	} else if !containsString(metrics, r.Metric) {
+		return fmt.Errorf("metric %q is not in the chart, available: %s", r.Metric, strings.Join(metrics, ", "))
+	}
+
+	if r.Reduce == "" {
+		r.Reduce = "last"
+	}
+	switch r.Reduce {
+	case "last", "first", "sum", "avg", "min", "max":
+	default:
+		return errors.New("reduce must be one of last, first, sum, avg, min, max")
+	}
+
+	if r.Condition == "" {
+		r.Condition = models.AlertConditionValue
+	}
+	switch r.Condition {
+	case models.AlertConditionValue:
+		r.CompareDays = 0
+	case models.AlertConditionChange:
+		if r.CompareDays == 0 {
+			r.CompareDays = defaultCompareDays
+		}
+		if r.CompareDays < 1 || r.CompareDays > maxCompareDays {
+			return fmt.Errorf("compare_days must be between 1 and %d", maxCompareDays)
+		}
+	default:
+		return fmt.Errorf("condition must be %s or %s", models.AlertConditionValue, models.AlertConditionChange)
+	}
+	if _, ok := alertOperators[r.Operator]; !ok {
+		return errors.New("operator must be one of gt, gte, lt, lte")
+	}
+
+	if r.IntervalMinutes < 0 || (r.IntervalMinutes > 0 && r.IntervalMinutes < minAlertInterval) {
+		return fmt.Errorf("interval_minutes must be 0 or at least %d", minAlertInterval)
+	}
+	if r.IntervalMinutes == 0 && !r.OnRefresh {
+		return errors.New("set interval_minutes or on_refresh to evaluate the alert")
+	}
+
+	recipients, err := normalizeRecipients(r.Channels.Recipients)
+	if err != nil {
+		return err
+	}
+	r.Channels.Recipients = recipients
+	if r.Channels.WebhookURL != "" {
+		u, err := url.Parse(r.Channels.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("webhook_url must be an http or https URL")
+		}
+		if err := checkWebhookHost(u.Hostname()); err != nil {
+			return err
+		}
+	}
+
+	r.NextEvalAt = nil
+	if r.IntervalMinutes > 0 {
+		next := time.Now().Add(time.Duration(r.IntervalMinutes) * time.Minute)
+		r.NextEvalAt = &next
+	}
+	if r.State == "" {
+		r.State = models.AlertStateOK
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// DescribeAlertCondition 告警条件的可读描述，例如 sum(revenue) < 10000
+func DescribeAlertCondition(r *models.AlertRule) string {
+	symbol := alertOperators[r.Operator].symbol
+	if r.Condition == models.AlertConditionChange {
+		return fmt.Sprintf("%s 较 %d 天前变化 %s %s%%", r.Metric, r.CompareDays, symbol, formatAlertValue(r.Threshold))
+	}
+	return fmt.Sprintf("%s %s %s", r.Metric, symbol, formatAlertValue(r.Threshold))
+}
+
+func formatAlertValue(v float64) string {
+	return fmt.Sprintf("%.10g", v)
+}
+
+// AlertSilenced 规则是否处于静音或暂停通知期间
+func AlertSilenced(r *models.AlertRule, now time.Time) bool {
+	return r.Muted || (r.SnoozedUntil != nil && now.Before(*r.SnoozedUntil))
+}
+
+// EvaluateAlert 查询图表指标并检查告警条件，每次检查都记录到 alert_history；
+// 从未触发变为触发时发送站内通知、邮件和 webhook。查询和配置错误记录为 error 状态，只有保存记录失败时返回错误
+func EvaluateAlert(ctx context.Context, rule *models.AlertRule, trigger string) (*models.AlertEvent, error) {
+	now := time.Now()
+	event := &models.AlertEvent{
+		RuleID:      rule.ID,
+		ChartID:     rule.ChartID,
+		Trigger:     trigger,
+		CreatedBy:   rule.CreatedBy,
+		EvaluatedAt: now,
+	}
+
+	chart, value, err := alertValue(ctx, rule)
+	switch {
+	case err != nil:
+		event.State = models.AlertStateError
+		event.Error = err.Error()
+	case value == nil:
+		event.State = models.AlertStateNoData
+	default:
+		event.Value = value
+		compared := *value
+		if rule.Condition == models.AlertConditionChange {
+			baseline, err := alertBaseline(ctx, rule, now)
+			if err != nil {
+				return nil, err
+			}
+			if baseline == nil || *baseline == 0 {
+				event.State = models.AlertStateNoData
+				break
+			}
+			change := (*value - *baseline) / math.Abs(*baseline) * 100
+			event.Baseline, event.Change = baseline, &change
+			compared = change
+		}
+		event.State = models.AlertStateOK
+		if alertOperators[rule.Operator].match(compared, rule.Threshold) {
+			event.State = models.AlertStateTriggered
+		}
+	}
+
+	firing := event.State == models.AlertStateTriggered && rule.State != models.AlertStateTriggered
+	if firing && !AlertSilenced(rule, now) {
+		event.Notified = true
+		if err := notifyAlert(ctx, rule, chart, event); err != nil {
+			event.NotifyError = err.Error()
+			log.Printf("Failed to notify alert %s: %v", rule.ID.Hex(), err)
+		}
+	}
+
+	result, err := db.GetCollection("alert_history").InsertOne(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	event.ID = result.InsertedID.(primitive.ObjectID)
+
+	set := bson.M{"state": event.State, "last_evaluated_at": now}
+	if event.Value != nil {
+		set["last_value"] = *event.Value
+	}
+	if firing {
+		set["last_triggered_at"] = now
+	}
+	if _, err := db.GetCollection("alert_rules").UpdateOne(ctx, bson.M{"_id": rule.ID}, bson.M{"$set": set}); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// alertValue 执行图表查询并按 Reduce 方式取出指标值，结果为空时返回空值
+func alertValue(ctx context.Context, rule *models.AlertRule) (*models.Chart, *float64, error) {
+	var chart models.Chart
+	err := db.GetCollection("charts").FindOne(ctx, bson.M{
+		"_id":        rule.ChartID,
+		"created_by": rule.CreatedBy,
+	}).Decode(&chart)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errors.New("chart not found")
+		}
+		return nil, nil, err
+	}
+
+	ds, err := LoadDataSource(ctx, chart.DataSourceID, chart.CreatedBy)
+	if err != nil {
+		return &chart, nil, err
+	}
+	res, err := RunChartQuery(ctx, ds, chart.Config, query.Options{Parameters: rule.Parameters})
+	if err != nil {
+		return &chart, nil, err
+	}
+
+	col := -1
+	for i, c := range res.Columns {
+		if c.Name == rule.Metric {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		return &chart, nil, &query.Error{Field: "metric", Message: fmt.Sprintf("metric %q is not in the chart result", rule.Metric)}
+	}
+
+	var values []float64
+	for _, row := range res.Rows {
+		if col >= len(row) {
+			continue
+		}
+		switch v := row[col].(type) {
+		case float64:
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				values = append(values, v)
+			}
+		case int64:
+			values = append(values, float64(v))
+		case int:
+			values = append(values, float64(v))
+		}
+	}
+	if len(values) == 0 {
+		return &chart, nil, nil
+	}
+
+	v := values[len(values)-1]
+	switch rule.Reduce {
+	case "first":
+		v = values[0]
+	case "sum", "avg":
+		v = 0
+		for _, x := range values {
+			v += x
+		}
+		if rule.Reduce == "avg" {
+			v /= float64(len(values))
+		}
+	case "min", "max":
+		v = values[0]
+		for _, x := range values[1:] {
+			if (rule.Reduce == "min" && x < v) || (rule.Reduce == "max" && x > v) {
+				v = x
+			}
+		}
+	}
+	return &chart, &v, nil
+}
+
+// alertBaseline change 条件的对比值：CompareDays 天前（前后一天内）最近一次检查记录的指标值，
+// 没有足够早的记录时返回空
+func alertBaseline(ctx context.Context, rule *models.AlertRule, now time.Time) (*float64, error) {
+	at := now.AddDate(0, 0, -rule.CompareDays)
+	var event models.AlertEvent
+	err := db.GetCollection("alert_history").FindOne(ctx, bson.M{
+		"rule_id":      rule.ID,
+		"value":        bson.M{"$exists": true},
+		"evaluated_at": bson.M{"$lte": at, "$gte": at.AddDate(0, 0, -1)},
+	}, options.FindOne().SetSort(bson.M{"evaluated_at": -1})).Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event.Value, nil
+}
+
+// alertWebhookPayload 告警 webhook 的请求体
+type alertWebhookPayload struct {
+	RuleID      string    `json:"rule_id"`
+	RuleName    string    `json:"rule_name"`
+	ChartID     string    `json:"chart_id"`
+	ChartName   string    `json:"chart_name"`
+	Condition   string    `json:"condition"`
+	State       string    `json:"state"`
+	Value       *float64  `json:"value"`
+	Baseline    *float64  `json:"baseline,omitempty"`
+	Change      *float64  `json:"change,omitempty"`
+	Threshold   float64   `json:"threshold"`
+	EvaluatedAt time.Time `json:"evaluated_at"`
+}
+
+// notifyAlert 发送站内通知，并按规则配置发送邮件和 webhook，返回邮件或 webhook 的发送错误
+func notifyAlert(ctx context.Context, rule *models.AlertRule, chart *models.Chart, event *models.AlertEvent) error {
+	condition := DescribeAlertCondition(rule)
+	value := formatAlertValue(*event.Value)
+
+	events.Publish(events.Event{
+		Type:   events.AlertTriggered,
+		UserID: rule.CreatedBy,
+		Payload: map[string]interface{}{
+			"rule_id":   rule.ID.Hex(),
+			"rule_name": rule.Name,
+			"chart_id":  rule.ChartID.Hex(),
+			"condition": condition,
+			"value":     value,
+		},
+	})
+
+	var errs []string
+	if rule.Channels.Email {
+		alert := utils.AlertEmail{
+			RuleName:    rule.Name,
+			ChartName:   chart.Name,
+			Condition:   condition,
+			Value:       value,
+			EvaluatedAt: event.EvaluatedAt.Format("2006-01-02 15:04:05 MST"),
+		}
+		if event.Baseline != nil {
+			alert.Baseline = fmt.Sprintf("%s（%d 天前，变化 %.1f%%）", formatAlertValue(*event.Baseline), rule.CompareDays, *event.Change)
+		}
+		if err := sendAlertEmail(ctx, rule, alert); err != nil {
+			errs = append(errs, "email: "+err.Error())
+		}
+	}
+
+	if rule.Channels.WebhookURL != "" {
+		payload := alertWebhookPayload{
+			RuleID:      rule.ID.Hex(),
+			RuleName:    rule.Name,
+			ChartID:     rule.ChartID.Hex(),
+			ChartName:   chart.Name,
+			Condition:   condition,
+			State:       event.State,
+			Value:       event.Value,
+			Baseline:    event.Baseline,
+			Change:      event.Change,
+			Threshold:   rule.Threshold,
+			EvaluatedAt: event.EvaluatedAt,
+		}
+		if err := postAlertWebhook(ctx, rule.Channels.WebhookURL, payload); err != nil {
+			errs = append(errs, "webhook: "+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// sendAlertEmail 发送告警邮件，未设置收件人时发送给规则创建者
+func sendAlertEmail(ctx context.Context, rule *models.AlertRule, alert utils.AlertEmail) error {
+	recipients := rule.Channels.Recipients
+	if len(recipients) == 0 {
+		email, err := userEmail(ctx, rule.CreatedBy)
+		if err != nil {
+			return err
+		}
+		recipients = []string{email}
+	}
+	return utils.SendAlertEmail(recipients, alert)
+}
+
+// errWebhookFailed 返回给用户的 webhook 失败原因，具体错误只写入日志，避免泄露内网的探测结果
+var errWebhookFailed = errors.New("delivery failed")
+
+// blockedWebhookIP 不允许 webhook 访问的地址：回环、内网、链路本地、组播和未指定地址
+func blockedWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// checkWebhookHost 解析 webhook 主机名，任一地址为内网地址时拒绝
+func checkWebhookHost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return errors.New("webhook_url host cannot be resolved")
+	}
+	for _, addr := range addrs {
+		if blockedWebhookIP(addr.IP) {
+			return errors.New("webhook_url must not point to a private or local address")
+		}
+	}
+	return nil
+}
+
+// webhookClient 调用告警 webhook 的 HTTP 客户端。连接时再次检查实际连接的地址，防止 DNS 重新绑定；
+// 不跟随重定向，不使用代理
+var webhookClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || blockedWebhookIP(ip) {
+					return fmt.Errorf("webhook address %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// postAlertWebhook 以 JSON 调用告警 webhook，非 2xx 响应（包括重定向）视为失败
+func postAlertWebhook(ctx context.Context, webhookURL string, payload alertWebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.GlobalConfig.Alert.WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		log.Printf("Alert webhook %s failed: %v", webhookURL, err)
+		return errWebhookFailed
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Printf("Alert webhook %s returned %s", webhookURL, resp.Status)
+		return errWebhookFailed
+	}
+	return nil
+}
+
+// runDueAlerts 检查到期的定时告警规则；先推进 next_eval_at 再检查，多个实例同时运行时只会被一个实例领取
+func runDueAlerts(ctx context.Context) {
+	collection := db.GetCollection("alert_rules")
+	now := time.Now()
+	for {
+		var rule models.AlertRule
+		err := collection.FindOne(ctx, bson.M{"enabled": true, "next_eval_at": bson.M{"$lte": now}},
+			options.FindOne().SetSort(bson.M{"next_eval_at": 1})).Decode(&rule)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load due alerts: %v", err)
+			return
+		}
+
+		next := now.Add(time.Duration(rule.IntervalMinutes) * time.Minute)
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": rule.ID, "next_eval_at": rule.NextEvalAt},
+			bson.M{"$set": bson.M{"next_eval_at": next}})
+		if err != nil {
+			log.Printf("Failed to claim alert %s: %v", rule.ID.Hex(), err)
+			return
+		}
+		if result.ModifiedCount == 0 {
+			continue // 已被其他实例领取
+		}
+
+		if _, err := EvaluateAlert(ctx, &rule, AlertTriggerSchedule); err != nil {
+			log.Printf("Failed to record alert evaluation %s: %v", rule.ID.Hex(), err)
+		}
+	}
+}
+
+// StartAlertScheduler 按固定间隔检查到期的告警规则
+func StartAlertScheduler(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			runDueAlerts(ctx)
+			cancel()
+		}
+	}()
+	log.Printf("Alert scheduler started, interval: %s", interval)
+}
+
+// evaluateRefreshAlerts 数据源更新后检查引用它的图表上设置了 on_refresh 的告警规则
+func evaluateRefreshAlerts(e events.Event) {
+	hex, _ := e.Payload["data_source_id"].(string)
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	cursor, err := db.GetCollection("charts").Find(ctx, bson.M{"data_source_id": id},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Printf("Failed to load charts of data source %s: %v", hex, err)
+		return
+	}
+	var charts []models.Chart
+	if err := cursor.All(ctx, &charts); err != nil || len(charts) == 0 {
+		return
+	}
+	chartIDs := make([]primitive.ObjectID, len(charts))
+	for i, chart := range charts {
+		chartIDs[i] = chart.ID
+	}
+
+	cursor, err = db.GetCollection("alert_rules").Find(ctx, bson.M{
+		"chart_id":   bson.M{"$in": chartIDs},
+		"enabled":    true,
+		"on_refresh": true,
+	})
+	if err != nil {
+		log.Printf("Failed to load alerts of data source %s: %v", hex, err)
+		return
+	}
+	var rules []models.AlertRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return
+	}
+	for i := range rules {
+		if _, err := EvaluateAlert(ctx, &rules[i], AlertTriggerRefresh); err != nil {
+			log.Printf("Failed to record alert evaluation %s: %v", rules[i].ID.Hex(), err)
+		}
+	}
+}
+
+// RegisterAlertHandlers 数据源更新后检查相关的告警规则
+func RegisterAlertHandlers() {
+	events.Subscribe(events.DataSourceUpdated, evaluateRefreshAlerts)
+}
+
+// DeleteChartAlerts 删除图表上的告警规则及其检查记录，图表被删除时调用
+func DeleteChartAlerts(ctx context.Context, chartIDs []primitive.ObjectID) error {
+	if len(chartIDs) == 0 {
+		return nil
+	}
+	filter := bson.M{"chart_id": bson.M{"$in": chartIDs}}
+	if _, err := db.GetCollection("alert_rules").DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	_, err := db.GetCollection("alert_history").DeleteMany(ctx, filter)
+	return err
+}
@@ -0,0 +1,92 @@
+// services/chart_query.go
+package services
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"bi-backend/config"
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/query"
+)
+
+// ChartTypePivot 透视表图表类型
+const ChartTypePivot = "pivot"
+
+// ErrDataSourceNotFound 数据源不存在或不属于当前用户
+var ErrDataSourceNotFound = errors.New("data source not found")
+
+// LoadDataSource 读取指定用户的数据源
+func LoadDataSource(ctx context.Context, id, userID primitive.ObjectID) (*models.DataSource, error) {
+	var ds models.DataSource
+	err := db.GetCollection("data_sources").FindOne(ctx, bson.M{
+		"_id":        id,
+		"created_by": userID,
+	}).Decode(&ds)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrDataSourceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ds, nil
+}
+
+// RunChartQuery 在数据源上执行图表查询，附加全局的超时和行数限制，结果按数据源版本缓存
+func RunChartQuery(ctx context.Context, ds *models.DataSource, cfg models.ChartConfig, opts query.Options) (*query.Result, error) {
+	limits := config.GlobalConfig.Query
+	if opts.MaxRows <= 0 || opts.MaxRows > limits.MaxRows {
+		opts.MaxRows = limits.MaxRows
+	}
+
+	var cached query.Result
+	key, hit := loadCachedQuery(ctx, ds, "chart", cfg, opts, &cached)
+	if hit {
+		return &cached, nil
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+	res, err := query.Run(queryCtx, query.NewTable(ds), cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	storeCachedQuery(ctx, key, res)
+	return res, nil
+}
+
+// RunPivotQuery 在数据源上执行透视表查询，结果按数据源版本缓存
+func RunPivotQuery(ctx context.Context, ds *models.DataSource, cfg models.ChartConfig, opts query.Options) (*query.PivotResult, error) {
+	limits := config.GlobalConfig.Query
+	if opts.MaxRows <= 0 || opts.MaxRows > limits.MaxRows {
+		opts.MaxRows = limits.MaxRows
+	}
+
+	var cached query.PivotResult
+	key, hit := loadCachedQuery(ctx, ds, ChartTypePivot, cfg, opts, &cached)
+	if hit {
+		return &cached, nil
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+	res, err := query.Pivot(queryCtx, query.NewTable(ds), cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	storeCachedQuery(ctx, key, res)
+	return res, nil
+}
+
+// RunChart 按图表类型执行查询，pivot 类型返回交叉表，其余返回分组聚合结果
+func RunChart(ctx context.Context, ds *models.DataSource, chartType string, cfg models.ChartConfig, opts query.Options) (interface{}, error) {
+	if chartType == ChartTypePivot {
+		return RunPivotQuery(ctx, ds, cfg, opts)
+	}
+	return RunChartQuery(ctx, ds, cfg, opts)
+}
@@ -0,0 +1,33 @@
+// services/chart_render.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"bi-backend/models"
+	"bi-backend/query"
+	"bi-backend/render"
+)
+
+// RenderChart 在服务端执行图表查询并渲染为 PNG 或 SVG 图片，用于邮件、PDF 等没有浏览器的场景
+func RenderChart(ctx context.Context, chart *models.Chart, opts render.Options, params map[string]interface{}) ([]byte, error) {
+	if !render.Supported(chart.Type) {
+		return nil, &query.Error{Field: "type", Message: fmt.Sprintf("%s charts cannot be rendered as images", chart.Type)}
+	}
+	ds, err := LoadDataSource(ctx, chart.DataSourceID, chart.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	res, err := RunChartQuery(ctx, ds, chart.Config, query.Options{Parameters: params})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := render.Render(&buf, chart.Type, chart.Config, res, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,159 @@
+// services/chart_validation.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bi-backend/db"
+	"bi-backend/events"
+	"bi-backend/models"
+	"bi-backend/query"
+	"bi-backend/utils"
+)
+
+// withoutContent 只读取数据源的表头和列类型，不读取数据行
+var withoutContent = options.Find().SetProjection(bson.M{"content": 0})
+
+// LoadDataSourceSchema 读取指定用户的数据源，不包含数据行，用于检查图表配置
+func LoadDataSourceSchema(ctx context.Context, id, userID primitive.ObjectID) (*models.DataSource, error) {
+	var ds models.DataSource
+	err := db.GetCollection("data_sources").FindOne(ctx, bson.M{
+		"_id":        id,
+		"created_by": userID,
+	}, options.FindOne().SetProjection(bson.M{"content": 0})).Decode(&ds)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrDataSourceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := inferMissingSchema(ctx, &ds); err != nil {
+		return nil, err
+	}
+	return &ds, nil
+}
+
+// schemaSampleRows 推断缺失的列类型时读取的行数
+const schemaSampleRows = 1000
+
+// inferMissingSchema 没有 schema 的历史数据源读取部分数据行推断列类型，避免数值列被当作文本导致图表检查失败
+func inferMissingSchema(ctx context.Context, ds *models.DataSource) error {
+	if len(ds.Schema) > 0 {
+		return nil
+	}
+	var sample struct {
+		Content [][]string `bson:"content"`
+	}
+	err := db.GetCollection("data_sources").FindOne(ctx, bson.M{"_id": ds.ID},
+		options.FindOne().SetProjection(bson.M{"content": bson.M{"$slice": schemaSampleRows}})).Decode(&sample)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	ds.Schema = utils.InferSchema(ds.Headers, sample.Content)
+	return nil
+}
+
+// ValidateChartConfig 按数据源的表头和列类型检查图表配置，返回所有字段级错误
+func ValidateChartConfig(ds *models.DataSource, chartType string, cfg models.ChartConfig) []*query.Error {
+	return query.Validate(query.NewTable(ds), cfg, chartType == ChartTypePivot)
+}
+
+// BrokenChart 配置与数据源不再匹配的图表，例如字段被删除或类型变化
+type BrokenChart struct {
+	ChartID        primitive.ObjectID `json:"chart_id"`
+	Name           string             `json:"name"`
+	Type           string             `json:"type"`
+	DataSourceID   primitive.ObjectID `json:"data_source_id"`
+	DataSourceName string             `json:"data_source_name,omitempty"`
+	Errors         []*query.Error     `json:"errors"`
+}
+
+// FindBrokenCharts 检查用户的图表，返回配置失效的图表；filter 为附加的图表查询条件
+func FindBrokenCharts(ctx context.Context, userID primitive.ObjectID, filter bson.M) ([]BrokenChart, error) {
+	chartFilter := bson.M{"created_by": userID}
+	for k, v := range filter {
+		chartFilter[k] = v
+	}
+	cursor, err := db.GetCollection("charts").Find(ctx, chartFilter)
+	if err != nil {
+		return nil, err
+	}
+	var charts []models.Chart
+	if err := cursor.All(ctx, &charts); err != nil {
+		return nil, err
+	}
+
+	sources := map[primitive.ObjectID]*models.DataSource{}
+	ids := make([]primitive.ObjectID, 0, len(charts))
+	for _, chart := range charts {
+		ids = append(ids, chart.DataSourceID)
+	}
+	cursor, err = db.GetCollection("data_sources").Find(ctx, bson.M{
+		"_id":        bson.M{"$in": ids},
+		"created_by": userID,
+	}, withoutContent)
+	if err != nil {
+		return nil, err
+	}
+	var list []models.DataSource
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if err := inferMissingSchema(ctx, &list[i]); err != nil {
+			return nil, err
+		}
+		sources[list[i].ID] = &list[i]
+	}
+
+	broken := []BrokenChart{}
+	for _, chart := range charts {
+		item := BrokenChart{ChartID: chart.ID, Name: chart.Name, Type: chart.Type, DataSourceID: chart.DataSourceID}
+		ds, ok := sources[chart.DataSourceID]
+		if !ok {
+			item.Errors = []*query.Error{{Field: "data_source_id", Message: "data source not found"}}
+			broken = append(broken, item)
+			continue
+		}
+		item.DataSourceName = ds.Name
+		if item.Errors = ValidateChartConfig(ds, chart.Type, chart.Config); len(item.Errors) > 0 {
+			broken = append(broken, item)
+		}
+	}
+	return broken, nil
+}
+
+// notifyBrokenCharts 数据源变化后检查引用它的图表，配置失效时通知用户
+func notifyBrokenCharts(e events.Event) {
+	hex, _ := e.Payload["data_source_id"].(string)
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return
+	}
+	broken, err := FindBrokenCharts(context.Background(), e.UserID, bson.M{"data_source_id": id})
+	if err != nil {
+		log.Printf("Failed to check charts of data source %s: %v", hex, err)
+		return
+	}
+	if len(broken) == 0 {
+		return
+	}
+
+	names := make([]string, len(broken))
+	chartIDs := make([]string, len(broken))
+	for i, b := range broken {
+		names[i] = b.Name
+		chartIDs[i] = b.ChartID.Hex()
+	}
+	e.Payload = map[string]interface{}{"data_source_id": hex, "chart_ids": chartIDs}
+	createNotification(e, "图表配置失效",
+		fmt.Sprintf("数据源变化后，%d 个图表的配置不再有效：%s", len(broken), strings.Join(names, "、")))
+}
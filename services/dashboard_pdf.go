@@ -0,0 +1,284 @@
+// services/dashboard_pdf.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bi-backend/config"
+	"bi-backend/models"
+	"bi-backend/query"
+	"bi-backend/render"
+)
+
+// PDF 页面布局，单位为毫米
+const (
+	pdfMargin      = 10.0
+	pdfFooter      = 8.0  // 页脚页码占用的高度
+	pdfGap         = 3.0  // 图表之间的间距
+	pdfRowHeight   = 12.0 // 布局中每一行的高度
+	pdfGridColumns = 12   // 布局的最少列数，与前端栅格一致
+	pdfPixelsPerMM = 4.0  // 图表图片的分辨率，约 100 DPI
+	pdfTableRow    = 5.0
+)
+
+// pdfDoc 导出过程中的 PDF 文档和字体设置
+type pdfDoc struct {
+	pdf  *gofpdf.Fpdf
+	font string
+	tr   func(string) string // 内置字体只支持 cp1252，配置了 TrueType 字体时原样输出
+}
+
+func newPDFDoc() *pdfDoc {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(false, 0)
+	d := &pdfDoc{pdf: pdf, font: "Helvetica", tr: pdf.UnicodeTranslatorFromDescriptor("")}
+	// 中文需要配置渲染字体（RENDER_FONT_PATH），与图表图片使用同一字体
+	if path := config.GlobalConfig.Render.FontPath; path != "" {
+		pdf.AddUTF8Font("report", "", path)
+		d.font = "report"
+		d.tr = func(s string) string { return s }
+	}
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin - 2)
+		d.setFont(8, 150)
+		pdf.CellFormat(0, 4, fmt.Sprintf("%d", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	return d
+}
+
+// setFont 设置字号和灰度文字颜色
+func (d *pdfDoc) setFont(size float64, gray int) {
+	d.pdf.SetFont(d.font, "", size)
+	d.pdf.SetTextColor(gray, gray, gray)
+}
+
+// fit 截断超出宽度的文本
+func (d *pdfDoc) fit(s string, width float64) string {
+	s = d.tr(s)
+	if d.pdf.GetStringWidth(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && d.pdf.GetStringWidth(string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// ExportDashboardPDF 按仪表盘布局将图表导出为 A4 横向 PDF，页首为仪表盘名称、描述和导出时间；
+// 图表在服务端渲染为图片，表格类图表输出为表格，筛选和参数与仪表盘查询一致
+func ExportDashboardPDF(ctx context.Context, dashboard *models.Dashboard, q DashboardQuery) ([]byte, error) {
+	charts, err := LoadDashboardCharts(ctx, dashboard)
+	if err != nil {
+		return nil, err
+	}
+	results, err := RunDashboard(ctx, dashboard, q)
+	if err != nil {
+		return nil, err
+	}
+	chartByID := make(map[primitive.ObjectID]*models.Chart, len(charts))
+	for i := range charts {
+		chartByID[charts[i].ID] = &charts[i]
+	}
+	resultByID := make(map[primitive.ObjectID]DashboardChartResult, len(results))
+	for _, res := range results {
+		resultByID[res.ChartID] = res
+	}
+
+	d := newPDFDoc()
+	pdf := d.pdf
+	pdf.AddPage()
+	pageW, pageH := pdf.GetPageSize()
+	contentW := pageW - 2*pdfMargin
+	bottom := pageH - pdfMargin - pdfFooter
+
+	// 页首
+	d.setFont(18, 30)
+	pdf.MultiCell(contentW, 8, d.tr(dashboard.Name), "", "L", false)
+	if dashboard.Description != "" {
+		d.setFont(10, 90)
+		pdf.MultiCell(contentW, 5, d.tr(dashboard.Description), "", "L", false)
+	}
+	d.setFont(8, 130)
+	pdf.CellFormat(contentW, 5, "Exported at "+time.Now().Format("2006-01-02 15:04:05 MST"), "", 1, "L", false, 0, "")
+	top := pdf.GetY() + pdfGap
+
+	layout := make([]models.ChartLayout, 0, len(dashboard.Layout))
+	cols := pdfGridColumns
+	for _, item := range dashboard.Layout {
+		if _, ok := chartByID[item.ChartID]; !ok {
+			continue
+		}
+		if item.Width <= 0 {
+			item.Width = pdfGridColumns / 2
+		}
+		if item.Height <= 0 {
+			item.Height = 4
+		}
+		if item.X < 0 {
+			item.X = 0
+		}
+		if item.X+item.Width > cols {
+			cols = item.X + item.Width
+		}
+		layout = append(layout, item)
+	}
+	sort.SliceStable(layout, func(i, j int) bool {
+		if layout[i].Y != layout[j].Y {
+			return layout[i].Y < layout[j].Y
+		}
+		return layout[i].X < layout[j].X
+	})
+
+	// 按布局坐标换算位置，放不下的图表从新的一页开始，新页以该图表所在的行为起点
+	unit := (contentW + pdfGap) / float64(cols)
+	baseRow := 0
+	if len(layout) > 0 {
+		baseRow = layout[0].Y
+	}
+	for _, item := range layout {
+		y := top + float64(item.Y-baseRow)*pdfRowHeight
+		h := float64(item.Height)*pdfRowHeight - pdfGap
+		if y+h > bottom && y > top {
+			pdf.AddPage()
+			top, baseRow, y = pdfMargin, item.Y, pdfMargin
+		}
+		if y+h > bottom {
+			h = bottom - y
+		}
+		x := pdfMargin + float64(item.X)*unit
+		w := float64(item.Width)*unit - pdfGap
+		if err := d.drawChart(chartByID[item.ChartID], resultByID[item.ChartID], x, y, w, h); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawChart 在指定区域绘制一个图表，查询或配置错误时绘制错误提示
+func (d *pdfDoc) drawChart(chart *models.Chart, res DashboardChartResult, x, y, w, h float64) error {
+	pdf := d.pdf
+	pdf.SetDrawColor(220, 220, 220)
+	pdf.Rect(x, y, w, h, "D")
+
+	if res.Error != "" {
+		d.placeholder(chart.Name, res.Error, x, y, w, h)
+		return nil
+	}
+	data, ok := res.Data.(*query.Result)
+	if !ok {
+		d.placeholder(chart.Name, "Pivot tables are not included in PDF export", x, y, w, h)
+		return nil
+	}
+	if !render.Supported(chart.Type) {
+		d.drawTable(chart.Name, data, x, y, w, h)
+		return nil
+	}
+
+	// 图片宽高至少为渲染允许的最小尺寸，嵌入时再缩放到布局区域
+	opts := render.Options{
+		Format: render.FormatPNG,
+		Width:  max(int(w*pdfPixelsPerMM), render.MinSize),
+		Height: max(int(h*pdfPixelsPerMM), render.MinSize),
+		Title:  chart.Name,
+	}
+	var img bytes.Buffer
+	if err := render.Render(&img, chart.Type, chart.Config, data, opts); err != nil {
+		var queryErr *query.Error
+		if !errors.As(err, &queryErr) {
+			return err
+		}
+		d.placeholder(chart.Name, queryErr.Error(), x, y, w, h)
+		return nil
+	}
+	imgOpts := gofpdf.ImageOptions{ImageType: "PNG"}
+	name := "chart-" + chart.ID.Hex()
+	pdf.RegisterImageOptionsReader(name, imgOpts, &img)
+	pdf.ImageOptions(name, x+0.5, y+0.5, w-1, h-1, false, imgOpts, 0, "")
+	return pdf.Error()
+}
+
+// chartHeading 绘制图表名称，返回名称下方的位置
+func (d *pdfDoc) chartHeading(name string, x, y, w float64) float64 {
+	d.setFont(10, 50)
+	d.pdf.SetXY(x+2, y+2)
+	d.pdf.CellFormat(w-4, 5, d.fit(name, w-4), "", 0, "L", false, 0, "")
+	return y + 8
+}
+
+// placeholder 无法渲染的图表显示名称和原因
+func (d *pdfDoc) placeholder(name, message string, x, y, w, h float64) {
+	bodyY := d.chartHeading(name, x, y, w)
+	d.setFont(9, 150)
+	d.pdf.SetXY(x+2, bodyY+(h-(bodyY-y))/2-5)
+	d.pdf.MultiCell(w-4, 4.5, d.tr(message), "", "C", false)
+}
+
+// drawTable 表格类图表输出为表格，放不下的行在末尾注明剩余行数
+func (d *pdfDoc) drawTable(name string, res *query.Result, x, y, w, h float64) {
+	pdf := d.pdf
+	rowY := d.chartHeading(name, x, y, w)
+	if len(res.Columns) == 0 {
+		return
+	}
+	colW := (w - 4) / float64(len(res.Columns))
+	limit := y + h - pdfTableRow - 1
+
+	d.setFont(8, 50)
+	pdf.SetFillColor(242, 242, 242)
+	pdf.SetXY(x+2, rowY)
+	for _, col := range res.Columns {
+		pdf.CellFormat(colW, pdfTableRow, d.fit(col.Name, colW-2), "B", 0, "L", true, 0, "")
+	}
+	rowY += pdfTableRow
+
+	d.setFont(8, 70)
+	for i, row := range res.Rows {
+		if rowY+pdfTableRow > limit && i < len(res.Rows)-1 {
+			d.setFont(7, 150)
+			pdf.SetXY(x+2, rowY)
+			pdf.CellFormat(w-4, pdfTableRow, fmt.Sprintf("%d more rows", len(res.Rows)-i), "", 0, "L", false, 0, "")
+			return
+		}
+		pdf.SetXY(x+2, rowY)
+		for j, col := range res.Columns {
+			var v interface{}
+			if j < len(row) {
+				v = row[j]
+			}
+			align := "L"
+			if col.Type == "number" {
+				align = "R"
+			}
+			pdf.CellFormat(colW, pdfTableRow, d.fit(cellText(v), colW-2), "", 0, align, false, 0, "")
+		}
+		rowY += pdfTableRow
+	}
+}
+
+// cellText 表格单元格的显示文本
+func cellText(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return fmt.Sprintf("%.10g", x)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,361 @@
+// services/dashboard_query.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/query"
+	"bi-backend/utils"
+)
+
+// 仪表盘筛选器类型
+const (
+	DashboardFilterDateRange   = "date_range"
+	DashboardFilterCategory    = "category"
+	DashboardFilterNumberRange = "number_range"
+)
+
+// DashboardFilterValue 仪表盘筛选器的取值。
+// category 为选中的值；date_range、number_range 为 [起, 止]，任一端可以为空，日期只有年月日时包含当天
+type DashboardFilterValue struct {
+	FilterID string                    `json:"filter_id"`
+	Values   []interface{}             `json:"values"`
+	Relative *models.RelativeDateRange `json:"relative,omitempty"` // date_range 使用相对日期
+}
+
+// CrossFilter 点击图表产生的交叉筛选，作用于同一数据源的其他图表
+type CrossFilter struct {
+	ChartID primitive.ObjectID `json:"chart_id"`
+	Field   string             `json:"field"`
+	Values  []interface{}      `json:"values"`
+}
+
+// DashboardQuery 仪表盘查询参数
+type DashboardQuery struct {
+	Filters      []DashboardFilterValue   `json:"filters"`
+	CrossFilters []CrossFilter            `json:"cross_filters"`
+	ChartIDs     []primitive.ObjectID     `json:"chart_ids"`   // 只查询这些图表，为空表示全部
+	DrillPaths   map[string][]interface{} `json:"drill_paths"` // 图表 ID 到下钻路径，按路径查询下一层级
+	Parameters   map[string]interface{}   `json:"parameters"`  // 仪表盘参数取值，未传入的使用默认值
+}
+
+// DashboardChartResult 仪表盘中单个图表的查询结果，单个图表失败不影响其他图表
+type DashboardChartResult struct {
+	ChartID primitive.ObjectID   `json:"chart_id"`
+	Data    interface{}          `json:"data,omitempty"`
+	Filters []models.ChartFilter `json:"applied_filters,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+// ValidateDashboardFilters 检查筛选器定义，ID 必须唯一且每个筛选器至少绑定一个字段
+func ValidateDashboardFilters(filters []models.DashboardFilter) error {
+	seen := map[string]bool{}
+	for _, f := range filters {
+		if f.ID == "" {
+			return &query.Error{Field: "filters", Message: "filter id is required"}
+		}
+		if seen[f.ID] {
+			return &query.Error{Field: f.ID, Message: "duplicate filter id"}
+		}
+		seen[f.ID] = true
+
+		switch f.Type {
+		case DashboardFilterDateRange, DashboardFilterCategory, DashboardFilterNumberRange:
+		default:
+			return &query.Error{Field: f.ID, Message: fmt.Sprintf("unsupported filter type %q", f.Type)}
+		}
+		if f.DefaultRelative != nil && f.Type != DashboardFilterDateRange {
+			return &query.Error{Field: f.ID, Message: "relative defaults are only supported by date_range filters"}
+		}
+		if len(f.Bindings) == 0 {
+			return &query.Error{Field: f.ID, Message: "filter must be bound to at least one field"}
+		}
+		for _, b := range f.Bindings {
+			if b.DataSourceID.IsZero() || b.Field == "" {
+				return &query.Error{Field: f.ID, Message: "binding requires data_source_id and field"}
+			}
+		}
+	}
+	return nil
+}
+
+// LoadDashboardCharts 按布局顺序读取仪表盘上的图表，只包含仪表盘所有者的图表
+func LoadDashboardCharts(ctx context.Context, dashboard *models.Dashboard) ([]models.Chart, error) {
+	ids := make([]primitive.ObjectID, 0, len(dashboard.Layout))
+	for _, item := range dashboard.Layout {
+		ids = append(ids, item.ChartID)
+	}
+	if len(ids) == 0 {
+		return []models.Chart{}, nil
+	}
+
+	cursor, err := db.GetCollection("charts").Find(ctx, bson.M{
+		"_id":        bson.M{"$in": ids},
+		"created_by": dashboard.CreatedBy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Chart
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]models.Chart, len(found))
+	for _, chart := range found {
+		byID[chart.ID] = chart
+	}
+	charts := make([]models.Chart, 0, len(found))
+	for _, id := range ids {
+		if chart, ok := byID[id]; ok {
+			charts = append(charts, chart)
+		}
+	}
+	return charts, nil
+}
+
+// rangeFilters 将 [起, 止] 转换为 gte/lte 过滤条件，只有年月日的结束日期包含当天
+func rangeFilters(field, filterType string, values []interface{}) []models.ChartFilter {
+	var out []models.ChartFilter
+	if len(values) > 0 && values[0] != nil && fmt.Sprint(values[0]) != "" {
+		out = append(out, models.ChartFilter{Field: field, Operator: query.OpGte, Values: values[:1]})
+	}
+	if len(values) > 1 && values[1] != nil && fmt.Sprint(values[1]) != "" {
+		end := fmt.Sprint(values[1])
+		if filterType == DashboardFilterDateRange {
+			if t, err := time.Parse("2006-01-02", end); err == nil {
+				out = append(out, models.ChartFilter{Field: field, Operator: query.OpLt,
+					Values: []interface{}{t.AddDate(0, 0, 1).Format("2006-01-02")}})
+				return out
+			}
+		}
+		out = append(out, models.ChartFilter{Field: field, Operator: query.OpLte, Values: values[1:2]})
+	}
+	return out
+}
+
+// filterConditions 将筛选器取值转换为绑定字段上的过滤条件
+func filterConditions(f models.DashboardFilter, field string, v DashboardFilterValue) []models.ChartFilter {
+	switch f.Type {
+	case DashboardFilterCategory:
+		if len(v.Values) == 0 {
+			return nil
+		}
+		return []models.ChartFilter{{Field: field, Operator: query.OpIn, Values: v.Values}}
+	case DashboardFilterDateRange:
+		if v.Relative != nil {
+			return []models.ChartFilter{{Field: field, Operator: query.OpRelative, Relative: v.Relative}}
+		}
+	}
+	return rangeFilters(field, f.Type, v.Values)
+}
+
+// binding 筛选器在图表上绑定的字段，未绑定时返回 false
+func binding(f models.DashboardFilter, chart *models.Chart) (string, bool) {
+	for _, b := range f.Bindings {
+		if b.DataSourceID != chart.DataSourceID {
+			continue
+		}
+		if len(b.ChartIDs) == 0 {
+			return b.Field, true
+		}
+		for _, id := range b.ChartIDs {
+			if id == chart.ID {
+				return b.Field, true
+			}
+		}
+	}
+	return "", false
+}
+
+// chartDimension 在图表配置中查找字段对应的维度，包括透视表的行列维度和下钻层级
+func chartDimension(chart *models.Chart, field string) (models.ChartDimension, bool) {
+	dims := append([]models.ChartDimension{}, chart.Config.Dimensions...)
+	if p := chart.Config.Pivot; p != nil {
+		dims = append(append(dims, p.Rows...), p.Columns...)
+	}
+	dims = append(dims, chart.Config.Hierarchy...)
+	for _, d := range dims {
+		if d.Field == field {
+			return d, true
+		}
+	}
+	return models.ChartDimension{}, false
+}
+
+// DashboardChartFilters 计算仪表盘筛选和交叉筛选作用于某个图表的过滤条件
+func DashboardChartFilters(dashboard *models.Dashboard, charts []models.Chart, chart *models.Chart, q DashboardQuery) ([]models.ChartFilter, error) {
+	values := make(map[string]DashboardFilterValue, len(q.Filters))
+	for _, v := range q.Filters {
+		values[v.FilterID] = v
+	}
+
+	var filters []models.ChartFilter
+	for _, f := range dashboard.Filters {
+		field, ok := binding(f, chart)
+		if !ok {
+			continue
+		}
+		v, ok := values[f.ID]
+		if !ok {
+			v = DashboardFilterValue{FilterID: f.ID, Values: f.DefaultValues, Relative: f.DefaultRelative}
+		}
+		filters = append(filters, filterConditions(f, field, v)...)
+	}
+
+	for _, cf := range q.CrossFilters {
+		if cf.ChartID == chart.ID {
+			continue
+		}
+		var source *models.Chart
+		for i := range charts {
+			if charts[i].ID == cf.ChartID {
+				source = &charts[i]
+				break
+			}
+		}
+		if source == nil || source.DataSourceID != chart.DataSourceID {
+			continue
+		}
+		// 只能按来源图表展示的维度筛选，避免通过分享和嵌入链接探测图表未展示的列
+		dim, ok := chartDimension(source, cf.Field)
+		if !ok {
+			return nil, &query.Error{Field: cf.Field, Message: "cross filter field is not a dimension of chart " + cf.ChartID.Hex()}
+		}
+		var loc *time.Location
+		if source.Config.Timezone != "" {
+			loc, _ = time.LoadLocation(source.Config.Timezone)
+		}
+		cross, err := query.ValueFilters(dim, cf.Values, loc)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, cross...)
+	}
+	return filters, nil
+}
+
+// checkDashboardQuery 检查查询参数引用的筛选器和图表是否存在，交叉筛选只能使用来源图表的维度
+func checkDashboardQuery(dashboard *models.Dashboard, charts []models.Chart, q DashboardQuery) error {
+	known := map[string]models.DashboardFilter{}
+	for _, f := range dashboard.Filters {
+		known[f.ID] = f
+	}
+	for _, v := range q.Filters {
+		f, ok := known[v.FilterID]
+		if !ok {
+			return &query.Error{Field: v.FilterID, Message: "unknown dashboard filter"}
+		}
+		if f.Type != DashboardFilterCategory && len(v.Values) > 2 {
+			return &query.Error{Field: v.FilterID, Message: "range filters take [start, end]"}
+		}
+		if f.Type == DashboardFilterNumberRange {
+			for _, value := range v.Values {
+				if value == nil || fmt.Sprint(value) == "" {
+					continue
+				}
+				if _, ok := utils.ParseNumber(fmt.Sprint(value)); !ok {
+					return &query.Error{Field: v.FilterID, Message: fmt.Sprintf("%v is not a number", value)}
+				}
+			}
+		}
+	}
+	for _, cf := range q.CrossFilters {
+		var source *models.Chart
+		for i := range charts {
+			if charts[i].ID == cf.ChartID {
+				source = &charts[i]
+				break
+			}
+		}
+		if source == nil {
+			return &query.Error{Field: "cross_filters", Message: "chart " + cf.ChartID.Hex() + " is not on this dashboard"}
+		}
+		if _, ok := chartDimension(source, cf.Field); !ok {
+			return &query.Error{Field: cf.Field, Message: "cross filter field is not a dimension of chart " + cf.ChartID.Hex()}
+		}
+	}
+	return nil
+}
+
+// RunDashboard 按筛选条件查询仪表盘上的图表，同一数据源只读取一次
+func RunDashboard(ctx context.Context, dashboard *models.Dashboard, q DashboardQuery) ([]DashboardChartResult, error) {
+	charts, err := LoadDashboardCharts(ctx, dashboard)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDashboardQuery(dashboard, charts, q); err != nil {
+		return nil, err
+	}
+	params, err := ResolveParameters(dashboard.Parameters, q.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[primitive.ObjectID]bool{}
+	for _, id := range q.ChartIDs {
+		wanted[id] = true
+	}
+	sources := map[primitive.ObjectID]*models.DataSource{}
+	results := make([]DashboardChartResult, 0, len(charts))
+	for i := range charts {
+		chart := &charts[i]
+		if len(wanted) > 0 && !wanted[chart.ID] {
+			continue
+		}
+		res := DashboardChartResult{ChartID: chart.ID}
+
+		filters, err := DashboardChartFilters(dashboard, charts, chart, q)
+		if err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		res.Filters = filters
+
+		cfg := chart.Config
+		if path, ok := q.DrillPaths[chart.ID.Hex()]; ok {
+			if cfg, _, err = query.Drill(cfg, path); err != nil {
+				res.Error = err.Error()
+				results = append(results, res)
+				continue
+			}
+		}
+
+		ds, ok := sources[chart.DataSourceID]
+		if !ok {
+			ds, err = LoadDataSource(ctx, chart.DataSourceID, dashboard.CreatedBy)
+			if err != nil && !errors.Is(err, ErrDataSourceNotFound) {
+				return nil, err
+			}
+			sources[chart.DataSourceID] = ds
+		}
+		if ds == nil {
+			res.Error = ErrDataSourceNotFound.Error()
+			results = append(results, res)
+			continue
+		}
+
+		data, err := RunChart(ctx, ds, chart.Type, cfg, query.Options{Filters: filters, Parameters: params})
+		if err != nil {
+			// 超时或数据库错误中止整个请求，配置错误只标记当前图表
+			var queryErr *query.Error
+			if !errors.As(err, &queryErr) {
+				return nil, err
+			}
+			res.Error = queryErr.Error()
+		} else {
+			res.Data = data
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
@@ -0,0 +1,464 @@
+// services/ingest.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"bi-backend/config"
+	"bi-backend/db"
+	"bi-backend/events"
+	"bi-backend/models"
+	"bi-backend/storage"
+	"bi-backend/utils"
+)
+
+// ErrIngestQueueFull 导入队列已满
+var ErrIngestQueueFull = errors.New("ingest queue is full")
+
+// ingestTimeout 单个导入任务的最长处理时间
+const ingestTimeout = 30 * time.Minute
+
+// ingestLease 处理中的任务开始超过该时长仍未结束，说明处理它的实例已经退出，可以重新排队；
+// 运行中的任务最迟在 ingestTimeout 时被取消，留出一分钟写入结果
+const ingestLease = ingestTimeout + time.Minute
+
+// ingestRequeueInterval 检查处理中断任务的间隔
+const ingestRequeueInterval = 5 * time.Minute
+
+// 各阶段结束时的进度百分比
+const (
+	percentValidated = 10
+	percentParsed    = 70
+	percentInferred  = 80
+	percentStored    = 90
+)
+
+var (
+	ingestQueue     chan primitive.ObjectID
+	ingestQueueOnce sync.Once
+)
+
+func ingestJobs() *mongo.Collection {
+	return db.GetCollection("ingest_jobs")
+}
+
+// StartIngestWorkers 启动导入任务工作池，并重新排队上次退出时尚未完成的任务
+func StartIngestWorkers(cfg config.IngestConfig) {
+	ingestQueueOnce.Do(func() {
+		ingestQueue = make(chan primitive.ObjectID, cfg.QueueSize)
+		for i := 0; i < cfg.Workers; i++ {
+			go func() {
+				for id := range ingestQueue {
+					runIngestJob(id)
+				}
+			}()
+		}
+		go func() {
+			requeueIngestJobs(true)
+			ticker := time.NewTicker(ingestRequeueInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				requeueIngestJobs(false)
+			}
+		}()
+		log.Printf("Ingest workers started: %d, queue size: %d", cfg.Workers, cfg.QueueSize)
+	})
+}
+
+// ingestInstance 当前实例的标识，临时文件保存在本机磁盘上，使用主机名区分实例
+var ingestInstance = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}()
+
+// requeueIngestJobs 重新排队处理中断的任务，即开始处理超过 ingestLease 仍未结束的任务；
+// 其他实例正在处理的任务不受影响。服务启动时 includeQueued 为 true，同时继续处理排队中的任务，
+// 任务由 runIngestJob 原子抢占，不会被重复处理。
+// 使用本地临时文件的任务只由保存临时文件的实例重新排队，其他实例读取不到该文件
+func requeueIngestJobs(includeQueued bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	collection := ingestJobs()
+	stale := bson.M{
+		"status":     models.IngestStatusRunning,
+		"started_at": bson.M{"$lte": time.Now().Add(-ingestLease)},
+	}
+	pending := stale
+	if includeQueued {
+		pending = bson.M{"$or": bson.A{bson.M{"status": models.IngestStatusQueued}, stale}}
+	}
+	filter := bson.M{"$and": bson.A{pending, bson.M{"$or": bson.A{
+		bson.M{"temp_path": bson.M{"$exists": false}},
+		bson.M{"instance": ingestInstance},
+		bson.M{"instance": bson.M{"$exists": false}}, // 记录实例之前创建的任务
+	}}}}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to load pending ingest jobs: %v", err)
+		return
+	}
+	var jobs []models.IngestJob
+	if err := cursor.All(ctx, &jobs); err != nil {
+		log.Printf("Failed to decode pending ingest jobs: %v", err)
+		return
+	}
+
+	requeued := 0
+	for _, job := range jobs {
+		if job.Status == models.IngestStatusRunning {
+			// 只在任务状态未变化时重置，避免与其他实例同时重新排队
+			result, err := collection.UpdateOne(ctx,
+				bson.M{"_id": job.ID, "status": models.IngestStatusRunning, "started_at": job.StartedAt},
+				bson.M{"$set": bson.M{
+					"status":     models.IngestStatusQueued,
+					"phase":      models.IngestPhaseQueued,
+					"percent":    0,
+					"updated_at": time.Now(),
+				}})
+			if err != nil || result.ModifiedCount == 0 {
+				continue
+			}
+		}
+		ingestQueue <- job.ID
+		requeued++
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d ingest jobs", requeued)
+	}
+}
+
+// SaveIngestTempFile 将上传内容写入临时目录，供后台任务解析
+func SaveIngestTempFile(r io.Reader) (string, error) {
+	dir := config.GlobalConfig.Ingest.TempDir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "ingest-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// EnqueueIngestJob 保存导入任务并放入队列，队列已满时任务直接标记为失败
+func EnqueueIngestJob(ctx context.Context, job *models.IngestJob) error {
+	if ingestQueue == nil {
+		return fmt.Errorf("ingest workers not started")
+	}
+
+	now := time.Now()
+	job.ID = primitive.NewObjectID()
+	if job.TempPath != "" {
+		job.Instance = ingestInstance
+	}
+	job.Status = models.IngestStatusQueued
+	job.Phase = models.IngestPhaseQueued
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	if _, err := ingestJobs().InsertOne(ctx, job); err != nil {
+		return err
+	}
+
+	select {
+	case ingestQueue <- job.ID:
+		return nil
+	default:
+		ingestJobs().UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{
+			"status":     models.IngestStatusFailed,
+			"error":      "导入队列已满，请稍后重试",
+			"updated_at": time.Now(),
+		}})
+		return ErrIngestQueueFull
+	}
+}
+
+// IngestDataSourceName 数据源名称，沿用带时间戳的文件名
+func IngestDataSourceName(job *models.IngestJob) string {
+	return fmt.Sprintf("%s_%s", job.CreatedAt.Format("20060102150405"), job.FileName)
+}
+
+// NewDataSource 构造上传文件对应的数据源记录
+func NewDataSource(userID primitive.ObjectID, fileName, fileType, objectKey string, size int64, data [][]string, headers []string) models.DataSource {
+	// 存储桶为私有，file_url 指向需要鉴权的下载接口
+	dataSourceID := primitive.NewObjectID()
+	return models.DataSource{
+		ID:        dataSourceID,
+		Name:      fileName,
+		Type:      fileType,
+		FileURL:   DataSourceFileURL(dataSourceID),
+		ObjectKey: objectKey,
+		FileSize:  size,
+		Content:   data,
+		Headers:   headers,
+		CreatedBy: userID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// setIngestProgress 更新任务阶段和进度，分片上传的任务同步更新上传会话的进度
+func setIngestProgress(job *models.IngestJob, phase string, percent float64, fields bson.M) {
+	set := bson.M{"phase": phase, "percent": percent, "updated_at": time.Now()}
+	for k, v := range fields {
+		set[k] = v
+	}
+	ingestJobs().UpdateOne(context.TODO(), bson.M{"_id": job.ID}, bson.M{"$set": set})
+
+	if !job.UploadSessionID.IsZero() {
+		db.GetCollection("upload_sessions").UpdateOne(context.TODO(),
+			bson.M{"_id": job.UploadSessionID},
+			bson.M{"$set": bson.M{"progress": percent, "updated_at": time.Now()}},
+		)
+	}
+}
+
+// progressReader 统计已读取的字节数，用于估算解析进度
+type progressReader struct {
+	r        io.Reader
+	read     int64
+	total    int64
+	lastSent time.Time
+	report   func(fraction float64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	// 限制写库频率
+	if p.total > 0 && time.Since(p.lastSent) >= time.Second {
+		p.lastSent = time.Now()
+		p.report(float64(p.read) / float64(p.total))
+	}
+	return n, err
+}
+
+// openIngestSource 打开任务对应的原始文件
+func openIngestSource(ctx context.Context, job *models.IngestJob) (io.ReaderAt, func(), error) {
+	if job.TempPath != "" {
+		f, err := os.Open(job.TempPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() { f.Close() }, nil
+	}
+
+	// 分片上传的文件已在存储中，大小已在创建会话时受限
+	reader, err := storage.Default().Get(ctx, job.ObjectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, job.FileSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(content)) != job.FileSize {
+		return nil, nil, &utils.UploadError{Status: 400, Msg: "文件大小与声明不一致"}
+	}
+	return bytes.NewReader(content), func() {}, nil
+}
+
+// runIngestJob 校验、解析文件，推断列类型并创建数据源
+func runIngestJob(id primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), ingestTimeout)
+	defer cancel()
+
+	// 抢占任务，避免重复处理
+	now := time.Now()
+	var job models.IngestJob
+	err := ingestJobs().FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": models.IngestStatusQueued},
+		bson.M{"$set": bson.M{
+			"status":     models.IngestStatusRunning,
+			"phase":      models.IngestPhaseValidating,
+			"started_at": now,
+			"updated_at": now,
+		}},
+	).Decode(&job)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Failed to claim ingest job %s: %v", id.Hex(), err)
+		}
+		return
+	}
+	// 解析在后台 goroutine 中进行，没有 gin 的 Recovery，panic 时标记任务失败而不是让进程退出
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Ingest job %s panicked: %v\n%s", job.ID.Hex(), r, debug.Stack())
+			failIngestJob(&job, "文件解析失败")
+		}
+	}()
+	if job.TempPath != "" {
+		defer os.Remove(job.TempPath)
+	}
+
+	// 1. 校验文件内容
+	src, closeSrc, err := openIngestSource(ctx, &job)
+	if err != nil {
+		log.Printf("Failed to open file for ingest job %s: %v", job.ID.Hex(), err)
+		failIngestJob(&job, uploadErrorMsg(err, "读取文件失败"))
+		return
+	}
+	defer closeSrc()
+
+	if err := utils.ValidateUpload(src, job.FileSize, job.FileType); err != nil {
+		failIngestJob(&job, uploadErrorMsg(err, "文件校验失败"))
+		return
+	}
+	setIngestProgress(&job, models.IngestPhaseParsing, percentValidated, nil)
+
+	// 2. 解析文件，按已读取的字节数估算进度
+	reader := &progressReader{
+		r:        io.NewSectionReader(src, 0, job.FileSize),
+		total:    job.FileSize,
+		lastSent: time.Now(),
+		report: func(fraction float64) {
+			percent := percentValidated + fraction*(percentParsed-percentValidated)
+			setIngestProgress(&job, models.IngestPhaseParsing, percent, nil)
+		},
+	}
+	data, headers, err := ParseFile(reader, job.FileType)
+	if err != nil {
+		log.Printf("Error parsing file for ingest job %s: %v", job.ID.Hex(), err)
+		failIngestJob(&job, "文件解析失败: "+err.Error())
+		return
+	}
+	setIngestProgress(&job, models.IngestPhaseInferring, percentParsed, bson.M{
+		"rows_total": len(data),
+		"columns":    len(headers),
+	})
+	if err := CheckDataSourceShape(ctx, job.CreatedBy, len(data), len(headers)); err != nil {
+		failIngestJob(&job, err.Error())
+		return
+	}
+
+	// 3. 推断列类型
+	schema := utils.InferSchema(headers, data)
+	setIngestProgress(&job, models.IngestPhaseStoring, percentInferred, nil)
+
+	// 4. 解析成功后才把临时文件写入存储
+	if job.ObjectKey == "" {
+		objectKey := storage.NewObjectKey(job.CreatedBy.Hex(), job.FileName)
+		file := io.NewSectionReader(src, 0, job.FileSize)
+		if err := storage.Default().Put(ctx, objectKey, file, job.FileSize, ""); err != nil {
+			log.Printf("Error uploading file for ingest job %s: %v", job.ID.Hex(), err)
+			failIngestJob(&job, "Failed to upload file")
+			return
+		}
+		job.ObjectKey = objectKey
+	}
+	setIngestProgress(&job, models.IngestPhaseSaving, percentStored, bson.M{"object_key": job.ObjectKey})
+
+	// 5. 创建数据源
+	dataSource := NewDataSource(job.CreatedBy, IngestDataSourceName(&job), job.FileType, job.ObjectKey, job.FileSize, data, headers)
+	dataSource.Schema = schema
+	if _, err := db.GetCollection("data_sources").InsertOne(ctx, dataSource); err != nil {
+		log.Printf("Error saving data source for ingest job %s: %v", job.ID.Hex(), err)
+		failIngestJob(&job, "Failed to save data source")
+		return
+	}
+
+	finished := time.Now()
+	ingestJobs().UpdateOne(context.TODO(), bson.M{"_id": job.ID}, bson.M{"$set": bson.M{
+		"status":         models.IngestStatusSucceeded,
+		"phase":          models.IngestPhaseDone,
+		"percent":        100,
+		"rows_processed": len(data),
+		"data_source_id": dataSource.ID,
+		"finished_at":    finished,
+		"updated_at":     finished,
+	}})
+	if !job.UploadSessionID.IsZero() {
+		db.GetCollection("upload_sessions").UpdateOne(context.TODO(),
+			bson.M{"_id": job.UploadSessionID},
+			bson.M{"$set": bson.M{
+				"status":         models.UploadStatusCompleted,
+				"progress":       100,
+				"data_source_id": dataSource.ID,
+				"updated_at":     finished,
+			}},
+		)
+	}
+
+	events.Publish(events.Event{
+		Type:   events.IngestSucceeded,
+		UserID: job.CreatedBy,
+		Payload: map[string]interface{}{
+			"job_id":         job.ID.Hex(),
+			"file_name":      job.FileName,
+			"data_source_id": dataSource.ID.Hex(),
+			"rows":           len(data),
+			"columns":        len(headers),
+		},
+	})
+	log.Printf("Ingest job %s finished, data source: %v", job.ID.Hex(), dataSource.ID)
+}
+
+// uploadErrorMsg 校验错误返回其提示信息，其他错误使用默认提示
+func uploadErrorMsg(err error, fallback string) string {
+	var uploadErr *utils.UploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr.Msg
+	}
+	return fallback
+}
+
+// failIngestJob 标记任务失败，已写入存储的文件不再保留
+func failIngestJob(job *models.IngestJob, msg string) {
+	if job.ObjectKey != "" {
+		if err := storage.DeleteObject(context.TODO(), job.ObjectKey); err != nil {
+			log.Printf("Failed to delete file %s: %v", job.ObjectKey, err)
+		}
+	}
+
+	finished := time.Now()
+	ingestJobs().UpdateOne(context.TODO(), bson.M{"_id": job.ID}, bson.M{"$set": bson.M{
+		"status":      models.IngestStatusFailed,
+		"error":       msg,
+		"finished_at": finished,
+		"updated_at":  finished,
+	}})
+	if !job.UploadSessionID.IsZero() {
+		db.GetCollection("upload_sessions").UpdateOne(context.TODO(),
+			bson.M{"_id": job.UploadSessionID},
+			bson.M{"$set": bson.M{"status": models.UploadStatusFailed, "error": msg, "updated_at": finished}},
+		)
+	}
+
+	events.Publish(events.Event{
+		Type:   events.IngestFailed,
+		UserID: job.CreatedBy,
+		Payload: map[string]interface{}{
+			"job_id":    job.ID.Hex(),
+			"file_name": job.FileName,
+			"error":     msg,
+		},
+	})
+	log.Printf("Ingest job %s failed: %s", job.ID.Hex(), msg)
+}
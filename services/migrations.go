@@ -0,0 +1,144 @@
+// services/migrations.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"bi-backend/db"
+	"bi-backend/storage"
+	"bi-backend/utils"
+)
+
+// migration 一次性的数据迁移，按名称记录在 migrations 集合中，只执行一次
+type migration struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+var migrations = []migration{
+	{Name: "20261018_private_file_urls", Run: migratePrivateFileURLs},
+	{Name: "20261018_backfill_schema", Run: backfillSchema},
+}
+
+// RunMigrations 依次执行尚未执行过的迁移
+func RunMigrations(ctx context.Context) error {
+	collection := db.GetCollection("migrations")
+
+	for _, m := range migrations {
+		err := collection.FindOne(ctx, bson.M{"name": m.Name}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		log.Printf("Running migration: %s", m.Name)
+		if err := m.Run(ctx); err != nil {
+			return fmt.Errorf("migration %s failed: %v", m.Name, err)
+		}
+		if _, err := collection.InsertOne(ctx, bson.M{"name": m.Name, "applied_at": time.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DataSourceFileURL 数据源原始文件的下载接口地址
+func DataSourceFileURL(id primitive.ObjectID) string {
+	return "/api/datasources/" + id.Hex() + "/file"
+}
+
+// migratePrivateFileURLs 将历史数据中保存的公开存储地址替换为下载接口地址，并补全 object_key
+func migratePrivateFileURLs(ctx context.Context) error {
+	collection := db.GetCollection("data_sources")
+	cursor, err := collection.Find(ctx, bson.M{
+		"file_url": bson.M{"$regex": "^https?://"},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			FileURL   string             `bson:"file_url"`
+			ObjectKey string             `bson:"object_key"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		objectKey := doc.ObjectKey
+		if objectKey == "" {
+			objectKey = storage.KeyFromURL(doc.FileURL)
+		}
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{
+			"$set": bson.M{
+				"object_key": objectKey,
+				"file_url":   DataSourceFileURL(doc.ID),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %d data source file URLs", migrated)
+	return nil
+}
+
+// backfillSchema 为导入时还没有推断列类型的历史数据源补全 schema
+func backfillSchema(ctx context.Context) error {
+	collection := db.GetCollection("data_sources")
+	cursor, err := collection.Find(ctx, bson.M{
+		"$or": bson.A{
+			bson.M{"schema": bson.M{"$exists": false}},
+			bson.M{"schema": nil},
+			bson.M{"schema": bson.M{"$size": 0}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID      primitive.ObjectID `bson:"_id"`
+			Headers []string           `bson:"headers"`
+			Content [][]string         `bson:"content"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{
+			"$set": bson.M{"schema": utils.InferSchema(doc.Headers, doc.Content)},
+		})
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Backfilled schema for %d data sources", migrated)
+	return nil
+}
@@ -0,0 +1,90 @@
+// services/notifications.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bi-backend/db"
+	"bi-backend/events"
+	"bi-backend/models"
+)
+
+// RegisterNotificationHandlers 订阅需要通知用户的事件
+func RegisterNotificationHandlers() {
+	events.Subscribe(events.IngestSucceeded, func(e events.Event) {
+		createNotification(e, "数据导入完成",
+			fmt.Sprintf("文件 %v 已导入，共 %v 行", e.Payload["file_name"], e.Payload["rows"]))
+	})
+	events.Subscribe(events.IngestFailed, func(e events.Event) {
+		createNotification(e, "数据导入失败",
+			fmt.Sprintf("文件 %v 导入失败：%v", e.Payload["file_name"], e.Payload["error"]))
+	})
+	events.Subscribe(events.DataSourceUpdated, notifyBrokenCharts)
+	events.Subscribe(events.AlertTriggered, func(e events.Event) {
+		createNotification(e, "指标告警",
+			fmt.Sprintf("告警 %v 已触发：%v，当前值 %v", e.Payload["rule_name"], e.Payload["condition"], e.Payload["value"]))
+	})
+}
+
+// createNotification 将事件保存为站内通知
+func createNotification(e events.Event, title, message string) {
+	notification := models.Notification{
+		UserID:    e.UserID,
+		Type:      e.Type,
+		Title:     title,
+		Message:   message,
+		Data:      e.Payload,
+		CreatedAt: time.Now(),
+	}
+	if _, err := db.GetCollection("notifications").InsertOne(context.TODO(), notification); err != nil {
+		log.Printf("Failed to save notification for %s: %v", e.Type, err)
+	}
+}
+
+// maxRecipients 报表和告警邮件的收件人上限
+const maxRecipients = 50
+
+// normalizeRecipients 检查邮箱地址，转换为小写并去重
+func normalizeRecipients(list []string) ([]string, error) {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(list))
+	for _, r := range list {
+		addr, err := mail.ParseAddress(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q", r)
+		}
+		email := strings.ToLower(addr.Address)
+		if !seen[email] {
+			seen[email] = true
+			out = append(out, email)
+		}
+	}
+	if len(out) > maxRecipients {
+		return nil, fmt.Errorf("at most %d recipients are allowed", maxRecipients)
+	}
+	return out, nil
+}
+
+// userEmail 用户的邮箱地址，用于报表和告警未指定收件人时发送给本人
+func userEmail(ctx context.Context, userID primitive.ObjectID) (string, error) {
+	var user models.User
+	err := db.GetCollection("users").FindOne(ctx, bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"email": 1})).Decode(&user)
+	if err != nil {
+		return "", fmt.Errorf("failed to load user email: %v", err)
+	}
+	if user.Email == "" {
+		return "", errors.New("user has no email address")
+	}
+	return user.Email, nil
+}
@@ -0,0 +1,165 @@
+// services/parameters.go
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"bi-backend/models"
+	"bi-backend/query"
+	"bi-backend/utils"
+)
+
+// 仪表盘参数类型
+const (
+	ParameterSelect = "select"
+	ParameterNumber = "number"
+	ParameterDate   = "date"
+)
+
+// ParameterQueryPrefix URL 中参数的前缀，例如 ?p.region=north&p.region=south
+const ParameterQueryPrefix = "p."
+
+// validParameterName 参数名只能包含字母、数字和下划线，且不能以数字开头
+func validParameterName(name string) bool {
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// ValidateDashboardParameters 检查参数定义，名称必须唯一，默认值必须符合类型
+func ValidateDashboardParameters(params []models.DashboardParameter) error {
+	seen := map[string]bool{}
+	for _, p := range params {
+		if !validParameterName(p.Name) {
+			return &query.Error{Field: "parameters", Message: fmt.Sprintf("invalid parameter name %q", p.Name)}
+		}
+		if seen[p.Name] {
+			return &query.Error{Field: p.Name, Message: "duplicate parameter name"}
+		}
+		seen[p.Name] = true
+
+		switch p.Type {
+		case ParameterSelect, ParameterNumber, ParameterDate:
+		default:
+			return &query.Error{Field: p.Name, Message: fmt.Sprintf("unsupported parameter type %q", p.Type)}
+		}
+		if p.Default != nil {
+			if _, err := parameterValue(p, p.Default); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parameterValue 按参数类型转换取值：number 为 float64，date 和 select 为字符串，多选为 []interface{}
+func parameterValue(p models.DashboardParameter, raw interface{}) (interface{}, error) {
+	list, isList := raw.([]interface{})
+	if isList && (p.Type != ParameterSelect || !p.Multiple) {
+		if len(list) != 1 {
+			return nil, &query.Error{Field: p.Name, Message: "parameter takes a single value"}
+		}
+		raw, isList = list[0], false
+	}
+
+	if isList {
+		values := make([]interface{}, 0, len(list))
+		for _, item := range list {
+			v, err := parameterValue(models.DashboardParameter{Name: p.Name, Type: p.Type, Options: p.Options}, item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+
+	s := strings.TrimSpace(fmt.Sprint(raw))
+	switch p.Type {
+	case ParameterNumber:
+		if n, ok := raw.(float64); ok {
+			return n, nil
+		}
+		n, ok := utils.ParseNumber(s)
+		if !ok {
+			return nil, &query.Error{Field: p.Name, Message: fmt.Sprintf("%q is not a number", s)}
+		}
+		return n, nil
+	case ParameterDate:
+		if _, ok := utils.ParseDate(s, "", nil); !ok {
+			return nil, &query.Error{Field: p.Name, Message: fmt.Sprintf("%q is not a date", s)}
+		}
+		return s, nil
+	}
+
+	if len(p.Options) > 0 {
+		allowed := false
+		for _, option := range p.Options {
+			allowed = allowed || fmt.Sprint(option) == s
+		}
+		if !allowed {
+			return nil, &query.Error{Field: p.Name, Message: fmt.Sprintf("%q is not one of the parameter options", s)}
+		}
+	}
+	if p.Multiple {
+		return []interface{}{s}, nil
+	}
+	return s, nil
+}
+
+// ResolveParameters 合并传入的参数值和默认值并按类型转换，未定义的参数返回错误
+func ResolveParameters(defs []models.DashboardParameter, values map[string]interface{}) (map[string]interface{}, error) {
+	known := make(map[string]models.DashboardParameter, len(defs))
+	for _, p := range defs {
+		known[p.Name] = p
+	}
+	for name := range values {
+		if _, ok := known[name]; !ok {
+			return nil, &query.Error{Field: name, Message: "unknown dashboard parameter"}
+		}
+	}
+
+	resolved := make(map[string]interface{}, len(defs))
+	for _, p := range defs {
+		raw, ok := values[p.Name]
+		if !ok || raw == nil || raw == "" {
+			raw = p.Default
+		}
+		if raw == nil || raw == "" {
+			continue
+		}
+		v, err := parameterValue(p, raw)
+		if err != nil {
+			return nil, err
+		}
+		resolved[p.Name] = v
+	}
+	return resolved, nil
+}
+
+// URLParameters 从 URL 查询参数中读取 p.<name> 形式的参数值，多次出现时为多个值
+func URLParameters(values url.Values) map[string]interface{} {
+	params := map[string]interface{}{}
+	for key, list := range values {
+		name := strings.TrimPrefix(key, ParameterQueryPrefix)
+		if name == key || name == "" || len(list) == 0 {
+			continue
+		}
+		if len(list) == 1 {
+			params[name] = list[0]
+			continue
+		}
+		items := make([]interface{}, len(list))
+		for i, v := range list {
+			items[i] = v
+		}
+		params[name] = items
+	}
+	return params
+}
@@ -0,0 +1,120 @@
+// services/parse.go
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/xuri/excelize/v2"
+
+	"bi-backend/config"
+)
+
+// ParseFile 根据文件类型解析文件内容，返回数据行和表头
+func ParseFile(file io.Reader, fileType string) ([][]string, []string, error) {
+	switch fileType {
+	case "excel":
+		return parseExcel(file)
+	case "csv":
+		return parseCSV(file)
+	case "json":
+		return parseJSON(file)
+	default:
+		return nil, nil, fmt.Errorf("unsupported file type: %s", fileType)
+	}
+}
+
+// 解析 Excel 文件
+func parseExcel(file io.Reader) ([][]string, []string, error) {
+	// 限制解压后的大小，防止 zip 炸弹耗尽内存
+	unzipLimit := config.GlobalConfig.Upload.XLSXMaxUncompressed
+	f, err := excelize.OpenReader(file, excelize.Options{
+		UnzipSizeLimit:    unzipLimit,
+		UnzipXMLSizeLimit: unzipLimit,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open excel file: %v", err)
+	}
+	defer f.Close()
+
+	// 获取第一个 Sheet
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, nil, fmt.Errorf("excel file has no sheets")
+	}
+	firstSheet := sheets[0]
+
+	// 读取所有行
+	rows, err := f.GetRows(firstSheet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read excel rows: %v", err)
+	}
+
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("empty excel file")
+	}
+
+	// 第一行作为表头
+	headers := rows[0]
+	// 其余行作为数据
+	data := rows[1:]
+
+	return data, headers, nil
+}
+
+// 解析 CSV 文件
+func parseCSV(file io.Reader) ([][]string, []string, error) {
+	reader := csv.NewReader(file)
+
+	// 读取所有记录
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("empty CSV file")
+	}
+
+	// 第一行作为表头
+	headers := records[0]
+	// 其余行作为数据
+	data := records[1:]
+
+	return data, headers, nil
+}
+
+// 解析 JSON 文件
+func parseJSON(file io.Reader) ([][]string, []string, error) {
+	var jsonData []map[string]interface{}
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(&jsonData); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode JSON: %v", err)
+	}
+
+	if len(jsonData) == 0 {
+		return nil, nil, fmt.Errorf("empty JSON file")
+	}
+
+	// 从第一条记录获取表头
+	headers := make([]string, 0)
+	for key := range jsonData[0] {
+		headers = append(headers, key)
+	}
+
+	// 转换数据
+	var data [][]string
+	for _, item := range jsonData {
+		row := make([]string, len(headers))
+		for i, header := range headers {
+			if val, ok := item[header]; ok {
+				row[i] = fmt.Sprint(val)
+			}
+		}
+		data = append(data, row)
+	}
+
+	return data, headers, nil
+}
@@ -0,0 +1,132 @@
+// services/query_cache.go
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"bi-backend/cache"
+	"bi-backend/config"
+	"bi-backend/events"
+	"bi-backend/models"
+	"bi-backend/query"
+)
+
+// queryCacheKeyPrefix 数据源查询缓存的前缀，数据源变化时按前缀清除
+func queryCacheKeyPrefix(dataSourceID string) string {
+	return "query:" + dataSourceID + ":"
+}
+
+// queryCacheKey 根据规范化后的图表配置、查询选项和数据源版本生成缓存键。
+// 只影响展示的配置（settings、visualMap、dualAxis）不参与计算
+func queryCacheKey(ds *models.DataSource, kind string, cfg models.ChartConfig, opts query.Options) (string, error) {
+	cfg.Settings = nil
+	cfg.VisualMap = nil
+	cfg.DualAxis = nil
+	if kind != ChartTypePivot {
+		cfg.Pivot = nil
+	}
+
+	// 含相对日期过滤时结果随当前时间变化，按分钟区分
+	now := opts.Now
+	if now.IsZero() && hasRelativeFilter(cfg.Filters, opts.Filters) {
+		now = time.Now().Truncate(time.Minute)
+	}
+	location := ""
+	if opts.Location != nil {
+		location = opts.Location.String()
+	}
+
+	payload, err := json.Marshal(struct {
+		Kind      string                 `json:"kind"`
+		Version   int64                  `json:"version"`
+		UpdatedAt int64                  `json:"updated_at"`
+		Config    models.ChartConfig     `json:"config"`
+		Filters   []models.ChartFilter   `json:"filters"`
+		Location  string                 `json:"location"`
+		Now       int64                  `json:"now"`
+		MaxRows   int                    `json:"max_rows"`
+		Params    map[string]interface{} `json:"params"`
+	}{kind, ds.Version, ds.UpdatedAt.UnixNano(), cfg, opts.Filters, location, now.Unix(), opts.MaxRows, opts.Parameters})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return queryCacheKeyPrefix(ds.ID.Hex()) + hex.EncodeToString(sum[:]), nil
+}
+
+func hasRelativeFilter(groups ...[]models.ChartFilter) bool {
+	for _, filters := range groups {
+		for _, f := range filters {
+			if f.Operator == query.OpRelative {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadCachedQuery 读取缓存的查询结果，返回的 key 用于写入缓存，未启用缓存时为空
+func loadCachedQuery(ctx context.Context, ds *models.DataSource, kind string, cfg models.ChartConfig, opts query.Options, out interface{}) (string, bool) {
+	c := cache.Default()
+	if c == nil {
+		return "", false
+	}
+	key, err := queryCacheKey(ds, kind, cfg, opts)
+	if err != nil {
+		log.Printf("Failed to build query cache key: %v", err)
+		return "", false
+	}
+	data, ok := c.Get(ctx, key)
+	if !ok {
+		return key, false
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		log.Printf("Failed to decode cached query result %s: %v", key, err)
+		return key, false
+	}
+	return key, true
+}
+
+// storeCachedQuery 写入查询结果缓存，失败时只记录日志
+func storeCachedQuery(ctx context.Context, key string, result interface{}) {
+	c := cache.Default()
+	if c == nil || key == "" {
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to encode query result %s: %v", key, err)
+		return
+	}
+	if err := c.Set(ctx, key, data, config.GlobalConfig.Cache.TTL); err != nil {
+		log.Printf("Failed to cache query result %s: %v", key, err)
+	}
+}
+
+// InvalidateQueryCache 清除数据源的全部查询缓存
+func InvalidateQueryCache(ctx context.Context, dataSourceID string) {
+	c := cache.Default()
+	if c == nil {
+		return
+	}
+	if err := c.DeletePrefix(ctx, queryCacheKeyPrefix(dataSourceID)); err != nil {
+		log.Printf("Failed to invalidate query cache for data source %s: %v", dataSourceID, err)
+	}
+}
+
+// RegisterQueryCacheHandlers 数据源更新或删除时清除查询缓存。
+// 缓存键包含数据源版本，即使清除失败，旧结果也不会再被读取，只是等待过期
+func RegisterQueryCacheHandlers() {
+	invalidate := func(e events.Event) {
+		if id, ok := e.Payload["data_source_id"].(string); ok {
+			InvalidateQueryCache(context.Background(), id)
+		}
+	}
+	events.Subscribe(events.DataSourceUpdated, invalidate)
+	events.Subscribe(events.DataSourceDeleted, invalidate)
+}
@@ -0,0 +1,265 @@
+// services/quota.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bi-backend/config"
+	"bi-backend/db"
+	"bi-backend/models"
+)
+
+// 受配额限制的资源
+const (
+	QuotaStorage     = "storage"
+	QuotaDataSources = "data_sources"
+	QuotaDashboards  = "dashboards"
+	QuotaCharts      = "charts"
+	QuotaMLModels    = "ml_models"
+	QuotaUpload      = "upload"
+	QuotaRows        = "rows"
+	QuotaColumns     = "columns"
+)
+
+var quotaLabels = map[string]string{
+	QuotaStorage:     "存储空间",
+	QuotaDataSources: "数据源数量",
+	QuotaDashboards:  "仪表盘数量",
+	QuotaCharts:      "图表数量",
+	QuotaMLModels:    "模型数量",
+	QuotaUpload:      "单次上传大小",
+	QuotaRows:        "单个数据源行数",
+	QuotaColumns:     "单个数据源列数",
+}
+
+// QuotaError 超出配额，Status 为对应的 HTTP 状态码
+type QuotaError struct {
+	Status   int
+	Resource string
+	Limit    int64
+	Used     int64
+}
+
+func (e *QuotaError) Error() string {
+	switch e.Resource {
+	case QuotaStorage, QuotaUpload:
+		return fmt.Sprintf("%s超出配额（已用 %.1f MB，上限 %.1f MB）",
+			quotaLabels[e.Resource], float64(e.Used)/(1<<20), float64(e.Limit)/(1<<20))
+	case QuotaRows, QuotaColumns:
+		return fmt.Sprintf("%s超出配额（%d，上限 %d）", quotaLabels[e.Resource], e.Used, e.Limit)
+	default:
+		return fmt.Sprintf("%s已达上限（%d）", quotaLabels[e.Resource], e.Limit)
+	}
+}
+
+// DefaultQuota 未单独设置时的默认配额
+func DefaultQuota() models.UserQuota {
+	q := config.GlobalConfig.Quota
+	return models.UserQuota{
+		MaxStorageBytes:         q.MaxStorageBytes,
+		MaxUploadBytes:          q.MaxUploadBytes,
+		MaxDataSources:          q.MaxDataSources,
+		MaxRowsPerDataSource:    q.MaxRowsPerDataSource,
+		MaxColumnsPerDataSource: q.MaxColumnsPerDataSource,
+		MaxDashboards:           q.MaxDashboards,
+		MaxCharts:               q.MaxCharts,
+		MaxMLModels:             q.MaxMLModels,
+	}
+}
+
+// GetQuota 获取用户生效的配额
+func GetQuota(ctx context.Context, userID primitive.ObjectID) (models.UserQuota, error) {
+	var user struct {
+		Quota *models.UserQuota `bson:"quota"`
+	}
+	err := db.GetCollection("users").FindOne(ctx, bson.M{"_id": userID},
+		options.FindOne().SetProjection(bson.M{"quota": 1})).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return models.UserQuota{}, err
+	}
+	if user.Quota == nil {
+		return DefaultQuota(), nil
+	}
+	return *user.Quota, nil
+}
+
+// activeIngestUsage 排队和处理中的导入任务占用的数据源数量和存储空间
+func activeIngestUsage(ctx context.Context, userID primitive.ObjectID) (count, bytes int64, err error) {
+	return sumFileSize(ctx, ingestJobs(), bson.M{
+		"created_by": userID,
+		"status":     bson.M{"$in": []string{models.IngestStatusQueued, models.IngestStatusRunning}},
+	})
+}
+
+// openUploadUsage 尚未创建导入任务的分片上传会话占用的数据源数量和存储空间，
+// 包括未过期的上传中会话和已合并但还没有创建导入任务的会话；exclude 为不计入的会话
+func openUploadUsage(ctx context.Context, userID, exclude primitive.ObjectID) (count, bytes int64, err error) {
+	filter := bson.M{
+		"created_by": userID,
+		"job_id":     bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"status": models.UploadStatusUploading, "expires_at": bson.M{"$gt": time.Now()}},
+			bson.M{"status": models.UploadStatusProcessing},
+		},
+	}
+	if !exclude.IsZero() {
+		filter["_id"] = bson.M{"$ne": exclude}
+	}
+	return sumFileSize(ctx, db.GetCollection("upload_sessions"), filter)
+}
+
+// sumFileSize 统计匹配的文档数量和 file_size 之和
+func sumFileSize(ctx context.Context, collection *mongo.Collection, filter bson.M) (count, bytes int64, err error) {
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{
+			"_id":   nil,
+			"count": bson.M{"$sum": 1},
+			"bytes": bson.M{"$sum": "$file_size"},
+		}},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Count int64 `bson:"count"`
+		Bytes int64 `bson:"bytes"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, 0, err
+		}
+	}
+	return result.Count, result.Bytes, cursor.Err()
+}
+
+// dataSourceUsage 已有数据源、导入中任务和进行中的分片上传的数量和存储空间，exclude 为不计入的上传会话
+func dataSourceUsage(ctx context.Context, userID, exclude primitive.ObjectID) (count, bytes int64, err error) {
+	count, bytes, err = sumFileSize(ctx, db.GetCollection("data_sources"), bson.M{"created_by": userID})
+	if err != nil {
+		return 0, 0, err
+	}
+	pendingCount, pendingBytes, err := activeIngestUsage(ctx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	uploadCount, uploadBytes, err := openUploadUsage(ctx, userID, exclude)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count + pendingCount + uploadCount, bytes + pendingBytes + uploadBytes, nil
+}
+
+// GetQuotaUsage 统计用户当前的资源用量
+func GetQuotaUsage(ctx context.Context, userID primitive.ObjectID) (models.QuotaUsage, error) {
+	var usage models.QuotaUsage
+	var err error
+	usage.DataSources, usage.StorageBytes, err = dataSourceUsage(ctx, userID, primitive.NilObjectID)
+	if err != nil {
+		return usage, err
+	}
+
+	counts := map[string]*int64{
+		"dashboards": &usage.Dashboards,
+		"charts":     &usage.Charts,
+		"ml_models":  &usage.MLModels,
+	}
+	for collection, target := range counts {
+		n, err := db.GetCollection(collection).CountDocuments(ctx, bson.M{"created_by": userID})
+		if err != nil {
+			return usage, err
+		}
+		*target = n
+	}
+	return usage, nil
+}
+
+// CheckUploadQuota 检查上传文件是否超出单次上传大小、存储空间和数据源数量配额
+func CheckUploadQuota(ctx context.Context, userID primitive.ObjectID, size int64) error {
+	return checkUploadQuota(ctx, userID, size, primitive.NilObjectID)
+}
+
+// CheckUploadSessionQuota 合并分片前再次检查配额，用量中不计入该会话本身，
+// 避免并行创建多个上传会话时每个会话都单独通过检查
+func CheckUploadSessionQuota(ctx context.Context, session *models.UploadSession) error {
+	return checkUploadQuota(ctx, session.CreatedBy, session.FileSize, session.ID)
+}
+
+func checkUploadQuota(ctx context.Context, userID primitive.ObjectID, size int64, exclude primitive.ObjectID) error {
+	quota, err := GetQuota(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if quota.MaxUploadBytes > 0 && size > quota.MaxUploadBytes {
+		return &QuotaError{Status: 413, Resource: QuotaUpload, Limit: quota.MaxUploadBytes, Used: size}
+	}
+
+	count, bytes, err := dataSourceUsage(ctx, userID, exclude)
+	if err != nil {
+		return err
+	}
+	if quota.MaxDataSources > 0 && count >= quota.MaxDataSources {
+		return &QuotaError{Status: 403, Resource: QuotaDataSources, Limit: quota.MaxDataSources, Used: count}
+	}
+	if quota.MaxStorageBytes > 0 && bytes+size > quota.MaxStorageBytes {
+		return &QuotaError{Status: 403, Resource: QuotaStorage, Limit: quota.MaxStorageBytes, Used: bytes}
+	}
+	return nil
+}
+
+// CheckDataSourceShape 检查解析后的数据行数和列数
+func CheckDataSourceShape(ctx context.Context, userID primitive.ObjectID, rows, columns int) error {
+	quota, err := GetQuota(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if quota.MaxRowsPerDataSource > 0 && int64(rows) > quota.MaxRowsPerDataSource {
+		return &QuotaError{Status: 413, Resource: QuotaRows, Limit: quota.MaxRowsPerDataSource, Used: int64(rows)}
+	}
+	if quota.MaxColumnsPerDataSource > 0 && int64(columns) > quota.MaxColumnsPerDataSource {
+		return &QuotaError{Status: 413, Resource: QuotaColumns, Limit: quota.MaxColumnsPerDataSource, Used: int64(columns)}
+	}
+	return nil
+}
+
+// CheckCountQuota 检查仪表盘、图表或模型数量是否已达上限
+func CheckCountQuota(ctx context.Context, userID primitive.ObjectID, resource string) error {
+	quota, err := GetQuota(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var limit int64
+	var collection string
+	switch resource {
+	case QuotaDashboards:
+		limit, collection = quota.MaxDashboards, "dashboards"
+	case QuotaCharts:
+		limit, collection = quota.MaxCharts, "charts"
+	case QuotaMLModels:
+		limit, collection = quota.MaxMLModels, "ml_models"
+	default:
+		return fmt.Errorf("unknown quota resource: %s", resource)
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	used, err := db.GetCollection(collection).CountDocuments(ctx, bson.M{"created_by": userID})
+	if err != nil {
+		return err
+	}
+	if used >= limit {
+		return &QuotaError{Status: 403, Resource: resource, Limit: limit, Used: used}
+	}
+	return nil
+}
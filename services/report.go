@@ -0,0 +1,349 @@
+// services/report.go
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/query"
+	"bi-backend/render"
+	"bi-backend/utils"
+)
+
+// 报表发送的触发方式
+const (
+	ReportTriggerSchedule = "schedule"
+	ReportTriggerManual   = "manual"
+)
+
+const (
+	minReportInterval = time.Hour // cron 计划的最小发送间隔，避免误配置为每分钟发送
+	reportImageWidth  = 800
+	reportImageHeight = 450
+)
+
+// ValidateReportSubscription 检查订阅的发送计划、时区、格式和收件人，规范化收件人列表并计算下一次发送时间
+func ValidateReportSubscription(s *models.ReportSubscription) error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("subscription name is required")
+	}
+	if s.Format == "" {
+		s.Format = models.ReportFormatPDF
+	}
+	if s.Format != models.ReportFormatPDF && s.Format != models.ReportFormatImages {
+		return fmt.Errorf("format must be %s or %s", models.ReportFormatPDF, models.ReportFormatImages)
+	}
+	if s.Hour < 0 || s.Hour > 23 || s.Minute < 0 || s.Minute > 59 {
+		return errors.New("hour must be 0-23 and minute must be 0-59")
+	}
+	if s.Weekday < 0 || s.Weekday > 6 {
+		return errors.New("weekday must be 0 (Sunday) to 6 (Saturday)")
+	}
+
+	recipients, err := normalizeRecipients(s.Recipients)
+	if err != nil {
+		return err
+	}
+	s.Recipients = recipients
+
+	sched, loc, err := reportSchedule(s)
+	if err != nil {
+		return err
+	}
+	if s.Schedule == models.ReportScheduleCron {
+		// 检查接下来几次发送的间隔
+		t := sched.Next(time.Now().In(loc))
+		for i := 0; i < 5; i++ {
+			next := sched.Next(t)
+			if next.Sub(t) < minReportInterval {
+				return errors.New("cron schedule must not run more than once per hour")
+			}
+			t = next
+		}
+	}
+	s.NextRunAt = sched.Next(time.Now().In(loc))
+	return nil
+}
+
+// reportSchedule 订阅对应的 cron 计划和时区，daily 和 weekly 转换为等价的 cron 表达式
+func reportSchedule(s *models.ReportSubscription) (cron.Schedule, *time.Location, error) {
+	loc := time.Local
+	if s.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, nil, fmt.Errorf("invalid timezone %q", s.Timezone)
+		}
+	}
+
+	var spec string
+	switch s.Schedule {
+	case models.ReportScheduleDaily:
+		spec = fmt.Sprintf("%d %d * * *", s.Minute, s.Hour)
+	case models.ReportScheduleWeekly:
+		spec = fmt.Sprintf("%d %d * * %d", s.Minute, s.Hour, s.Weekday)
+	case models.ReportScheduleCron:
+		if strings.Contains(s.Cron, "TZ=") {
+			return nil, nil, errors.New("use the timezone field instead of CRON_TZ")
+		}
+		spec = s.Cron
+	default:
+		return nil, nil, fmt.Errorf("schedule must be %s, %s or %s",
+			models.ReportScheduleDaily, models.ReportScheduleWeekly, models.ReportScheduleCron)
+	}
+
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cron expression: %v", err)
+	}
+	return sched, loc, nil
+}
+
+// nextReportRun 订阅在 after 之后的下一次发送时间
+func nextReportRun(s *models.ReportSubscription, after time.Time) (time.Time, error) {
+	sched, loc, err := reportSchedule(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.Next(after.In(loc)), nil
+}
+
+// SendReport 渲染订阅的仪表盘并发送给收件人，发送结果记录到 report_deliveries 并更新订阅的最近状态；
+// 发送失败不返回错误，只有保存记录失败时返回错误
+func SendReport(ctx context.Context, sub *models.ReportSubscription, trigger string) (*models.ReportDelivery, error) {
+	delivery := &models.ReportDelivery{
+		SubscriptionID: sub.ID,
+		DashboardID:    sub.DashboardID,
+		Trigger:        trigger,
+		CreatedBy:      sub.CreatedBy,
+		StartedAt:      time.Now(),
+	}
+
+	recipients, err := reportRecipients(ctx, sub)
+	if err == nil {
+		delivery.Recipients = recipients
+		err = deliverReport(ctx, sub, recipients)
+	}
+	delivery.FinishedAt = time.Now()
+	delivery.Status = models.ReportStatusSent
+	if err != nil {
+		delivery.Status = models.ReportStatusFailed
+		delivery.Error = err.Error()
+		log.Printf("Failed to send report %s: %v", sub.ID.Hex(), err)
+	}
+
+	result, err := db.GetCollection("report_deliveries").InsertOne(ctx, delivery)
+	if err != nil {
+		return nil, err
+	}
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+
+	_, err = db.GetCollection("report_subscriptions").UpdateOne(ctx, bson.M{"_id": sub.ID}, bson.M{"$set": bson.M{
+		"last_run_at": delivery.FinishedAt,
+		"last_status": delivery.Status,
+		"last_error":  delivery.Error,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// reportRecipients 订阅的收件人，未设置时发送给订阅者本人
+func reportRecipients(ctx context.Context, sub *models.ReportSubscription) ([]string, error) {
+	if len(sub.Recipients) > 0 {
+		return sub.Recipients, nil
+	}
+	email, err := userEmail(ctx, sub.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return []string{email}, nil
+}
+
+// deliverReport 按订阅格式生成 PDF 附件或内嵌图表图片并发送邮件
+func deliverReport(ctx context.Context, sub *models.ReportSubscription, recipients []string) error {
+	var dashboard models.Dashboard
+	err := db.GetCollection("dashboards").FindOne(ctx, bson.M{
+		"_id":        sub.DashboardID,
+		"created_by": sub.CreatedBy,
+	}).Decode(&dashboard)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errors.New("dashboard not found")
+		}
+		return err
+	}
+
+	now := time.Now()
+	if loc, err := time.LoadLocation(sub.Timezone); err == nil {
+		now = now.In(loc)
+	}
+	report := utils.ReportEmail{
+		Title:       dashboard.Name,
+		Description: dashboard.Description,
+		GeneratedAt: now.Format("2006-01-02 15:04 MST"),
+	}
+	q := DashboardQuery{Parameters: sub.Parameters}
+
+	var attachments []utils.EmailAttachment
+	if sub.Format == models.ReportFormatPDF {
+		pdf, err := ExportDashboardPDF(ctx, &dashboard, q)
+		if err != nil {
+			return err
+		}
+		report.Attached = true
+		attachments = append(attachments, utils.EmailAttachment{
+			Name: fmt.Sprintf("%s_%s.pdf", safeFileName(dashboard.Name), now.Format("20060102")),
+			Data: pdf,
+		})
+	} else {
+		charts, images, err := reportImages(ctx, &dashboard, q)
+		if err != nil {
+			return err
+		}
+		report.Charts = charts
+		attachments = images
+	}
+
+	subject := fmt.Sprintf("[报表] %s - %s", dashboard.Name, now.Format("2006-01-02"))
+	return utils.SendReportEmail(recipients, subject, report, attachments)
+}
+
+// reportImages 将仪表盘上可渲染的图表绘制为 PNG 内嵌图片，其余图表在正文中说明原因
+func reportImages(ctx context.Context, dashboard *models.Dashboard, q DashboardQuery) ([]utils.ReportEmailChart, []utils.EmailAttachment, error) {
+	charts, err := LoadDashboardCharts(ctx, dashboard)
+	if err != nil {
+		return nil, nil, err
+	}
+	results, err := RunDashboard(ctx, dashboard, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[string]DashboardChartResult, len(results))
+	for _, res := range results {
+		byID[res.ChartID.Hex()] = res
+	}
+
+	var out []utils.ReportEmailChart
+	var images []utils.EmailAttachment
+	for i := range charts {
+		chart := &charts[i]
+		item := utils.ReportEmailChart{Name: chart.Name}
+		res := byID[chart.ID.Hex()]
+		data, ok := res.Data.(*query.Result)
+		switch {
+		case res.Error != "":
+			item.Message = res.Error
+		case !ok || !render.Supported(chart.Type):
+			item.Message = "该类型的图表无法在邮件中显示，请在平台中查看"
+		default:
+			var buf bytes.Buffer
+			opts := render.Options{Format: render.FormatPNG, Width: reportImageWidth, Height: reportImageHeight}
+			if err := render.Render(&buf, chart.Type, chart.Config, data, opts); err != nil {
+				var queryErr *query.Error
+				if !errors.As(err, &queryErr) {
+					return nil, nil, err
+				}
+				item.Message = queryErr.Error()
+				break
+			}
+			item.ImageCID = fmt.Sprintf("chart-%s.png", chart.ID.Hex())
+			images = append(images, utils.EmailAttachment{Name: item.ImageCID, Data: buf.Bytes(), Inline: true})
+		}
+		out = append(out, item)
+	}
+	return out, images, nil
+}
+
+// safeFileName 去掉文件名中不能使用的字符
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		return "dashboard"
+	}
+	return name
+}
+
+// runDueReports 发送到期的订阅；先推进 next_run_at 再发送，多个实例同时运行时同一次计划只会被一个实例领取
+func runDueReports(ctx context.Context) {
+	collection := db.GetCollection("report_subscriptions")
+	now := time.Now()
+	for {
+		var sub models.ReportSubscription
+		err := collection.FindOne(ctx, bson.M{"enabled": true, "next_run_at": bson.M{"$lte": now}},
+			options.FindOne().SetSort(bson.M{"next_run_at": 1})).Decode(&sub)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load due reports: %v", err)
+			return
+		}
+
+		next, err := nextReportRun(&sub, now)
+		if err != nil {
+			log.Printf("Disabling report %s with invalid schedule: %v", sub.ID.Hex(), err)
+			_, err = collection.UpdateOne(ctx, bson.M{"_id": sub.ID}, bson.M{"$set": bson.M{
+				"enabled":     false,
+				"last_status": models.ReportStatusFailed,
+				"last_error":  err.Error(),
+			}})
+			if err != nil {
+				return
+			}
+			continue
+		}
+		// 错过的计划（例如服务停机期间）只补发一次
+		result, err := collection.UpdateOne(ctx,
+			bson.M{"_id": sub.ID, "next_run_at": sub.NextRunAt},
+			bson.M{"$set": bson.M{"next_run_at": next}})
+		if err != nil {
+			log.Printf("Failed to claim report %s: %v", sub.ID.Hex(), err)
+			return
+		}
+		if result.ModifiedCount == 0 {
+			continue // 已被其他实例领取
+		}
+
+		if _, err := SendReport(ctx, &sub, ReportTriggerSchedule); err != nil {
+			log.Printf("Failed to record report delivery %s: %v", sub.ID.Hex(), err)
+		}
+	}
+}
+
+// StartReportScheduler 按固定间隔检查并发送到期的报表订阅
+func StartReportScheduler(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+			runDueReports(ctx)
+			cancel()
+		}
+	}()
+	log.Printf("Report scheduler started, interval: %s", interval)
+}
@@ -0,0 +1,65 @@
+// services/sql_query.go
+package services
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/query"
+)
+
+// ResolveDataSource 按 ID 或名称查找当前用户的数据源，名称重复时要求使用 ID
+func ResolveDataSource(ctx context.Context, ref string, userID primitive.ObjectID) (*models.DataSource, error) {
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		return LoadDataSource(ctx, id, userID)
+	}
+
+	var matches []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	cursor, err := db.GetCollection("data_sources").Find(ctx,
+		bson.M{"name": ref, "created_by": userID},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(2))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &matches); err != nil {
+		return nil, err
+	}
+	switch len(matches) {
+	case 0:
+		return nil, ErrDataSourceNotFound
+	case 1:
+		return LoadDataSource(ctx, matches[0].ID, userID)
+	}
+	return nil, &query.Error{Field: "sql", Message: "data source name \"" + ref + "\" is ambiguous, use its ID"}
+}
+
+// SQLQueryResult 即席查询结果
+type SQLQueryResult struct {
+	DataSourceID primitive.ObjectID `json:"data_source_id"`
+	*query.Result
+}
+
+// RunSQLQuery 解析即席查询并在用户自己的数据源上执行，受全局的超时和行数限制约束
+func RunSQLQuery(ctx context.Context, sql string, userID primitive.ObjectID) (*SQLQueryResult, error) {
+	q, err := query.ParseSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := ResolveDataSource(ctx, q.From, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := RunChartQuery(ctx, ds, q.Config, query.Options{MaxRows: q.Limit})
+	if err != nil {
+		return nil, err
+	}
+	return &SQLQueryResult{DataSourceID: ds.ID, Result: q.Project(res)}, nil
+}
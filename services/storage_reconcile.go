@@ -0,0 +1,259 @@
+// services/storage_reconcile.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bi-backend/config"
+	"bi-backend/db"
+	"bi-backend/models"
+	"bi-backend/storage"
+)
+
+// uploadPrefix 上传文件在存储后端中的统一前缀
+const uploadPrefix = "uploads/"
+
+// ExportPrefix 导出文件的前缀，下载链接过期后由存储清理删除
+const ExportPrefix = "exports/"
+
+// ReconcileReport 孤儿文件清理结果
+type ReconcileReport struct {
+	DryRun     bool              `json:"dry_run"`
+	Scanned    int               `json:"scanned"`
+	Referenced int               `json:"referenced"`
+	Orphaned   []string          `json:"orphaned"`
+	Deleted    []string          `json:"deleted"`
+	Expired    int               `json:"expired_uploads"` // 清理的过期分片上传会话数
+	Exports    []string          `json:"expired_exports"` // 下载链接已过期的导出文件
+	Failed     map[string]string `json:"failed,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+}
+
+// referencedObjectKeys 收集数据源和进行中的上传会话引用的对象 key
+func referencedObjectKeys(ctx context.Context) (map[string]bool, error) {
+	collection := db.GetCollection("data_sources")
+	cursor, err := collection.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"object_key": 1, "file_url": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := make(map[string]bool)
+	for cursor.Next(ctx) {
+		var doc struct {
+			ObjectKey string `bson:"object_key"`
+			FileURL   string `bson:"file_url"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if doc.ObjectKey != "" {
+			keys[doc.ObjectKey] = true
+		}
+		// 兼容尚未记录 object_key 的历史数据
+		if key := storage.KeyFromURL(doc.FileURL); key != "" {
+			keys[key] = true
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	// 上传中和处理中的分片上传文件尚未关联数据源，同样视为被引用
+	sessions, err := db.GetCollection("upload_sessions").Find(ctx, bson.M{
+		"status": bson.M{"$in": []string{models.UploadStatusUploading, models.UploadStatusProcessing}},
+	}, options.Find().SetProjection(bson.M{"object_key": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer sessions.Close(ctx)
+
+	for sessions.Next(ctx) {
+		var doc struct {
+			ObjectKey string `bson:"object_key"`
+		}
+		if err := sessions.Decode(&doc); err != nil {
+			return nil, err
+		}
+		keys[doc.ObjectKey] = true
+	}
+	if err := sessions.Err(); err != nil {
+		return nil, err
+	}
+
+	// 导入中的任务可能已写入存储但尚未创建数据源
+	jobs, err := ingestJobs().Find(ctx, bson.M{
+		"status":     bson.M{"$in": []string{models.IngestStatusQueued, models.IngestStatusRunning}},
+		"object_key": bson.M{"$exists": true},
+	}, options.Find().SetProjection(bson.M{"object_key": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer jobs.Close(ctx)
+
+	for jobs.Next(ctx) {
+		var doc struct {
+			ObjectKey string `bson:"object_key"`
+		}
+		if err := jobs.Decode(&doc); err != nil {
+			return nil, err
+		}
+		keys[doc.ObjectKey] = true
+	}
+	return keys, jobs.Err()
+}
+
+// expireUploadSessions 取消已过期但仍未完成的分片上传，释放存储中的分片
+func expireUploadSessions(ctx context.Context, dryRun bool) (int, error) {
+	collection := db.GetCollection("upload_sessions")
+	cursor, err := collection.Find(ctx, bson.M{
+		"status":     models.UploadStatusUploading,
+		"expires_at": bson.M{"$lt": time.Now()},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	mp, _ := storage.Multipart()
+	expired := 0
+	for cursor.Next(ctx) {
+		var session models.UploadSession
+		if err := cursor.Decode(&session); err != nil {
+			return expired, err
+		}
+		expired++
+		if dryRun {
+			continue
+		}
+
+		if mp != nil {
+			if err := mp.AbortMultipart(ctx, session.ObjectKey, session.UploadID); err != nil {
+				log.Printf("Failed to abort expired upload %s: %v", session.ID.Hex(), err)
+				continue
+			}
+		}
+		collection.UpdateOne(ctx, bson.M{"_id": session.ID}, bson.M{
+			"$set": bson.M{"status": models.UploadStatusAborted, "error": "上传已过期", "updated_at": time.Now()},
+		})
+	}
+	return expired, cursor.Err()
+}
+
+// ReconcileStorage 找出存储后端中没有被任何数据源引用的上传文件并删除，同时删除下载链接已过期的导出文件
+// 只处理最后修改时间早于 minAge 的文件，避免误删正在上传中的文件
+func ReconcileStorage(ctx context.Context, minAge time.Duration, dryRun bool) (*ReconcileReport, error) {
+	backend := storage.Default()
+	if backend == nil {
+		return nil, fmt.Errorf("storage not initialized")
+	}
+
+	report := &ReconcileReport{
+		DryRun:    dryRun,
+		Orphaned:  []string{},
+		Deleted:   []string{},
+		Exports:   []string{},
+		Failed:    map[string]string{},
+		StartedAt: time.Now(),
+	}
+
+	expired, err := expireUploadSessions(ctx, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire upload sessions: %v", err)
+	}
+	report.Expired = expired
+
+	// 先列出对象再查询引用，列出之后新上传的文件不会被误判
+	objects, err := backend.List(ctx, uploadPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %v", err)
+	}
+	referenced, err := referencedObjectKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load referenced keys: %v", err)
+	}
+
+	cutoff := time.Now().Add(-minAge)
+	for _, obj := range objects {
+		report.Scanned++
+		if referenced[obj.Key] {
+			report.Referenced++
+			continue
+		}
+		if obj.LastModified.After(cutoff) {
+			continue
+		}
+
+		report.Orphaned = append(report.Orphaned, obj.Key)
+		if dryRun {
+			continue
+		}
+		if err := backend.Delete(ctx, obj.Key); err != nil {
+			report.Failed[obj.Key] = err.Error()
+			continue
+		}
+		report.Deleted = append(report.Deleted, obj.Key)
+	}
+
+	if err := expireExports(ctx, backend, report); err != nil {
+		return nil, fmt.Errorf("failed to expire exports: %v", err)
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// expireExports 删除下载链接已过期的导出文件
+func expireExports(ctx context.Context, backend storage.Backend, report *ReconcileReport) error {
+	objects, err := backend.List(ctx, ExportPrefix)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-config.GlobalConfig.Storage.PresignTTL)
+	for _, obj := range objects {
+		if obj.LastModified.After(cutoff) {
+			continue
+		}
+		report.Exports = append(report.Exports, obj.Key)
+		if report.DryRun {
+			continue
+		}
+		if err := backend.Delete(ctx, obj.Key); err != nil {
+			report.Failed[obj.Key] = err.Error()
+		}
+	}
+	return nil
+}
+
+// StartStorageReconciler 按固定间隔在后台清理孤儿文件
+func StartStorageReconciler(interval, minAge time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			report, err := ReconcileStorage(ctx, minAge, false)
+			cancel()
+			if err != nil {
+				log.Printf("Storage reconcile failed: %v", err)
+				continue
+			}
+			log.Printf("Storage reconcile finished: scanned=%d, orphaned=%d, deleted=%d, expired_exports=%d, failed=%d",
+				report.Scanned, len(report.Orphaned), len(report.Deleted), len(report.Exports), len(report.Failed))
+		}
+	}()
+	log.Printf("Storage reconciler started, interval: %s", interval)
+}
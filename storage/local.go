@@ -0,0 +1,314 @@
+// storage/local.go
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-backend/config"
+)
+
+// localBackend 本地磁盘存储，适用于私有化部署和测试环境
+type localBackend struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+func newLocalBackend(cfg config.StorageConfig) (*localBackend, error) {
+	if cfg.LocalSecret == "" {
+		return nil, fmt.Errorf("STORAGE_LOCAL_SECRET is required for local storage")
+	}
+
+	root, err := filepath.Abs(cfg.LocalDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage dir: %v", err)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage dir: %v", err)
+	}
+
+	return &localBackend{
+		root:    root,
+		baseURL: strings.TrimRight(cfg.LocalBaseURL, "/"),
+		secret:  []byte(cfg.LocalSecret),
+	}, nil
+}
+
+// filePath 将对象 key 映射为磁盘路径，拒绝越出根目录的 key
+func (l *localBackend) filePath(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "\x00") {
+		return "", fmt.Errorf("invalid object key: %q", key)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (l *localBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	p, err := l.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免读到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *localBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *localBackend) Delete(ctx context.Context, key string) error {
+	p, err := l.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *localBackend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := l.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		LastModified: fi.ModTime(),
+	}, nil
+}
+
+func (l *localBackend) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if _, err := l.filePath(key); err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", l.sign(key, exp))
+	return l.baseURL + "/files/" + escapeKey(key) + "?" + q.Encode(), nil
+}
+
+func (l *localBackend) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *localBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(l.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// 跳过分片目录和写入中的临时文件
+		if d.IsDir() {
+			if p != l.root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         fi.Size(),
+			ContentType:  mime.TypeByExtension(path.Ext(key)),
+			LastModified: fi.ModTime(),
+		})
+		return nil
+	})
+	return objects, err
+}
+
+// multipartDir 分片上传任务的临时目录
+func (l *localBackend) multipartDir(uploadID string) (string, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\.`) {
+		return "", fmt.Errorf("invalid upload id: %q", uploadID)
+	}
+	return filepath.Join(l.root, ".multipart", uploadID), nil
+}
+
+func (l *localBackend) InitMultipart(ctx context.Context, key, contentType string) (string, error) {
+	if _, err := l.filePath(key); err != nil {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(b)
+
+	dir, _ := l.multipartDir(uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (l *localBackend) PutPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (string, error) {
+	dir, err := l.multipartDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return "", ErrNotFound
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(number))); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (l *localBackend) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	dir, err := l.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, part := range parts {
+		f, err := os.Open(filepath.Join(dir, strconv.Itoa(part.Number)))
+		if err != nil {
+			return fmt.Errorf("missing part %d: %v", part.Number, err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	if err := l.Put(ctx, key, io.MultiReader(readers...), -1, ""); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (l *localBackend) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	dir, err := l.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// ServeHTTP 校验预签名参数后返回文件内容，挂载时需去掉 /files 前缀
+func (l *localBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	exp := r.URL.Query().Get("expires")
+	sig := r.URL.Query().Get("signature")
+
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expUnix ||
+		!hmac.Equal([]byte(sig), []byte(l.sign(key, exp))) {
+		http.Error(w, "invalid or expired signature", http.StatusForbidden)
+		return
+	}
+
+	p, err := l.filePath(key)
+	if err != nil {
+		http.Error(w, "invalid object key", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "failed to read file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(key)))
+	http.ServeContent(w, r, path.Base(key), fi.ModTime(), f)
+}
+
+// escapeKey 逐段转义对象 key，保留路径分隔符
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,32 @@
+// storage/multipart.go
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// Part 已上传的分片
+type Part struct {
+	Number int
+	ETag   string
+}
+
+// MultipartBackend 支持分片上传的存储后端，用于大文件的断点续传
+// 除最后一个分片外，S3 要求每个分片不小于 5MB
+type MultipartBackend interface {
+	// InitMultipart 创建分片上传任务，返回上传 ID
+	InitMultipart(ctx context.Context, key, contentType string) (string, error)
+	// PutPart 上传单个分片，相同编号重复上传会覆盖，返回分片 ETag
+	PutPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (string, error)
+	// CompleteMultipart 按编号顺序合并分片为完整对象
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error
+	// AbortMultipart 取消分片上传并清理已上传的分片
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+}
+
+// Multipart 获取当前后端的分片上传能力，不支持时返回 false
+func Multipart() (MultipartBackend, bool) {
+	m, ok := backend.(MultipartBackend)
+	return m, ok
+}
@@ -2,92 +2,181 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
-	"mime/multipart"
-	"os"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+
+	"bi-backend/config"
 )
 
-type CloudStorage struct {
+// ossBackend 阿里云 OSS 存储
+type ossBackend struct {
 	client *oss.Client
 	bucket *oss.Bucket
 }
 
-var cloudStorage *CloudStorage
-
-// InitCloudStorage 初始化云存储
-func InitCloudStorage() error {
-	required := []string{"OSS_ACCESS_KEY_ID", "OSS_ACCESS_KEY_SECRET", "OSS_ENDPOINT", "OSS_BUCKET"}
-	for _, env := range required {
-		if os.Getenv(env) == "" {
-			return fmt.Errorf("missing required environment variable: %s", env)
-		}
+func newOSSBackend(cfg config.StorageConfig) (*ossBackend, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKeyID == "" || cfg.AccessKeySecret == "" {
+		return nil, fmt.Errorf("OSS_ENDPOINT, OSS_BUCKET, OSS_ACCESS_KEY_ID and OSS_ACCESS_KEY_SECRET are required for oss storage")
 	}
 
-	log.Printf("Initializing OSS client with endpoint: %s, bucket: %s",
-		os.Getenv("OSS_ENDPOINT"),
-		os.Getenv("OSS_BUCKET"))
+	log.Printf("Initializing OSS client with endpoint: %s, bucket: %s", cfg.Endpoint, cfg.Bucket)
 
-	client, err := oss.New(
-		os.Getenv("OSS_ENDPOINT"),
-		os.Getenv("OSS_ACCESS_KEY_ID"),
-		os.Getenv("OSS_ACCESS_KEY_SECRET"),
-	)
+	client, err := oss.New(cfg.Endpoint, cfg.AccessKeyID, cfg.AccessKeySecret)
 	if err != nil {
-		return fmt.Errorf("failed to create OSS client: %v", err)
+		return nil, fmt.Errorf("failed to create OSS client: %v", err)
 	}
 
-	bucket, err := client.Bucket(os.Getenv("OSS_BUCKET"))
+	bucket, err := client.Bucket(cfg.Bucket)
 	if err != nil {
-		return fmt.Errorf("failed to get bucket: %v", err)
+		return nil, fmt.Errorf("failed to get bucket: %v", err)
 	}
 
-	cloudStorage = &CloudStorage{
+	return &ossBackend{
 		client: client,
 		bucket: bucket,
+	}, nil
+}
+
+func (o *ossBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	// 对象一律私有，只能通过预签名链接访问
+	opts := []oss.Option{oss.WithContext(ctx), oss.ObjectACL(oss.ACLPrivate)}
+	if contentType != "" {
+		opts = append(opts, oss.ContentType(contentType))
+	}
+	if size >= 0 {
+		opts = append(opts, oss.ContentLength(size))
 	}
+	return o.bucket.PutObject(key, r, opts...)
+}
 
-	log.Println("Cloud storage initialized successfully")
-	return nil
+func (o *ossBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := o.bucket.GetObject(key, oss.WithContext(ctx))
+	if isOSSNotFound(err) {
+		return nil, ErrNotFound
+	}
+	return body, err
 }
 
-// GetCloudStorage 获取云存储实例
-func GetCloudStorage() *CloudStorage {
-	return cloudStorage
+func (o *ossBackend) Delete(ctx context.Context, key string) error {
+	err := o.bucket.DeleteObject(key, oss.WithContext(ctx))
+	if isOSSNotFound(err) {
+		return nil
+	}
+	return err
 }
 
-// UploadFile 上传文件到 OSS
-func UploadFile(file *multipart.FileHeader) (string, error) {
-	if cloudStorage == nil || cloudStorage.bucket == nil {
-		return "", fmt.Errorf("storage not initialized")
+func (o *ossBackend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	header, err := o.bucket.GetObjectDetailedMeta(key, oss.WithContext(ctx))
+	if isOSSNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// 生成唯一文件名
-	filename := time.Now().Format("20060102150405") + "_" + file.Filename
-	objectKey := "uploads/" + filename
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+	return &ObjectInfo{
+		Key:          key,
+		Size:         size,
+		ContentType:  header.Get("Content-Type"),
+		LastModified: lastModified,
+	}, nil
+}
 
-	// 打开上传的文件
-	src, err := file.Open()
+func (o *ossBackend) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	signed, err := o.bucket.SignURL(key, oss.HTTPGet, int64(expires.Seconds()))
 	if err != nil {
-		return "", fmt.Errorf("failed to open file: %v", err)
+		return "", err
 	}
-	defer src.Close()
+	// SDK 生成的地址可能是 http 协议，统一改为 https
+	return strings.Replace(signed, "http://", "https://", 1), nil
+}
 
-	// 上传到 OSS
-	err = cloudStorage.bucket.PutObject(objectKey, src)
+func (o *ossBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var (
+		objects []ObjectInfo
+		token   string
+	)
+	for {
+		opts := []oss.Option{oss.WithContext(ctx), oss.Prefix(prefix), oss.MaxKeys(1000)}
+		if token != "" {
+			opts = append(opts, oss.ContinuationToken(token))
+		}
+		result, err := o.bucket.ListObjectsV2(opts...)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Objects {
+			objects = append(objects, ObjectInfo{
+				Key:          obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+			})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (o *ossBackend) uploadResult(key, uploadID string) oss.InitiateMultipartUploadResult {
+	return oss.InitiateMultipartUploadResult{
+		Bucket:   o.bucket.BucketName,
+		Key:      key,
+		UploadID: uploadID,
+	}
+}
+
+func (o *ossBackend) InitMultipart(ctx context.Context, key, contentType string) (string, error) {
+	opts := []oss.Option{oss.WithContext(ctx), oss.ObjectACL(oss.ACLPrivate)}
+	if contentType != "" {
+		opts = append(opts, oss.ContentType(contentType))
+	}
+	imur, err := o.bucket.InitiateMultipartUpload(key, opts...)
+	if err != nil {
+		return "", err
+	}
+	return imur.UploadID, nil
+}
+
+func (o *ossBackend) PutPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (string, error) {
+	part, err := o.bucket.UploadPart(o.uploadResult(key, uploadID), r, size, number, oss.WithContext(ctx))
 	if err != nil {
-		return "", fmt.Errorf("failed to upload to OSS: %v", err)
+		return "", err
 	}
+	return part.ETag, nil
+}
 
-	// 构造访问 URL
-	bucketName := os.Getenv("OSS_BUCKET")
-	cloudURL := fmt.Sprintf("https://%s.%s/%s",
-		bucketName,
-		"oss-cn-beijing.aliyuncs.com",
-		objectKey)
+func (o *ossBackend) CompleteMultipart(ctx context.Context, key, uploadID string, parts []Part) error {
+	ossParts := make([]oss.UploadPart, 0, len(parts))
+	for _, p := range parts {
+		ossParts = append(ossParts, oss.UploadPart{PartNumber: p.Number, ETag: p.ETag})
+	}
+	_, err := o.bucket.CompleteMultipartUpload(o.uploadResult(key, uploadID), ossParts, oss.WithContext(ctx))
+	return err
+}
 
-	return cloudURL, nil
+func (o *ossBackend) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	err := o.bucket.AbortMultipartUpload(o.uploadResult(key, uploadID), oss.WithContext(ctx))
+	if isOSSNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func isOSSNotFound(err error) bool {
+	if se, ok := err.(oss.ServiceError); ok {
+		return se.StatusCode == http.StatusNotFound
+	}
+	return false
 }
@@ -0,0 +1,149 @@
+// storage/storage.go
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bi-backend/config"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("storage: object not found")
+
+// ObjectInfo 对象元信息
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Backend 存储后端接口，local / s3 / oss 驱动均实现该接口
+type Backend interface {
+	// Put 写入对象，size 未知时传 -1
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，调用方负责关闭
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// Stat 获取对象元信息，对象不存在时返回 ErrNotFound
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// PresignGet 生成带有效期的下载链接
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// List 列出指定前缀下的所有对象
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+var backend Backend
+
+// Init 根据配置初始化存储后端
+func Init(cfg config.StorageConfig) error {
+	var (
+		b   Backend
+		err error
+	)
+
+	switch cfg.Driver {
+	case "local":
+		b, err = newLocalBackend(cfg)
+	case "s3":
+		b, err = newS3Backend(cfg)
+	case "oss":
+		b, err = newOSSBackend(cfg)
+	default:
+		return fmt.Errorf("unsupported storage driver: %s", cfg.Driver)
+	}
+	if err != nil {
+		return err
+	}
+
+	backend = b
+	log.Printf("Storage initialized successfully, driver: %s", cfg.Driver)
+	return nil
+}
+
+// Default 获取当前存储后端，未初始化时返回 nil
+func Default() Backend {
+	return backend
+}
+
+// SetDefault 替换当前存储后端，便于测试或嵌入其他实现
+func SetDefault(b Backend) {
+	backend = b
+}
+
+// DeleteObject 删除对象，存储未初始化时返回错误
+func DeleteObject(ctx context.Context, key string) error {
+	if backend == nil {
+		return fmt.Errorf("storage not initialized")
+	}
+	if key == "" {
+		return nil
+	}
+	return backend.Delete(ctx, key)
+}
+
+// KeyFromURL 从历史记录中保存的公开访问地址还原对象 key
+// 地址格式为 https://bucket.endpoint/uploads/xxx，对象 key 即完整路径，其他格式返回空字符串
+func KeyFromURL(fileURL string) string {
+	u, err := url.Parse(fileURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Path == "" {
+		return ""
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// LocalFileHandler 返回 local 驱动的文件访问处理器，其他驱动返回 nil
+func LocalFileHandler() http.Handler {
+	if l, ok := backend.(*localBackend); ok {
+		return l
+	}
+	return nil
+}
+
+// NewObjectKey 为上传文件生成唯一的对象 key，格式为 uploads/<用户 ID>/<时间戳>_<随机串>_<文件名>，
+// owner 为空时省略用户目录
+func NewObjectKey(owner, filename string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	name := time.Now().Format("20060102150405") + "_" + hex.EncodeToString(b) + "_" + filename
+	if owner == "" {
+		return "uploads/" + name
+	}
+	return "uploads/" + owner + "/" + name
+}
+
+// UploadFile 上传表单文件，返回对象 key
+// 存储桶为私有，访问文件需通过 PresignGet 生成临时链接
+func UploadFile(file *multipart.FileHeader) (string, error) {
+	if backend == nil {
+		return "", fmt.Errorf("storage not initialized")
+	}
+
+	objectKey := NewObjectKey("", file.Filename)
+
+	// 打开上传的文件
+	src, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer src.Close()
+
+	contentType := file.Header.Get("Content-Type")
+	if err := backend.Put(context.Background(), objectKey, src, file.Size, contentType); err != nil {
+		return "", fmt.Errorf("failed to upload file: %v", err)
+	}
+
+	return objectKey, nil
+}
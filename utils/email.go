@@ -3,17 +3,43 @@ package utils
 
 import (
 	"bi-backend/config"
+	"io"
 
 	"gopkg.in/gomail.v2"
 )
 
 func SendEmail(to, subject, body string) error {
+	return SendEmailWithAttachments([]string{to}, subject, body, nil)
+}
+
+// EmailAttachment 邮件附件，Inline 为 true 时作为内嵌图片，正文中通过 cid:<Name> 引用
+type EmailAttachment struct {
+	Name   string
+	Data   []byte
+	Inline bool
+}
+
+// SendEmailWithAttachments 向多个收件人发送带附件的 HTML 邮件
+func SendEmailWithAttachments(to []string, subject, body string, attachments []EmailAttachment) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", config.GlobalConfig.Email.From)
-	m.SetHeader("To", to)
+	m.SetHeader("To", to...)
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/html", body)
 
+	for _, a := range attachments {
+		data := a.Data
+		copyData := gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+		if a.Inline {
+			m.Embed(a.Name, copyData)
+		} else {
+			m.Attach(a.Name, copyData)
+		}
+	}
+
 	d := gomail.NewDialer(
 		config.GlobalConfig.Email.Host,
 		config.GlobalConfig.Email.Port,
@@ -59,6 +59,112 @@ const resetPasswordEmailTemplate = `
 </html>
 `
 
+// 仪表盘报表邮件模板，图表图片通过 cid 引用内嵌附件
+const reportEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>{{.Title}}</title>
+</head>
+<body style="font-family: 'Microsoft YaHei', Arial, sans-serif;">
+    <div style="max-width: 800px; margin: 0 auto; padding: 20px;">
+        <h2 style="color: #333;">{{.Title}}</h2>
+        {{if .Description}}<p style="color: #555;">{{.Description}}</p>{{end}}
+        <p style="color: #888; font-size: 12px;">生成时间：{{.GeneratedAt}}</p>
+        {{if .Attached}}<p>完整的仪表盘报表见附件 PDF。</p>{{end}}
+        {{range .Charts}}
+        <h3 style="color: #333; margin-top: 24px;">{{.Name}}</h3>
+        {{if .ImageCID}}<img src="cid:{{.ImageCID}}" alt="{{.Name}}" style="max-width: 100%;">{{else}}<p style="color: #999;">{{.Message}}</p>{{end}}
+        {{end}}
+        <hr style="border: 1px solid #eee; margin: 20px 0;">
+        <p style="color: #666; font-size: 12px;">此邮件由系统根据报表订阅自动发送，请勿直接回复。</p>
+    </div>
+</body>
+</html>
+`
+
+// ReportEmail 报表邮件的内容
+type ReportEmail struct {
+	Title       string
+	Description string
+	GeneratedAt string
+	Attached    bool // 是否附带 PDF
+	Charts      []ReportEmailChart
+}
+
+// ReportEmailChart 邮件正文中的一个图表，ImageCID 为空时显示 Message
+type ReportEmailChart struct {
+	Name     string
+	ImageCID string
+	Message  string
+}
+
+// SendReportEmail 发送仪表盘报表邮件
+func SendReportEmail(to []string, subject string, report ReportEmail, attachments []EmailAttachment) error {
+	t, err := template.New("report").Parse(reportEmailTemplate)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, report); err != nil {
+		return err
+	}
+
+	return SendEmailWithAttachments(to, subject, body.String(), attachments)
+}
+
+// 指标告警邮件模板
+const alertEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>指标告警：{{.RuleName}}</title>
+</head>
+<body style="font-family: 'Microsoft YaHei', Arial, sans-serif;">
+    <div style="max-width: 600px; margin: 0 auto; padding: 20px;">
+        <h2 style="color: #d9534f;">指标告警：{{.RuleName}}</h2>
+        <p>图表 <strong>{{.ChartName}}</strong> 的指标满足了告警条件。</p>
+        <table style="border-collapse: collapse; width: 100%;">
+            <tr><td style="padding: 6px; color: #666;">告警条件</td><td style="padding: 6px;">{{.Condition}}</td></tr>
+            <tr><td style="padding: 6px; color: #666;">当前值</td><td style="padding: 6px;">{{.Value}}</td></tr>
+            {{if .Baseline}}<tr><td style="padding: 6px; color: #666;">对比值</td><td style="padding: 6px;">{{.Baseline}}</td></tr>{{end}}
+            <tr><td style="padding: 6px; color: #666;">检查时间</td><td style="padding: 6px;">{{.EvaluatedAt}}</td></tr>
+        </table>
+        <hr style="border: 1px solid #eee; margin: 20px 0;">
+        <p style="color: #666; font-size: 12px;">此邮件由系统根据告警规则自动发送，请勿直接回复。可以在平台中将规则静音或暂停通知。</p>
+    </div>
+</body>
+</html>
+`
+
+// AlertEmail 告警邮件的内容
+type AlertEmail struct {
+	RuleName    string
+	ChartName   string
+	Condition   string
+	Value       string
+	Baseline    string
+	EvaluatedAt string
+}
+
+// SendAlertEmail 发送指标告警邮件
+func SendAlertEmail(to []string, alert AlertEmail) error {
+	t, err := template.New("alert").Parse(alertEmailTemplate)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, alert); err != nil {
+		return err
+	}
+
+	return SendEmailWithAttachments(to, "[告警] "+alert.RuleName, body.String(), nil)
+}
+
 func SendVerificationEmail(email, token string) error {
 	t, err := template.New("verify").Parse(verificationEmailTemplate)
 	if err != nil {
@@ -25,3 +25,12 @@ func Error(c *gin.Context, code int, msg string) {
 		Msg:  msg,
 	})
 }
+
+// ErrorWithData 返回错误并附带详细信息，例如字段级的校验错误
+func ErrorWithData(c *gin.Context, code int, msg string, data interface{}) {
+	c.JSON(code, Response{
+		Code: code,
+		Msg:  msg,
+		Data: data,
+	})
+}
@@ -0,0 +1,180 @@
+// utils/schema.go
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"bi-backend/models"
+)
+
+// 列类型
+const (
+	ColumnTypeNumber  = "number"
+	ColumnTypeDate    = "date"
+	ColumnTypeBoolean = "boolean"
+	ColumnTypeString  = "string"
+)
+
+// schemaSampleSize 推断列类型时每列最多检查的非空值数量
+const schemaSampleSize = 1000
+
+// DateLayouts 自动识别的日期格式，按优先级排列
+var DateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"2006/01/02",
+	"2006/01/02 15:04:05",
+	"2006/1/2",
+	"2006/1/2 15:04:05",
+	"2006-1-2",
+	"2006年01月02日",
+	"2006年1月2日",
+	"01/02/2006",
+	"2006-01",
+	"2006/01",
+}
+
+// IsNullValue 判断单元格是否为空值
+func IsNullValue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "null", "nil", "nan", "n/a", "na", "-", "<nil>":
+		return true
+	}
+	return false
+}
+
+// ParseNumber 解析数值单元格
+func ParseNumber(value string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return f, err == nil
+}
+
+// ParseBool 解析布尔单元格
+func ParseBool(value string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes":
+		return true, true
+	case "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// DetectDateLayout 识别日期字符串的格式，无法识别时返回空字符串
+func DetectDateLayout(value string) string {
+	value = strings.TrimSpace(value)
+	for _, layout := range DateLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return layout
+		}
+	}
+	return ""
+}
+
+// ParseDate 按指定格式解析日期，layout 为空时自动识别，loc 为空时使用 UTC
+func ParseDate(value, layout string, loc *time.Location) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if loc == nil {
+		loc = time.UTC
+	}
+	if layout == "" {
+		layout = DetectDateLayout(value)
+		if layout == "" {
+			return time.Time{}, false
+		}
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	return t, err == nil
+}
+
+// InferSchema 根据数据推断每一列的类型，每列最多采样 schemaSampleSize 个非空值
+func InferSchema(headers []string, rows [][]string) []models.ColumnSchema {
+	schema := make([]models.ColumnSchema, len(headers))
+	for col, name := range headers {
+		isNumber, isBool, isDate := true, true, true
+		layout := ""
+		sampled := 0
+		nullable := false
+
+		for _, row := range rows {
+			if col >= len(row) || IsNullValue(row[col]) {
+				nullable = true
+				continue
+			}
+			if sampled >= schemaSampleSize {
+				continue
+			}
+			sampled++
+
+			value := row[col]
+			if isNumber {
+				_, isNumber = ParseNumber(value)
+			}
+			if isBool {
+				_, isBool = ParseBool(value)
+			}
+			if isDate {
+				// 以第一个值识别出的格式为准，其余值必须符合同一格式
+				if layout == "" {
+					layout = DetectDateLayout(value)
+					isDate = layout != ""
+				} else {
+					_, isDate = ParseDate(value, layout, nil)
+				}
+			}
+		}
+
+		column := models.ColumnSchema{Name: name, Type: ColumnTypeString, Nullable: nullable}
+		switch {
+		case sampled == 0:
+		case isNumber:
+			column.Type = ColumnTypeNumber
+		case isBool:
+			column.Type = ColumnTypeBoolean
+		case isDate:
+			column.Type = ColumnTypeDate
+			column.Format = layout
+		}
+		schema[col] = column
+	}
+	return schema
+}
+
+// dateTokens 常见的日期格式占位符与 Go layout 的对应关系，按长度从长到短匹配
+var dateTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"}, {"yyyy", "2006"},
+	{"YY", "06"}, {"yy", "06"},
+	{"MM", "01"}, {"DD", "02"}, {"dd", "02"},
+	{"HH", "15"}, {"hh", "03"}, {"mm", "04"}, {"ss", "05"},
+	{"M", "1"}, {"D", "2"}, {"d", "2"}, {"H", "15"}, {"h", "3"}, {"m", "4"}, {"s", "5"},
+}
+
+// NormalizeDateLayout 将 YYYY-MM-DD 风格的格式转换为 Go layout，已经是 Go layout 时原样返回
+func NormalizeDateLayout(format string) string {
+	if format == "" || strings.Contains(format, "2006") || strings.Contains(format, "15:04") {
+		return format
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(format); {
+		matched := false
+		for _, t := range dateTokens {
+			if strings.HasPrefix(format[i:], t.token) {
+				b.WriteString(t.layout)
+				i += len(t.token)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(format[i])
+			i++
+		}
+	}
+	return b.String()
+}
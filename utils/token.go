@@ -5,7 +5,9 @@ import (
 	"bi-backend/config"
 	"bi-backend/models"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -27,6 +29,84 @@ func GenerateToken(userID, username, role string) (string, error) {
 	return token.SignedString([]byte(config.GlobalConfig.JWT.Secret))
 }
 
+// shareSigningKey 分享访问令牌的签名密钥，由 JWT 密钥派生，分享令牌不能当作登录令牌使用
+func shareSigningKey() []byte {
+	sum := sha256.Sum256([]byte("dashboard-share:" + config.GlobalConfig.JWT.Secret))
+	return sum[:]
+}
+
+// GenerateShareAccessToken 为输入密码后的分享访客生成访问令牌，subject 为分享 ID
+func GenerateShareAccessToken(shareID string, expiresAt time.Time) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:   shareID,
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(shareSigningKey())
+}
+
+// ParseShareAccessToken 校验分享访问令牌，返回分享 ID
+func ParseShareAccessToken(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return shareSigningKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return "", errors.New("invalid share access token")
+	}
+	return claims.Subject, nil
+}
+
+// ErrEmbedDisabled 未配置嵌入密钥
+var ErrEmbedDisabled = errors.New("embedding is not configured")
+
+// GenerateEmbedToken 使用嵌入密钥签发嵌入令牌，有效期不能超过 EmbedMaxTTL
+func GenerateEmbedToken(claims *models.EmbedClaims, ttl time.Duration) (string, error) {
+	cfg := config.GlobalConfig.JWT
+	if cfg.EmbedSecret == "" {
+		return "", ErrEmbedDisabled
+	}
+	if ttl <= 0 || ttl > cfg.EmbedMaxTTL {
+		ttl = cfg.EmbedMaxTTL
+	}
+	now := time.Now()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(cfg.EmbedSecret))
+}
+
+// ParseEmbedToken 校验嵌入令牌的签名和有效期，必须带有过期时间且有效期不超过 EmbedMaxTTL
+func ParseEmbedToken(tokenString string) (*models.EmbedClaims, error) {
+	cfg := config.GlobalConfig.JWT
+	if cfg.EmbedSecret == "" {
+		return nil, ErrEmbedDisabled
+	}
+
+	claims := &models.EmbedClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(cfg.EmbedSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid embed token")
+	}
+	if claims.ExpiresAt == nil || time.Until(claims.ExpiresAt.Time) > cfg.EmbedMaxTTL+time.Minute {
+		return nil, errors.New("embed token must expire within " + cfg.EmbedMaxTTL.String())
+	}
+	if claims.DashboardID == "" {
+		return nil, errors.New("embed token has no dashboard_id")
+	}
+	return claims, nil
+}
+
 // 生成随机token
 func GenerateRandomToken() string {
 	b := make([]byte, 32)
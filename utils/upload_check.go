@@ -0,0 +1,128 @@
+// utils/upload_check.go
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"bi-backend/config"
+)
+
+// sniffLen 用于识别文件类型的头部字节数
+const sniffLen = 4096
+
+// UploadError 上传文件校验失败，Status 为对应的 HTTP 状态码
+type UploadError struct {
+	Status int
+	Msg    string
+}
+
+func (e *UploadError) Error() string {
+	return e.Msg
+}
+
+// MaxUploadSize 获取指定文件类型允许的最大字节数，未知类型返回 0
+func MaxUploadSize(fileType string) int64 {
+	return config.GlobalConfig.Upload.MaxSize[fileType]
+}
+
+// MaxUploadSizeAll 所有文件类型中最大的上传限制，用于限制请求体大小
+func MaxUploadSizeAll() int64 {
+	var max int64
+	for _, size := range config.GlobalConfig.Upload.MaxSize {
+		if size > max {
+			max = size
+		}
+	}
+	return max
+}
+
+// SniffFileType 根据文件头部内容识别文件类型，返回 excel / json / csv，无法识别时返回空字符串
+func SniffFileType(head []byte) string {
+	// xlsx 为 zip 压缩包
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return "excel"
+	}
+
+	// 其余支持的类型都是文本，含有 NUL 字节或可识别的二进制格式一律拒绝
+	if bytes.IndexByte(head, 0) >= 0 {
+		return ""
+	}
+	if ct := http.DetectContentType(head); !strings.HasPrefix(ct, "text/plain") && ct != "application/octet-stream" {
+		return ""
+	}
+
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF")), " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return "json"
+	}
+	return "csv"
+}
+
+// CheckXLSXArchive 检查 xlsx 压缩包结构，防御 zip 炸弹
+func CheckXLSXArchive(r io.ReaderAt, size int64) error {
+	limits := config.GlobalConfig.Upload
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return &UploadError{Status: 415, Msg: "无效的 Excel 文件"}
+	}
+	if len(zr.File) > limits.XLSXMaxEntries {
+		return &UploadError{Status: 413, Msg: fmt.Sprintf("Excel 文件包含的条目过多（%d）", len(zr.File))}
+	}
+
+	var total uint64
+	hasWorkbook := false
+	for _, f := range zr.File {
+		if f.Name == "xl/workbook.xml" {
+			hasWorkbook = true
+		}
+
+		total += f.UncompressedSize64
+		if total > uint64(limits.XLSXMaxUncompressed) {
+			return &UploadError{Status: 413, Msg: "Excel 文件解压后体积过大"}
+		}
+		// 小文件的压缩比天然偏高，只检查超过 1MB 的条目
+		if f.UncompressedSize64 > 1<<20 &&
+			float64(f.UncompressedSize64) > float64(f.CompressedSize64)*limits.XLSXMaxRatio {
+			return &UploadError{Status: 413, Msg: "Excel 文件压缩比异常，疑似恶意文件"}
+		}
+	}
+	if !hasWorkbook {
+		return &UploadError{Status: 415, Msg: "文件不是有效的 xlsx 工作簿"}
+	}
+	return nil
+}
+
+// ValidateUpload 校验上传文件的大小和内容，要求实际内容与声明的类型一致
+func ValidateUpload(r io.ReaderAt, size int64, declaredType string) error {
+	maxSize := MaxUploadSize(declaredType)
+	if maxSize == 0 {
+		return &UploadError{Status: 400, Msg: "Unsupported file type"}
+	}
+	if size > maxSize {
+		return &UploadError{Status: 413, Msg: fmt.Sprintf("文件大小超过限制（最大 %d MB）", maxSize>>20)}
+	}
+	if size == 0 {
+		return &UploadError{Status: 400, Msg: "文件为空"}
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	sniffed := SniffFileType(head[:n])
+	if sniffed != declaredType {
+		return &UploadError{Status: 415, Msg: "文件内容与声明的类型不一致"}
+	}
+
+	if declaredType == "excel" {
+		return CheckXLSXArchive(r, size)
+	}
+	return nil
+}